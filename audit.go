@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/immutos/matchstick/internal/audit"
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// version is the matchstick version, set at build time with:
+//
+//	-ldflags "-X main.version=v1.2.3"
+//
+// Otherwise the module version (or VCS revision) is used.
+var version string
+
+// bootIDPath is where the kernel exposes the random boot ID.
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// appendAudit appends a record of the boot (as reported) to the audit log on
+// the data filesystem.
+func appendAudit(opts *config.Options, report *bootreport.Report) {
+	path := filepath.Join(opts.Mount, opts.AuditLog)
+
+	rec := &audit.Record{
+		Time:    time.Now().UTC(),
+		Version: matchstickVersion(),
+		Image:   imageIdentity(opts, "/"),
+		Options: report.Options,
+		Devices: report.Devices,
+		Mounts:  report.Mounts,
+		Argv:    report.Argv,
+	}
+
+	if id, err := os.ReadFile(bootIDPath); err == nil {
+		rec.BootID = strings.TrimSpace(string(id))
+	}
+
+	if err := audit.Append(path, rec, opts.AuditChain); err != nil {
+		degrade("Failed to append audit record", slog.String("path", path), slog.Any("error", err))
+		return
+	}
+
+	if err := audit.SetAppendOnly(path); err != nil {
+		slog.Debug("Failed to make audit log append-only", slog.String("path", path), slog.Any("error", err))
+	}
+}
+
+// matchstickVersion returns the version matchstick was built as.
+func matchstickVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+
+	return info.Main.Version
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/bootloader"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+)
+
+// bootMount is where the boot device is (temporarily) mounted.
+const bootMount = "/run/matchstick/boot"
+
+// efivarsMount is where the EFI variables filesystem is mounted.
+const efivarsMount = bootloader.DefaultEFIVarsDir
+
+// markBootAttempt records the boot attempt with the bootloader (before
+// handing off to init), so that unless the boot is later marked as
+// successful, the bootloader can fall back to another entry (or slot).
+func markBootAttempt(opts *config.Options) {
+	kind, err := bootloader.ParseKind(opts.Bootloader)
+	if err != nil {
+		slog.Warn("Not recording boot attempt", slog.Any("error", err))
+		return
+	}
+
+	state := &bootloader.State{Bootloader: kind, Device: opts.BootDevice, FSType: opts.BootFSType}
+
+	switch kind {
+	case bootloader.None:
+		return
+	case bootloader.GRUB:
+		state.Path = opts.Grubenv
+
+		err = withBootDevice(state, func(root string) error {
+			return setGrubenv(filepath.Join(root, state.Path), "boot_success", "0")
+		})
+	case bootloader.SystemdBoot:
+		if mounted, _ := sys.IsMountpoint(efivarsMount); !mounted {
+			if err := sys.Mount("efivarfs", efivarsMount, "efivarfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+				slog.Debug("Failed to mount EFI variables", slog.Any("error", err))
+			}
+		}
+
+		// systemd-boot has already counted the attempt.
+		state.Path, err = bootloader.LoaderBootCountPath(efivarsMount)
+		if err == nil && state.Path == "" {
+			slog.Debug("systemd-boot is not counting boot attempts")
+			return
+		}
+	}
+	if err != nil {
+		slog.Warn("Failed to record boot attempt", slog.String("bootloader", string(kind)), slog.Any("error", err))
+		return
+	}
+
+	slog.Info("Recorded boot attempt", slog.String("bootloader", string(kind)), slog.String("path", state.Path))
+
+	if err := state.Write(bootloader.DefaultStatePath); err != nil {
+		slog.Warn("Failed to write boot attempt", slog.String("path", bootloader.DefaultStatePath), slog.Any("error", err))
+	}
+}
+
+// runBless implements the bless subcommand, which marks the boot recorded by
+// markBootAttempt as successful.
+func runBless(args []string) error {
+	fs := pflag.NewFlagSet("bless", pflag.ContinueOnError)
+	statePath := fs.String("state", bootloader.DefaultStatePath, "The boot attempt recorded before executing init")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	state, err := bootloader.ReadState(*statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("No boot attempt was recorded, nothing to do")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = withBootDevice(state, func(root string) error {
+		path := filepath.Join(root, state.Path)
+
+		switch state.Bootloader {
+		case bootloader.GRUB:
+			return setGrubenv(path, "boot_success", "1")
+		case bootloader.SystemdBoot:
+			blessed, err := bootloader.Bless(path)
+			if err == nil {
+				slog.Info("Blessed boot loader entry", slog.String("entry", blessed))
+			}
+			return err
+		default:
+			return fmt.Errorf("unsupported bootloader %q", state.Bootloader)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Marked boot as successful", slog.String("bootloader", string(state.Bootloader)))
+
+	return os.Remove(*statePath)
+}
+
+// setGrubenv sets a variable in a GRUB environment block.
+func setGrubenv(path, key, value string) error {
+	env, err := bootloader.ReadGrubenv(path)
+	if err != nil {
+		return err
+	}
+
+	env.Set(key, value)
+
+	return env.Write(path)
+}
+
+// withBootDevice calls fn with the root of the boot device, which is mounted
+// (read-write) for the duration.
+func withBootDevice(state *bootloader.State, fn func(root string) error) error {
+	// Anything written to the image would never be read by the bootloader.
+	if state.Device == "" {
+		return errors.New("no boot device is configured")
+	}
+
+	if err := sys.MkdirAll(bootMount, 0o755); err != nil {
+		return err
+	}
+
+	device := plan.ResolveDevice(state.Device)
+	if err := sys.Mount(device, bootMount, state.FSType, unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount boot device: %w", err)
+	}
+	defer func() {
+		if err := sys.Unmount(bootMount, 0); err != nil {
+			slog.Warn("Failed to unmount boot device", slog.Any("error", err))
+		}
+	}()
+
+	return fn(bootMount)
+}
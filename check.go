@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/immutos/matchstick/internal/check"
+	"github.com/immutos/matchstick/internal/modules"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// runCheck implements the check subcommand.
+func runCheck(args []string) error {
+	var opts config.Options
+	fs := config.NewFlagSet("check", &opts)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if lockedDown(false) {
+		violations, err := decodeTrustedOptions(&opts, fs)
+		if err != nil {
+			return err
+		}
+
+		if len(violations) > 0 {
+			slog.Warn("Options set outside of the trusted config are ignored", slog.Any("options", violations))
+		}
+	} else if err := config.Load(&opts, false); err != nil {
+		return err
+	}
+
+	if err := readDirsFile(&opts, "/"); err != nil {
+		return err
+	}
+
+	c := &check.Checker{FilesystemsPath: check.DefaultFilesystemsPath}
+
+	// Without the module directory, filesystems must already be supported.
+	if dir, err := modules.DefaultDir(); err == nil {
+		c.ModulesDir = dir
+	}
+
+	r := c.Run(&opts)
+	r.Print(os.Stdout)
+
+	if r.Failed() {
+		return errors.New("one or more checks failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/immutos/matchstick/internal/clock"
+	"github.com/immutos/matchstick/internal/stage"
+)
+
+// sanitizeClock sets the system clock from the RTC (if present), and
+// otherwise makes sure it is no earlier than the build time of the image.
+func sanitizeClock(tracker *stage.Tracker) {
+	_ = tracker.Run(context.Background(), "clock", 0, func(ctx context.Context) error {
+		rtc, err := clock.ReadRTC(clock.DefaultRTC)
+		if err != nil {
+			slog.Debug("Failed to read RTC", slog.Any("error", err))
+		}
+
+		floor := clock.Floor(clock.BuildTime, clock.DefaultEpochFiles)
+
+		t, ok := clock.Decide(time.Now(), rtc, floor)
+		if !ok {
+			return nil
+		}
+
+		slog.Info("Setting system clock", slog.Time("time", t), slog.Bool("rtc", t.Equal(rtc)))
+
+		if err := clock.Set(t); err != nil {
+			slog.Warn("Failed to set system clock", slog.Any("error", err))
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// matchstick-shutdown cleanly tears down the overlays and data filesystem set
+// up by matchstick, before the machine is halted or rebooted. It's run (with
+// no arguments) by matchstick-shutdown.service, which is stopped before
+// umount.target, while the filesystems are still writable. Installed as a
+// systemd-shutdown hook (see systemd-shutdown(8)), it's run with the action
+// instead, and only drops the key of a stateless data device, as by then
+// everything has been unmounted (or remounted read-only).
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/dmcrypt"
+	"github.com/immutos/matchstick/internal/fstrim"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/internal/provider"
+	"github.com/immutos/matchstick/internal/teardown"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "matchstick-shutdown:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var fs pflag.FlagSet
+	fs.Init("matchstick-shutdown", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: matchstick-shutdown [flags]")
+		fmt.Fprintln(os.Stderr, "       matchstick-shutdown halt|poweroff|reboot|kexec")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+
+	reportPath := fs.String("report", bootreport.DefaultPath, "The boot report written by matchstick")
+	trim := fs.Bool("trim", false, "Discard the unused blocks of the data filesystem before unmounting it (regardless of the trim policy)")
+	dryRun := fs.Bool("dry-run", false, "Print the filesystems that would be unmounted and exit")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	r, err := bootreport.Read(*reportPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Not booted with matchstick.
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if r.Container {
+		return nil
+	}
+
+	stateless := r.String("provider") == "stateless-encrypted" && !r.Bool("volatile")
+
+	// Run as a systemd-shutdown hook (with the action, eg. reboot).
+	if fs.NArg() > 0 {
+		if !stateless || *dryRun {
+			return nil
+		}
+
+		// The data filesystem may not have been mounted.
+		if _, err := os.Stat(dmcrypt.Path(provider.MapperName)); err != nil {
+			return nil
+		}
+
+		// Drop the random key of a stateless data device.
+		if err := dmcrypt.Close(provider.MapperName); err != nil {
+			return fmt.Errorf("failed to close data device: %w", err)
+		}
+
+		return nil
+	}
+
+	mounts, err := mountinfo.Read(mountinfo.DefaultPath)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+
+	targets := teardown.Targets(r, mounts)
+
+	if *dryRun {
+		for _, target := range targets {
+			fmt.Println(target)
+		}
+
+		return nil
+	}
+
+	// Flush the upper layers (and everything else) before anything is
+	// unmounted, so nothing is lost if unmounting fails.
+	unix.Sync()
+
+	var errs []error
+	for _, target := range targets {
+		if err := syncfs(target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	data := r.String("mount")
+	dataMounted := !r.Bool("volatile") && slices.Contains(targets, data)
+
+	if dataMounted && !stateless && (*trim || trimDue(r)) {
+		trimmed, err := fstrim.Trim(data)
+		if err == nil {
+			fmt.Printf("matchstick-shutdown: %s: %d bytes trimmed\n", data, trimmed)
+			err = fstrim.Record(data, time.Now())
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := teardown.Unmount(mounter.System{}, targets); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// trimDue returns true if the trim policy is to trim the data filesystem at
+// shutdown, and it hasn't been trimmed within the trim interval.
+func trimDue(r *bootreport.Report) bool {
+	policy, err := fstrim.ParsePolicy(r.String("trim"))
+	if err != nil || policy != fstrim.Shutdown {
+		return false
+	}
+
+	if p := r.String("provider"); (p != "" && p != "block") || provider.IsNFS(r.String("datafstype")) {
+		return false
+	}
+
+	return fstrim.Due(r.String("mount"), r.Duration("trim_interval"), time.Now())
+}
+
+// syncfs flushes the filesystem mounted at path.
+func syncfs(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := unix.Syncfs(int(f.Fd())); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/audit"
+	"github.com/immutos/matchstick/internal/bootreport"
+)
+
+// runAudit verifies the chain of records in the audit log.
+func runAudit(r *bootreport.Report) error {
+	if r.String("audit_log") == "" {
+		return errors.New("the audit log is not enabled")
+	}
+
+	path := filepath.Join(r.String("mount"), r.String("audit_log"))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := audit.Verify(f)
+	if err != nil {
+		return fmt.Errorf("audit log %s failed verification: %w", path, err)
+	}
+
+	fmt.Printf("Verified %d audit records\n", n)
+
+	return nil
+}
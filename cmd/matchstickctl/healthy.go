@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/health"
+	"github.com/immutos/matchstick/internal/mountinfo"
+)
+
+// runHealthy marks the boot as healthy, and (if rollback is enabled) replaces
+// the snapshot the overlays are rolled back to.
+func runHealthy(r *bootreport.Report) error {
+	if r.Container {
+		return errors.New("not supported in a container")
+	}
+
+	if !persistent(r) {
+		fmt.Println("No persistent data filesystem, nothing to do")
+		return nil
+	}
+
+	mounts, err := mountinfo.Read(mountinfo.DefaultPath)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+
+	mount := r.String("mount")
+	if mountinfo.Find(mounts, mount) == nil {
+		return fmt.Errorf("data filesystem is not mounted on %s", mount)
+	}
+
+	if r.Int("rollback_after") > 0 {
+		var upperDirs []string
+		for _, dir := range overlayDirs(r) {
+			if o := r.Overlay(dir); o != nil {
+				upperDir, _ := bootreport.OverlayDirs(o.Options)
+				upperDirs = append(upperDirs, upperDir)
+			}
+		}
+
+		if err := health.Snapshot(mount, upperDirs); err != nil {
+			return fmt.Errorf("failed to snapshot overlays: %w", err)
+		}
+	}
+
+	if err := health.MarkHealthy(mount); err != nil {
+		return err
+	}
+
+	fmt.Println("Marked boot as healthy")
+
+	return nil
+}
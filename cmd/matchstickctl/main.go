@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// matchstickctl inspects and controls matchstick at runtime, using the boot
+// report written by matchstick.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/spf13/pflag"
+)
+
+const usage = `Usage: matchstickctl [flags] <command> [args]
+
+Commands:
+  status            Show the data mount, the overlays and any next boot request
+  reset <dir>       Discard the changes made to an overlaid directory
+  healthy           Mark the boot as healthy (and snapshot the overlays, if
+                    rollback is enabled)
+  next-boot <mode>  Use a volatile or persistent data mount for the next boot
+                    (or clear the request)
+  audit             Verify the audit log (and print the number of records)
+
+Flags:
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "matchstickctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var fs pflag.FlagSet
+	fs.Init("matchstickctl", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	reportPath := fs.String("report", bootreport.DefaultPath, "The boot report written by matchstick")
+	asJSON := fs.Bool("json", false, "Print the status as JSON (the boot report)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+
+	r, err := bootreport.Read(*reportPath)
+	if err != nil {
+		return fmt.Errorf("failed to read boot report (was the system booted with matchstick?): %w", err)
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+
+	switch cmd {
+	case "status":
+		return runStatus(r, *asJSON)
+	case "healthy":
+		return runHealthy(r)
+	case "reset":
+		if len(cmdArgs) != 1 {
+			return errors.New("usage: matchstickctl reset <dir>")
+		}
+
+		return runReset(r, cmdArgs[0])
+	case "next-boot":
+		if len(cmdArgs) != 1 {
+			return errors.New("usage: matchstickctl next-boot volatile|persistent|clear")
+		}
+
+		return runNextBoot(r, cmdArgs[0])
+	case "audit":
+		return runAudit(r)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
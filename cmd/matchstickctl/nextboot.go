@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/internal/nextboot"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/storage"
+)
+
+// runNextBoot writes (or clears) the marker on the persistent data device
+// selecting a volatile or persistent data mount for the next boot.
+func runNextBoot(r *bootreport.Report, arg string) error {
+	var mode nextboot.Mode
+	if arg != "clear" {
+		var err error
+		if mode, err = nextboot.ParseMode(arg); err != nil {
+			return err
+		}
+	}
+
+	return withDataMount(r, func(mount string) error {
+		if mode == "" {
+			if err := nextboot.Clear(mount); err != nil {
+				return err
+			}
+
+			fmt.Println("Cleared next boot request")
+			return nil
+		}
+
+		if err := nextboot.Write(mount, mode); err != nil {
+			return err
+		}
+
+		fmt.Printf("The next boot will use a %s data mount\n", mode)
+		return nil
+	})
+}
+
+// withDataMount calls fn with the mountpoint of the persistent data
+// filesystem. If it isn't mounted (eg. on a volatile boot) the data device is
+// temporarily mounted.
+func withDataMount(r *bootreport.Report, fn func(mount string) error) error {
+	if r.Container {
+		return errors.New("not supported in a container")
+	}
+
+	mounts, err := mountinfo.Read(mountinfo.DefaultPath)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+
+	if r.String("provider") == "stateless-encrypted" {
+		return errors.New("the data device is wiped every boot")
+	}
+
+	mount := r.String("mount")
+	if persistent(r) && mountinfo.Find(mounts, mount) != nil {
+		return fn(mount)
+	}
+
+	data := r.String("data")
+	if data == "" {
+		return errors.New("no persistent data device is configured (data must be set)")
+	}
+
+	device := storage.ResolveDevice(data)
+
+	fi, err := os.Stat(device)
+	if err != nil {
+		return fmt.Errorf("persistent data device %s is not available: %w", data, err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("persistent data device %s is not a block device", data)
+	}
+
+	fstype := r.String("datafstype")
+	if fstype == "" {
+		if fstype, err = fsprobe.Probe(device); err != nil {
+			return err
+		}
+	}
+
+	dir, err := os.MkdirTemp("/run", "matchstickctl-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dir)
+
+	var m mounter.System
+	if err := m.Mount(device, dir, fstype, 0, r.String("data_options")); err != nil {
+		return fmt.Errorf("failed to mount persistent data device %s: %w", data, err)
+	}
+
+	err = fn(dir)
+
+	if unmountErr := m.Unmount(dir, 0); unmountErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to unmount persistent data device: %w", unmountErr))
+	}
+
+	return err
+}
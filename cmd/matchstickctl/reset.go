@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/internal/trace"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+// runReset discards the changes made to an overlaid directory by clearing its
+// upper layer, and remounts the overlay.
+func runReset(r *bootreport.Report, dir string) error {
+	dir = filepath.Clean(dir)
+	if dir == "/" {
+		return errors.New("refusing to reset the root filesystem overlay")
+	}
+
+	o := r.Overlay(dir)
+	if o == nil {
+		return fmt.Errorf("%s was not overlaid at boot", dir)
+	}
+
+	mounts, err := mountinfo.Read(mountinfo.DefaultPath)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+
+	if m := mountinfo.Find(mounts, dir); m == nil || m.FSType != "overlay" {
+		return fmt.Errorf("%s is no longer an overlay", dir)
+	}
+
+	upperDir, workDir := bootreport.OverlayDirs(o.Options)
+
+	// Never remove anything outside of the data filesystem.
+	mount := filepath.Clean(r.String("mount"))
+	for _, d := range []string{upperDir, workDir} {
+		if d == "" || !strings.HasPrefix(d, mount+"/") {
+			return fmt.Errorf("unexpected overlay directory %q (not on the data filesystem %s)", d, mount)
+		}
+	}
+
+	// The overlay is remounted as it was at boot.
+	flags, err := trace.ParseMountFlags(o.Flags)
+	if err != nil {
+		return fmt.Errorf("invalid flags of the overlay on %s: %w", dir, err)
+	}
+
+	propagation, err := trace.ParseMountFlags(r.Propagation(dir))
+	if err != nil {
+		return fmt.Errorf("invalid propagation of the overlay on %s: %w", dir, err)
+	}
+
+	var m mounter.System
+
+	if err := m.Unmount(dir, 0); err != nil {
+		if errors.Is(err, unix.EBUSY) {
+			return fmt.Errorf("%s is in use, stop any processes using it (eg. with fuser -m %s) and try again: %w", dir, dir, err)
+		}
+
+		return fmt.Errorf("failed to unmount %s: %w", dir, err)
+	}
+
+	for _, d := range []string{upperDir, workDir} {
+		if err := clearDir(d); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", d, err)
+		}
+	}
+
+	if err := m.Mount("overlay", dir, "overlay", flags, o.Options); err != nil {
+		return fmt.Errorf("failed to remount overlay on %s: %w", dir, err)
+	}
+
+	if err := mounter.Propagate(m, dir, propagation); err != nil {
+		return fmt.Errorf("failed to set propagation of the overlay on %s: %w", dir, err)
+	}
+
+	fmt.Printf("Reset %s\n", dir)
+
+	return nil
+}
+
+// clearDir removes the contents of dir (but not dir itself).
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/health"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/internal/nextboot"
+)
+
+// runStatus prints the data mount, the overlays (and whether they are still
+// mounted) and any pending next boot request.
+func runStatus(r *bootreport.Report, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	if r.Container {
+		fmt.Println("Running in a container, no mounts were performed.")
+		return nil
+	}
+
+	mounts, err := mountinfo.Read(mountinfo.DefaultPath)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+
+	mount := r.String("mount")
+
+	switch {
+	case r.Bool("disable"):
+		fmt.Println("Data:      disabled")
+	case r.Bool("volatile"):
+		fmt.Printf("Data:      volatile (tmpfs on %s)\n", mount)
+	case r.String("provider") == "stateless-encrypted":
+		fmt.Printf("Data:      stateless (%s encrypted with a random key on %s)\n", r.Devices["data"], mount)
+	default:
+		fmt.Printf("Data:      persistent (%s on %s)\n", r.Devices["data"], mount)
+	}
+
+	next := "-"
+	if persistent(r) && mountinfo.Find(mounts, mount) != nil {
+		mode, err := nextboot.Read(mount)
+		if err != nil {
+			return fmt.Errorf("failed to read next boot marker: %w", err)
+		}
+
+		if mode != "" {
+			next = string(mode)
+		}
+	}
+	fmt.Printf("Next boot: %s\n", next)
+
+	if r.DataRecovery != "" {
+		fmt.Printf("Recovery:  data filesystem was corrupt (%s)\n", r.DataRecovery)
+	}
+
+	if r.Int("rollback_after") > 0 && persistent(r) {
+		fmt.Printf("Health:    %d of %d unhealthy boots before rollback", health.Pending(mount), r.Int("rollback_after"))
+		if r.RolledBack {
+			fmt.Print(" (rolled back this boot)")
+		}
+		fmt.Println()
+	}
+
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DIR\tSTATE\tUPPER")
+
+	for _, dir := range overlayDirs(r) {
+		o := r.Overlay(dir)
+		if o == nil {
+			fmt.Fprintf(w, "%s\tfailed\t-\n", dir)
+			continue
+		}
+
+		state := "inactive"
+		if mi := mountinfo.Find(mounts, dir); mi != nil && mi.FSType == "overlay" {
+			state = "active"
+		}
+
+		upper, _ := bootreport.OverlayDirs(o.Options)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", dir, state, upper)
+	}
+
+	for _, s := range r.Skipped {
+		fmt.Fprintf(w, "%s\tskipped (%s)\t-\n", s.Dir, s.Reason)
+	}
+
+	return w.Flush()
+}
+
+// persistent returns true if the data filesystem is kept across boots.
+func persistent(r *bootreport.Report) bool {
+	return !r.Bool("volatile") && !r.Bool("disable") && r.String("provider") != "stateless-encrypted"
+}
+
+// overlayDirs returns the directories matchstick overlaid (or attempted to).
+func overlayDirs(r *bootreport.Report) []string {
+	var dirs []string
+
+	seen := make(map[string]bool)
+	for _, m := range r.Mounts {
+		dir := r.Path(m.Target)
+		if m.FSType != "overlay" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/cmdline"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/virt"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"github.com/spf13/pflag"
+)
+
+// runningInContainer returns true if the process is running in a container,
+// unless overridden with the container option.
+func runningInContainer(opts *config.Options, fs *pflag.FlagSet) bool {
+	override := containerOverride(opts, fs)
+
+	switch strings.ToLower(override) {
+	case "true", "yes", "1", "on":
+		slog.Debug("Forcing container mode", slog.String("container", override))
+		return true
+	case "false", "no", "0", "off":
+		slog.Debug("Forcing bare metal mode", slog.String("container", override))
+		return false
+	case "", "auto":
+	default:
+		slog.Warn("Invalid container override, detecting instead", slog.String("container", override))
+	}
+
+	reason, container := virt.DetectContainer("/", os.Environ())
+	slog.Debug("Detected container", slog.Bool("container", container), slog.String("reason", reason))
+
+	return container
+}
+
+// containerOverride returns the container option, from (in increasing order
+// of precedence) the kernel command line, environment and flags. This is
+// needed before the options are decoded, as it determines where they are
+// decoded from.
+func containerOverride(opts *config.Options, fs *pflag.FlagSet) string {
+	if fs.Changed("container") {
+		return opts.Container
+	}
+
+	var override config.Options
+
+	// Where the kernel command line isn't trusted, it mustn't be able to skip
+	// lockdown (the option will be treated as a violation instead).
+	if _, err := os.Stat(trustedConfigPath); err != nil {
+		// /proc may be missing (if it couldn't be mounted).
+		if cl := cmdline.NewCmdLine(); cl.Err == nil {
+			params := make(map[string][]string)
+			for _, p := range cl.Params {
+				params[p.Key] = append(params[p.Key], p.Value)
+			}
+
+			if err := config.DecodeMulti(&override, params); err != nil {
+				slog.Debug("Failed to decode command line", slog.Any("error", fmt.Errorf("container override: %w", err)))
+			}
+		}
+	}
+
+	if err := config.Decode(&override, config.EnvironAsMap(os.Environ())); err != nil {
+		slog.Debug("Failed to decode environment variables", slog.Any("error", fmt.Errorf("container override: %w", err)))
+	}
+
+	return override.Container
+}
+
+// containerPlan computes the plan within a container, where the data
+// filesystem (unless volatile) is expected to be provided by the container
+// runtime (eg. as a volume) at the mount point.
+func containerPlan(opts *config.Options, args []string) (*plan.Plan, error) {
+	popts := *opts
+	if !popts.Volatile {
+		popts.Provider = plan.ProviderExisting
+	}
+
+	return plan.New(&popts, args)
+}
+
+// containerOverlays sets up the overlays within a container (eg. a system
+// container), in whichever mode the container's privileges allow. It returns
+// the overlays that were skipped.
+func containerOverlays(tracker *stage.Tracker, opts *config.Options, args []string) []bootreport.Skipped {
+	mode, err := overlay.ParseMode(opts.OverlayMode)
+	if err != nil {
+		degrade("Invalid overlay mode", slog.Any("error", err))
+		return nil
+	}
+
+	p, err := containerPlan(opts, args)
+	if err != nil {
+		degrade("Failed to compute plan", slog.Any("error", err))
+		return nil
+	}
+
+	var skipped []bootreport.Skipped
+	for _, dir := range p.Skipped {
+		skipped = append(skipped, bootreport.Skipped{Dir: dir, Reason: "directory does not exist"})
+	}
+
+	if err := sys.MkdirAll(opts.Mount, 0o755); err != nil {
+		degrade("Failed to create data mount", slog.Any("error", err))
+		return skipped
+	}
+
+	if p.Data != nil {
+		// Without privileges, a directory in the container's own (writable)
+		// filesystem is just as volatile.
+		if err := sys.Mount(p.Data.Source, p.Data.Target, p.Data.FSType, p.Data.Flags, p.Data.Data); err != nil {
+			slog.Warn("Failed to mount volatile data mount, using a directory instead", slog.Any("error", err))
+		}
+
+		if err := mounter.Chattr(sys, p.Data.Target, p.Data.Attrs); err != nil {
+			slog.Warn("Failed to set data mount attributes", slog.Any("error", err))
+		}
+	}
+
+	if mode == overlay.Auto {
+		err := tracker.Run(context.Background(), "overlay-probe", 0, func(ctx context.Context) (err error) {
+			mode, err = overlay.Probe(sys, filepath.Join(opts.Mount, ".matchstick-probe"), opts.FuseOverlayfs)
+			return err
+		})
+		if err != nil {
+			degrade("Overlays are unavailable in this container", slog.Any("error", err))
+			return skipped
+		}
+	}
+
+	slog.Info("Mounting overlays in container", slog.String("mode", string(mode)))
+
+	return append(skipped, mountOverlays(context.Background(), tracker, opts, p, mode)...)
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/immutos/matchstick/internal/failure"
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/internal/fstools"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/salvage"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/storage"
+	"golang.org/x/sys/unix"
+)
+
+// errDataCorrupt is wrapped by the error of a data filesystem that can't be
+// mounted, even after being checked (and repaired).
+var errDataCorrupt = errors.New("data filesystem is corrupt")
+
+// salvageDir is where what can be read from a corrupt data filesystem is
+// kept, while it's recreated.
+const salvageDir = "/run/matchstick/salvage"
+
+// salvageArchiveDir is where (on the recreated data filesystem) the salvaged
+// files are archived.
+const salvageArchiveDir = ".matchstick/salvage"
+
+// dataRecovery is set to the policy applied, if the data filesystem was
+// corrupt.
+var dataRecovery failure.Corrupt
+
+// checkData checks (and repairs) the data filesystem after it failed to
+// mount, mounting it again if it was repaired. If it still can't be mounted
+// the returned error wraps errDataCorrupt, otherwise (eg. if the filesystem
+// is clean, or can't be checked) mountErr is returned.
+func checkData(ctx context.Context, tracker *stage.Tracker, opts *config.Options, prov storage.Provider, spec *storage.Spec, device string, mountErr error) error {
+	slog.Warn("Failed to mount data filesystem, checking it", slog.String("device", device), slog.Any("error", mountErr))
+
+	var result fstools.Result
+	err := tracker.Run(ctx, "data-fsck", opts.PrepareTimeout, func(ctx context.Context) (err error) {
+		result, err = fstools.Fsck(ctx, spec.FSType, device)
+		return err
+	})
+	if err != nil {
+		// The filesystem may be beyond checking (eg. its superblock was
+		// overwritten).
+		if _, probeErr := fsprobe.Probe(device); errors.Is(probeErr, fsprobe.ErrNotFound) {
+			return fmt.Errorf("%w (no filesystem found): %w", errDataCorrupt, mountErr)
+		}
+
+		slog.Warn("Failed to check data filesystem", slog.Any("error", err))
+		return mountErr
+	}
+
+	slog.Info("Checked data filesystem", slog.String("result", result.String()))
+
+	switch result {
+	case fstools.Clean:
+		// The filesystem isn't to blame.
+		return mountErr
+	case fstools.Repaired:
+		if mountErr = prov.Mount(ctx, spec, device); mountErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %w", errDataCorrupt, mountErr)
+}
+
+// recoverData applies the corrupt data filesystem policy, returning the data
+// device once a data filesystem is mounted (or err, if the policy is to
+// handle it like any other failure).
+func recoverData(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan, device string, err error) (string, error) {
+	policy, _ := failure.ParseCorrupt(opts.DataOnCorrupt)
+
+	switch policy {
+	case failure.CorruptVolatile:
+		slog.Warn("Using volatile data mount for this boot, as the data filesystem is corrupt", slog.Any("error", err))
+
+		dataRecovery = policy
+		useVolatile(opts, p)
+
+		return mountData(ctx, tracker, opts, p)
+	case failure.CorruptReformat:
+		slog.Warn("Reformatting the data filesystem, as it is corrupt", slog.String("device", device), slog.Any("error", err))
+
+		dataRecovery = policy
+
+		return reformatData(ctx, tracker, opts, p, device)
+	default:
+		return device, err
+	}
+}
+
+// useVolatile switches to a volatile data mount (a tmpfs) for this boot.
+func useVolatile(opts *config.Options, p *plan.Plan) {
+	opts.Volatile = true
+	setFailureOptions(opts)
+
+	p.Provider = "tmpfs"
+	p.Data = &plan.Mount{
+		Source:      "tmpfs",
+		Target:      p.Data.Target,
+		FSType:      "tmpfs",
+		Flags:       p.Data.Flags,
+		Propagation: p.Data.Propagation,
+		Attrs:       p.Data.Attrs,
+	}
+}
+
+// reformatData copies what can be read from the corrupt data filesystem to
+// memory, recreates it, and mounts it. The salvaged files are then archived
+// on the new data filesystem.
+func reformatData(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan, device string) (string, error) {
+	salvaged, err := salvageData(p.Data.FSType, device)
+	defer func() {
+		if salvaged {
+			if err := sys.Unmount(filepath.Join(salvageDir, "data"), unix.MNT_DETACH); err != nil {
+				slog.Warn("Failed to unmount salvaged files", slog.Any("error", err))
+			}
+		}
+	}()
+	if err != nil {
+		return device, err
+	}
+
+	id := dataIdentity(opts.Data)
+
+	err = tracker.Run(ctx, "data-mkfs", opts.PrepareTimeout, func(ctx context.Context) error {
+		return fstools.Mkfs(ctx, p.Data.FSType, device, id)
+	})
+	if err != nil {
+		return device, fmt.Errorf("failed to recreate data filesystem: %w", err)
+	}
+
+	device, err = mountData(ctx, tracker, opts, p)
+	if err != nil {
+		return device, err
+	}
+
+	if salvaged {
+		archive := filepath.Join(p.Data.Target, salvageArchiveDir, time.Now().UTC().Format("20060102T150405Z"))
+
+		n, err := salvage.Copy(filepath.Join(salvageDir, "data"), archive)
+		if err != nil {
+			slog.Warn("Failed to archive some salvaged files", slog.Any("error", err))
+		}
+
+		slog.Info("Archived salvaged files", slog.String("path", archive), slog.Int("files", n))
+	}
+
+	return device, nil
+}
+
+// dataIdentity returns the identity the data filesystem must be recreated
+// with, so that it's still found (on the next boot) by the tag it's given by.
+// Partition tags (PARTUUID and PARTLABEL) are unaffected by reformatting.
+func dataIdentity(data string) fstools.Identity {
+	var id fstools.Identity
+
+	switch tag, value, _ := plan.DeviceTag(data); tag {
+	case "LABEL":
+		id.Label = value
+	case "UUID":
+		id.UUID = value
+	}
+
+	return id
+}
+
+// salvageData copies what can be read from the corrupt data filesystem to a
+// tmpfs (limited to a quarter of memory), returning true if anything was
+// salvaged (and the tmpfs is mounted). An error is returned if the corrupt
+// filesystem couldn't be unmounted again, as it then mustn't be recreated.
+func salvageData(fstype, device string) (salvaged bool, err error) {
+	src, dst := filepath.Join(salvageDir, "old"), filepath.Join(salvageDir, "data")
+
+	for _, dir := range []string{src, dst} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			slog.Warn("Failed to create salvage directory", slog.Any("error", err))
+			return false, nil
+		}
+	}
+
+	// Replaying the journal would write to the device.
+	var data string
+	switch fstype {
+	case "ext3", "ext4":
+		data = "noload"
+	case "xfs":
+		data = "norecovery"
+	}
+
+	if err := sys.Mount(device, src, fstype, unix.MS_RDONLY, data); err != nil {
+		slog.Warn("Failed to mount data filesystem read-only, nothing can be salvaged", slog.Any("error", err))
+		return false, nil
+	}
+
+	// The corrupt filesystem must never still be mounted when it's recreated,
+	// so it isn't lazily detached (its superblock could be written back over
+	// the new filesystem).
+	defer func() {
+		if uerr := sys.Unmount(src, 0); uerr != nil {
+			err = fmt.Errorf("failed to unmount corrupt data filesystem: %w", uerr)
+		}
+	}()
+
+	if err := sys.Mount("tmpfs", dst, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0700,size=25%"); err != nil {
+		slog.Warn("Failed to mount salvage tmpfs", slog.Any("error", err))
+		return false, nil
+	}
+
+	n, err := salvage.Copy(src, dst)
+	if err != nil {
+		slog.Warn("Failed to salvage some files", slog.Any("error", err))
+	}
+
+	slog.Info("Salvaged files from the data filesystem", slog.Int("files", n))
+
+	return true, nil
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/immutos/matchstick/internal/environ"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// initEnviron returns the environment init is executed with. Variables that
+// set matchstick's options are never inherited (they may carry secrets).
+// Invalid variables are logged and skipped.
+func initEnviron(opts *config.Options) []string {
+	env, err := environ.Build(config.StripOptions(os.Environ()), opts.EnvScrub, opts.Env)
+	if err != nil {
+		slog.Warn("Ignoring invalid environment variables", slog.Any("error", err))
+	}
+
+	slog.Debug("Constructed init environment", slog.Any("env", environ.Redact(env, opts.EnvSecrets)))
+
+	return env
+}
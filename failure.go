@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/immutos/matchstick/internal/emergency"
+	"github.com/immutos/matchstick/internal/failure"
+	"github.com/immutos/matchstick/internal/i18n"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// failureCountName is the name of the file (in the root of the recovery
+// device, if recovery is configured, otherwise the data filesystem) that
+// records the number of consecutive failed boots.
+const failureCountName = ".matchstick-failures"
+
+var (
+	// fatalMu ensures only one failure is handled, even if a timeout fires
+	// concurrently with another failure.
+	fatalMu sync.Mutex
+	// printer localizes operator-facing console messages.
+	printer = i18n.NewPrinter(i18n.DefaultLang)
+	// failureOpts are the options used to handle failures.
+	failureOpts = config.Options{
+		Cmd:         "/lib/systemd/systemd",
+		OnFailure:   string(failure.Shell),
+		RebootDelay: 10 * time.Second,
+	}
+	// failureArgs are the arguments passed through to init when continuing
+	// after a failure.
+	failureArgs []string
+	// lockdown is set if only the trusted config is honored (assumed to be
+	// the case until the options have been decoded), in which case failures
+	// never start an unauthenticated emergency shell.
+	lockdown = lockedDown(false)
+)
+
+// setFailureOptions configures how subsequent failures will be handled.
+func setFailureOptions(opts *config.Options) {
+	printer = i18n.NewPrinter(opts.Lang)
+
+	if _, err := failure.ParsePolicy(opts.OnFailure); err != nil {
+		fatal("Invalid failure policy", slog.Any("error", err))
+	}
+
+	failureOpts = *opts
+}
+
+// degrade handles a failure that the system can boot without (eg. a single
+// overlay). If the failure policy is to continue, it logs the error and
+// returns (so the caller can skip the failed operation), otherwise it behaves
+// like fatal.
+func degrade(msg string, args ...any) {
+	if policy, _ := failure.ParsePolicy(failureOpts.OnFailure); policy != failure.Continue {
+		fatal(msg, args...)
+	}
+
+	slog.Error(msg, args...)
+	slog.Warn("Continuing boot in a degraded state")
+}
+
+// fatal logs the error, prints a (localized) failure summary to the console
+// and applies the failure policy. When not running as PID 1 it simply exits.
+func fatal(msg string, args ...any) {
+	// Never unlocked, as the process will exit (or be replaced).
+	fatalMu.Lock()
+
+	slog.Error(msg, args...)
+
+	printConsole(fmt.Sprintf("\n%s\n%s\n%s\n",
+		printer.Sprintf(i18n.MsgBootFailed),
+		printer.Sprintf(i18n.MsgFailureReason, msg),
+		printer.Sprintf(i18n.MsgSeeKernelLog)))
+
+	if os.Getpid() == 1 {
+		policy, err := failure.ParsePolicy(failureOpts.OnFailure)
+		if err != nil {
+			policy = failure.Shell
+		}
+
+		slog.Debug("Applying failure policy", slog.String("policy", string(policy)))
+
+		// Only init (with the continue policy) should be left to pet the
+		// watchdog, otherwise it may reset the machine from underneath an
+		// emergency shell (or the reboot delay).
+		releaseWatchdog(policy == failure.Continue && failureOpts.WatchdogHandoff)
+
+		switch policy {
+		case failure.Shell:
+			startEmergencyShell(msg)
+		case failure.Reboot:
+			reboot()
+		case failure.Continue:
+			// Never execute an init that failed verification (or hardening).
+			if initUntrusted {
+				startEmergencyShell(msg)
+				break
+			}
+
+			// The failure can't be skipped, so boot the image as-is.
+			slog.Warn("Executing init without overlays", slog.Any("cmd", failureOpts.Cmd))
+
+			err := sys.Exec(failureOpts.Cmd, plan.Argv(&failureOpts, failureArgs), initEnviron(&failureOpts))
+			slog.Error("Failed to exec init", slog.Any("cmd", failureOpts.Cmd), slog.Any("error", err))
+		case failure.Panic:
+		}
+	}
+
+	os.Exit(1)
+}
+
+// stopSetupTimeout stops the global setup timeout (if any) once setup has
+// completed. If it has already fired, fatal is applying the failure policy, so
+// booting mustn't continue.
+func stopSetupTimeout(t *time.Timer) {
+	if t == nil || t.Stop() {
+		return
+	}
+
+	// fatal never returns (or releases the lock).
+	fatalMu.Lock()
+}
+
+// startEmergencyShell runs an emergency shell on the console, and once it
+// exits, re-executes matchstick to retry booting. In lockdown, the shell must
+// require authentication, otherwise the machine is rebooted instead.
+func startEmergencyShell(msg string) {
+	if lockdown {
+		if _, err := emergency.FindShell(true); err != nil {
+			slog.Warn("No authenticated emergency shell available in lockdown, rebooting instead")
+			reboot()
+			return
+		}
+	}
+
+	printConsole(printer.Sprintf(i18n.MsgEmergencyShell))
+
+	env := append(os.Environ(), "MATCHSTICK_ERROR="+msg)
+	if err := emergency.Shell(emergency.DefaultConsole, env, lockdown); err != nil {
+		slog.Error("Emergency shell failed", slog.Any("error", err))
+	}
+
+	printConsole(printer.Sprintf(i18n.MsgRetryingBoot))
+
+	// The retry starts from scratch, so it mustn't stack its mounts on top of
+	// those of the failed attempt.
+	undoMounts()
+
+	if self, err := os.Executable(); err == nil {
+		err = sys.Exec(self, os.Args, os.Environ())
+		slog.Error("Failed to re-execute matchstick", slog.Any("error", err))
+	}
+}
+
+// undoMounts detaches the filesystems mounted so far, most recently mounted
+// first. Once the root has been switched the recorded targets no longer
+// refer to the same mounts, so nothing is undone.
+func undoMounts() {
+	var targets []string
+	for _, op := range sys.Ops() {
+		switch {
+		case op.Err != nil:
+		case op.Kind == "unmount":
+			for i := len(targets) - 1; i >= 0; i-- {
+				if targets[i] == op.Target {
+					targets = slices.Delete(targets, i, i+1)
+					break
+				}
+			}
+		case op.Kind != "mount" || op.Flags&(unix.MS_REMOUNT|unix.MS_SHARED|unix.MS_PRIVATE|unix.MS_SLAVE|unix.MS_UNBINDABLE) != 0:
+		case op.Flags&unix.MS_MOVE != 0:
+			slog.Warn("Not undoing mounts as the root has been switched")
+			return
+		default:
+			targets = append(targets, op.Target)
+		}
+	}
+
+	for i := len(targets) - 1; i >= 0; i-- {
+		if err := sys.Unmount(targets[i], unix.MNT_DETACH); err != nil {
+			slog.Warn("Failed to unmount", slog.String("target", targets[i]), slog.Any("error", err))
+		}
+	}
+}
+
+// reboot reboots the machine (or boots the recovery kernel), after a delay
+// that increases exponentially with the number of consecutive failed boots.
+func reboot() {
+	failures := recordFailure()
+
+	// Remote devices may be able to rescue themselves.
+	bootRecovery(failures)
+
+	delay := failure.Backoff(failures, failureOpts.RebootDelay)
+
+	slog.Info("Rebooting", slog.Int("failures", failures), slog.Duration("delay", delay))
+	printConsole(printer.Sprintf(i18n.MsgRebooting, delay))
+
+	time.Sleep(delay)
+
+	unix.Sync()
+
+	if err := unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART); err != nil {
+		slog.Error("Failed to reboot", slog.Any("error", err))
+	}
+}
+
+// recordFailure increments (and returns) the count of consecutive failed
+// boots. With recovery configured, the count is kept on the recovery device,
+// so that boots failing before the data filesystem is mounted are counted.
+// Otherwise it's kept on the data filesystem (on a best effort basis, as it
+// may not be mounted).
+func recordFailure() int {
+	increment := func(root string) (int, error) {
+		path := filepath.Join(root, failureCountName)
+
+		failures := failure.ReadCount(path) + 1
+		return failures, failure.WriteCount(path, failures)
+	}
+
+	failures := 1
+
+	var err error
+	if failureOpts.RecoveryKernel != "" {
+		err = withRecoveryDevice(&failureOpts, 0, func(root string) (err error) {
+			failures, err = increment(root)
+			return err
+		})
+	} else {
+		failures, err = increment(failureOpts.Mount)
+	}
+	if err != nil {
+		slog.Debug("Failed to record failure count", slog.Any("error", err))
+	}
+
+	return max(failures, 1)
+}
+
+// clearFailureCount resets the consecutive failure count after a successful
+// setup. dataMounted is whether the data filesystem is mounted.
+func clearFailureCount(opts *config.Options, dataMounted bool) {
+	if opts.RecoveryKernel == "" {
+		if dataMounted {
+			if err := os.Remove(filepath.Join(opts.Mount, failureCountName)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Failed to clear failure count", slog.Any("error", err))
+			}
+		}
+
+		return
+	}
+
+	// The recovery device is only written to if there is a count to clear.
+	var failed bool
+	err := withRecoveryDevice(opts, unix.MS_RDONLY, func(root string) error {
+		_, err := os.Stat(filepath.Join(root, failureCountName))
+		failed = err == nil
+		return nil
+	})
+	if err == nil && failed {
+		err = withRecoveryDevice(opts, 0, func(root string) error {
+			return os.Remove(filepath.Join(root, failureCountName))
+		})
+	}
+	if err != nil {
+		slog.Warn("Failed to clear failure count", slog.Any("error", err))
+	}
+}
+
+// printConsole prints a message to the console (or stderr if the console is
+// unavailable).
+func printConsole(msg string) {
+	if f, err := os.OpenFile(emergency.DefaultConsole, os.O_WRONLY|unix.O_NOCTTY, 0); err == nil {
+		fmt.Fprintln(f, msg)
+		_ = f.Close()
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// resolveInit falls back to the first of the fallbacks that exists, if init
+// doesn't, rather than the exec failing (and the kernel panicking).
+func resolveInit(opts *config.Options) {
+	err := plan.Executable(opts.Cmd)
+	if err == nil {
+		return
+	}
+
+	slog.Warn("Init is not executable", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+
+	cmd, err := plan.FindInit(opts.InitFallbacks)
+	if err != nil {
+		fatal("Failed to find init", slog.Any("cmd", opts.Cmd), slog.Any("fallbacks", opts.InitFallbacks), slog.Any("error", err))
+	}
+
+	slog.Warn("Falling back to alternative init", slog.Any("cmd", cmd))
+
+	// The arguments were intended for the configured init.
+	opts.Cmd = cmd
+	opts.CmdArgs = ""
+}
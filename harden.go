@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/immutos/matchstick/internal/harden"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// execInit executes init, with reduced privileges if hardening is configured.
+func execInit(opts *config.Options, argv, env []string) {
+	if !hardeningConfigured(opts) {
+		if err := sys.Exec(opts.Cmd, argv, env); err != nil {
+			fatal("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+		}
+
+		return
+	}
+
+	h, err := prepareHardening(opts)
+	if err != nil {
+		initUntrusted = true
+		fatal("Failed to harden init", slog.Any("error", err))
+	}
+
+	// Once hardened, the failure policy can no longer be applied, so anything
+	// that could make the exec fail is checked first.
+	if err := plan.Executable(opts.Cmd); err != nil {
+		fatal("Init is not executable", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+	}
+
+	argv0p, err := unix.BytePtrFromString(opts.Cmd)
+	if err != nil {
+		fatal("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+	}
+
+	argvp, err := syscall.SlicePtrFromStrings(argv)
+	if err != nil {
+		fatal("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+	}
+
+	envp, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		fatal("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+	}
+
+	slog.Debug("Hardening init", slog.Any("drop_caps", opts.DropCapabilities),
+		slog.Bool("no_new_privs", opts.NoNewPrivs), slog.Any("securebits", opts.Securebits),
+		slog.String("seccomp", opts.Seccomp))
+
+	// The hardening only applies to the current thread.
+	runtime.LockOSThread()
+
+	// The seccomp filter is loaded last, so the failure policy can still be
+	// applied if any of it fails.
+	if err := h.apply(); err != nil {
+		initUntrusted = true
+		fatal("Failed to harden init", slog.Any("error", err))
+	}
+
+	// Nothing else (not even logging) may be called before the exec, as the
+	// filter may not allow it.
+	_, _, _ = unix.RawSyscall(unix.SYS_EXECVE, uintptr(unsafe.Pointer(argv0p)),
+		uintptr(unsafe.Pointer(&argvp[0])), uintptr(unsafe.Pointer(&envp[0])))
+
+	// The filter always allows sync, reboot and exit_group (the capabilities
+	// remain effective until exec). If the reboot fails, exiting panics the
+	// kernel instead.
+	unix.Sync()
+	_ = unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART)
+	os.Exit(1)
+}
+
+// hardeningConfigured returns true if any privilege hardening is configured.
+func hardeningConfigured(opts *config.Options) bool {
+	return len(opts.DropCapabilities) > 0 || opts.NoNewPrivs || len(opts.Securebits) > 0 || opts.Seccomp != ""
+}
+
+// hardening is the parsed privilege hardening configuration.
+type hardening struct {
+	caps       []int
+	bits       int
+	noNewPrivs bool
+	filter     []unix.SockFilter
+}
+
+// prepareHardening parses (and compiles) everything up front, so nothing is
+// applied if the configuration is invalid (and the seccomp filter isn't needed
+// to read the allowlist).
+func prepareHardening(opts *config.Options) (*hardening, error) {
+	caps, err := harden.ParseCapabilities(opts.DropCapabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := harden.ParseSecurebits(opts.Securebits)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter []unix.SockFilter
+	if opts.Seccomp != "" {
+		action, err := harden.ParseAction(opts.SeccompAction)
+		if err != nil {
+			return nil, err
+		}
+
+		names, err := harden.ReadAllowlist(opts.Seccomp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seccomp allowlist: %w", err)
+		}
+
+		if filter, err = harden.Filter(names, action); err != nil {
+			return nil, fmt.Errorf("failed to compile seccomp filter: %w", err)
+		}
+	}
+
+	return &hardening{caps: caps, bits: bits, noNewPrivs: opts.NoNewPrivs, filter: filter}, nil
+}
+
+// apply applies the hardening to the current thread.
+func (h *hardening) apply() error {
+	// Securebits (and the bounding set) can only be changed with CAP_SETPCAP,
+	// which may be about to be dropped (it remains effective until exec).
+	if h.bits != 0 {
+		if err := harden.SetSecurebits(h.bits); err != nil {
+			return fmt.Errorf("failed to set securebits: %w", err)
+		}
+	}
+
+	if err := harden.DropCapabilities(h.caps); err != nil {
+		return err
+	}
+
+	if h.noNewPrivs {
+		if err := harden.SetNoNewPrivs(); err != nil {
+			return fmt.Errorf("failed to set no_new_privs: %w", err)
+		}
+	}
+
+	// Last of all, as the filter may not allow any of the above.
+	if h.filter != nil {
+		if err := harden.LoadFilter(h.filter); err != nil {
+			return fmt.Errorf("failed to load seccomp filter: %w", err)
+		}
+	}
+
+	return nil
+}
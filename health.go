@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/health"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// checkHealth records the boot as pending on the data filesystem (until
+// matchstickctl marks it healthy), first rolling back the upper layers to the
+// last healthy snapshot if too many consecutive boots were never marked
+// healthy. It returns true if the upper layers were rolled back.
+func checkHealth(opts *config.Options, p *plan.Plan) bool {
+	mount := p.Data.Target
+
+	rolledBack := false
+	if pending := health.Pending(mount); pending >= opts.RollbackAfter {
+		slog.Warn("Boot was not marked healthy, rolling back", slog.Int("pending", pending))
+
+		rolledBack = rollback(mount, p)
+	}
+
+	if _, err := health.MarkPending(mount); err != nil {
+		slog.Warn("Failed to record pending boot", slog.Any("error", err))
+	}
+
+	return rolledBack
+}
+
+// rollback restores the upper layers of the planned overlays from the last
+// healthy snapshot.
+func rollback(mount string, p *plan.Plan) bool {
+	if !health.HasSnapshot(mount) {
+		slog.Warn("No healthy snapshot to roll back to")
+		return false
+	}
+
+	for _, o := range p.Overlays {
+		restored, err := health.Restore(mount, o.UpperDir, o.WorkDir)
+		if err != nil {
+			degrade("Failed to roll back overlay", slog.String("dir", o.Dir), slog.Any("error", err))
+			continue
+		}
+
+		if restored {
+			slog.Info("Rolled back overlay", slog.String("dir", o.Dir))
+		}
+	}
+
+	// Start counting again from the snapshot.
+	if err := health.MarkHealthy(mount); err != nil {
+		slog.Warn("Failed to reset pending boot count", slog.Any("error", err))
+	}
+
+	return true
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/hostname"
+	"github.com/immutos/matchstick/internal/netconf"
+	"github.com/immutos/matchstick/internal/provision"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// resolveHostname returns the hostname to assign (if any). The hostname
+// option takes precedence over the provisioning config, which takes
+// precedence over the DHCP lease.
+func resolveHostname(opts *config.Options, provisionConf *provision.Config, lease *netconf.Lease) string {
+	if opts.Hostname != "" {
+		return opts.Hostname
+	}
+
+	if provisionConf != nil && provisionConf.Hostname != "" {
+		return provisionConf.Hostname
+	}
+
+	if lease != nil {
+		return lease.Hostname
+	}
+
+	return ""
+}
+
+// setHostname sets the hostname of the system.
+func setHostname(name string) {
+	if err := hostname.Validate(name); err != nil {
+		degrade("Failed to set hostname", slog.Any("error", err))
+		return
+	}
+
+	slog.Info("Setting hostname", slog.String("hostname", name))
+
+	if err := unix.Sethostname([]byte(name)); err != nil {
+		degrade("Failed to set hostname", slog.Any("error", err))
+	}
+}
+
+// persistHostname writes the hostname to /etc/hostname within root (once
+// /etc has been overlaid), so init doesn't reset it to the image's baked-in
+// name.
+func persistHostname(root, name string) {
+	if hostname.Validate(name) != nil {
+		return
+	}
+
+	path := filepath.Join(root, hostname.DefaultPath)
+	if err := hostname.Write(path, name); err != nil {
+		slog.Warn("Failed to write hostname", slog.String("path", path), slog.Any("error", err))
+	}
+}
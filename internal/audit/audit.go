@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package audit maintains an append-only history of what each boot used (the
+// image, the effective configuration and the mounts performed), on the data
+// filesystem. Records can be hash-chained, so that modifying (or removing) a
+// record is evident.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+)
+
+// DefaultPath is where the audit log is written (relative to the data
+// filesystem).
+const DefaultPath = ".matchstick/audit.log"
+
+// maxRecordSize is the maximum size of a record read back (to chain to it).
+const maxRecordSize = 1 << 20
+
+// Record describes a boot.
+type Record struct {
+	// Time is when the record was written.
+	Time time.Time `json:"time"`
+	// BootID is the kernel's random boot ID.
+	BootID string `json:"boot_id,omitempty"`
+	// Version is the matchstick version.
+	Version string `json:"version,omitempty"`
+	// Image is the identity of the image booted (eg. a verity root hash).
+	Image string `json:"image,omitempty"`
+	// Options is the effective configuration.
+	Options map[string]any `json:"options"`
+	// Devices are the resolved devices (eg. "data" and "root").
+	Devices map[string]string `json:"devices,omitempty"`
+	// Mounts are the mounts performed (or attempted), in order.
+	Mounts []bootreport.Mount `json:"mounts"`
+	// Argv is the argument vector init is executed with.
+	Argv []string `json:"argv"`
+	// Prev is the SHA-256 digest of the previous record (as written), if
+	// records are chained.
+	Prev string `json:"prev,omitempty"`
+}
+
+// Append appends the record to the log at path (creating it if necessary).
+// If chain is set, the record includes the digest of the previous record.
+func Append(path string, rec *Record, chain bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rec.Prev = ""
+	if chain {
+		last, err := lastRecord(f)
+		if err != nil {
+			return err
+		}
+
+		if last != nil {
+			rec.Prev = digest(last)
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Verify checks the chain of records read from r, returning the number of
+// records. Unchained records (eg. from before chaining was enabled) are
+// accepted, but once the chain starts every record must link to its
+// predecessor.
+func Verify(r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxRecordSize)
+
+	var n int
+	var prev []byte
+	var chained bool
+	for sc.Scan() {
+		n++
+
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return n, fmt.Errorf("record %d: invalid record: %w", n, err)
+		}
+
+		if rec.Prev != "" || chained {
+			if prev == nil || rec.Prev != digest(prev) {
+				return n, fmt.Errorf("record %d: does not chain to the previous record", n)
+			}
+
+			chained = true
+		}
+
+		prev = bytes.Clone(sc.Bytes())
+	}
+
+	return n, sc.Err()
+}
+
+// lastRecord returns the last record in f (as written), or nil if f is
+// empty.
+func lastRecord(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := max(info.Size()-maxRecordSize, 0)
+	buf := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	buf = bytes.TrimSuffix(buf, []byte("\n"))
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	i := bytes.LastIndexByte(buf, '\n')
+	if i < 0 && offset > 0 {
+		return nil, errors.New("last record is too large")
+	}
+
+	return buf[i+1:], nil
+}
+
+func digest(record []byte) string {
+	sum := sha256.Sum256(record)
+	return hex.EncodeToString(sum[:])
+}
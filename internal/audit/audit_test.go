@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audit_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/audit"
+	"github.com/immutos/matchstick/internal/bootreport"
+)
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), audit.DefaultPath)
+
+	for i := 0; i < 3; i++ {
+		rec := &audit.Record{
+			Time:    time.Unix(int64(i), 0).UTC(),
+			Image:   "roothash=abc",
+			Options: map[string]any{"mount": "/mnt/data"},
+			Mounts:  []bootreport.Mount{{Source: "/dev/vda2", Target: "/mnt/data", FSType: "ext4"}},
+			Argv:    []string{"/sbin/init"},
+		}
+
+		if err := audit.Append(path, rec, true); err != nil {
+			t.Fatalf("failed to append record: %v", err)
+		}
+
+		if (i == 0) != (rec.Prev == "") {
+			t.Errorf("record %d: unexpected previous record digest %q", i, rec.Prev)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := audit.Verify(bytes.NewReader(data))
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 verified records, got %d: %v", n, err)
+	}
+
+	// Tampering with a record breaks the chain.
+	tampered := strings.Replace(string(data), "/dev/vda2", "/dev/vda3", 1)
+	if n, err := audit.Verify(strings.NewReader(tampered)); err == nil || n != 2 {
+		t.Errorf("expected the second record to fail verification, got %d: %v", n, err)
+	}
+
+	// As does removing one.
+	lines := strings.SplitAfter(string(data), "\n")
+	if _, err := audit.Verify(strings.NewReader(lines[0] + lines[2])); err == nil {
+		t.Error("expected a removed record to fail verification")
+	}
+}
+
+func TestAppendUnchained(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	for i := 0; i < 2; i++ {
+		if err := audit.Append(path, &audit.Record{Time: time.Unix(int64(i), 0)}, false); err != nil {
+			t.Fatalf("failed to append record: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if n, err := audit.Verify(f); err != nil || n != 2 {
+		t.Errorf("expected 2 verified records, got %d: %v", n, err)
+	}
+}
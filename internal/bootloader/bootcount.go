@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bootloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// DefaultEFIVarsDir is where the EFI variables filesystem is mounted.
+const DefaultEFIVarsDir = "/sys/firmware/efi/efivars"
+
+// loaderBootCountPathVar is the EFI variable in which systemd-boot records
+// the path (on the ESP) of the boot loader entry being counted.
+const loaderBootCountPathVar = "LoaderBootCountPath-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+
+// LoaderBootCountPath returns the path (relative to the ESP) of the boot
+// loader entry systemd-boot is counting boot attempts of. It returns an
+// empty path if boot counting isn't in use.
+func LoaderBootCountPath(efivarsDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(efivarsDir, loaderBootCountPathVar))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	// The first four bytes are the variable's attributes, followed by a NUL
+	// terminated UTF-16LE string.
+	if len(data) < 4 || len(data)%2 != 0 {
+		return "", errors.New("malformed EFI variable")
+	}
+
+	data = data[4:]
+
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0 {
+			break
+		}
+
+		u = append(u, c)
+	}
+
+	return strings.ReplaceAll(string(utf16.Decode(u)), `\`, "/"), nil
+}
+
+// bootCounterRe matches the boot counters of a boot loader entry file name,
+// eg. "linux+2-1.conf" (two attempts left, one attempt made).
+var bootCounterRe = regexp.MustCompile(`^(.+?)\+\d+(-\d+)?(\.conf|\.efi)$`)
+
+// Bless marks the boot loader entry at path (with boot counters in its name)
+// as good, by removing the counters from its name.
+func Bless(path string) (string, error) {
+	m := bootCounterRe.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return "", fmt.Errorf("%s has no boot counters", path)
+	}
+
+	blessed := filepath.Join(filepath.Dir(path), m[1]+m[3])
+	if err := os.Rename(path, blessed); err != nil {
+		return "", err
+	}
+
+	return blessed, nil
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bootloader_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/immutos/matchstick/internal/bootloader"
+)
+
+func TestGrubenv(t *testing.T) {
+	data := "# GRUB Environment Block\nsaved_entry=a\nmulti=line\\\none\\\\two\n"
+	data += strings.Repeat("#", 1024-len(data))
+
+	env, err := bootloader.ParseGrubenv([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := env.Get("saved_entry"); v != "a" {
+		t.Errorf("saved_entry = %q, want a", v)
+	}
+
+	if v, _ := env.Get("multi"); v != "line\none\\two" {
+		t.Errorf("multi = %q", v)
+	}
+
+	env.Set("boot_success", "0")
+
+	encoded, err := env.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(encoded) != 1024 {
+		t.Errorf("expected the block size to be preserved, got %d bytes", len(encoded))
+	}
+
+	if !strings.HasPrefix(string(encoded), data[:strings.Index(data, "#####")]+"boot_success=0\n") {
+		t.Errorf("unexpected encoding: %q", encoded)
+	}
+
+	path := filepath.Join(t.TempDir(), "grubenv")
+	if err := env.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = bootloader.ReadGrubenv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := env.Get("boot_success"); v != "0" {
+		t.Errorf("boot_success = %q, want 0", v)
+	}
+
+	env.Set("large", strings.Repeat("x", 1024))
+	if _, err := env.Bytes(); err == nil {
+		t.Error("expected an error when the block overflows")
+	}
+}
+
+func TestParseGrubenvInvalid(t *testing.T) {
+	if _, err := bootloader.ParseGrubenv([]byte("saved_entry=a\n")); err == nil {
+		t.Error("expected an error for a missing header")
+	}
+}
+
+func TestLoaderBootCountPath(t *testing.T) {
+	dir := t.TempDir()
+
+	if path, err := bootloader.LoaderBootCountPath(dir); err != nil || path != "" {
+		t.Errorf("expected no path without the variable, got %q (%v)", path, err)
+	}
+
+	data := []byte{0x06, 0, 0, 0}
+	for _, c := range utf16.Encode([]rune(`\loader\entries\linux+2-1.conf`)) {
+		data = binary.LittleEndian.AppendUint16(data, c)
+	}
+	data = append(data, 0, 0)
+
+	if err := os.WriteFile(filepath.Join(dir, "LoaderBootCountPath-4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := bootloader.LoaderBootCountPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "/loader/entries/linux+2-1.conf"; path != want {
+		t.Errorf("LoaderBootCountPath() = %q, want %q", path, want)
+	}
+}
+
+func TestBless(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "linux+2-1.conf")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	blessed, err := bootloader.Bless(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "linux.conf"); blessed != want {
+		t.Errorf("Bless() = %q, want %q", blessed, want)
+	}
+
+	if _, err := os.Stat(blessed); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := bootloader.Bless(blessed); err == nil {
+		t.Error("expected an error for an entry without boot counters")
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, s := range []string{"", "none", "grub", "systemd-boot"} {
+		if _, err := bootloader.ParseKind(s); err != nil {
+			t.Errorf("ParseKind(%q): %v", s, err)
+		}
+	}
+
+	if _, err := bootloader.ParseKind("lilo"); err == nil {
+		t.Error("expected error for unknown bootloader")
+	}
+
+	path := filepath.Join(t.TempDir(), "run", "bootloader.json")
+
+	want := bootloader.State{Bootloader: bootloader.GRUB, Device: "/dev/vda1", FSType: "vfat", Path: "/grub/grubenv"}
+	if err := want.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := bootloader.ReadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got != want {
+		t.Errorf("ReadState() = %+v, want %+v", *got, want)
+	}
+}
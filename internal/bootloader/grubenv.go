@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package bootloader integrates with the boot counting (and A/B fallback)
+// machinery of bootloaders, so they can tell whether a boot succeeded.
+package bootloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// grubenvHeader is the first line of a GRUB environment block.
+const grubenvHeader = "# GRUB Environment Block\n"
+
+// grubenvSize is the size of a newly created GRUB environment block.
+const grubenvSize = 1024
+
+// Grubenv is a GRUB environment block (eg. /boot/grub/grubenv).
+type Grubenv struct {
+	keys   []string
+	values map[string]string
+	size   int
+}
+
+// ReadGrubenv reads a GRUB environment block.
+func ReadGrubenv(path string) (*Grubenv, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseGrubenv(data)
+}
+
+// ParseGrubenv parses a GRUB environment block. Backslashes escape
+// backslashes and newlines in values.
+func ParseGrubenv(data []byte) (*Grubenv, error) {
+	if !bytes.HasPrefix(data, []byte(grubenvHeader)) {
+		return nil, errors.New("not a GRUB environment block")
+	}
+
+	env := &Grubenv{values: make(map[string]string), size: len(data)}
+
+	rest := data[len(grubenvHeader):]
+	for len(rest) > 0 {
+		// Padding (and comments).
+		if rest[0] == '#' {
+			if i := bytes.IndexByte(rest, '\n'); i != -1 {
+				rest = rest[i+1:]
+				continue
+			}
+
+			break
+		}
+
+		var line []byte
+		for len(rest) > 0 && rest[0] != '\n' {
+			if rest[0] == '\\' && len(rest) > 1 {
+				rest = rest[1:]
+			}
+
+			line = append(line, rest[0])
+			rest = rest[1:]
+		}
+
+		if len(rest) > 0 {
+			rest = rest[1:]
+		}
+
+		key, value, ok := strings.Cut(string(line), "=")
+		if !ok {
+			continue
+		}
+
+		env.Set(key, value)
+	}
+
+	return env, nil
+}
+
+// Get returns the value of a variable.
+func (e *Grubenv) Get(key string) (string, bool) {
+	value, ok := e.values[key]
+	return value, ok
+}
+
+// Set sets the value of a variable.
+func (e *Grubenv) Set(key, value string) {
+	if _, ok := e.values[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+
+	e.values[key] = value
+}
+
+// Bytes returns the encoded environment block, padded to its original size
+// (GRUB writes to the block in place, so its size must not change).
+func (e *Grubenv) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(grubenvHeader)
+
+	escaper := strings.NewReplacer(`\`, `\\`, "\n", "\\\n")
+	for _, key := range e.keys {
+		buf.WriteString(key + "=" + escaper.Replace(e.values[key]) + "\n")
+	}
+
+	size := e.size
+	if size == 0 {
+		size = grubenvSize
+	}
+
+	if buf.Len() > size {
+		return nil, fmt.Errorf("environment block exceeds %d bytes", size)
+	}
+
+	buf.Write(bytes.Repeat([]byte{'#'}, size-buf.Len()))
+
+	return buf.Bytes(), nil
+}
+
+// Write atomically writes the environment block to path.
+func (e *Grubenv) Write(path string) error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".grubenv-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
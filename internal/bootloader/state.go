@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bootloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kind identifies a bootloader.
+type Kind string
+
+const (
+	// None disables bootloader integration.
+	None Kind = ""
+	// GRUB records boot attempts in a GRUB environment block.
+	GRUB Kind = "grub"
+	// SystemdBoot uses systemd-boot's boot counting.
+	SystemdBoot Kind = "systemd-boot"
+)
+
+// ParseKind parses a bootloader name, an empty string (or "none") selects
+// None.
+func ParseKind(s string) (Kind, error) {
+	switch k := Kind(strings.ToLower(s)); k {
+	case None, GRUB, SystemdBoot:
+		return k, nil
+	case "none":
+		return None, nil
+	}
+
+	return "", fmt.Errorf("unknown bootloader %q", s)
+}
+
+// DefaultStatePath is where the boot attempt is recorded for marking the boot
+// as successful later.
+const DefaultStatePath = "/run/matchstick/bootloader.json"
+
+// State records a boot attempt.
+type State struct {
+	// Bootloader is the bootloader that counted the attempt.
+	Bootloader Kind `json:"bootloader"`
+	// Device is the device holding Path (if empty, Path is relative to the
+	// root filesystem).
+	Device string `json:"device,omitempty"`
+	// FSType is the filesystem type of Device.
+	FSType string `json:"fstype,omitempty"`
+	// Path is the GRUB environment block, or the systemd-boot entry being
+	// counted.
+	Path string `json:"path"`
+}
+
+// ReadState reads a recorded boot attempt.
+func ReadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	return &s, nil
+}
+
+// Write records the boot attempt at path.
+func (s *State) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package bootreport describes what matchstick did at boot (the effective
+// configuration, the resolved devices, every mount performed and the stage
+// timings) as JSON, so that fleet monitoring agents and support bundles can
+// capture exactly what happened.
+package bootreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/trace"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// DefaultPath is where the boot report is written.
+const DefaultPath = "/run/matchstick/boot.json"
+
+// Report describes the boot setup.
+type Report struct {
+	// Container is set if matchstick was running in a container.
+	Container bool `json:"container"`
+	// Options is the effective configuration.
+	Options map[string]any `json:"options"`
+	// Devices are the resolved devices (eg. "data" and "root").
+	Devices map[string]string `json:"devices,omitempty"`
+	// Mounts are the mounts performed (or attempted), in order.
+	Mounts []Mount `json:"mounts"`
+	// Skipped are the configured directories that weren't overlaid.
+	Skipped []Skipped `json:"skipped,omitempty"`
+	// RolledBack is set if the upper layers were rolled back to the last
+	// healthy snapshot.
+	RolledBack bool `json:"rolled_back,omitempty"`
+	// DataRecovery is the policy applied (eg. "reformat") if the data
+	// filesystem was corrupt.
+	DataRecovery string `json:"data_recovery,omitempty"`
+	// Argv is the argument vector init is executed with.
+	Argv []string `json:"argv"`
+	// Timings are the stage timings.
+	Timings *stage.Report `json:"timings,omitempty"`
+}
+
+// Mount is a mount performed (or attempted).
+type Mount struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	FSType  string `json:"fstype"`
+	Flags   string `json:"flags,omitempty"`
+	Options string `json:"options,omitempty"`
+	// Error is set if the mount failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Skipped is a configured directory that wasn't overlaid.
+type Skipped struct {
+	Dir    string `json:"dir"`
+	Reason string `json:"reason"`
+}
+
+// Mounts returns the mounts among the recorded operations.
+func Mounts(ops []mounter.Op) []Mount {
+	mounts := []Mount{}
+	for _, op := range ops {
+		if op.Kind != "mount" {
+			continue
+		}
+
+		m := Mount{
+			Source:  op.Source,
+			Target:  op.Target,
+			FSType:  op.FSType,
+			Options: op.Data,
+		}
+
+		if op.Flags != 0 {
+			m.Flags = trace.MountFlags(op.Flags)
+		}
+
+		if op.Err != nil {
+			m.Error = op.Err.Error()
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts
+}
+
+// Read reads a report written by Write.
+func Read(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid boot report: %w", err)
+	}
+
+	return &r, nil
+}
+
+// String returns the value of a string option.
+func (r *Report) String(name string) string {
+	s, _ := r.Options[name].(string)
+	return s
+}
+
+// Bool returns the value of a boolean option.
+func (r *Report) Bool(name string) bool {
+	b, _ := r.Options[name].(bool)
+	return b
+}
+
+// Int returns the value of an integer option.
+func (r *Report) Int(name string) int {
+	// Numbers are decoded from JSON as float64.
+	switch v := r.Options[name].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// Duration returns the value of a duration option.
+func (r *Report) Duration(name string) time.Duration {
+	return time.Duration(r.Int(name))
+}
+
+// Path returns where a path recorded in the report (eg. a mount target) is
+// found after switching to the real root filesystem (when booted from an
+// initramfs).
+func (r *Report) Path(path string) string {
+	newRoot := r.String("new_root")
+	if r.String("root") == "" || newRoot == "" || !strings.HasPrefix(path, newRoot) {
+		return path
+	}
+
+	if rel := strings.TrimPrefix(path, newRoot); rel == "" || rel[0] == '/' {
+		return filepath.Join("/", rel)
+	}
+
+	return path
+}
+
+// Overlay returns the (successful) overlay mount on dir, with its paths
+// translated with Path (nil if dir wasn't overlaid).
+func (r *Report) Overlay(dir string) *Mount {
+	for i := len(r.Mounts) - 1; i >= 0; i-- {
+		m := r.Mounts[i]
+		if m.FSType != "overlay" || m.Error != "" || r.Path(m.Target) != filepath.Clean(dir) {
+			continue
+		}
+
+		m.Target = r.Path(m.Target)
+
+		opts := strings.Split(m.Options, ",")
+		for j, opt := range opts {
+			if key, value, ok := strings.Cut(opt, "="); ok && (key == "lowerdir" || key == "upperdir" || key == "workdir") {
+				opts[j] = key + "=" + r.Path(value)
+			}
+		}
+		m.Options = strings.Join(opts, ",")
+
+		return &m
+	}
+
+	return nil
+}
+
+// Propagation returns the propagation type (as formatted mount flags, eg.
+// "MS_SHARED") last set on the mount on target, with its path translated with
+// Path, or an empty string if it was left as the kernel set it.
+func (r *Report) Propagation(target string) string {
+	for i := len(r.Mounts) - 1; i >= 0; i-- {
+		m := r.Mounts[i]
+		if m.Source == "" && m.FSType == "" && m.Error == "" && r.Path(m.Target) == filepath.Clean(target) {
+			return m.Flags
+		}
+	}
+
+	return ""
+}
+
+// OverlayDirs returns the upper and work directories in overlay mount
+// options.
+func OverlayDirs(options string) (upperDir, workDir string) {
+	for _, opt := range strings.Split(options, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "upperdir":
+			upperDir = value
+		case "workdir":
+			workDir = value
+		}
+	}
+
+	return upperDir, workDir
+}
+
+// Write atomically writes the report as JSON to path, only readable by root
+// (as it describes the configuration).
+func (r *Report) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
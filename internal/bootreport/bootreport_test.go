@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bootreport_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+func TestReport(t *testing.T) {
+	m := mounter.NewRecorder(mounter.NewFake("/mnt/data", "/etc"))
+
+	_ = m.Mount("/dev/vda2", "/mnt/data", "ext4", unix.MS_NOSUID, "")
+	_ = m.Mount("overlay", "/srv", "overlay", 0, "lowerdir=/srv")
+	_ = m.Exec("/sbin/init", []string{"/sbin/init"}, nil)
+
+	r := &bootreport.Report{
+		Options: map[string]any{"volatile": false, "rollback_after": 3, "trim_interval": time.Hour},
+		Devices: map[string]string{"data": "/dev/vda2"},
+		Mounts:  bootreport.Mounts(m.Ops()),
+		Skipped: []bootreport.Skipped{{Dir: "/srv", Reason: "no such file or directory"}},
+		Argv:    []string{"/sbin/init"},
+	}
+
+	want := []bootreport.Mount{
+		{Source: "/dev/vda2", Target: "/mnt/data", FSType: "ext4", Flags: "MS_NOSUID"},
+		{Source: "overlay", Target: "/srv", FSType: "overlay", Options: "lowerdir=/srv", Error: r.Mounts[1].Error},
+	}
+
+	if !reflect.DeepEqual(r.Mounts, want) {
+		t.Errorf("Mounts = %+v, want %+v", r.Mounts, want)
+	}
+
+	if r.Mounts[1].Error == "" {
+		t.Error("expected the failed mount to be reported")
+	}
+
+	if p := r.Propagation("/mnt/data"); p != "" {
+		t.Errorf("Propagation(/mnt/data) = %q, want none", p)
+	}
+
+	path := filepath.Join(t.TempDir(), "run/matchstick/boot.json")
+	if err := r.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got bootreport.Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got.Mounts, r.Mounts) || got.Devices["data"] != "/dev/vda2" {
+		t.Errorf("unexpected report: %s", data)
+	}
+
+	// Propagation is set on an existing mount.
+	_ = m.Mount("", "/mnt/data", "", unix.MS_SHARED|unix.MS_REC, "")
+	r.Mounts = bootreport.Mounts(m.Ops())
+
+	if p := r.Propagation("/mnt/data"); p != "MS_REC|MS_SHARED" {
+		t.Errorf("Propagation(/mnt/data) = %q, want MS_REC|MS_SHARED", p)
+	}
+
+	// Numbers are decoded as float64.
+	if n := got.Int("rollback_after"); n != 3 {
+		t.Errorf("Int(rollback_after) = %d, want 3", n)
+	}
+
+	if d := got.Duration("trim_interval"); d != time.Hour {
+		t.Errorf("Duration(trim_interval) = %s, want 1h", d)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected the temporary file to be renamed")
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package check validates a configuration, and the environment it will be
+// applied to, without modifying the system.
+package check
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/internal/modules"
+	"github.com/immutos/matchstick/internal/netconf"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/provider"
+)
+
+// DefaultFilesystemsPath is the path of the kernel's list of supported filesystems.
+const DefaultFilesystemsPath = "/proc/filesystems"
+
+// Result is the outcome of a single check.
+type Result struct {
+	// Name describes what was checked.
+	Name string
+	// Err is non-nil if the check failed.
+	Err error
+	// Warning indicates a failure that won't prevent booting.
+	Warning bool
+}
+
+// Report is the collected results of all checks.
+type Report struct {
+	Results []Result
+}
+
+// Failed returns true if any (non-warning) check failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Err != nil && !res.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes a human readable report to w.
+func (r *Report) Print(w io.Writer) {
+	for _, res := range r.Results {
+		switch {
+		case res.Err == nil:
+			fmt.Fprintf(w, "ok    %s\n", res.Name)
+		case res.Warning:
+			fmt.Fprintf(w, "warn  %s: %v\n", res.Name, res.Err)
+		default:
+			fmt.Fprintf(w, "FAIL  %s: %v\n", res.Name, res.Err)
+		}
+	}
+}
+
+func (r *Report) add(name string, err error) {
+	r.Results = append(r.Results, Result{Name: name, Err: err})
+}
+
+func (r *Report) warn(name string, err error) {
+	r.Results = append(r.Results, Result{Name: name, Err: err, Warning: true})
+}
+
+// Checker validates a configuration.
+type Checker struct {
+	// FilesystemsPath is the path of the kernel's list of supported filesystems.
+	FilesystemsPath string
+	// ModulesDir is the module directory of the running kernel. Filesystems
+	// that aren't supported yet pass if their module is found in it, as
+	// matchstick loads them at boot.
+	ModulesDir string
+}
+
+// Run checks the given options.
+func (c *Checker) Run(opts *config.Options) *Report {
+	var r Report
+
+	p, err := plan.New(opts, nil)
+	r.add("configuration is valid", err)
+	if err != nil {
+		return &r
+	}
+
+	filesystems, err := readFilesystems(c.FilesystemsPath)
+	r.add("supported filesystems are readable", err)
+
+	switch p.Provider {
+	case "block":
+		err := checkBlockDevice(p.Data.Source)
+		r.add(fmt.Sprintf("data device %s exists", opts.Data), err)
+
+		if err == nil && p.Data.FSType == "" {
+			fstype, err := fsprobe.Probe(p.Data.Source)
+			r.add("data filesystem type can be detected", err)
+			p.Data.FSType = fstype
+		}
+	case "tmpfs", plan.ProviderExisting:
+	case "nfs":
+		_, _, err := provider.ParseNFSSource(opts.Data)
+		r.add(fmt.Sprintf("NFS source %s is valid", opts.Data), err)
+		r.add("network is configured", checkNetworkConfigured(opts.IP))
+	default:
+		_, err := provider.Get(p.Provider, opts.ProvidersDir)
+		r.add(fmt.Sprintf("provider %s exists", p.Provider), err)
+	}
+
+	if filesystems != nil && p.Data != nil && p.Data.FSType != "" {
+		r.add(fmt.Sprintf("kernel supports %s filesystem", p.Data.FSType), c.checkFilesystem(filesystems, p.Data.FSType, "fs-"+p.Data.FSType))
+
+		if len(p.Overlays) > 0 {
+			r.add("kernel supports overlay filesystem", c.checkFilesystem(filesystems, "overlay", "overlay"))
+		}
+	}
+
+	r.add(fmt.Sprintf("mountpoint %s exists", opts.Mount), checkDir(opts.Mount))
+
+	for _, dir := range p.Skipped {
+		r.warn(fmt.Sprintf("directory %s exists", dir), errors.New("directory does not exist and will not be overlaid"))
+	}
+
+	if err := plan.Executable(opts.Cmd); err != nil && len(opts.InitFallbacks) > 0 {
+		if fallback, ferr := plan.FindInit(opts.InitFallbacks); ferr == nil {
+			r.warn(fmt.Sprintf("init %s is executable", opts.Cmd), fmt.Errorf("%w, falling back to %s", err, fallback))
+		} else {
+			r.add(fmt.Sprintf("init %s is executable", opts.Cmd), err)
+		}
+	} else {
+		r.add(fmt.Sprintf("init %s is executable", opts.Cmd), err)
+	}
+
+	return &r
+}
+
+func checkNetworkConfigured(ip string) error {
+	if conf, err := netconf.ParseIP(ip); err != nil {
+		return err
+	} else if conf == nil {
+		return errors.New("ip is not set, the network must be configured by the kernel")
+	}
+
+	return nil
+}
+
+func readFilesystems(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	filesystems := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form "[nodev]\t<fstype>".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			filesystems[fields[len(fields)-1]] = true
+		}
+	}
+
+	return filesystems, scanner.Err()
+}
+
+// checkFilesystem checks that fstype is supported, or that its module (which
+// matchstick loads at boot) exists.
+func (c *Checker) checkFilesystem(filesystems map[string]bool, fstype, module string) error {
+	if filesystems[fstype] {
+		return nil
+	}
+
+	if c.ModulesDir == "" {
+		return fmt.Errorf("%s is not listed in /proc/filesystems (is the module loaded?)", fstype)
+	}
+
+	if _, err := modules.NewLoader(c.ModulesDir).Resolve(module); err != nil {
+		return fmt.Errorf("%s is not listed in /proc/filesystems, and can't be loaded: %w", fstype, err)
+	}
+
+	return nil
+}
+
+func checkBlockDevice(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("%s is not a block device", path)
+	}
+
+	return nil
+}
+
+func checkDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	return nil
+}
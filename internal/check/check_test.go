@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package check_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/check"
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/plan"
+)
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+
+	filesystems := filepath.Join(root, "filesystems")
+	if err := os.WriteFile(filesystems, []byte("nodev\ttmpfs\nnodev\toverlay\n\text4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mount := filepath.Join(root, "mnt")
+	if err := os.Mkdir(mount, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	init := filepath.Join(root, "init")
+	if err := os.WriteFile(init, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &check.Checker{FilesystemsPath: filesystems}
+
+	t.Run("Valid", func(t *testing.T) {
+		r := c.Run(&config.Options{
+			Volatile: true,
+			Mount:    mount,
+			Dirs:     []string{root, filepath.Join(root, "missing")},
+			Cmd:      init,
+		})
+
+		var sb strings.Builder
+		r.Print(&sb)
+
+		if r.Failed() {
+			t.Fatalf("unexpected failure:\n%s", sb.String())
+		}
+
+		if !strings.Contains(sb.String(), "warn") {
+			t.Errorf("expected warning for missing directory:\n%s", sb.String())
+		}
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		r := c.Run(&config.Options{
+			Provider: plan.ProviderExisting,
+			Mount:    mount,
+			Cmd:      init,
+		})
+
+		if r.Failed() {
+			var sb strings.Builder
+			r.Print(&sb)
+			t.Fatalf("unexpected failure:\n%s", sb.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		r := c.Run(&config.Options{
+			Data:       filepath.Join(root, "nonexistent"),
+			DataFSType: "btrfs",
+			Mount:      mount,
+			Cmd:        filesystems,
+		})
+
+		var failed []string
+		for _, res := range r.Results {
+			if res.Err != nil {
+				failed = append(failed, res.Name)
+			}
+		}
+
+		if len(failed) != 3 {
+			t.Errorf("expected device, filesystem and init checks to fail, got %v", failed)
+		}
+	})
+
+	t.Run("Module", func(t *testing.T) {
+		// overlay isn't supported yet, but its module can be loaded at boot.
+		dir := filepath.Join(root, "modules")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "modules.dep"), []byte("kernel/fs/overlayfs/overlay.ko:\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		tmpfsOnly := filepath.Join(root, "filesystems-tmpfs")
+		if err := os.WriteFile(tmpfsOnly, []byte("nodev\ttmpfs\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		opts := &config.Options{
+			Volatile: true,
+			Mount:    mount,
+			Dirs:     []string{root},
+			Cmd:      init,
+		}
+
+		mc := &check.Checker{FilesystemsPath: tmpfsOnly, ModulesDir: dir}
+		if r := mc.Run(opts); r.Failed() {
+			var sb strings.Builder
+			r.Print(&sb)
+			t.Errorf("unexpected failure:\n%s", sb.String())
+		}
+
+		mc.ModulesDir = t.TempDir()
+		if r := mc.Run(opts); !r.Failed() {
+			t.Error("expected failure for a filesystem without a module")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package clock ensures the system clock is sane early in boot, on machines
+// without a battery-backed RTC the clock otherwise starts at the epoch (which
+// breaks TLS certificate validation, among other things).
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// BuildTime is the time matchstick was built (in seconds since the epoch),
+// set at build time (to the commit timestamp, so builds are reproducible)
+// with:
+//
+//	-ldflags "-X github.com/immutos/matchstick/internal/clock.BuildTime=$(git log -1 --format=%ct)"
+var BuildTime string
+
+// DefaultRTC is the default real-time clock device.
+const DefaultRTC = "/dev/rtc0"
+
+// DefaultEpochFiles are files whose modification time the clock should not be
+// earlier than (the same files systemd uses).
+var DefaultEpochFiles = []string{
+	"/usr/lib/clock-epoch",
+	"/var/lib/systemd/timesync/clock",
+}
+
+// Floor returns the earliest plausible time, the latest of the build time and
+// the modification times of the epoch files.
+func Floor(buildTime string, epochFiles []string) time.Time {
+	var floor time.Time
+
+	if secs, err := strconv.ParseInt(buildTime, 10, 64); err == nil {
+		floor = time.Unix(secs, 0)
+	}
+
+	for _, path := range epochFiles {
+		if st, err := os.Stat(path); err == nil && st.ModTime().After(floor) {
+			floor = st.ModTime()
+		}
+	}
+
+	return floor
+}
+
+// ReadRTC reads the time from a real-time clock (which is assumed to be in
+// UTC).
+func ReadRTC(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	rtc, err := unix.IoctlGetRTCTime(int(f.Fd()))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return time.Date(int(rtc.Year)+1900, time.Month(rtc.Mon+1), int(rtc.Mday),
+		int(rtc.Hour), int(rtc.Min), int(rtc.Sec), 0, time.UTC), nil
+}
+
+// Decide returns the time the clock should be set to (and true), or false if
+// the clock should be left alone. The RTC time is used if it is later than
+// the current time and the floor, otherwise the clock is clamped to the
+// floor.
+func Decide(now, rtc, floor time.Time) (time.Time, bool) {
+	if !rtc.IsZero() && rtc.After(floor) && rtc.Sub(now) > time.Minute {
+		return rtc, true
+	}
+
+	if now.Before(floor) {
+		return floor, true
+	}
+
+	return time.Time{}, false
+}
+
+// Set sets the system clock.
+func Set(t time.Time) error {
+	tv := unix.NsecToTimeval(t.UnixNano())
+	if err := unix.Settimeofday(&tv); err != nil {
+		return errors.Join(errors.New("failed to set the system clock"), err)
+	}
+
+	return nil
+}
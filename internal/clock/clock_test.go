@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clock_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/clock"
+)
+
+func TestFloor(t *testing.T) {
+	build := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	epoch := build.Add(24 * time.Hour)
+
+	path := filepath.Join(t.TempDir(), "clock-epoch")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, epoch, epoch); err != nil {
+		t.Fatal(err)
+	}
+
+	buildTime := strconv.FormatInt(build.Unix(), 10)
+
+	if floor := clock.Floor(buildTime, nil); !floor.Equal(build) {
+		t.Errorf("expected the build time %s, got %s", build, floor)
+	}
+
+	if floor := clock.Floor(buildTime, []string{path, path + "-missing"}); !floor.Equal(epoch) {
+		t.Errorf("expected the epoch file time %s, got %s", epoch, floor)
+	}
+
+	if floor := clock.Floor("", nil); !floor.IsZero() {
+		t.Errorf("expected no floor, got %s", floor)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	floor := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	epoch := time.Unix(0, 0)
+	later := floor.Add(365 * 24 * time.Hour)
+
+	tests := []struct {
+		name      string
+		now, rtc  time.Time
+		expected  time.Time
+		shouldSet bool
+	}{
+		{"rtc", epoch, later, later, true},
+		{"no rtc", epoch, time.Time{}, floor, true},
+		{"rtc before floor", epoch, epoch.Add(time.Hour), floor, true},
+		{"clock already sane", later, later, time.Time{}, false},
+		{"clock ahead of rtc", later, floor.Add(time.Hour), time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := clock.Decide(tt.now, tt.rtc, floor)
+		if ok != tt.shouldSet || !got.Equal(tt.expected) {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", tt.name, tt.expected, tt.shouldSet, got, ok)
+		}
+	}
+}
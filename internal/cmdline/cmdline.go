@@ -22,7 +22,18 @@ import (
 type CmdLine struct {
 	Raw   string
 	AsMap map[string]string
-	Err   error
+	// Params holds every parameter in the order it appeared, so that repeated
+	// parameters can be interpreted (AsMap only holds the last value).
+	Params []Param
+	Err    error
+}
+
+// Param is a single parsed kernel command line parameter.
+type Param struct {
+	// Key is the canonical key, with dashes replaced by underscores.
+	Key string
+	// Value is the dequoted value, or "1" if the parameter has no value.
+	Value string
 }
 
 // NewCmdLine returns a populated CmdLine struct
@@ -43,6 +54,7 @@ func parse(cmdlineReader io.Reader) *CmdLine {
 	// This works because string(nil) is ""
 	line.Raw = strings.TrimRight(string(raw), "\n")
 	line.AsMap = parseToMap(line.Raw)
+	line.Params = parseToParams(line.Raw)
 	return line
 }
 
@@ -53,10 +65,15 @@ func dequote(line string) string {
 
 	quotationMarks := `"'`
 
+	// Like the kernel, only a leading quote (and the matching trailing quote,
+	// if present) is removed. Quotes within the value are retained.
 	var quote byte
 	if strings.ContainsAny(string(line[0]), quotationMarks) {
 		quote = line[0]
-		line = line[1 : len(line)-1]
+		line = line[1:]
+		if len(line) > 0 && line[len(line)-1] == quote {
+			line = line[:len(line)-1]
+		}
 	}
 
 	var context []byte
@@ -88,27 +105,84 @@ func dequote(line string) string {
 	return string(newLine)
 }
 
-func doParse(input string, handler func(flag, key, canonicalKey, value, trimmedValue string)) {
-	lastQuote := rune(0)
-	quotedFieldsCheck := func(c rune) bool {
+// tokenize splits a kernel command line into parameters. Whitespace within
+// quotes (or escaped with a backslash) doesn't split parameters, and quotes
+// escaped with a backslash don't begin or end a quoted section.
+func tokenize(input string) []string {
+	var tokens []string
+	var token strings.Builder
+	var inToken bool
+	var quote rune
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		var escaped bool
+		if c == '\\' && i+1 < len(runes) {
+			next := runes[i+1]
+			if quote != 0 {
+				escaped = next == quote
+			} else {
+				escaped = isQuote(next) || unicode.IsSpace(next)
+			}
+		}
+
 		switch {
-		case c == lastQuote:
-			lastQuote = rune(0)
-			return false
-		case lastQuote != rune(0):
-			return false
-		case unicode.In(c, unicode.Quotation_Mark):
-			lastQuote = c
-			return false
+		case escaped:
+			// Keep escaped quotes intact (they are unescaped by dequote), but
+			// unescape whitespace.
+			if !unicode.IsSpace(runes[i+1]) {
+				token.WriteRune(c)
+			}
+			token.WriteRune(runes[i+1])
+			inToken = true
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			token.WriteRune(c)
+		case isQuote(c):
+			quote = c
+			token.WriteRune(c)
+			inToken = true
+		case unicode.IsSpace(c):
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
 		default:
-			return unicode.IsSpace(c)
+			token.WriteRune(c)
+			inToken = true
 		}
 	}
 
-	for _, flag := range strings.FieldsFunc(string(input), quotedFieldsCheck) {
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens
+}
+
+func isQuote(c rune) bool {
+	return c == '"' || c == '\''
+}
+
+func doParse(input string, handler func(flag, key, canonicalKey, value, trimmedValue string)) {
+	for _, flag := range tokenize(input) {
 		// kernel variables must allow '-' and '_' to be equivalent in variable
 		// names. We will replace dashes with underscores for processing.
 
+		// Like the kernel, a quote may enclose the whole parameter (eg.
+		// "key=value with spaces").
+		if len(flag) > 0 && isQuote(rune(flag[0])) {
+			if split := strings.Index(flag, "="); split > 1 && !strings.ContainsAny(flag[1:split], `"'`) {
+				flag = flag[1:split+1] + flag[:1] + flag[split+1:]
+			}
+		}
+
 		// Split the flag into a key and value, setting value="1" if none
 		split := strings.Index(flag, "=")
 
@@ -144,6 +218,31 @@ func parseToMap(input string) map[string]string {
 	return flagMap
 }
 
+// parseToParams turns a space-separated kernel commandline into an ordered
+// list of parameters
+func parseToParams(input string) []Param {
+	var params []Param
+	doParse(input, func(flag, key, canonicalKey, value, trimmedValue string) {
+		params = append(params, Param{Key: canonicalKey, Value: trimmedValue})
+	})
+
+	return params
+}
+
+// Values returns every value of a (possibly repeated) flag, in order
+func (c *CmdLine) Values(flag string) []string {
+	canonicalFlag := strings.Replace(flag, "-", "_", -1)
+
+	var values []string
+	for _, p := range c.Params {
+		if p.Key == canonicalFlag {
+			values = append(values, p.Value)
+		}
+	}
+
+	return values
+}
+
 // ContainsFlag verifies that the kernel cmdline has a flag set
 func (c *CmdLine) ContainsFlag(flag string) bool {
 	_, present := c.Flag(flag)
@@ -200,3 +200,73 @@ func TestBadRead(t *testing.T) {
 		t.Errorf("parse(&badreader{}): got nil, want %v", io.ErrClosedPipe)
 	}
 }
+
+func TestParseToParams(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []Param
+	}{
+		{
+			name:  "quoted value with spaces",
+			input: `ro matchstick.cmd="/bin/app --name 'my app'" quiet`,
+			want: []Param{
+				{Key: "ro", Value: "1"},
+				{Key: "matchstick.cmd", Value: `/bin/app --name 'my app'`},
+				{Key: "quiet", Value: "1"},
+			},
+		},
+		{
+			name:  "quoted parameter",
+			input: `"matchstick.cmd=/bin/app --verbose"`,
+			want: []Param{
+				{Key: "matchstick.cmd", Value: "/bin/app --verbose"},
+			},
+		},
+		{
+			name:  "escaped quote within quotes",
+			input: `matchstick.cmd="/bin/echo \" hello" ro`,
+			want: []Param{
+				{Key: "matchstick.cmd", Value: `/bin/echo " hello`},
+				{Key: "ro", Value: "1"},
+			},
+		},
+		{
+			name:  "escaped space and comma",
+			input: `matchstick.dirs=/srv/my\ data,/srv/a\,b`,
+			want: []Param{
+				{Key: "matchstick.dirs", Value: `/srv/my data,/srv/a\,b`},
+			},
+		},
+		{
+			name:  "repeated keys",
+			input: `matchstick.dirs=/etc matchstick.dirs=/var console=tty0 console=ttyS0`,
+			want: []Param{
+				{Key: "matchstick.dirs", Value: "/etc"},
+				{Key: "matchstick.dirs", Value: "/var"},
+				{Key: "console", Value: "tty0"},
+				{Key: "console", Value: "ttyS0"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseToParams(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseToParams(%s) = \n%#v, want \n%#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValues(t *testing.T) {
+	c := parse(strings.NewReader("console=tty0 console=ttyS0,115200 ro"))
+
+	if got, want := c.Values("console"), []string{"tty0", "ttyS0,115200"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Values(console) = %v, want %v", got, want)
+	}
+
+	// AsMap retains last-wins semantics.
+	if got, _ := c.Flag("console"); got != "ttyS0,115200" {
+		t.Errorf("Flag(console) = %v, want ttyS0,115200", got)
+	}
+}
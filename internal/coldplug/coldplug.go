@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package coldplug replays the uevents of devices that were detected before
+// anything was listening for them, so that dynamically created device nodes
+// and symlinks (eg. /dev/disk/by-label/...) exist before matchstick resolves
+// the data device.
+package coldplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Mode selects how coldplugging is performed.
+type Mode string
+
+const (
+	// None doesn't coldplug devices.
+	None Mode = "none"
+	// Trigger writes "add" to the uevent file of each device (for any uevent
+	// helper, eg. mdev, to act on).
+	Trigger Mode = "trigger"
+	// Udevd briefly runs systemd-udevd, to trigger and process the uevents.
+	Udevd Mode = "udevd"
+)
+
+// ParseMode parses a coldplug mode, an empty string selects None.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", None:
+		return None, nil
+	case Trigger, Udevd:
+		return Mode(s), nil
+	default:
+		return "", fmt.Errorf("unknown coldplug mode %q", s)
+	}
+}
+
+// DefaultSysDir is where sysfs is mounted.
+const DefaultSysDir = "/sys"
+
+// Subsystems are the device classes that are coldplugged.
+var Subsystems = []string{"block"}
+
+// udevdPaths are the locations searched for systemd-udevd.
+var udevdPaths = []string{
+	"/lib/systemd/systemd-udevd",
+	"/usr/lib/systemd/systemd-udevd",
+	"/sbin/udevd",
+}
+
+// udevadmPaths are the locations searched for udevadm.
+var udevadmPaths = []string{
+	"/bin/udevadm",
+	"/usr/bin/udevadm",
+	"/sbin/udevadm",
+}
+
+// Run coldplugs devices using the given mode.
+func Run(ctx context.Context, mode Mode) error {
+	switch mode {
+	case Trigger:
+		return TriggerUevents(DefaultSysDir, Subsystems...)
+	case Udevd:
+		return RunUdevd(ctx)
+	default:
+		return nil
+	}
+}
+
+// TriggerUevents writes "add" to the uevent file of every device in the
+// given subsystems.
+func TriggerUevents(sysDir string, subsystems ...string) error {
+	var errs []error
+	for _, subsystem := range subsystems {
+		uevents, err := filepath.Glob(filepath.Join(sysDir, "class", subsystem, "*", "uevent"))
+		if err != nil {
+			return err
+		}
+
+		for _, uevent := range uevents {
+			slog.Debug("Triggering uevent", slog.String("path", uevent))
+
+			if err := os.WriteFile(uevent, []byte("add"), 0); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RunUdevd runs systemd-udevd until the coldplug uevents have been processed,
+// and then stops it (init is expected to start it again). The udev database
+// in /run/udev is preserved.
+func RunUdevd(ctx context.Context) error {
+	udevd, err := findExecutable(udevdPaths)
+	if err != nil {
+		return fmt.Errorf("systemd-udevd: %w", err)
+	}
+
+	udevadm, err := findExecutable(udevadmPaths)
+	if err != nil {
+		return fmt.Errorf("udevadm: %w", err)
+	}
+
+	cmd := exec.Command(udevd)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start systemd-udevd: %w", err)
+	}
+
+	defer func() {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		_ = cmd.Wait()
+	}()
+
+	for _, args := range [][]string{
+		{"trigger", "--type=subsystems", "--action=add"},
+		{"trigger", "--type=devices", "--action=add"},
+		{"settle"},
+	} {
+		udevadmCmd := exec.CommandContext(ctx, udevadm, args...)
+		udevadmCmd.Stdout = os.Stdout
+		udevadmCmd.Stderr = os.Stderr
+
+		if err := udevadmCmd.Run(); err != nil {
+			return fmt.Errorf("udevadm %s failed: %w", args[0], err)
+		}
+	}
+
+	return nil
+}
+
+// WaitFor waits until path exists, or the context is done.
+func WaitFor(ctx context.Context, path string) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", path, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func findExecutable(paths []string) (string, error) {
+	for _, path := range paths {
+		if st, err := os.Stat(path); err == nil && st.Mode().IsRegular() && st.Mode()&0o111 != 0 {
+			return path, nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
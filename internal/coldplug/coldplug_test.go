@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coldplug_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/coldplug"
+)
+
+func TestTriggerUevents(t *testing.T) {
+	sysDir := t.TempDir()
+
+	var uevents []string
+	for _, dev := range []string{"vda", "vda1"} {
+		dir := filepath.Join(sysDir, "class", "block", dev)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		uevent := filepath.Join(dir, "uevent")
+		if err := os.WriteFile(uevent, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		uevents = append(uevents, uevent)
+	}
+
+	if err := coldplug.TriggerUevents(sysDir, "block", "net"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uevent := range uevents {
+		data, err := os.ReadFile(uevent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "add" {
+			t.Errorf("expected %s to contain add, got %q", uevent, data)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vda1")
+
+	time.AfterFunc(200*time.Millisecond, func() {
+		_ = os.WriteFile(path, nil, 0o644)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := coldplug.WaitFor(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err := coldplug.WaitFor(ctx, path+"-missing"); err == nil {
+		t.Error("expected waiting for a missing path to time out")
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"strings"
+
+	"github.com/immutos/matchstick/internal/shlex"
+)
+
+// NormalizeCmd splits any arguments off cmd (eg. "/usr/bin/app --verbose"),
+// prepending them to cmd_args, so that cmd is always the path of init.
+func NormalizeCmd(opts *Options) {
+	words := shlex.Argv(opts.Cmd)
+	if len(words) < 2 {
+		return
+	}
+
+	opts.Cmd = words[0]
+	opts.CmdArgs = strings.TrimSpace(quoteArgs(words[1:]) + " " + opts.CmdArgs)
+}
+
+// Args returns the arguments init is executed with (from cmd_args).
+func (opts *Options) Args() []string {
+	return shlex.Argv(opts.CmdArgs)
+}
+
+// quoteArgs quotes args, so they are split back into the same arguments.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
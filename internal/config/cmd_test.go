@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/config"
+)
+
+func TestNormalizeCmd(t *testing.T) {
+	opts := &config.Options{
+		Cmd:     `/usr/bin/app --name "it's mine" --verbose`,
+		CmdArgs: `--config='/etc/app/app config.toml'`,
+	}
+
+	config.NormalizeCmd(opts)
+
+	if opts.Cmd != "/usr/bin/app" {
+		t.Errorf("Cmd = %q, want /usr/bin/app", opts.Cmd)
+	}
+
+	want := []string{"--name", "it's mine", "--verbose", "--config=/etc/app/app config.toml"}
+	if args := opts.Args(); !reflect.DeepEqual(args, want) {
+		t.Errorf("Args() = %q, want %q", args, want)
+	}
+
+	// A bare path is left alone.
+	opts = &config.Options{Cmd: "/lib/systemd/systemd"}
+	config.NormalizeCmd(opts)
+
+	if opts.Cmd != "/lib/systemd/systemd" || opts.Args() != nil {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+}
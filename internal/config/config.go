@@ -0,0 +1,435 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/immutos/matchstick/internal/environ"
+	"github.com/immutos/matchstick/internal/util"
+	"github.com/mitchellh/mapstructure"
+)
+
+type Options struct {
+	// Data is the device to which write operations will be redirected.
+	Data string `cmdline:"data"`
+	// DataFSType is the filesystem type of the data device.
+	DataFSType string `cmdline:"datafstype"`
+	// DataOptions are additional (filesystem specific) mount options for the
+	// data filesystem.
+	DataOptions string `cmdline:"data_options"`
+	// Root is the real root device (when running from an initramfs), it
+	// defaults to the kernel's root= parameter.
+	Root string `cmdline:"root"`
+	// RootFSType is the filesystem type of the real root device, it defaults
+	// to the kernel's rootfstype= parameter.
+	RootFSType string `cmdline:"root_fstype"`
+	// RootOptions are the mount options of the real root device, they
+	// default to the kernel's rootflags= parameter.
+	RootOptions string `cmdline:"root_options"`
+	// NewRoot is where the real root filesystem is mounted before switching
+	// to it.
+	NewRoot string `cmdline:"new_root"`
+	// Provider is the name of the provider used to set up the data filesystem
+	// (defaults to "block", or "tmpfs" if volatile).
+	Provider string `cmdline:"provider"`
+	// ProvidersDir is the directory searched for external providers.
+	ProvidersDir string `cmdline:"providers_dir"`
+	// The mountpoint to be used for the data filesystem.
+	Mount string `cmdline:"mount"`
+	// Dirs is a list of directories to overlay on top of the data filesystem.
+	Dirs []string `cmdline:"dirs"`
+	// OverlayRoot specifies whether the whole root filesystem is overlaid
+	// (rather than just Dirs).
+	OverlayRoot bool `cmdline:"overlay_root"`
+	// DirsFile is the path of a file (within the image) listing the
+	// directories to overlay, and their options. It replaces Dirs.
+	DirsFile string `cmdline:"dirs_file"`
+	// DirOptions holds per-directory options (as read from DirsFile).
+	DirOptions map[string]DirOptions `cmdline:"-"`
+	// Coldplug selects how devices are coldplugged before the data device is
+	// resolved, one of "none", "trigger" or "udevd".
+	Coldplug string `cmdline:"coldplug"`
+	// IP is the network configuration, in the kernel's ip= syntax (eg. "dhcp"
+	// or "<client-ip>::<gw-ip>:<netmask>:<hostname>:<device>:off").
+	IP string `cmdline:"ip"`
+	// NetworkTimeout is the maximum time to spend setting up the network.
+	NetworkTimeout time.Duration `cmdline:"network_timeout"`
+	// Clock specifies whether to set the system clock from the RTC, or
+	// otherwise clamp it to no earlier than the build time of the image.
+	Clock bool `cmdline:"clock"`
+	// RandomSeed specifies whether to persist a random seed on the data
+	// filesystem, and load it into the kernel's random pool at boot.
+	RandomSeed bool `cmdline:"random_seed"`
+	// RandomSeedCredit specifies whether the random seed is credited as
+	// entropy (which is unsafe if the data filesystem is cloned).
+	RandomSeedCredit bool `cmdline:"random_seed_credit"`
+	// Hostname is the hostname to assign to the system (overriding the
+	// provisioning config and DHCP).
+	Hostname string `cmdline:"hostname"`
+	// Modules is a list of additional kernel modules to load before mounting.
+	Modules []string `cmdline:"modules"`
+	// Watchdog is the hardware watchdog device armed during setup (if set).
+	Watchdog string `cmdline:"watchdog"`
+	// WatchdogTimeout is the timeout to set on the watchdog (zero keeps the
+	// device's default).
+	WatchdogTimeout time.Duration `cmdline:"watchdog_timeout"`
+	// WatchdogLimit is how long the watchdog is petted for, after which a
+	// hung setup will reset the machine.
+	WatchdogLimit time.Duration `cmdline:"watchdog_limit"`
+	// WatchdogHandoff is whether the watchdog is left armed for init to pet
+	// (rather than disarmed) before init is executed.
+	WatchdogHandoff bool `cmdline:"watchdog_handoff"`
+	// Disable specifies whether the data filesystem and overlays are skipped,
+	// booting the image as-is (for debugging).
+	Disable bool `cmdline:"disable"`
+	// DisableRW specifies whether the root filesystem is (re)mounted
+	// read-write when disabled.
+	DisableRW bool `cmdline:"disable_rw"`
+	// Cmd is the init process to be executed after the filesystem has been setup.
+	// It may include arguments (with shell-like quoting), which are split off
+	// into CmdArgs.
+	Cmd string `cmdline:"cmd"`
+	// CmdArgs are the arguments init is executed with (with shell-like
+	// quoting).
+	CmdArgs string `cmdline:"cmd_args"`
+	// InitFallbacks are tried (in order) if Cmd doesn't exist.
+	InitFallbacks []string `cmdline:"init_fallbacks"`
+	// InitArgsPassthrough is which of the arguments matchstick was executed
+	// with are passed through to init: "none", "positional" (those that
+	// aren't matchstick's own flags) or "all".
+	InitArgsPassthrough string `cmdline:"init_args_passthrough"`
+	// Env are additional environment variables (of the form NAME=value) init
+	// is executed with.
+	Env []string `cmdline:"env"`
+	// EnvScrub specifies whether the inherited environment is scrubbed
+	// (except for the terminal type and container manager hints).
+	EnvScrub bool `cmdline:"env_scrub"`
+	// EnvSecrets are the names of secret-bearing environment variables,
+	// whose values are never logged (in addition to those that look like
+	// secrets, eg. DB_PASSWORD).
+	EnvSecrets []string `cmdline:"env_secrets"`
+	// Container overrides container detection: "auto", "true" or "false".
+	Container string `cmdline:"container"`
+	// ContainerOverlays specifies whether the overlays are still set up when
+	// running in a container (eg. a system container).
+	ContainerOverlays bool `cmdline:"container_overlays"`
+	// OverlayMode is how overlays are mounted in a container: "auto",
+	// "kernel", "userxattr" or "fuse".
+	OverlayMode string `cmdline:"overlay_mode"`
+	// FuseOverlayfs is the path of the fuse-overlayfs binary.
+	FuseOverlayfs string `cmdline:"fuse_overlayfs"`
+	// Supervise specifies whether matchstick remains PID 1, running init as a
+	// child process (rather than executing it).
+	Supervise bool `cmdline:"supervise"`
+	// OnExit is the policy applied when a supervised init exits.
+	OnExit string `cmdline:"on_exit"`
+	// MaxRestarts is the maximum number of consecutive restarts of a
+	// supervised init, before the failure policy is applied.
+	MaxRestarts int `cmdline:"max_restarts"`
+	// RestartDelay is the delay before the first restart of a supervised
+	// init, doubling with each consecutive restart.
+	RestartDelay time.Duration `cmdline:"restart_delay"`
+	// Volatile specifies whether the data filesystem should be volatile.
+	Volatile bool `cmdline:"volatile"`
+	// HooksDir is the directory containing the pre-mount.d and post-mount.d
+	// hook directories.
+	HooksDir string `cmdline:"hooks_dir"`
+	// TmpfilesDir is the directory containing the tmpfiles.d style
+	// configuration files, applied once the overlays are mounted.
+	TmpfilesDir string `cmdline:"tmpfiles_dir"`
+	// PreMountHooks is a list of additional executables to run before the
+	// data filesystem is mounted.
+	PreMountHooks []string `cmdline:"pre_mount_hooks"`
+	// Mounts is a list of extra filesystems to mount after the overlays, each
+	// of the form source:target[:fstype[:options]].
+	Mounts []string `cmdline:"mounts"`
+	// PostMountHooks is a list of additional executables to run after the
+	// overlays have been mounted.
+	PostMountHooks []string `cmdline:"post_mount_hooks"`
+	// ConfigURL is the URL of a remote provisioning config to apply.
+	ConfigURL string `cmdline:"config_url"`
+	// ConfigSHA256 is the expected SHA-256 digest of the provisioning config.
+	ConfigSHA256 string `cmdline:"config_sha256"`
+	// ConfigPublicKey is the ed25519 public key used to verify the signature
+	// of the provisioning config.
+	ConfigPublicKey string `cmdline:"config_pubkey"`
+	// Lang is the language used for operator-facing console messages.
+	Lang string `cmdline:"lang"`
+	// Debug specifies whether to log at debug level (tracing resolved options,
+	// system calls and decisions), it overrides LogLevel.
+	Debug bool `cmdline:"debug"`
+	// LogLevel is the minimum level of log records, one of "debug", "info",
+	// "warn" or "error".
+	LogLevel string `cmdline:"log_level"`
+	// LogFormat is the format of log records written to stderr, the console,
+	// serial tty and log file, one of "text" or "json".
+	LogFormat string `cmdline:"log_format"`
+	// LogConsole specifies whether to also log to /dev/console.
+	LogConsole bool `cmdline:"log_console"`
+	// LogSerial is the path of a serial tty to also log to (eg. /dev/ttyS0).
+	LogSerial string `cmdline:"log_serial"`
+	// LogFile is the path of a file (relative to the data filesystem) to also
+	// log to, once the data filesystem has been mounted.
+	LogFile string `cmdline:"log_file"`
+	// Redact are the names of options (or log attributes) whose values are
+	// never logged, in addition to those that look like secrets (eg.
+	// config_token).
+	Redact []string `cmdline:"redact"`
+	// AuditLog is the path of a file (relative to the data filesystem) a
+	// record of each boot is appended to.
+	AuditLog string `cmdline:"audit_log"`
+	// AuditChain specifies whether audit records are hash-chained (so that
+	// tampering is evident).
+	AuditChain bool `cmdline:"audit_chain"`
+	// Retries is the maximum number of attempts made to resolve devices and
+	// mount filesystems, when failing with transient errors.
+	Retries int `cmdline:"retries"`
+	// RetryDelay is the delay before the first retry, it doubles with each
+	// subsequent retry.
+	RetryDelay time.Duration `cmdline:"retry_delay"`
+	// Timeout is the maximum time boot setup may take in total (zero is
+	// unlimited).
+	Timeout time.Duration `cmdline:"timeout"`
+	// DeviceTimeout is the maximum time to wait for the data device.
+	DeviceTimeout time.Duration `cmdline:"device_timeout"`
+	// PrepareTimeout is the maximum time to spend preparing the data device
+	// (eg. checking or unlocking it).
+	PrepareTimeout time.Duration `cmdline:"prepare_timeout"`
+	// MountTimeout is the maximum time to spend mounting the data filesystem,
+	// and separately, the overlays.
+	MountTimeout time.Duration `cmdline:"mount_timeout"`
+	// DataFlags are the hardening options (nosuid, nodev or noexec, or their
+	// negations) the data filesystem is mounted with.
+	DataFlags []string `cmdline:"data_flags"`
+	// OverlayFlags are the hardening options the overlays are mounted with,
+	// which can be overridden per-directory.
+	OverlayFlags []string `cmdline:"overlay_flags"`
+	// DataPropagation is the propagation type of the data filesystem mount,
+	// one of "shared", "slave" or "private", prefixed with "r" to also apply
+	// to the mounts below it (eg. "rshared"). Unset leaves it unchanged.
+	DataPropagation string `cmdline:"data_propagation"`
+	// Propagation is the propagation type of the overlay mounts (as for
+	// DataPropagation), which can be overridden per-directory.
+	Propagation string `cmdline:"propagation"`
+	// DataMode, DataOwner and DataLabel are the permissions (in octal), owner
+	// (numeric uid[:gid]) and security label set on the root directory of
+	// the data filesystem once it's mounted. Unset leaves them unchanged.
+	DataMode  string `cmdline:"data_mode"`
+	DataOwner string `cmdline:"data_owner"`
+	DataLabel string `cmdline:"data_label"`
+	// MountConcurrency is the maximum number of overlays mounted at once.
+	MountConcurrency int `cmdline:"mount_concurrency"`
+	// HooksTimeout is the maximum time to spend running each stage's hooks.
+	HooksTimeout time.Duration `cmdline:"hooks_timeout"`
+	// OnFailure is the failure policy applied if setup fails, one of "shell",
+	// "reboot", "panic" or "continue".
+	OnFailure string `cmdline:"on_failure"`
+	// DataOnCorrupt is what happens if the data filesystem is corrupt (it
+	// can't be mounted even after being repaired), one of "shell" (the
+	// failure policy applies), "volatile" or "reformat".
+	DataOnCorrupt string `cmdline:"data_on_corrupt"`
+	// RebootDelay is the initial delay before rebooting (with the reboot
+	// failure policy), it doubles with each consecutive failure.
+	RebootDelay time.Duration `cmdline:"reboot_delay"`
+	// InitSHA256 is the expected hex encoded SHA-256 digest of init.
+	InitSHA256 string `cmdline:"init_sha256"`
+	// VerifyPublicKey is a base64 encoded ed25519 public key, init (and the
+	// manifest) must have a valid detached signature (with a ".sig" suffix).
+	VerifyPublicKey string `cmdline:"verify_pubkey"`
+	// VerifyManifest is the path of a SHA-256 manifest of critical files
+	// (within the image), which are verified before init is executed.
+	VerifyManifest string `cmdline:"verify_manifest"`
+	// OnViolation is what to do when, in lockdown (with a trusted config),
+	// options are set outside of the trusted config: "fail" or "ignore".
+	OnViolation string `cmdline:"on_violation"`
+	// Rlimits are the resource limits set for init, each of the form
+	// "name=soft[:hard]" (eg. "nofile=1024:65536").
+	Rlimits []string `cmdline:"rlimits"`
+	// OOMScoreAdj is the oom_score_adj of init (zero leaves it unchanged).
+	OOMScoreAdj int `cmdline:"oom_score_adj"`
+	// Cgroup is the cgroup v2 init is placed in (relative to the root of the
+	// hierarchy), eg. "app.slice/app".
+	Cgroup string `cmdline:"cgroup"`
+	// DropCapabilities are the capabilities dropped from the bounding set
+	// before executing init (eg. "sys_admin", or "all").
+	DropCapabilities []string `cmdline:"drop_caps"`
+	// NoNewPrivs specifies whether init (and its descendants) are prevented
+	// from gaining privileges, eg. with setuid binaries.
+	NoNewPrivs bool `cmdline:"no_new_privs"`
+	// Securebits are the securebits set before executing init (eg. "noroot").
+	Securebits []string `cmdline:"securebits"`
+	// Seccomp is the path of a seccomp allowlist (syscall names, one per
+	// line) applied to init.
+	Seccomp string `cmdline:"seccomp"`
+	// SeccompAction is the action taken on syscalls that aren't allowed, one
+	// of "errno", "kill" or "log".
+	SeccompAction string `cmdline:"seccomp_action"`
+	// MeasurePCR is the TPM PCR the configuration, image identity and init
+	// binary are measured into before executing init (zero disables it).
+	MeasurePCR int `cmdline:"measure_pcr"`
+	// TPMDevice is the TPM device used for measurements.
+	TPMDevice string `cmdline:"tpm_device"`
+	// ImageID identifies the image being booted (eg. a verity root hash). It
+	// defaults to the kernel's roothash= parameter, or the IMAGE_ID and
+	// IMAGE_VERSION in /etc/os-release.
+	ImageID string `cmdline:"image_id"`
+	// Bootloader is the bootloader whose boot counting is integrated with:
+	// grub or systemd-boot (empty disables it).
+	Bootloader string `cmdline:"bootloader"`
+	// BootDevice is the device holding the GRUB environment block, or the
+	// ESP for systemd-boot (required with a bootloader).
+	BootDevice string `cmdline:"boot_device"`
+	// BootFSType is the filesystem type of the boot device.
+	BootFSType string `cmdline:"boot_fstype"`
+	// Grubenv is the path of the GRUB environment block (relative to the boot
+	// device).
+	Grubenv string `cmdline:"grubenv"`
+	// RecoveryKernel is the recovery kernel booted (with kexec) after
+	// RecoveryAfter consecutive failed boots (with the reboot policy).
+	RecoveryKernel string `cmdline:"recovery_kernel"`
+	// RecoveryInitrd is the initrd of the recovery kernel.
+	RecoveryInitrd string `cmdline:"recovery_initrd"`
+	// RecoveryCmdline is the command line of the recovery kernel (defaults to
+	// the current command line).
+	RecoveryCmdline string `cmdline:"recovery_cmdline"`
+	// RecoveryDevice is the device (eg. the ESP) holding the recovery kernel
+	// and initrd, and the failed boot count (required with a recovery
+	// kernel).
+	RecoveryDevice string `cmdline:"recovery_device"`
+	// RecoveryFSType is the filesystem type of the recovery device.
+	RecoveryFSType string `cmdline:"recovery_fstype"`
+	// RecoveryAfter is the number of consecutive failed boots after which the
+	// recovery kernel is booted.
+	RecoveryAfter int `cmdline:"recovery_after"`
+	// RollbackAfter is the number of consecutive boots that aren't marked
+	// healthy after which the upper layers are rolled back to the last
+	// healthy snapshot (zero disables boot health checking).
+	RollbackAfter int `cmdline:"rollback_after"`
+	// DryRun specifies whether to print the planned mount operations and exit
+	// without mounting anything (ignored when running as PID 1).
+	DryRun bool `cmdline:"dry_run"`
+	// Scrub specifies whether a background scrub of the data filesystem should
+	// be started before executing init.
+	Scrub bool `cmdline:"scrub"`
+	// ScrubRate is the maximum rate (in MiB/s) at which the scrub will read
+	// from the data filesystem.
+	ScrubRate int64 `cmdline:"scrub_rate"`
+	// Trim is how the data filesystem is trimmed, one of "off", "discard"
+	// (mounting it with the discard option), "boot" or "shutdown".
+	Trim string `cmdline:"trim"`
+	// TrimInterval is the minimum interval between trims of the data
+	// filesystem (with the boot and shutdown policies).
+	TrimInterval time.Duration `cmdline:"trim_interval"`
+}
+
+// Decode decodes a map of (prefixed) option keys and values into opts.
+// Keys that don't correspond to a known option are ignored.
+func Decode(opts *Options, m map[string]string) error {
+	multi := make(map[string][]string, len(m))
+	for key, value := range m {
+		multi[key] = []string{value}
+	}
+
+	return DecodeMulti(opts, multi)
+}
+
+// DecodeMulti is like Decode but accepts options that were specified more
+// than once (in order). Repeated list options are appended to one another,
+// for all other options the last value wins.
+func DecodeMulti(opts *Options, m map[string][]string) error {
+	canonical, err := canonicalize(m)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           opts,
+		TagName:          "cmdline",
+		WeaklyTypedInput: true,
+		// Replace (rather than merge into) default slice values.
+		ZeroFields: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			util.StringToSliceHookFunc(','),
+			util.StringToBooleanHookFunc(),
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(canonical)
+}
+
+// LogValue logs the options by name (as used on the kernel command line).
+func (opts *Options) LogValue() slog.Value {
+	var attrs []slog.Attr
+	opts.each(func(name string, value any) {
+		attrs = append(attrs, slog.Any(name, value))
+	})
+
+	return slog.GroupValue(attrs...)
+}
+
+// AsMap returns the options keyed by name (eg. for inclusion in a report),
+// with the values of secret-bearing environment variables redacted.
+func (opts *Options) AsMap() map[string]any {
+	m := make(map[string]any)
+	opts.each(func(name string, value any) {
+		m[name] = value
+	})
+
+	return m
+}
+
+// each calls fn with the name and value of each option, in order.
+func (opts *Options) each(fn func(name string, value any)) {
+	v := reflect.ValueOf(opts).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("cmdline")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		value := v.Field(i).Interface()
+		if name == "env" {
+			// Never reveal the values of secret-bearing variables.
+			value = environ.Redact(opts.Env, opts.EnvSecrets)
+		}
+
+		fn(name, value)
+	}
+}
+
+// isListOption returns true if the named option accepts a list of values.
+func isListOption(name string) bool {
+	t := reflect.TypeOf(Options{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("cmdline") == name {
+			return t.Field(i).Type.Kind() == reflect.Slice
+		}
+	}
+
+	return false
+}
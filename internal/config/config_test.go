@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/config"
+)
+
+func TestDecodeEnviron(t *testing.T) {
+	opts := config.Options{
+		Mount: "/mnt/data",
+		Dirs:  []string{"/etc", "/home", "/root", "/srv", "/var"},
+	}
+
+	environ := []string{
+		"PATH=/usr/bin:/bin",
+		"MATCHSTICK_DATA=/dev/vda2",
+		"MATCHSTICK_DATAFSTYPE=ext4",
+		"MATCHSTICK_DIRS=/etc,/var",
+		"MATCHSTICK_VOLATILE=yes",
+	}
+
+	if err := config.Decode(&opts, config.EnvironAsMap(environ)); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Data != "/dev/vda2" {
+		t.Errorf("Data = %q, want %q", opts.Data, "/dev/vda2")
+	}
+
+	if opts.DataFSType != "ext4" {
+		t.Errorf("DataFSType = %q, want %q", opts.DataFSType, "ext4")
+	}
+
+	if opts.Mount != "/mnt/data" {
+		t.Errorf("Mount = %q, want %q", opts.Mount, "/mnt/data")
+	}
+
+	if len(opts.Dirs) != 2 || opts.Dirs[0] != "/etc" || opts.Dirs[1] != "/var" {
+		t.Errorf("Dirs = %v, want [/etc /var]", opts.Dirs)
+	}
+
+	if !opts.Volatile {
+		t.Error("Volatile = false, want true")
+	}
+}
+
+func TestStripOptions(t *testing.T) {
+	environ := []string{"PATH=/usr/bin:/bin", "MATCHSTICK_DATA=/dev/vda2", "MATCHSTICK=1", "container=podman"}
+
+	want := []string{"PATH=/usr/bin:/bin", "MATCHSTICK=1", "container=podman"}
+	if got := config.StripOptions(environ); !reflect.DeepEqual(got, want) {
+		t.Errorf("StripOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeInvalidBoolean(t *testing.T) {
+	var opts config.Options
+	if err := config.Decode(&opts, map[string]string{"matchstick.volatile": "maybe"}); err == nil {
+		t.Error("expected error decoding invalid boolean")
+	}
+}
+
+func TestDecodeAliases(t *testing.T) {
+	prefix, aliases := config.Prefix, config.Aliases
+	t.Cleanup(func() {
+		config.Prefix, config.Aliases = prefix, aliases
+	})
+
+	config.Prefix = "immutableinit"
+
+	t.Run("Prefixes", func(t *testing.T) {
+		var opts config.Options
+		err := config.Decode(&opts, map[string]string{
+			"immutableinit.data":     "/dev/vda2",
+			"matchstick.data_fstype": "ext4",
+			"root":                   "/dev/vda1",
+			"mount":                  "/ignored",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Data != "/dev/vda2" || opts.DataFSType != "ext4" || opts.Mount != "" {
+			t.Errorf("unexpected options: %+v", opts)
+		}
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		var opts config.Options
+		err := config.Decode(&opts, map[string]string{
+			"immutableinit.datafstype": "ext4",
+			"matchstick.datafstype":    "xfs",
+		})
+		if err == nil {
+			t.Fatal("expected conflicting options to be rejected")
+		}
+	})
+
+	t.Run("Environ", func(t *testing.T) {
+		var opts config.Options
+		err := config.Decode(&opts, config.EnvironAsMap([]string{"IMMUTABLEINIT_DATA=/dev/vda2"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Data != "/dev/vda2" {
+			t.Errorf("Data = %q, want %q", opts.Data, "/dev/vda2")
+		}
+	})
+}
+
+func TestDecodeMulti(t *testing.T) {
+	opts := config.Options{
+		Dirs: []string{"/etc", "/home", "/root", "/srv", "/var"},
+	}
+
+	err := config.DecodeMulti(&opts, map[string][]string{
+		"matchstick.dirs":       {"/etc", `/srv/a\,b,/var`},
+		"matchstick.datafstype": {"ext4", "xfs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"/etc", "/srv/a,b", "/var"}; !reflect.DeepEqual(opts.Dirs, want) {
+		t.Errorf("Dirs = %v, want %v", opts.Dirs, want)
+	}
+
+	if opts.DataFSType != "xfs" {
+		t.Errorf("DataFSType = %q, want %q", opts.DataFSType, "xfs")
+	}
+}
+
+func TestApplySystemdVolatile(t *testing.T) {
+	for _, tt := range []struct {
+		mode        string
+		volatile    bool
+		dirs        []string
+		overlayRoot bool
+	}{
+		{"1", true, []string{"/etc", "/var"}, false},
+		{"yes", true, []string{"/etc", "/var"}, false},
+		{"state", true, []string{"/var"}, false},
+		{"Overlay", true, []string{"/etc", "/var"}, true},
+		{"no", false, []string{"/etc", "/var"}, false},
+	} {
+		opts := &config.Options{Dirs: []string{"/etc", "/var"}}
+
+		if err := config.ApplySystemdVolatile(opts, tt.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.Volatile != tt.volatile || !reflect.DeepEqual(opts.Dirs, tt.dirs) || opts.OverlayRoot != tt.overlayRoot {
+			t.Errorf("%s: unexpected options: %+v", tt.mode, opts)
+		}
+	}
+
+	if err := config.ApplySystemdVolatile(&config.Options{}, "sometimes"); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
+
+func TestLogValueRedactsSecrets(t *testing.T) {
+	opts := &config.Options{
+		Env:        []string{"APP_MODE=kiosk", "DB_PASSWORD=hunter2", "LICENSE=abc"},
+		EnvSecrets: []string{"LICENSE"},
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("Resolved options", slog.Any("options", opts))
+
+	if out := buf.String(); strings.Contains(out, "hunter2") || strings.Contains(out, "abc") || !strings.Contains(out, "APP_MODE=kiosk") {
+		t.Errorf("unexpected log output: %s", out)
+	}
+	if env := opts.AsMap()["env"]; !reflect.DeepEqual(env, []string{"APP_MODE=kiosk", "DB_PASSWORD=<redacted>", "LICENSE=<redacted>"}) {
+		t.Errorf("unexpected env: %v", env)
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirOptions are per-directory overlay options.
+type DirOptions struct {
+	// Required causes boot to fail if the directory doesn't exist, rather
+	// than the directory being skipped.
+	Required bool
+	// Flags are hardening options applied on top of Options.OverlayFlags.
+	Flags []string
+	// Propagation overrides the propagation type of the overlay mount (see
+	// Options.Propagation).
+	Propagation string
+	// Mode, Owner and Label are the permissions, owner and security label
+	// of the upper and work directories (as for Options.DataMode).
+	Mode  string
+	Owner string
+	Label string
+}
+
+// ReadDirsFile reads a dirs file, replacing opts.Dirs (and opts.DirOptions)
+// with its contents.
+func ReadDirsFile(opts *Options, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dirs, dirOpts, err := parseDirsFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	opts.Dirs = dirs
+	opts.DirOptions = dirOpts
+
+	return nil
+}
+
+// parseDirsFile parses a newline separated list of directories, each
+// optionally followed by a comma separated list of options, eg:
+//
+//	# Comments and blank lines are ignored.
+//	/etc required
+//	/var nosuid,nodev,noexec,propagation=rshared
+//	/home mode=0755,owner=0:0,label=system_u:object_r:home_root_t:s0
+func parseDirsFile(r io.Reader) ([]string, map[string]DirOptions, error) {
+	var dirs []string
+	dirOpts := make(map[string]DirOptions)
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, nil, fmt.Errorf("line %d: unexpected trailing fields", lineno)
+		}
+
+		dir := filepath.Clean(fields[0])
+		if !filepath.IsAbs(dir) {
+			return nil, nil, fmt.Errorf("line %d: directory %q must be absolute", lineno, fields[0])
+		}
+
+		if _, ok := dirOpts[dir]; ok {
+			return nil, nil, fmt.Errorf("line %d: duplicate directory %q", lineno, dir)
+		}
+
+		var do DirOptions
+		if len(fields) == 2 {
+			var err error
+			do, err = parseDirOptions(fields[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+		}
+
+		dirs = append(dirs, dir)
+		dirOpts[dir] = do
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return dirs, dirOpts, nil
+}
+
+func parseDirOptions(s string) (DirOptions, error) {
+	var do DirOptions
+
+	for _, opt := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+
+		switch key {
+		case "required":
+			do.Required = true
+		case "nosuid", "suid", "nodev", "dev", "noexec", "exec":
+			do.Flags = append(do.Flags, key)
+		case "propagation":
+			do.Propagation = value
+		case "mode":
+			do.Mode = value
+		case "owner":
+			do.Owner = value
+		case "label":
+			do.Label = value
+		default:
+			return do, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	return do, nil
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDirsFile(t *testing.T) {
+	dirs, dirOpts, err := parseDirsFile(strings.NewReader(`
+# Overlay layout.
+/etc required
+/var/ noexec,propagation=rshared
+
+/home mode=0755,owner=0:100,label=user_home_dir_t
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"/etc", "/var", "/home"}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("dirs = %v, want %v", dirs, want)
+	}
+
+	if !dirOpts["/etc"].Required || dirOpts["/var"].Required || dirOpts["/var"].Propagation != "rshared" ||
+		!reflect.DeepEqual(dirOpts["/var"].Flags, []string{"noexec"}) ||
+		!reflect.DeepEqual(dirOpts["/home"], DirOptions{Mode: "0755", Owner: "0:100", Label: "user_home_dir_t"}) {
+		t.Errorf("unexpected dir options: %v", dirOpts)
+	}
+
+	for _, invalid := range []string{"etc", "/etc bogus", "/etc\n/etc", "/etc required extra"} {
+		if _, _, err := parseDirsFile(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
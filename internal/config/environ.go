@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"strings"
+)
+
+// EnvironAsMap converts MATCHSTICK_* environment variables (or those using
+// any other accepted prefix) into a map keyed the same way as the kernel
+// command line (eg. MATCHSTICK_DATA becomes matchstick.data), so they can be
+// passed to Decode.
+func EnvironAsMap(environ []string) map[string]string {
+	prefixes := Prefixes()
+
+	m := make(map[string]string)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+
+		if name, ok := envOptionName(prefixes, key); ok {
+			m[name] = value
+		}
+	}
+
+	return m
+}
+
+// StripOptions returns a copy of environ without the variables that set
+// options (eg. MATCHSTICK_DATA), so they aren't inherited by init.
+func StripOptions(environ []string) []string {
+	prefixes := Prefixes()
+
+	var stripped []string
+	for _, kv := range environ {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := envOptionName(prefixes, key); !ok {
+			stripped = append(stripped, kv)
+		}
+	}
+
+	return stripped
+}
+
+// envOptionName returns the (prefixed) option key for an environment
+// variable, eg. matchstick.data for MATCHSTICK_DATA.
+func envOptionName(prefixes []string, key string) (string, bool) {
+	for _, p := range prefixes {
+		envPrefix := strings.ToUpper(strings.ReplaceAll(p, "-", "_")) + "_"
+		if name, ok := strings.CutPrefix(key, envPrefix); ok {
+			return p + "." + strings.ToLower(name), true
+		}
+	}
+
+	return "", false
+}
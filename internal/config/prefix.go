@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPrefix is the original prefix of matchstick options, it is always
+// accepted so that rebranded builds don't break existing deployments.
+const DefaultPrefix = "matchstick"
+
+// Prefix is the prefix used for matchstick options on the kernel command
+// line. It can be overridden at build time, eg:
+//
+//	-ldflags "-X github.com/immutos/matchstick/internal/config.Prefix=immutableinit"
+var Prefix = DefaultPrefix
+
+// Aliases is a comma-separated list of additional prefixes that will be
+// accepted. It can also be overridden at build time.
+var Aliases = ""
+
+// optionAliases maps alternative spellings of option names to their
+// canonical names.
+var optionAliases = map[string]string{
+	"data_fstype":  "datafstype",
+	"data_fs_type": "datafstype",
+}
+
+// Prefixes returns all of the accepted option prefixes, with the primary
+// prefix first.
+func Prefixes() []string {
+	prefixes := []string{Prefix}
+
+	for _, p := range append(strings.Split(Aliases, ","), DefaultPrefix) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		var dup bool
+		for _, existing := range prefixes {
+			if strings.EqualFold(existing, p) {
+				dup = true
+				break
+			}
+		}
+
+		if !dup {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	return prefixes
+}
+
+// canonicalize strips the prefix from each key in m, and resolves any option
+// aliases. Keys without an accepted prefix are dropped. It is an error for
+// different spellings of the same option to have different values.
+func canonicalize(m map[string][]string) (map[string]string, error) {
+	prefixes := Prefixes()
+
+	canonical := make(map[string]string, len(m))
+	sources := make(map[string]string, len(m))
+
+	for key, values := range m {
+		name, ok := optionName(prefixes, key)
+		if !ok || len(values) == 0 {
+			continue
+		}
+
+		// Repeated list options are appended, otherwise the last value wins.
+		value := values[len(values)-1]
+		if isListOption(name) {
+			value = strings.Join(values, ",")
+		}
+
+		if existing, ok := canonical[name]; ok && existing != value {
+			return nil, fmt.Errorf("conflicting values for option %s: %s=%q and %s=%q",
+				name, sources[name], existing, key, value)
+		}
+
+		canonical[name] = value
+		sources[name] = key
+	}
+
+	return canonical, nil
+}
+
+func optionName(prefixes []string, key string) (string, bool) {
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+
+	for _, p := range prefixes {
+		if name, ok := strings.CutPrefix(key, strings.ToLower(strings.ReplaceAll(p, "-", "_"))+"."); ok {
+			if alias, ok := optionAliases[name]; ok {
+				name = alias
+			}
+
+			return name, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ReadOptionsFile reads a file of options, see ParseOptions.
+func ReadOptionsFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := ParseOptions(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// ParseOptions parses newline separated key=value options, keyed the same way
+// as the kernel command line (so they can be passed to DecodeMulti). The
+// prefix may be omitted, eg:
+//
+//	# Comments and blank lines are ignored.
+//	matchstick.data=LABEL=data
+//	dirs=/etc,/var
+func ParseOptions(r io.Reader) (map[string][]string, error) {
+	prefixes := Prefixes()
+
+	m := make(map[string][]string)
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key=value", lineno)
+		}
+
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", lineno)
+		}
+
+		if _, ok := optionName(prefixes, key); !ok {
+			key = Prefix + "." + key
+		}
+
+		m[key] = append(m[key], strings.TrimSpace(value))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// OptionKeys returns the (sorted) keys in m that would be decoded as options,
+// ie. those with an accepted prefix.
+func OptionKeys[V any](m map[string]V) []string {
+	prefixes := Prefixes()
+
+	var keys []string
+	for key := range m {
+		if _, ok := optionName(prefixes, key); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
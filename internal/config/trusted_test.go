@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package config_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/config"
+)
+
+func TestParseOptions(t *testing.T) {
+	m, err := config.ParseOptions(strings.NewReader(`
+# Comments and blank lines are ignored.
+matchstick.data = LABEL=data
+dirs=/etc
+dirs=/var
+on_failure=reboot
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"matchstick.data":       {"LABEL=data"},
+		"matchstick.dirs":       {"/etc", "/var"},
+		"matchstick.on_failure": {"reboot"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseOptions() = %v, want %v", m, want)
+	}
+
+	var opts config.Options
+	if err := config.DecodeMulti(&opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Data != "LABEL=data" || !reflect.DeepEqual(opts.Dirs, []string{"/etc", "/var"}) || opts.OnFailure != "reboot" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	if _, err := config.ParseOptions(strings.NewReader("volatile\n")); err == nil {
+		t.Error("expected error for a line without a value")
+	}
+}
+
+func TestOptionKeys(t *testing.T) {
+	keys := config.OptionKeys(map[string][]string{
+		"root":            {"/dev/vda1"},
+		"quiet":           {""},
+		"matchstick.dirs": {"/etc"},
+		"MatchStick.Data": {"/dev/vda2"},
+		"matchstickdata":  {"/dev/vda3"},
+	})
+
+	if want := []string{"MatchStick.Data", "matchstick.dirs"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("OptionKeys() = %v, want %v", keys, want)
+	}
+}
+
+func FuzzParseOptions(f *testing.F) {
+	f.Add("# comment\nmatchstick.data = LABEL=data\ndirs=/etc\n")
+	f.Add("immutableinit.volatile=1\non_failure=reboot")
+	f.Add("=\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		m, err := config.ParseOptions(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		// Every key is prefixed, so they're all decoded as options.
+		if keys := config.OptionKeys(m); len(keys) != len(m) {
+			t.Errorf("unprefixed keys parsed from %q: %v", data, m)
+		}
+
+		var opts config.Options
+		_ = config.DecodeMulti(&opts, m)
+	})
+}
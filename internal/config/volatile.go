@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SystemdVolatileParam is the kernel command line parameter systemd uses to
+// select a volatile boot.
+const SystemdVolatileParam = "systemd.volatile"
+
+// ApplySystemdVolatile maps a systemd.volatile= mode onto opts, so images
+// migrating from systemd's volatile support don't need bootloader changes:
+//
+//   - "yes" (or no value): all state is kept on a tmpfs (every overlaid
+//     directory is volatile).
+//   - "state": only /var is volatile.
+//   - "overlay": the whole root filesystem is overlaid with a tmpfs.
+//   - "no": nothing changes.
+func ApplySystemdVolatile(opts *Options, mode string) error {
+	switch strings.ToLower(mode) {
+	case "yes", "1", "true", "on":
+		opts.Volatile = true
+	case "state":
+		opts.Volatile = true
+		opts.Dirs = []string{"/var"}
+	case "overlay":
+		opts.Volatile = true
+		opts.OverlayRoot = true
+	case "no", "0", "false", "off":
+	default:
+		return fmt.Errorf("unknown %s mode %q", SystemdVolatileParam, mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dag runs a set of tasks concurrently, respecting the dependencies
+// between them.
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDependency is returned (wrapped) for tasks that weren't run as a
+// dependency failed.
+var ErrDependency = errors.New("dependency failed")
+
+// Graph is a set of tasks and the dependencies between them.
+type Graph struct {
+	tasks map[string]*task
+	// order is the order tasks were added in.
+	order []string
+}
+
+type task struct {
+	deps []string
+	fn   func(ctx context.Context) error
+}
+
+// New returns an empty graph.
+func New() *Graph {
+	return &Graph{tasks: map[string]*task{}}
+}
+
+// Add adds a task that will be run once all of its dependencies have
+// succeeded. Dependencies must be added before the tasks that depend on
+// them (which rules out cycles).
+func (g *Graph) Add(name string, deps []string, fn func(ctx context.Context) error) error {
+	if _, ok := g.tasks[name]; ok {
+		return fmt.Errorf("duplicate task %q", name)
+	}
+
+	for _, dep := range deps {
+		if _, ok := g.tasks[dep]; !ok {
+			return fmt.Errorf("task %q depends on unknown task %q", name, dep)
+		}
+	}
+
+	g.tasks[name] = &task{deps: deps, fn: fn}
+	g.order = append(g.order, name)
+
+	return nil
+}
+
+// Run runs the tasks, at most concurrency at a time (or all at once if
+// concurrency isn't positive). Tasks whose dependencies failed aren't run.
+// It returns the error of each task that failed (or wasn't run).
+func (g *Graph) Run(ctx context.Context, concurrency int) map[string]error {
+	if concurrency <= 0 {
+		concurrency = len(g.order)
+	}
+	sem := make(chan struct{}, max(concurrency, 1))
+
+	var (
+		mu   sync.Mutex
+		errs = map[string]error{}
+		done = make(map[string]chan struct{}, len(g.order))
+		wg   sync.WaitGroup
+	)
+
+	for _, name := range g.order {
+		done[name] = make(chan struct{})
+	}
+
+	for _, name := range g.order {
+		t := g.tasks[name]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[name])
+
+			for _, dep := range t.deps {
+				<-done[dep]
+			}
+
+			mu.Lock()
+			var failed []error
+			for _, dep := range t.deps {
+				if errs[dep] != nil {
+					failed = append(failed, fmt.Errorf("%w: %s", ErrDependency, dep))
+				}
+			}
+			if len(failed) > 0 {
+				errs[name] = errors.Join(failed...)
+			}
+			mu.Unlock()
+
+			if len(failed) > 0 {
+				return
+			}
+
+			var err error
+			select {
+			case sem <- struct{}{}:
+				err = t.fn(ctx)
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			if err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dag_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/dag"
+)
+
+func TestRun(t *testing.T) {
+	g := dag.New()
+
+	var (
+		mu       sync.Mutex
+		finished []string
+	)
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			finished = append(finished, name)
+			mu.Unlock()
+
+			return nil
+		}
+	}
+
+	mustAdd(t, g, "data", nil, record("data"))
+	mustAdd(t, g, "/etc", []string{"data"}, record("/etc"))
+	mustAdd(t, g, "/var", []string{"data"}, record("/var"))
+	mustAdd(t, g, "/var/lib/app", []string{"/var"}, record("/var/lib/app"))
+
+	if errs := g.Run(context.Background(), 0); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	index := make(map[string]int)
+	for i, name := range finished {
+		index[name] = i
+	}
+
+	if len(index) != 4 {
+		t.Fatalf("expected all tasks to run, got %v", finished)
+	}
+
+	if index["data"] != 0 || index["/var/lib/app"] < index["/var"] {
+		t.Errorf("dependencies not respected: %v", finished)
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	g := dag.New()
+
+	var running, peak atomic.Int32
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		mustAdd(t, g, name, nil, func(context.Context) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+
+			return nil
+		})
+	}
+
+	start := time.Now()
+	g.Run(context.Background(), 2)
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 concurrent tasks, got %d", peak.Load())
+	}
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("tasks finished too quickly (%s) to have been limited", elapsed)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	g := dag.New()
+
+	errBusy := errors.New("busy")
+	ran := false
+
+	mustAdd(t, g, "/var", nil, func(context.Context) error { return errBusy })
+	mustAdd(t, g, "/etc", nil, func(context.Context) error { return nil })
+	mustAdd(t, g, "/var/lib/app", []string{"/var"}, func(context.Context) error {
+		ran = true
+		return nil
+	})
+
+	errs := g.Run(context.Background(), 1)
+
+	if !errors.Is(errs["/var"], errBusy) {
+		t.Errorf("expected /var to fail with errBusy, got %v", errs["/var"])
+	}
+
+	if !errors.Is(errs["/var/lib/app"], dag.ErrDependency) || ran {
+		t.Errorf("expected /var/lib/app to be skipped, got %v", errs["/var/lib/app"])
+	}
+
+	if errs["/etc"] != nil {
+		t.Errorf("unexpected error for /etc: %v", errs["/etc"])
+	}
+}
+
+func TestAdd(t *testing.T) {
+	g := dag.New()
+	mustAdd(t, g, "a", nil, nil)
+
+	if err := g.Add("a", nil, nil); err == nil {
+		t.Error("expected error for a duplicate task")
+	}
+
+	if err := g.Add("b", []string{"c"}, nil); err == nil {
+		t.Error("expected error for an unknown dependency")
+	}
+}
+
+func mustAdd(t *testing.T, g *dag.Graph, name string, deps []string, fn func(context.Context) error) {
+	t.Helper()
+
+	if err := g.Add(name, deps, fn); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package devices
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSysDir is where sysfs is mounted.
+const DefaultSysDir = "/sys"
+
+// Node is a device node.
+type Node struct {
+	// Name is the path of the node, relative to /dev.
+	Name string
+	// Mode is the type (unix.S_IFCHR or unix.S_IFBLK) and permissions.
+	Mode  uint32
+	Major uint32
+	Minor uint32
+}
+
+// Essential are the nodes needed to log and to hand over to init.
+var Essential = []Node{
+	{Name: "console", Mode: unix.S_IFCHR | 0o600, Major: 5, Minor: 1},
+	{Name: "null", Mode: unix.S_IFCHR | 0o666, Major: 1, Minor: 3},
+	{Name: "zero", Mode: unix.S_IFCHR | 0o666, Major: 1, Minor: 5},
+	{Name: "kmsg", Mode: unix.S_IFCHR | 0o644, Major: 1, Minor: 11},
+	{Name: "urandom", Mode: unix.S_IFCHR | 0o666, Major: 1, Minor: 9},
+	{Name: "tty", Mode: unix.S_IFCHR | 0o666, Major: 5, Minor: 0},
+}
+
+// Create creates each of the nodes in devDir, nodes that already exist are
+// left as-is.
+func Create(devDir string, nodes []Node) error {
+	var errs []error
+	for _, n := range nodes {
+		path := filepath.Join(devDir, n.Name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err := unix.Mknod(path, n.Mode, int(unix.Mkdev(n.Major, n.Minor)))
+		if err != nil && !errors.Is(err, unix.EEXIST) {
+			errs = append(errs, fmt.Errorf("failed to create %s: %w", path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// LookupBlock looks up a block device by its kernel name (eg. "vda1" or
+// "/dev/vda1") in sysfs, returning os.ErrNotExist if the kernel doesn't know
+// about it (yet).
+func LookupBlock(sysDir, name string) (*Node, error) {
+	name = strings.TrimPrefix(name, "/dev/")
+
+	entries, err := os.ReadDir(filepath.Join(sysDir, "dev", "block"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		devName, err := ueventDevName(filepath.Join(sysDir, "dev", "block", e.Name(), "uevent"))
+		if err != nil || devName != name {
+			continue
+		}
+
+		major, minor, err := parseDevNumber(e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		return &Node{Name: name, Mode: unix.S_IFBLK | 0o660, Major: major, Minor: minor}, nil
+	}
+
+	return nil, fmt.Errorf("block device %s: %w", name, os.ErrNotExist)
+}
+
+// ueventDevName reads the DEVNAME of a device from its uevent file.
+func ueventDevName(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "DEVNAME="); ok {
+			return value, nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// parseDevNumber parses a "major:minor" device number.
+func parseDevNumber(s string) (uint32, uint32, error) {
+	majorStr, minorStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid device number %q", s)
+	}
+
+	major, err := strconv.ParseUint(majorStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device number %q: %w", s, err)
+	}
+
+	minor, err := strconv.ParseUint(minorStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device number %q: %w", s, err)
+	}
+
+	return uint32(major), uint32(minor), nil
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package devices_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/devices"
+	"golang.org/x/sys/unix"
+)
+
+func TestLookupBlock(t *testing.T) {
+	sysDir := t.TempDir()
+
+	for devNum, devName := range map[string]string{
+		"252:0": "vda",
+		"252:1": "vda1",
+		"259:3": "nvme0n1p2",
+	} {
+		dir := filepath.Join(sysDir, "dev", "block", devNum)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		uevent := "MAJOR=0\nMINOR=0\nDEVNAME=" + devName + "\nDEVTYPE=partition\n"
+		if err := os.WriteFile(filepath.Join(dir, "uevent"), []byte(uevent), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node, err := devices.LookupBlock(sysDir, "/dev/vda1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := devices.Node{Name: "vda1", Mode: unix.S_IFBLK | 0o660, Major: 252, Minor: 1}
+	if *node != expected {
+		t.Errorf("expected %+v, got %+v", expected, *node)
+	}
+
+	if _, err := devices.LookupBlock(sysDir, "sda"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
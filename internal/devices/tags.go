@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package devices creates static device nodes, for kernels built without
+// devtmpfs, and resolves the tags devices may be given as (eg. LABEL=data).
+package devices
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// deviceTags map the tags accepted in place of a device path (as for the
+// kernel's root= parameter) to the udev maintained symlink directories.
+var deviceTags = map[string]string{
+	"UUID":      "/dev/disk/by-uuid",
+	"LABEL":     "/dev/disk/by-label",
+	"PARTUUID":  "/dev/disk/by-partuuid",
+	"PARTLABEL": "/dev/disk/by-partlabel",
+}
+
+// Tag returns the (upper case) tag and its value, if device is given as a
+// tag (eg. LABEL=data).
+func Tag(device string) (string, string, bool) {
+	tag, value, ok := strings.Cut(device, "=")
+	if !ok {
+		return "", "", false
+	}
+
+	tag = strings.ToUpper(tag)
+	if _, ok := deviceTags[tag]; !ok {
+		return "", "", false
+	}
+
+	return tag, value, true
+}
+
+// Path returns the path of a device given either as a path, or as a tag (eg.
+// LABEL=data).
+func Path(device string) string {
+	if tag, value, ok := Tag(device); ok {
+		return filepath.Join(deviceTags[tag], value)
+	}
+
+	return device
+}
@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dmcrypt maps block devices with dm-crypt, using the device-mapper
+// ioctls directly (so cryptsetup isn't needed early in boot).
+package dmcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/immutos/matchstick/internal/devices"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultControl is the device-mapper control device.
+const DefaultControl = "/dev/mapper/control"
+
+// Cipher is the cipher used for ephemeral mappings.
+const Cipher = "aes-xts-plain64"
+
+// keySize is the size of the (XTS, so double length AES-256) key in bytes.
+const keySize = 64
+
+const (
+	// sizeof(struct dm_ioctl)
+	headerSize = 312
+	// sizeof(struct dm_target_spec)
+	targetSpecSize = 40
+)
+
+// Path returns the path of the mapped device.
+func Path(name string) string {
+	return filepath.Join("/dev/mapper", name)
+}
+
+// OpenEphemeral maps device as name with dm-crypt, using a random key that's
+// never stored (so the data written to the mapped device is unrecoverable
+// once it's closed, or the machine is powered off). It returns the path of
+// the mapped device.
+func OpenEphemeral(name, device string) (string, error) {
+	sectors, err := deviceSectors(device)
+	if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	defer clear(key)
+
+	params := cryptParams(key, device)
+	defer clear(params)
+
+	control, err := openControl()
+	if err != nil {
+		return "", err
+	}
+	defer control.Close()
+
+	// A mapping left behind by an earlier attempt (eg. before an emergency
+	// shell re-executed matchstick) is useless without its key.
+	_, _ = dmIoctl(control, unix.DM_DEV_REMOVE, name, 0, 0, nil)
+
+	hdr, err := dmIoctl(control, unix.DM_DEV_CREATE, name, 0, 0, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create mapping: %w", err)
+	}
+
+	table := cryptTable(sectors, params)
+	defer clear(table)
+
+	if _, err := dmIoctl(control, unix.DM_TABLE_LOAD, name, 1, 0, table); err != nil {
+		_, _ = dmIoctl(control, unix.DM_DEV_REMOVE, name, 0, 0, nil)
+		return "", fmt.Errorf("failed to load crypt table (is dm_crypt loaded?): %w", err)
+	}
+
+	// Resuming the device activates the loaded table.
+	if _, err := dmIoctl(control, unix.DM_DEV_SUSPEND, name, 0, 0, nil); err != nil {
+		_, _ = dmIoctl(control, unix.DM_DEV_REMOVE, name, 0, 0, nil)
+		return "", fmt.Errorf("failed to activate mapping: %w", err)
+	}
+
+	// Without udev, nothing else will create the node.
+	dev := binary.NativeEndian.Uint64(hdr[40:48])
+	node := devices.Node{
+		Name:  filepath.Join("mapper", name),
+		Mode:  unix.S_IFBLK | 0o600,
+		Major: unix.Major(dev),
+		Minor: unix.Minor(dev),
+	}
+	if err := devices.Create("/dev", []devices.Node{node}); err != nil {
+		return "", err
+	}
+
+	return Path(name), nil
+}
+
+// Close removes the mapping.
+func Close(name string) error {
+	control, err := openControl()
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+
+	if _, err := dmIoctl(control, unix.DM_DEV_REMOVE, name, 0, 0, nil); err != nil {
+		return err
+	}
+
+	if err := os.Remove(Path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// openControl opens the device-mapper control device, creating its node if
+// necessary.
+func openControl() (*os.File, error) {
+	node := devices.Node{Name: "mapper/control", Mode: unix.S_IFCHR | 0o600, Major: 10, Minor: 236}
+	if err := devices.Create("/dev", []devices.Node{node}); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(DefaultControl, os.O_RDWR, 0)
+}
+
+// deviceSectors returns the size of a block device in 512 byte sectors.
+func deviceSectors(device string) (uint64, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var size uint64
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, fmt.Errorf("failed to get size of %s: %w", device, errno)
+	}
+
+	if size < 512 {
+		return 0, fmt.Errorf("%s is empty", device)
+	}
+
+	return size / 512, nil
+}
+
+// cryptParams returns the parameters of a crypt target, of the form
+// "<cipher> <key> <iv_offset> <device> <offset>".
+func cryptParams(key []byte, device string) []byte {
+	params := make([]byte, 0, len(Cipher)+2*len(key)+len(device)+8)
+	params = append(params, Cipher+" "...)
+	params = hex.AppendEncode(params, key)
+	params = append(params, " 0 "+device+" 0"...)
+
+	return params
+}
+
+// cryptTable returns a table (of a single struct dm_target_spec, followed by
+// its NUL terminated parameters) mapping sectors with a crypt target.
+func cryptTable(sectors uint64, params []byte) []byte {
+	// The next spec must be 8 byte aligned.
+	size := (targetSpecSize + len(params) + 1 + 7) &^ 7
+
+	table := make([]byte, size)
+	binary.NativeEndian.PutUint64(table[0:8], 0)
+	binary.NativeEndian.PutUint64(table[8:16], sectors)
+	binary.NativeEndian.PutUint32(table[20:24], uint32(size))
+	copy(table[24:40], "crypt")
+	copy(table[targetSpecSize:], params)
+
+	return table
+}
+
+// dmIoctl issues a device-mapper ioctl on the named device, with data
+// following the header. It returns the header (and data) written by the
+// kernel.
+func dmIoctl(control *os.File, req uint, name string, targets, flags uint32, data []byte) ([]byte, error) {
+	buf := header(name, targets, flags, len(data))
+	buf = append(buf, data...)
+	defer clear(buf)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, control.Fd(), uintptr(req), uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return bytes.Clone(buf[:headerSize]), nil
+}
+
+// header returns a struct dm_ioctl, for dataSize bytes of data.
+func header(name string, targets, flags uint32, dataSize int) []byte {
+	hdr := make([]byte, headerSize, headerSize+dataSize)
+
+	// The interface version, the kernel rejects other major versions.
+	binary.NativeEndian.PutUint32(hdr[0:4], 4)
+	binary.NativeEndian.PutUint32(hdr[12:16], uint32(headerSize+dataSize))
+	binary.NativeEndian.PutUint32(hdr[16:20], headerSize)
+	binary.NativeEndian.PutUint32(hdr[20:24], targets)
+	binary.NativeEndian.PutUint32(hdr[28:32], flags)
+	copy(hdr[48:48+unix.DM_NAME_LEN-1], name)
+
+	return hdr
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dmcrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	hdr := header("matchstick-data", 1, 0, 48)
+
+	if len(hdr) != headerSize {
+		t.Fatalf("len(header) = %d, want %d", len(hdr), headerSize)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		want   uint32
+	}{
+		{"version", 0, 4},
+		{"data_size", 12, headerSize + 48},
+		{"data_start", 16, headerSize},
+		{"target_count", 20, 1},
+	} {
+		if got := binary.NativeEndian.Uint32(hdr[tt.offset:]); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if name := string(bytes.TrimRight(hdr[48:176], "\x00")); name != "matchstick-data" {
+		t.Errorf("name = %q, want matchstick-data", name)
+	}
+}
+
+func TestCryptTable(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, keySize)
+
+	params := cryptParams(key, "/dev/vda2")
+	if want := Cipher + " " + strings.Repeat("ab", keySize) + " 0 /dev/vda2 0"; string(params) != want {
+		t.Errorf("params = %q, want %q", params, want)
+	}
+
+	table := cryptTable(2048, params)
+
+	if len(table)%8 != 0 || len(table) < targetSpecSize+len(params)+1 {
+		t.Fatalf("unexpected table size %d", len(table))
+	}
+
+	if sectors := binary.NativeEndian.Uint64(table[8:16]); sectors != 2048 {
+		t.Errorf("length = %d, want 2048", sectors)
+	}
+
+	if next := binary.NativeEndian.Uint32(table[20:24]); int(next) != len(table) {
+		t.Errorf("next = %d, want %d", next, len(table))
+	}
+
+	if target := string(bytes.TrimRight(table[24:40], "\x00")); target != "crypt" {
+		t.Errorf("target_type = %q, want crypt", target)
+	}
+
+	if got := string(bytes.TrimRight(table[targetSpecSize:], "\x00")); got != string(params) {
+		t.Errorf("table params = %q, want %q", got, params)
+	}
+}
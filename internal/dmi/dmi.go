@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dmi reads strings from the SMBIOS/DMI tables exposed by the kernel.
+package dmi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	entriesPath   = "/sys/firmware/dmi/entries"
+	oemStringType = 11
+)
+
+// OEMStrings returns the strings contained in all SMBIOS type 11 (OEM Strings)
+// structures. Hypervisors typically allow these to be set per virtual machine,
+// eg. QEMU's "-smbios type=11,value=...".
+func OEMStrings() ([]string, error) {
+	entries, err := filepath.Glob(filepath.Join(entriesPath, fmt.Sprintf("%d-*", oemStringType)))
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	for _, entry := range entries {
+		raw, err := os.ReadFile(filepath.Join(entry, "raw"))
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := parseOEMStrings(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", entry, err)
+		}
+
+		strs = append(strs, s...)
+	}
+
+	return strs, nil
+}
+
+// AsMap returns the OEM strings of the form "key=value" as a map.
+func AsMap() (map[string]string, error) {
+	strs, err := OEMStrings()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string)
+	for _, s := range strs {
+		if key, value, ok := strings.Cut(s, "="); ok {
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return m, nil
+}
+
+// parseOEMStrings parses a raw type 11 structure. The structure consists of a
+// formatted area (type, length, handle and a string count) followed by a set
+// of NUL terminated strings, itself terminated by an additional NUL.
+func parseOEMStrings(raw []byte) ([]string, error) {
+	if len(raw) < 5 {
+		return nil, errors.New("structure too short")
+	}
+
+	if raw[0] != oemStringType {
+		return nil, fmt.Errorf("unexpected structure type %d", raw[0])
+	}
+
+	length := int(raw[1])
+	if length < 5 || length > len(raw) {
+		return nil, fmt.Errorf("invalid structure length %d", length)
+	}
+
+	count := int(raw[4])
+
+	strs := make([]string, 0, count)
+	for rest := raw[length:]; len(strs) < count; {
+		i := bytes.IndexByte(rest, 0)
+		if i <= 0 {
+			break
+		}
+
+		strs = append(strs, string(rest[:i]))
+		rest = rest[i+1:]
+	}
+
+	if len(strs) != count {
+		return nil, fmt.Errorf("expected %d strings, found %d", count, len(strs))
+	}
+
+	return strs, nil
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOEMStrings(t *testing.T) {
+	raw := []byte{oemStringType, 5, 0x00, 0x01, 2}
+	raw = append(raw, "matchstick.config_url=https://example.com/config.json\x00"...)
+	raw = append(raw, "matchstick.config_sha256=abcd\x00\x00"...)
+
+	got, err := parseOEMStrings(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"matchstick.config_url=https://example.com/config.json",
+		"matchstick.config_sha256=abcd",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOEMStrings() = %v, want %v", got, want)
+	}
+
+	if _, err := parseOEMStrings(raw[:len(raw)-20]); err == nil {
+		t.Error("expected error for truncated structure")
+	}
+}
@@ -0,0 +1,134 @@
+//go:build e2e
+
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package e2e_test
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/e2e"
+)
+
+func TestBootVolatile(t *testing.T) {
+	h := e2e.New(t)
+
+	r, err := h.Boot(context.Background(), []string{"matchstick.volatile=true", "matchstick.dirs=/etc,/var"})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, r.Output)
+	}
+
+	if m := r.Mount("/mnt/data"); m == nil || m.FSType != "tmpfs" {
+		t.Errorf("expected a tmpfs data mount, got %+v", m)
+	}
+
+	for _, dir := range []string{"/etc", "/var"} {
+		m := r.Mount(dir)
+		if m == nil || m.FSType != "overlay" || !strings.Contains(m.SuperOptions, "upperdir=/mnt/data"+dir) {
+			t.Errorf("expected an overlay on %s, got %+v", dir, m)
+		}
+	}
+
+	if r.Mount("/srv") != nil {
+		t.Error("unexpected overlay on /srv")
+	}
+
+	if !reflect.DeepEqual(r.Argv, []string{e2e.InitPath}) {
+		t.Errorf("Argv = %q", r.Argv)
+	}
+}
+
+func TestBootPersistent(t *testing.T) {
+	h := e2e.New(t)
+
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		t.Skip("mkfs.ext4 is unavailable")
+	}
+
+	disk, err := e2e.NewDisk(t.TempDir(), 64, "ext4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := h.Boot(context.Background(), []string{
+		"matchstick.data=/dev/vda",
+		"matchstick.datafstype=ext4",
+		"matchstick.modules=virtio_pci,virtio_blk",
+		"matchstick.dirs=/etc",
+	}, disk)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, r.Output)
+	}
+
+	if m := r.Mount("/mnt/data"); m == nil || m.FSType != "ext4" || m.Source != "/dev/vda" {
+		t.Errorf("expected /dev/vda on the data mount, got %+v", m)
+	}
+
+	if m := r.Mount("/etc"); m == nil || m.FSType != "overlay" {
+		t.Errorf("expected an overlay on /etc, got %+v", m)
+	}
+}
+
+func TestBootDisabled(t *testing.T) {
+	h := e2e.New(t)
+
+	r, err := h.Boot(context.Background(), []string{"matchstick.disable=true"})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, r.Output)
+	}
+
+	if m := r.Mount("/mnt/data"); m != nil {
+		t.Errorf("unexpected data mount when disabled: %+v", m)
+	}
+
+	if m := r.Mount("/etc"); m != nil {
+		t.Errorf("unexpected overlay when disabled: %+v", m)
+	}
+}
+
+func TestBootArgs(t *testing.T) {
+	h := e2e.New(t)
+
+	r, err := h.Boot(context.Background(), []string{"matchstick.volatile=true", `matchstick.cmd_args="--e2e --verbose"`})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, r.Output)
+	}
+
+	if !reflect.DeepEqual(r.Argv, []string{e2e.InitPath, "--e2e", "--verbose"}) {
+		t.Errorf("Argv = %q", r.Argv)
+	}
+}
+
+func TestBootFailure(t *testing.T) {
+	h := e2e.New(t)
+
+	// Without a data device, setup fails (and the failure policy panics).
+	r, err := h.Boot(context.Background(), nil)
+	if err == nil {
+		t.Fatal("expected boot to fail")
+	}
+
+	if !strings.Contains(r.Output, "Kernel panic") {
+		t.Errorf("expected a kernel panic:\n%s", r.Output)
+	}
+}
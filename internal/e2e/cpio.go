@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// cpioEntry is a file in an initramfs.
+type cpioEntry struct {
+	mode fs.FileMode
+	// data is the contents of a regular file, or the target of a symlink.
+	data []byte
+	// major and minor are the device number of a character device.
+	major, minor int
+}
+
+// archive is the (newc format) cpio archive of an initramfs.
+type archive map[string]cpioEntry
+
+// addDir adds a directory (and its parents).
+func (a archive) addDir(name string) {
+	for name = path.Clean(name); name != "." && name != "/"; name = path.Dir(name) {
+		if _, ok := a[name]; !ok {
+			a[name] = cpioEntry{mode: fs.ModeDir | 0o755}
+		}
+	}
+}
+
+// addFile adds a regular file.
+func (a archive) addFile(name string, data []byte, perm fs.FileMode) {
+	a.addDir(path.Dir(name))
+	a[path.Clean(name)] = cpioEntry{mode: perm, data: data}
+}
+
+// addHostFile adds a copy of a file on the host.
+func (a archive) addHostFile(name, hostPath string, perm fs.FileMode) error {
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		return err
+	}
+
+	a.addFile(name, data, perm)
+
+	return nil
+}
+
+// addSymlink adds a symlink to target.
+func (a archive) addSymlink(name, target string) {
+	a.addDir(path.Dir(name))
+	a[path.Clean(name)] = cpioEntry{mode: fs.ModeSymlink | 0o777, data: []byte(target)}
+}
+
+// addCharDev adds a character device node.
+func (a archive) addCharDev(name string, perm fs.FileMode, major, minor int) {
+	a.addDir(path.Dir(name))
+	a[path.Clean(name)] = cpioEntry{mode: fs.ModeDevice | fs.ModeCharDevice | perm, major: major, minor: minor}
+}
+
+// writeTo writes the archive to w, parents before their children.
+func (a archive) writeTo(w io.Writer) error {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if err := writeCpioEntry(w, i+1, strings.TrimPrefix(name, "/"), a[name]); err != nil {
+			return err
+		}
+	}
+
+	return writeCpioEntry(w, 0, "TRAILER!!!", cpioEntry{})
+}
+
+func writeCpioEntry(w io.Writer, ino int, name string, e cpioEntry) error {
+	mode := uint32(e.mode.Perm())
+	switch {
+	case e.mode.IsDir():
+		mode |= 0o040000
+	case e.mode&fs.ModeSymlink != 0:
+		mode |= 0o120000
+	case e.mode&fs.ModeCharDevice != 0:
+		mode |= 0o020000
+	case name != "TRAILER!!!":
+		mode |= 0o100000
+	}
+
+	nlink := 1
+	if e.mode.IsDir() {
+		nlink = 2
+	}
+
+	hdr := fmt.Sprintf("070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, nlink, 0, len(e.data), 0, 0, e.major, e.minor, len(name)+1, 0)
+
+	buf := append([]byte(hdr), name...)
+	buf = append(buf, 0)
+	buf = pad(buf)
+	buf = append(buf, e.data...)
+	buf = pad(buf)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// pad pads b to a multiple of four bytes.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
@@ -0,0 +1,384 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package e2e boots matchstick as init in a qemu virtual machine, with a
+// minimal initramfs (busybox and a reporting init), so that early boot
+// behavior can be tested end-to-end.
+//
+// The end-to-end tests are only built with the e2e build tag, and are skipped
+// if qemu, a kernel or a static busybox are unavailable. The kernel (and its
+// module directory) can be set with the E2E_KERNEL and E2E_MODULES
+// environment variables, qemu with E2E_QEMU, and busybox with E2E_BUSYBOX.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/modules"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"golang.org/x/sys/unix"
+)
+
+// initScript is the init executed by matchstick.
+//
+//go:embed testdata/init.sh
+var initScript []byte
+
+// InitPath is where the reporting init is installed in the initramfs.
+const InitPath = "/sbin/e2e-init"
+
+// marker prefixes the section headers written by the reporting init.
+const marker = "=== matchstick-e2e: "
+
+// DefaultModules are the kernel modules (if not built-in) included in the
+// initramfs.
+var DefaultModules = []string{"overlay", "ext4", "virtio_pci", "virtio_blk"}
+
+// Harness boots virtual machines with matchstick as init.
+type Harness struct {
+	// QEMU is the qemu system emulator for the target architecture.
+	QEMU string
+	// Kernel is the kernel image booted.
+	Kernel string
+	// ModulesDir is the module directory of the kernel (if any).
+	ModulesDir string
+	// Busybox is a statically linked busybox binary.
+	Busybox string
+	// Matchstick is the matchstick binary.
+	Matchstick string
+	// Timeout is the maximum time a boot may take.
+	Timeout time.Duration
+
+	// initramfs is the path of the built initramfs.
+	initramfs string
+}
+
+// New returns a harness, building matchstick and the initramfs in a
+// temporary directory. The test is skipped if qemu, a kernel or busybox are
+// unavailable.
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		QEMU:       os.Getenv("E2E_QEMU"),
+		Kernel:     os.Getenv("E2E_KERNEL"),
+		ModulesDir: os.Getenv("E2E_MODULES"),
+		Busybox:    os.Getenv("E2E_BUSYBOX"),
+		Timeout:    2 * time.Minute,
+	}
+
+	if h.QEMU == "" {
+		h.QEMU = "qemu-system-" + qemuArch()
+	}
+
+	if h.Kernel == "" || h.ModulesDir == "" {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			t.Fatal(err)
+		}
+		release := unix.ByteSliceToString(uts.Release[:])
+
+		if h.Kernel == "" {
+			h.Kernel = "/boot/vmlinuz-" + release
+		}
+
+		if h.ModulesDir == "" {
+			h.ModulesDir = filepath.Join("/lib/modules", release)
+		}
+	}
+
+	if h.Busybox == "" {
+		h.Busybox = "busybox"
+	}
+
+	var err error
+	if h.QEMU, err = exec.LookPath(h.QEMU); err != nil {
+		t.Skipf("qemu is unavailable: %v", err)
+	}
+
+	if h.Busybox, err = exec.LookPath(h.Busybox); err != nil {
+		t.Skipf("busybox is unavailable: %v", err)
+	}
+
+	if err := unix.Access(h.Kernel, unix.R_OK); err != nil {
+		t.Skipf("kernel %s is unreadable: %v", h.Kernel, err)
+	}
+
+	dir := t.TempDir()
+
+	h.Matchstick = filepath.Join(dir, "matchstick")
+	if err := buildMatchstick(h.Matchstick); err != nil {
+		t.Fatal(err)
+	}
+
+	h.initramfs = filepath.Join(dir, "initramfs.cpio")
+	if err := h.buildInitramfs(h.initramfs); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// buildMatchstick builds a static matchstick binary.
+func buildMatchstick(out string) error {
+	cmd := exec.Command("go", "build", "-o", out, "github.com/immutos/matchstick")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build matchstick: %w: %s", err, output)
+	}
+
+	return nil
+}
+
+// buildInitramfs writes the initramfs: matchstick as /init, busybox, the
+// reporting init, and the kernel modules (if any).
+func (h *Harness) buildInitramfs(path string) error {
+	a := archive{}
+
+	if err := a.addHostFile("/init", h.Matchstick, 0o755); err != nil {
+		return err
+	}
+
+	if err := a.addHostFile("/bin/busybox", h.Busybox, 0o755); err != nil {
+		return err
+	}
+
+	for _, applet := range []string{"sh", "cat", "echo", "mount", "sync", "ls"} {
+		a.addSymlink("/bin/"+applet, "busybox")
+	}
+	a.addSymlink("/sbin/poweroff", "../bin/busybox")
+
+	a.addFile(InitPath, initScript, 0o755)
+
+	// Without a console, init would have nowhere to write to.
+	a.addCharDev("/dev/console", 0o600, 5, 1)
+
+	for _, dir := range []string{"/etc", "/home", "/root", "/srv", "/var", "/mnt/data", "/proc", "/sys", "/run", "/tmp"} {
+		a.addDir(dir)
+	}
+
+	if err := a.addModules(h.ModulesDir, DefaultModules); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := a.writeTo(&buf); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// addModules adds the named modules (and their dependencies) from the module
+// directory dir, along with the module indexes.
+func (a archive) addModules(dir string, names []string) error {
+	if _, err := os.Stat(filepath.Join(dir, "modules.dep")); err != nil {
+		// The kernel may have everything built-in.
+		return nil
+	}
+
+	loader := modules.NewLoader(dir)
+	// Modules loaded on the host are still needed by the guest.
+	loader.SysModuleDir = filepath.Join(dir, "nonexistent")
+
+	target := filepath.Join("/lib/modules", filepath.Base(dir))
+
+	for _, index := range []string{"modules.dep", "modules.builtin", "modules.alias"} {
+		if err := a.addHostFile(filepath.Join(target, index), filepath.Join(dir, index), 0o644); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		paths, err := loader.Resolve(name)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			if err := a.addHostFile(filepath.Join(target, rel), path, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Result is the outcome of booting a virtual machine.
+type Result struct {
+	// Output is everything written to the console.
+	Output string
+	// Argv is the argument vector init was executed with (nil if init was
+	// never executed).
+	Argv []string
+	// Mounts is the mount table seen by init.
+	Mounts []mountinfo.Mount
+}
+
+// Mount returns the last mount on target (nil if nothing is mounted on it).
+func (r *Result) Mount(target string) *mountinfo.Mount {
+	return mountinfo.Find(r.Mounts, target)
+}
+
+// Boot boots a virtual machine with the given (additional) kernel command
+// line parameters, and disks (raw images, attached as virtio block devices
+// in order, eg. /dev/vda).
+func (h *Harness) Boot(ctx context.Context, cmdline []string, disks ...string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, h.QEMU, h.qemuArgs(cmdline, disks)...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s: %w", h.Timeout, ctx.Err())
+	}
+
+	r, parseErr := ParseOutput(out.String())
+	if err != nil {
+		return r, fmt.Errorf("qemu failed: %w", err)
+	}
+
+	return r, parseErr
+}
+
+// qemuArgs returns the qemu arguments for a boot.
+func (h *Harness) qemuArgs(cmdline, disks []string) []string {
+	console := "ttyS0"
+	args := []string{"-m", "512M", "-nographic", "-no-reboot"}
+
+	switch runtime.GOARCH {
+	case "arm64":
+		console = "ttyAMA0"
+		args = append(args, "-M", "virt", "-cpu", "max")
+	case "riscv64":
+		args = append(args, "-M", "virt")
+	}
+
+	if unix.Access("/dev/kvm", unix.R_OK|unix.W_OK) == nil {
+		args = append(args, "-enable-kvm")
+	}
+
+	params := append([]string{
+		"console=" + console,
+		// Panicking (with the panic failure policy) ends the boot.
+		"panic=-1",
+		"matchstick.cmd=" + InitPath,
+		"matchstick.on_failure=panic",
+	}, cmdline...)
+
+	args = append(args, "-kernel", h.Kernel, "-initrd", h.initramfs, "-append", strings.Join(params, " "))
+
+	for _, disk := range disks {
+		args = append(args, "-drive", "file="+disk+",format=raw,if=virtio")
+	}
+
+	return args
+}
+
+// qemuArch returns the qemu name of the target architecture.
+func qemuArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// ParseOutput parses the console output of a boot.
+func ParseOutput(output string) (*Result, error) {
+	r := &Result{Output: output}
+
+	sections := make(map[string]string)
+
+	var section string
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r", ""), "\n") {
+		if name, ok := strings.CutPrefix(line, marker); ok {
+			section = strings.TrimSuffix(name, " ===")
+			sections[section] = ""
+			continue
+		}
+
+		if section != "" {
+			sections[section] += line + "\n"
+		}
+	}
+
+	if _, ok := sections["done"]; !ok {
+		return r, errors.New("init didn't complete")
+	}
+
+	r.Argv = strings.Fields(sections["argv"])
+
+	var err error
+	r.Mounts, err = mountinfo.Parse(strings.NewReader(sections["mountinfo"]))
+
+	return r, err
+}
+
+// NewDisk creates a raw disk image of the given size (in MiB) in dir,
+// formatted with mkfs.<fstype> (if fstype is set, the ext family of
+// filesystems is assumed).
+func NewDisk(dir string, size int64, fstype string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("disk-%d.img", time.Now().UnixNano()))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size << 20); err != nil {
+		return "", err
+	}
+
+	if fstype == "" {
+		return path, nil
+	}
+
+	if output, err := exec.Command("mkfs."+fstype, "-q", "-F", path).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to format disk: %w: %s", err, output)
+	}
+
+	return path, nil
+}
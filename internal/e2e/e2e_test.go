@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package e2e
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	a := archive{}
+	a.addFile("/sbin/init", []byte("#!/bin/sh\n"), 0o755)
+	a.addSymlink("/bin/sh", "busybox")
+	a.addCharDev("/dev/console", 0o600, 5, 1)
+
+	var buf bytes.Buffer
+	if err := a.writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	type header struct {
+		name         string
+		mode         uint64
+		size         uint64
+		major, minor uint64
+	}
+
+	var got []header
+	for b := buf.Bytes(); len(b) > 0; {
+		if string(b[:6]) != "070701" {
+			t.Fatalf("bad magic %q", b[:6])
+		}
+
+		field := func(i int) uint64 {
+			v, err := strconv.ParseUint(string(b[6+i*8:6+(i+1)*8]), 16, 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+
+		size, nameSize := field(6), field(11)
+		name := string(b[110 : 110+nameSize-1])
+		got = append(got, header{name, field(1), size, field(9), field(10)})
+
+		if name == "TRAILER!!!" {
+			break
+		}
+
+		b = b[len(pad(b[:110+nameSize])):]
+		b = b[len(pad(b[:size])):]
+	}
+
+	want := []header{
+		{"bin", 0o040755, 0, 0, 0},
+		{"bin/sh", 0o120777, 7, 0, 0},
+		{"dev", 0o040755, 0, 0, 0},
+		{"dev/console", 0o020600, 0, 5, 1},
+		{"sbin", 0o040755, 0, 0, 0},
+		{"sbin/init", 0o100755, 10, 0, 0},
+		{"TRAILER!!!", 0, 0, 0, 0},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	output := "[    0.512] Run /init as init process\r\n" +
+		"=== matchstick-e2e: argv ===\r\n" +
+		"/sbin/e2e-init single\r\n" +
+		"=== matchstick-e2e: mountinfo ===\r\n" +
+		"1 1 0:2 / / rw - rootfs none rw\r\n" +
+		"21 1 0:21 / /mnt/data rw,relatime - tmpfs tmpfs rw\r\n" +
+		`22 1 0:22 / /etc rw,relatime - overlay overlay rw,lowerdir=/etc,upperdir=/mnt/data/etc,workdir=/mnt/data/.etc-work` + "\r\n" +
+		`23 1 0:23 / /srv/my\040dir rw - tmpfs tmpfs rw` + "\r\n" +
+		"=== matchstick-e2e: done ===\r\n"
+
+	r, err := ParseOutput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Argv, []string{"/sbin/e2e-init", "single"}) {
+		t.Errorf("Argv = %q", r.Argv)
+	}
+
+	if m := r.Mount("/etc"); m == nil || m.FSType != "overlay" || m.SuperOptions != "rw,lowerdir=/etc,upperdir=/mnt/data/etc,workdir=/mnt/data/.etc-work" {
+		t.Errorf("unexpected /etc mount: %+v", m)
+	}
+
+	if m := r.Mount("/srv/my dir"); m == nil {
+		t.Error("expected escaped mountpoint to be decoded")
+	}
+
+	if r.Mount("/var") != nil {
+		t.Error("unexpected /var mount")
+	}
+
+	if _, err := ParseOutput("Kernel panic - not syncing\n"); err == nil {
+		t.Error("expected error when init didn't complete")
+	}
+}
@@ -0,0 +1,12 @@
+#!/bin/sh
+# The init executed by matchstick in end-to-end tests. It reports what init
+# would see on the console, and powers off the machine.
+
+echo "=== matchstick-e2e: argv ==="
+echo "$0 $*"
+echo "=== matchstick-e2e: mountinfo ==="
+cat /proc/self/mountinfo
+echo "=== matchstick-e2e: done ==="
+
+sync
+poweroff -f
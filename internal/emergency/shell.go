@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package emergency provides an interactive shell on the console, so that an
+// operator can diagnose (and fix) a system that failed to boot.
+package emergency
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultConsole is the path of the system console.
+const DefaultConsole = "/dev/console"
+
+// Shells is the list of shells to try, in order of preference. sulogin is
+// preferred as it requires the root password.
+var Shells = []string{"/sbin/sulogin", "/usr/sbin/sulogin", "/bin/sh", "/usr/bin/sh"}
+
+// ErrNoShell is returned when no (suitable) emergency shell is available.
+var ErrNoShell = errors.New("no emergency shell available")
+
+// FindShell returns the first available shell. If login is set, only sulogin
+// is accepted (so the shell requires authentication).
+func FindShell(login bool) (string, error) {
+	for _, sh := range Shells {
+		if login && filepath.Base(sh) != "sulogin" {
+			continue
+		}
+
+		if fi, err := os.Stat(sh); err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0 {
+			return sh, nil
+		}
+	}
+
+	return "", ErrNoShell
+}
+
+// Shell runs an interactive shell on the console (as the session leader with
+// the console as its controlling terminal), and waits for it to exit. If login
+// is set, only a shell that requires authentication is run.
+func Shell(console string, env []string, login bool) error {
+	sh, err := FindShell(login)
+	if err != nil {
+		return err
+	}
+
+	tty, err := os.OpenFile(console, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	cmd := exec.Command(sh)
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	cmd.Env = env
+	cmd.SysProcAttr = &unix.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+		Ctty:    0,
+	}
+
+	return cmd.Run()
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package environ constructs the environment init is executed with.
+package environ
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultPath is the PATH set if it is missing.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// defaults are set if they are missing (the kernel normally sets HOME and
+// TERM for init).
+var defaults = []string{
+	"PATH=" + DefaultPath,
+	"HOME=/",
+	"TERM=linux",
+}
+
+// kept are preserved when scrubbing the inherited environment: the terminal
+// type and the hints set by container managers (see systemd's container
+// interface).
+var kept = []string{"TERM", "container", "container_uuid", "NOTIFY_SOCKET"}
+
+// secretPatterns match the names of variables that likely hold secrets.
+var secretPatterns = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|CREDENTIAL|PRIVATE|API_?KEY|ACCESS_?KEY)`)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Build constructs an environment from the inherited one (which, if scrub is
+// set, is reduced to a few well known variables), the standard variables (if
+// missing) and the given key=value pairs (which take precedence). Invalid
+// pairs are skipped, and returned as an error.
+func Build(inherited []string, scrub bool, set []string) ([]string, error) {
+	var env []string
+	for _, kv := range inherited {
+		name, _, _ := strings.Cut(kv, "=")
+		if !scrub || slices.Contains(kept, name) {
+			env = setVar(env, kv)
+		}
+	}
+
+	for _, kv := range defaults {
+		name, _, _ := strings.Cut(kv, "=")
+		if lookup(env, name) < 0 {
+			env = append(env, kv)
+		}
+	}
+
+	var errs []error
+	for _, kv := range set {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || !nameRe.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid environment variable %q: expected NAME=value", Redact([]string{kv}, nil)[0]))
+			continue
+		}
+
+		env = setVar(env, kv)
+	}
+
+	return env, errors.Join(errs...)
+}
+
+// IsSecret returns true if the named variable is (likely) secret-bearing,
+// either by name or because it is listed in secrets.
+func IsSecret(name string, secrets []string) bool {
+	return slices.Contains(secrets, name) || secretPatterns.MatchString(name)
+}
+
+// Redact returns a copy of env with the values of secret-bearing variables
+// replaced, so it can be logged.
+func Redact(env []string, secrets []string) []string {
+	redacted := make([]string, len(env))
+	for i, kv := range env {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || IsSecret(name, secrets) {
+			kv = name + "=<redacted>"
+		}
+
+		redacted[i] = kv
+	}
+
+	return redacted
+}
+
+func setVar(env []string, kv string) []string {
+	name, _, _ := strings.Cut(kv, "=")
+	if i := lookup(env, name); i >= 0 {
+		env[i] = kv
+		return env
+	}
+
+	return append(env, kv)
+}
+
+func lookup(env []string, name string) int {
+	return slices.IndexFunc(env, func(kv string) bool {
+		return strings.HasPrefix(kv, name+"=")
+	})
+}
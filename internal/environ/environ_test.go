@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package environ_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/environ"
+)
+
+func TestBuild(t *testing.T) {
+	inherited := []string{"HOME=/", "TERM=vt100", "BOOT_IMAGE=/vmlinuz", "MATCHSTICK_DATA=/dev/vda2", "container=podman"}
+
+	env, err := environ.Build(inherited, false, []string{"APP_MODE=kiosk", "TERM=xterm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"HOME=/", "TERM=xterm", "BOOT_IMAGE=/vmlinuz", "MATCHSTICK_DATA=/dev/vda2", "container=podman",
+		"PATH=" + environ.DefaultPath, "APP_MODE=kiosk"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("Build() = %v, want %v", env, want)
+	}
+
+	env, err = environ.Build(inherited, true, []string{"APP_MODE=kiosk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"TERM=vt100", "container=podman", "PATH=" + environ.DefaultPath, "HOME=/", "APP_MODE=kiosk"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("Build(scrub) = %v, want %v", env, want)
+	}
+
+	env, err = environ.Build(nil, true, []string{"DB_PASSWORD", "1X=y", "OK=1"})
+	if err == nil {
+		t.Error("expected error for invalid variables")
+	}
+
+	if want := []string{"PATH=" + environ.DefaultPath, "HOME=/", "TERM=linux", "OK=1"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("Build(invalid) = %v, want %v", env, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	env := []string{"PATH=/bin", "DB_PASSWORD=hunter2", "GITHUB_TOKEN=ghp_x", "LICENSE=abc", "AWS_ACCESS_KEY_ID=AKIA"}
+
+	want := []string{"PATH=/bin", "DB_PASSWORD=<redacted>", "GITHUB_TOKEN=<redacted>", "LICENSE=<redacted>", "AWS_ACCESS_KEY_ID=<redacted>"}
+	if got := environ.Redact(env, []string{"LICENSE"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Redact() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package failure implements the policies applied when boot setup fails.
+package failure
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy determines what happens when setup fails.
+type Policy string
+
+const (
+	// Shell starts an emergency shell on the console, retrying boot once it
+	// exits.
+	Shell Policy = "shell"
+	// Reboot reboots the machine, with an exponential backoff between
+	// consecutive failures.
+	Reboot Policy = "reboot"
+	// Panic exits, causing the kernel to panic.
+	Panic Policy = "panic"
+	// Continue skips the failed operation (eg. an overlay) and continues
+	// booting in a degraded state.
+	Continue Policy = "continue"
+)
+
+// ParsePolicy parses a policy name.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case Shell, Reboot, Panic, Continue:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown failure policy %q", s)
+}
+
+// Corrupt determines what happens when the data filesystem is corrupt (it
+// can't be mounted, even after being checked and repaired).
+type Corrupt string
+
+const (
+	// CorruptShell handles the failure like any other, with the failure
+	// policy (by default, starting an emergency shell).
+	CorruptShell Corrupt = "shell"
+	// CorruptVolatile boots with a volatile data filesystem, leaving the
+	// corrupt one untouched.
+	CorruptVolatile Corrupt = "volatile"
+	// CorruptReformat archives what can be read from the data filesystem,
+	// recreates it, and boots as if for the first time.
+	CorruptReformat Corrupt = "reformat"
+)
+
+// ParseCorrupt parses a corrupt data filesystem policy name (an empty name
+// is CorruptShell).
+func ParseCorrupt(s string) (Corrupt, error) {
+	switch c := Corrupt(strings.ToLower(s)); c {
+	case "":
+		return CorruptShell, nil
+	case CorruptShell, CorruptVolatile, CorruptReformat:
+		return c, nil
+	}
+
+	return "", fmt.Errorf("unknown corrupt data filesystem policy %q", s)
+}
+
+// MaxBackoff is the maximum delay before rebooting.
+const MaxBackoff = 10 * time.Minute
+
+// Backoff returns the delay before rebooting after the given number of
+// consecutive failures (including the current one).
+func Backoff(failures int, base time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > MaxBackoff {
+		delay = MaxBackoff
+	}
+
+	return delay
+}
+
+// ReadCount reads a consecutive failure count. A missing or malformed count
+// is treated as zero.
+func ReadCount(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// WriteCount records a consecutive failure count.
+func WriteCount(path string, n int) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(n)+"\n"), 0o644)
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package failure_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/failure"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"shell", "reboot", "panic", "Continue"} {
+		if _, err := failure.ParsePolicy(s); err != nil {
+			t.Errorf("ParsePolicy(%q): %v", s, err)
+		}
+	}
+
+	if _, err := failure.ParsePolicy("halt"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestParseCorrupt(t *testing.T) {
+	for _, s := range []string{"shell", "volatile", "Reformat"} {
+		if _, err := failure.ParseCorrupt(s); err != nil {
+			t.Errorf("ParseCorrupt(%q): %v", s, err)
+		}
+	}
+
+	if _, err := failure.ParseCorrupt("reboot"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 10 * time.Second},
+		{failures: 1, want: 10 * time.Second},
+		{failures: 2, want: 20 * time.Second},
+		{failures: 4, want: 80 * time.Second},
+		{failures: 100, want: failure.MaxBackoff},
+	} {
+		if got := failure.Backoff(tt.failures, 10*time.Second); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures")
+
+	if n := failure.ReadCount(path); n != 0 {
+		t.Errorf("ReadCount() = %d, want 0", n)
+	}
+
+	if err := failure.WriteCount(path, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := failure.ReadCount(path); n != 3 {
+		t.Errorf("ReadCount() = %d, want 3", n)
+	}
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package fsprobe detects the filesystem on a block device by looking for
+// the magic numbers in its superblock.
+package fsprobe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned if no known filesystem was found.
+var ErrNotFound = errors.New("no known filesystem found")
+
+// signature identifies a filesystem by the magic number at an offset.
+type signature struct {
+	fstype string
+	match  func(r io.ReaderAt) bool
+}
+
+// signatures are the supported filesystems. ext2 and ext3 are reported as
+// ext4, as the ext4 driver mounts them too.
+var signatures = []signature{
+	{"ext4", magic(0x438, []byte{0x53, 0xef})},
+	{"xfs", magic(0, []byte("XFSB"))},
+	{"btrfs", magic(0x10040, []byte("_BHRfS_M"))},
+	{"f2fs", magic(0x400, binary.LittleEndian.AppendUint32(nil, 0xf2f52010))},
+	{"vfat", vfat},
+}
+
+// Probe returns the filesystem type of the device at path. If more than one
+// filesystem is found (eg. as a disk was reformatted without wiping the old
+// signatures), an error listing them is returned.
+func Probe(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	found := Detect(f)
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%s: %w (supported: %s)", path, ErrNotFound, strings.Join(Supported(), ", "))
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%s: found multiple filesystems (%s), specify datafstype", path, strings.Join(found, ", "))
+	}
+}
+
+// Detect returns the filesystem types whose signatures are found.
+func Detect(r io.ReaderAt) []string {
+	var found []string
+	for _, sig := range signatures {
+		if sig.match(r) {
+			found = append(found, sig.fstype)
+		}
+	}
+
+	return found
+}
+
+// Supported returns the filesystem types that can be detected.
+func Supported() []string {
+	fstypes := make([]string, len(signatures))
+	for i, sig := range signatures {
+		fstypes[i] = sig.fstype
+	}
+
+	return fstypes
+}
+
+// magic matches the bytes at offset.
+func magic(offset int64, want []byte) func(r io.ReaderAt) bool {
+	return func(r io.ReaderAt) bool {
+		got := make([]byte, len(want))
+		if _, err := r.ReadAt(got, offset); err != nil {
+			return false
+		}
+
+		return bytes.Equal(got, want)
+	}
+}
+
+// vfat matches a FAT boot sector (the boot signature, along with a FAT12/16
+// or FAT32 filesystem type string).
+func vfat(r io.ReaderAt) bool {
+	return magic(0x1fe, []byte{0x55, 0xaa})(r) &&
+		(magic(0x36, []byte("FAT"))(r) || magic(0x52, []byte("FAT32"))(r))
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fsprobe_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/fsprobe"
+)
+
+func TestProbe(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		name  string
+		magic map[int64]string
+		want  string
+	}{
+		{"ext4", map[int64]string{0x438: "\x53\xef"}, "ext4"},
+		{"xfs", map[int64]string{0: "XFSB"}, "xfs"},
+		{"btrfs", map[int64]string{0x10040: "_BHRfS_M"}, "btrfs"},
+		{"f2fs", map[int64]string{0x400: "\x10\x20\xf5\xf2"}, "f2fs"},
+		{"fat16", map[int64]string{0x36: "FAT16   ", 0x1fe: "\x55\xaa"}, "vfat"},
+		{"fat32", map[int64]string{0x52: "FAT32   ", 0x1fe: "\x55\xaa"}, "vfat"},
+	} {
+		path := writeImage(t, dir, tt.name, tt.magic)
+
+		got, err := fsprobe.Probe(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: Probe() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeUnknown(t *testing.T) {
+	dir := t.TempDir()
+
+	// A boot signature alone isn't a FAT filesystem.
+	_, err := fsprobe.Probe(writeImage(t, dir, "empty", map[int64]string{0x1fe: "\x55\xaa"}))
+	if !errors.Is(err, fsprobe.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Leftover signatures from a previous filesystem.
+	_, err = fsprobe.Probe(writeImage(t, dir, "ambiguous", map[int64]string{0: "XFSB", 0x438: "\x53\xef"}))
+	if err == nil || !strings.Contains(err.Error(), "ext4, xfs") {
+		t.Errorf("expected an error listing both filesystems, got %v", err)
+	}
+
+	if _, err := fsprobe.Probe(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	if got, want := fsprobe.Supported(), []string{"ext4", "xfs", "btrfs", "f2fs", "vfat"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Supported() = %v, want %v", got, want)
+	}
+}
+
+func writeImage(t *testing.T, dir, name string, magic map[int64]string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(128 << 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for offset, data := range magic {
+		if _, err := f.WriteAt([]byte(data), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package fstools runs the userspace filesystem tools (mkfs and fsck) used to
+// create and repair the data filesystem.
+package fstools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+// Dirs are searched for the tools (PATH may not be set this early in boot).
+var Dirs = []string{"/usr/sbin", "/sbin", "/usr/bin", "/bin"}
+
+// ErrUnsupported is returned when a filesystem type can't be checked.
+var ErrUnsupported = errors.New("unsupported filesystem type")
+
+// mkfsArgs are the arguments passed to mkfs to create a filesystem without
+// prompting.
+var mkfsArgs = map[string][]string{
+	"ext2":  {"-q", "-F"},
+	"ext3":  {"-q", "-F"},
+	"ext4":  {"-q", "-F"},
+	"xfs":   {"-q", "-f"},
+	"btrfs": {"-q", "-f"},
+	"f2fs":  {"-q", "-f"},
+}
+
+// Find returns the path of the named executable in Dirs.
+func Find(name string) (string, error) {
+	for _, dir := range Dirs {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0 {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s not found (in %v)", name, Dirs)
+}
+
+// Identity is the label and UUID a filesystem is created with (empty values
+// are left to mkfs).
+type Identity struct {
+	Label string
+	UUID  string
+}
+
+// identityArgs returns the mkfs arguments setting the label and UUID of a
+// filesystem of type fstype.
+func identityArgs(fstype string, id Identity) ([]string, error) {
+	var args []string
+
+	if id.Label != "" {
+		switch fstype {
+		case "ext2", "ext3", "ext4", "xfs", "btrfs":
+			args = append(args, "-L", id.Label)
+		case "f2fs":
+			args = append(args, "-l", id.Label)
+		default:
+			return nil, fmt.Errorf("can't set the label of %s filesystems", fstype)
+		}
+	}
+
+	if id.UUID != "" {
+		switch fstype {
+		case "ext2", "ext3", "ext4", "btrfs", "f2fs":
+			args = append(args, "-U", id.UUID)
+		case "xfs":
+			args = append(args, "-m", "uuid="+id.UUID)
+		default:
+			return nil, fmt.Errorf("can't set the UUID of %s filesystems", fstype)
+		}
+	}
+
+	return args, nil
+}
+
+// Mkfs creates a filesystem on device with the given identity, destroying
+// its contents.
+func Mkfs(ctx context.Context, fstype, device string, id Identity) error {
+	name := "mkfs." + fstype
+
+	args, err := identityArgs(fstype, id)
+	if err != nil {
+		return err
+	}
+
+	path, err := Find(name)
+	if err != nil {
+		return err
+	}
+
+	args = append(slices.Clone(mkfsArgs[fstype]), args...)
+
+	cmd := exec.CommandContext(ctx, path, append(args, device)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, output)
+	}
+
+	return nil
+}
+
+// Result is the outcome of checking a filesystem.
+type Result int
+
+const (
+	// Clean means no errors were found.
+	Clean Result = iota
+	// Repaired means errors were found, and corrected.
+	Repaired
+	// Uncorrected means errors were found that couldn't be corrected.
+	Uncorrected
+)
+
+func (r Result) String() string {
+	switch r {
+	case Clean:
+		return "clean"
+	case Repaired:
+		return "repaired"
+	default:
+		return "uncorrected"
+	}
+}
+
+// Fsck checks device, automatically repairing any errors it can. It returns
+// ErrUnsupported for filesystem types it doesn't know how to check, and an
+// error if the checker itself failed.
+func Fsck(ctx context.Context, fstype, device string) (Result, error) {
+	switch fstype {
+	case "ext2", "ext3", "ext4":
+		return fsck(ctx, "fsck."+fstype, "-y", device)
+	case "vfat":
+		return fsck(ctx, "fsck.vfat", "-a", device)
+	case "xfs":
+		// xfs_repair doesn't report whether it repaired anything, so check
+		// without modifying first.
+		if result, err := fsck(ctx, "xfs_repair", "-n", device); err != nil || result == Clean {
+			return result, err
+		}
+
+		result, err := fsck(ctx, "xfs_repair", device)
+		if err == nil && result == Clean {
+			result = Repaired
+		}
+
+		return result, err
+	default:
+		return Uncorrected, fmt.Errorf("%w %q", ErrUnsupported, fstype)
+	}
+}
+
+// fsck runs a checker, interpreting its exit status following fsck(8): 0 is
+// clean, 1 (or 2) repaired, 4 uncorrected, and anything else an error.
+func fsck(ctx context.Context, name string, args ...string) (Result, error) {
+	path, err := Find(name)
+	if err != nil {
+		return Uncorrected, err
+	}
+
+	output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return Clean, nil
+	case !errors.As(err, &exitErr):
+		return Uncorrected, err
+	}
+
+	switch code := exitErr.ExitCode(); {
+	case code == 1 || code == 2:
+		return Repaired, nil
+	case code&4 != 0 && code&^(4|1|2) == 0:
+		return Uncorrected, nil
+	default:
+		return Uncorrected, fmt.Errorf("%s failed: %w: %s", name, err, output)
+	}
+}
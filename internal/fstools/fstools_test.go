@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fstools_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/fstools"
+)
+
+func TestFsck(t *testing.T) {
+	dir := t.TempDir()
+	fstools.Dirs = []string{dir}
+
+	// Stand-in checkers exiting with the given status.
+	for name, status := range map[string]string{
+		"fsck.ext2": "0",
+		"fsck.ext3": "1",
+		"fsck.ext4": "4",
+		"fsck.vfat": "8",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\nexit "+status+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for fstype, want := range map[string]fstools.Result{
+		"ext2": fstools.Clean,
+		"ext3": fstools.Repaired,
+		"ext4": fstools.Uncorrected,
+	} {
+		if got, err := fstools.Fsck(context.Background(), fstype, "/dev/null"); err != nil || got != want {
+			t.Errorf("Fsck(%s) = %v, %v, want %v", fstype, got, err, want)
+		}
+	}
+
+	if _, err := fstools.Fsck(context.Background(), "vfat", "/dev/null"); err == nil {
+		t.Error("expected error for an operational failure")
+	}
+
+	if _, err := fstools.Fsck(context.Background(), "btrfs", "/dev/null"); !errors.Is(err, fstools.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	if err := fstools.Mkfs(context.Background(), "ext4", "/dev/null", fstools.Identity{}); err == nil {
+		t.Error("expected error when mkfs is missing")
+	}
+}
+
+func TestMkfs(t *testing.T) {
+	dir := t.TempDir()
+	fstools.Dirs = []string{dir}
+
+	// Stand-ins recording their arguments.
+	args := filepath.Join(dir, "args")
+	for _, name := range []string{"mkfs.ext4", "mkfs.xfs"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\necho \"$@\" > "+args+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		fstype string
+		id     fstools.Identity
+		want   string
+	}{
+		{"ext4", fstools.Identity{}, "-q -F /dev/vdb\n"},
+		{"ext4", fstools.Identity{Label: "data"}, "-q -F -L data /dev/vdb\n"},
+		{"xfs", fstools.Identity{UUID: "0b0e5a2c-34a5-4d36-8d0a-52c0fe3f1a4b"}, "-q -f -m uuid=0b0e5a2c-34a5-4d36-8d0a-52c0fe3f1a4b /dev/vdb\n"},
+	} {
+		if err := fstools.Mkfs(context.Background(), tt.fstype, "/dev/vdb", tt.id); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != tt.want {
+			t.Errorf("mkfs.%s arguments = %q, want %q", tt.fstype, data, tt.want)
+		}
+	}
+
+	if err := fstools.Mkfs(context.Background(), "vfat", "/dev/vdb", fstools.Identity{UUID: "1234-ABCD"}); err == nil {
+		t.Error("expected error when the UUID can't be set")
+	}
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fstrim
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fitrim is _IOWR('X', 121, struct fstrim_range).
+const fitrim = 0xc0185879
+
+// Trim discards the unused blocks of the filesystem mounted at path,
+// returning the number of bytes trimmed.
+func Trim(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// struct fstrim_range { __u64 start; __u64 len; __u64 minlen; }
+	r := [3]uint64{0, math.MaxUint64, 0}
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fitrim, uintptr(unsafe.Pointer(&r)))
+	if errno != 0 {
+		return 0, fmt.Errorf("failed to trim %s: %w", path, errno)
+	}
+
+	// The kernel updates len with the number of bytes trimmed.
+	return r[1], nil
+}
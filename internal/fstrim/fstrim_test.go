@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fstrim_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/fstrim"
+)
+
+func TestTrim(t *testing.T) {
+	// Trimming a real filesystem would discard blocks of the machine running
+	// the tests, so only the error handling is tested.
+	if _, err := fstrim.Trim("/nonexistent"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
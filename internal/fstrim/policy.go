@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package fstrim discards the unused blocks of a mounted filesystem (as for
+// fstrim(8)), so flash storage can reclaim them.
+package fstrim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy determines how (and when) the data filesystem is trimmed.
+type Policy string
+
+const (
+	// Off never trims the data filesystem.
+	Off Policy = "off"
+	// Discard mounts the data filesystem with the discard option, so blocks
+	// are discarded as they are freed.
+	Discard Policy = "discard"
+	// Boot trims the data filesystem (in the background) after mounting it.
+	Boot Policy = "boot"
+	// Shutdown trims the data filesystem at shutdown (with
+	// matchstick-shutdown).
+	Shutdown Policy = "shutdown"
+)
+
+// ParsePolicy parses a trim policy, an empty string selects Off.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case "":
+		return Off, nil
+	case Off, Discard, Boot, Shutdown:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown trim policy %q", s)
+}
+
+// StampPath is the path (relative to the data filesystem) recording when it
+// was last trimmed.
+const StampPath = ".matchstick/last-trim"
+
+// Due returns true if the filesystem mounted at mount hasn't been trimmed
+// within interval (of now).
+func Due(mount string, interval time.Duration, now time.Time) bool {
+	data, err := os.ReadFile(filepath.Join(mount, StampPath))
+	if err != nil {
+		return true
+	}
+
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return true
+	}
+
+	last := time.Unix(secs, 0)
+
+	// Don't trust a stamp from the future (eg. before the clock was set).
+	return last.After(now) || now.Sub(last) >= interval
+}
+
+// Record records that the filesystem mounted at mount was trimmed at now.
+func Record(mount string, now time.Time) error {
+	path := filepath.Join(mount, StampPath)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(strconv.FormatInt(now.Unix(), 10)+"\n"), 0o644)
+}
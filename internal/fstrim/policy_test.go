@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fstrim_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/fstrim"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for s, want := range map[string]fstrim.Policy{
+		"":         fstrim.Off,
+		"off":      fstrim.Off,
+		"Discard":  fstrim.Discard,
+		"boot":     fstrim.Boot,
+		"shutdown": fstrim.Shutdown,
+	} {
+		if got, err := fstrim.ParsePolicy(s); err != nil || got != want {
+			t.Errorf("ParsePolicy(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+
+	if _, err := fstrim.ParsePolicy("always"); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
+
+func TestDue(t *testing.T) {
+	mount := t.TempDir()
+	now := time.Unix(1_700_000_000, 0)
+	week := 7 * 24 * time.Hour
+
+	if !fstrim.Due(mount, week, now) {
+		t.Error("expected a filesystem that was never trimmed to be due")
+	}
+
+	if err := fstrim.Record(mount, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if fstrim.Due(mount, week, now.Add(time.Hour)) {
+		t.Error("expected a recently trimmed filesystem not to be due")
+	}
+
+	if !fstrim.Due(mount, week, now.Add(week)) {
+		t.Error("expected the filesystem to be due after the interval")
+	}
+
+	if !fstrim.Due(mount, 0, now) {
+		t.Error("expected the filesystem to always be due without an interval")
+	}
+
+	if !fstrim.Due(mount, week, now.Add(-time.Hour)) {
+		t.Error("expected a stamp from the future to be ignored")
+	}
+
+	if err := os.WriteFile(filepath.Join(mount, fstrim.StampPath), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fstrim.Due(mount, week, now) {
+		t.Error("expected a malformed stamp to be ignored")
+	}
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package harden
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var capabilities = map[string]int{
+	"chown":              unix.CAP_CHOWN,
+	"dac_override":       unix.CAP_DAC_OVERRIDE,
+	"dac_read_search":    unix.CAP_DAC_READ_SEARCH,
+	"fowner":             unix.CAP_FOWNER,
+	"fsetid":             unix.CAP_FSETID,
+	"kill":               unix.CAP_KILL,
+	"setgid":             unix.CAP_SETGID,
+	"setuid":             unix.CAP_SETUID,
+	"setpcap":            unix.CAP_SETPCAP,
+	"linux_immutable":    unix.CAP_LINUX_IMMUTABLE,
+	"net_bind_service":   unix.CAP_NET_BIND_SERVICE,
+	"net_broadcast":      unix.CAP_NET_BROADCAST,
+	"net_admin":          unix.CAP_NET_ADMIN,
+	"net_raw":            unix.CAP_NET_RAW,
+	"ipc_lock":           unix.CAP_IPC_LOCK,
+	"ipc_owner":          unix.CAP_IPC_OWNER,
+	"sys_module":         unix.CAP_SYS_MODULE,
+	"sys_rawio":          unix.CAP_SYS_RAWIO,
+	"sys_chroot":         unix.CAP_SYS_CHROOT,
+	"sys_ptrace":         unix.CAP_SYS_PTRACE,
+	"sys_pacct":          unix.CAP_SYS_PACCT,
+	"sys_admin":          unix.CAP_SYS_ADMIN,
+	"sys_boot":           unix.CAP_SYS_BOOT,
+	"sys_nice":           unix.CAP_SYS_NICE,
+	"sys_resource":       unix.CAP_SYS_RESOURCE,
+	"sys_time":           unix.CAP_SYS_TIME,
+	"sys_tty_config":     unix.CAP_SYS_TTY_CONFIG,
+	"mknod":              unix.CAP_MKNOD,
+	"lease":              unix.CAP_LEASE,
+	"audit_write":        unix.CAP_AUDIT_WRITE,
+	"audit_control":      unix.CAP_AUDIT_CONTROL,
+	"setfcap":            unix.CAP_SETFCAP,
+	"mac_override":       unix.CAP_MAC_OVERRIDE,
+	"mac_admin":          unix.CAP_MAC_ADMIN,
+	"syslog":             unix.CAP_SYSLOG,
+	"wake_alarm":         unix.CAP_WAKE_ALARM,
+	"block_suspend":      unix.CAP_BLOCK_SUSPEND,
+	"audit_read":         unix.CAP_AUDIT_READ,
+	"perfmon":            unix.CAP_PERFMON,
+	"bpf":                unix.CAP_BPF,
+	"checkpoint_restore": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// lastCapPath is the highest capability supported by the running kernel.
+const lastCapPath = "/proc/sys/kernel/cap_last_cap"
+
+// ParseCapabilities parses capability names (with or without the "cap_"
+// prefix, eg. "cap_sys_admin" or "sys_admin"). The name "all" refers to every
+// capability supported by the kernel.
+func ParseCapabilities(names []string) ([]int, error) {
+	var caps []int
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "cap_")
+		if name == "all" {
+			for c := 0; c <= lastCap(); c++ {
+				caps = append(caps, c)
+			}
+			continue
+		}
+
+		c, ok := capabilities[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+
+		caps = append(caps, c)
+	}
+
+	return caps, nil
+}
+
+func lastCap() int {
+	if data, err := os.ReadFile(lastCapPath); err == nil {
+		if c, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return c
+		}
+	}
+
+	return unix.CAP_LAST_CAP
+}
+
+// DropCapabilities removes the capabilities from the bounding, ambient and
+// inheritable sets, so they can never be regained by executed programs. The
+// current thread keeps its effective capabilities (until it executes
+// another program).
+func DropCapabilities(caps []int) error {
+	if len(caps) == 0 {
+		return nil
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	for _, c := range caps {
+		// Capabilities unknown to the kernel are already absent.
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("failed to drop capability %d from the bounding set: %w", c, err)
+		}
+
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_LOWER, uintptr(c), 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("failed to drop ambient capability %d: %w", c, err)
+		}
+
+		data[c/32].Inheritable &^= 1 << (c % 32)
+	}
+
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+
+	return nil
+}
+
+// SetNoNewPrivs prevents executed programs from gaining privileges (eg. with
+// setuid binaries or file capabilities).
+func SetNoNewPrivs() error {
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
+
+var securebits = map[string]int{
+	"noroot":                      1 << 0,
+	"noroot_locked":               1 << 1,
+	"no_setuid_fixup":             1 << 2,
+	"no_setuid_fixup_locked":      1 << 3,
+	"keep_caps":                   1 << 4,
+	"keep_caps_locked":            1 << 5,
+	"no_cap_ambient_raise":        1 << 6,
+	"no_cap_ambient_raise_locked": 1 << 7,
+}
+
+// ParseSecurebits parses securebits names (eg. "noroot" or
+// "noroot_locked"), as described in capabilities(7).
+func ParseSecurebits(names []string) (int, error) {
+	var bits int
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "secbit_")
+
+		bit, ok := securebits[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown securebit %q", name)
+		}
+
+		bits |= bit
+	}
+
+	return bits, nil
+}
+
+// SetSecurebits sets the securebits of the current thread.
+func SetSecurebits(bits int) error {
+	return unix.Prctl(unix.PR_SET_SECUREBITS, uintptr(bits), 0, 0, 0)
+}
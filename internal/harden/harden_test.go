@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package harden
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := ParseCapabilities([]string{"cap_sys_admin", "NET_RAW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{unix.CAP_SYS_ADMIN, unix.CAP_NET_RAW}; !reflect.DeepEqual(caps, want) {
+		t.Errorf("ParseCapabilities() = %v, want %v", caps, want)
+	}
+
+	if caps, err := ParseCapabilities([]string{"all"}); err != nil || len(caps) < unix.CAP_BPF {
+		t.Errorf("ParseCapabilities(all) = %v, %v", caps, err)
+	}
+
+	if _, err := ParseCapabilities([]string{"sys_everything"}); err == nil {
+		t.Error("expected error for an unknown capability")
+	}
+}
+
+func TestParseSecurebits(t *testing.T) {
+	bits, err := ParseSecurebits([]string{"noroot", "secbit_noroot_locked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bits != 0b11 {
+		t.Errorf("ParseSecurebits() = %#x, want 0x3", bits)
+	}
+
+	if _, err := ParseSecurebits([]string{"keep_everything"}); err == nil {
+		t.Error("expected error for an unknown securebit")
+	}
+}
+
+func TestReadAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	if err := os.WriteFile(path, []byte("# Comments and blank lines are ignored.\nread\n\n  write\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ReadAllowlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"read", "write"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadAllowlist() = %v, want %v", names, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	prog, err := Filter([]string{"read", "write"}, Errno)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denied := seccompRetErrno | uint32(unix.EPERM)
+
+	for _, tt := range []struct {
+		arch, nr uint32
+		want     uint32
+	}{
+		{auditArch, syscalls["read"], seccompRetAllow},
+		{auditArch, syscalls["write"], seccompRetAllow},
+		{auditArch, syscalls["execve"], seccompRetAllow},
+		{auditArch, syscalls["reboot"], seccompRetAllow},
+		{auditArch, syscalls["mount"], denied},
+		{auditArch ^ 1, syscalls["read"], seccompRetKillProcess},
+	} {
+		if got := run(t, prog, tt.arch, tt.nr); got != tt.want {
+			t.Errorf("filter(arch=%#x, nr=%d) = %#x, want %#x", tt.arch, tt.nr, got, tt.want)
+		}
+	}
+
+	if _, err := Filter([]string{"frobnicate"}, Errno); err == nil {
+		t.Error("expected error for an unknown syscall")
+	}
+}
+
+// run interprets the (subset of) classic BPF used by Filter.
+func run(t *testing.T, prog []unix.SockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = map[uint32]uint32{seccompDataNr: nr, seccompDataArch: arch}[ins.K]
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x", ins.Code)
+		}
+	}
+
+	t.Fatal("filter fell off the end")
+	return 0
+}
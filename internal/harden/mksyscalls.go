@@ -0,0 +1,87 @@
+//go:build ignore
+
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// mksyscalls generates the seccomp syscall name tables, from the syscall
+// numbers in golang.org/x/sys/unix.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var arches = map[string]string{
+	"amd64":   "AUDIT_ARCH_X86_64",
+	"arm64":   "AUDIT_ARCH_AARCH64",
+	"riscv64": "AUDIT_ARCH_RISCV64",
+}
+
+var sysnumRe = regexp.MustCompile(`^\s+SYS_([A-Z0-9_]+)\s+=\s+\d+$`)
+
+func main() {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sys").Output()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir := filepath.Join(strings.TrimSpace(string(out)), "unix")
+
+	for arch, auditArch := range arches {
+		f, err := os.Open(filepath.Join(dir, "zsysnum_linux_"+arch+".go"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "// Code generated by mksyscalls.go; DO NOT EDIT.\n\n")
+		fmt.Fprintf(&buf, "package harden\n\nimport \"golang.org/x/sys/unix\"\n\n")
+		fmt.Fprintf(&buf, "const auditArch = unix.%s\n\n", auditArch)
+		fmt.Fprintf(&buf, "var syscalls = map[string]uint32{\n")
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if m := sysnumRe.FindStringSubmatch(scanner.Text()); m != nil {
+				fmt.Fprintf(&buf, "\t%q: unix.SYS_%s,\n", strings.ToLower(m[1]), m[1])
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		_ = f.Close()
+
+		fmt.Fprintf(&buf, "}\n")
+
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile("syscalls_linux_"+arch+".go", src, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
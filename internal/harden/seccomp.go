@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package harden reduces the privileges inherited by init (eg. for single
+// application appliances that don't need full root power), by dropping
+// capabilities, setting no_new_privs and securebits, and applying a seccomp
+// allowlist.
+//
+// Capabilities, securebits and seccomp filters are per-thread, so callers
+// must lock the OS thread and execute init from it.
+package harden
+
+//go:generate go run mksyscalls.go
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Action is the action taken when a syscall that isn't allowed is made.
+type Action string
+
+const (
+	// Errno fails the syscall with EPERM.
+	Errno Action = "errno"
+	// Kill kills the process.
+	Kill Action = "kill"
+	// Log allows the syscall, but logs it (to the audit log), for building an
+	// allowlist.
+	Log Action = "log"
+)
+
+// ParseAction parses a seccomp action.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(s)); a {
+	case Errno, Kill, Log:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown seccomp action %q", s)
+	}
+}
+
+// ReadAllowlist reads a newline separated list of syscall names.
+func ReadAllowlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names = append(names, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package harden
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Not (all) defined by golang.org/x/sys/unix.
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+
+	// x32 syscalls share the x86-64 audit arch, but have this bit set.
+	x32SyscallBit = 0x40000000
+
+	// Offsets within struct seccomp_data.
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// Filter compiles a seccomp filter allowing only the named syscalls (and
+// execve, so that init can be executed once it has been loaded, and sync,
+// reboot and exit_group, so that a failed exec can reboot). Syscalls for other
+// architectures are always killed.
+func Filter(names []string, action Action) ([]unix.SockFilter, error) {
+	if syscalls == nil {
+		return nil, fmt.Errorf("seccomp filters are unsupported on %s", runtime.GOARCH)
+	}
+
+	var ret uint32
+	switch action {
+	case Errno:
+		ret = seccompRetErrno | uint32(unix.EPERM)
+	case Kill:
+		ret = seccompRetKillProcess
+	case Log:
+		ret = seccompRetLog
+	default:
+		return nil, fmt.Errorf("unknown seccomp action %q", action)
+	}
+
+	allowed := []uint32{syscalls["execve"], syscalls["sync"], syscalls["reboot"], syscalls["exit_group"]}
+	for _, name := range names {
+		nr, ok := syscalls[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+
+		allowed = append(allowed, nr)
+	}
+
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+	}
+
+	if auditArch == unix.AUDIT_ARCH_X86_64 {
+		prog = append(prog,
+			jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, ret))
+	}
+
+	for _, nr := range allowed {
+		prog = append(prog,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+	}
+
+	return append(prog, stmt(unix.BPF_RET|unix.BPF_K, ret)), nil
+}
+
+// LoadFilter loads a seccomp filter into the current thread.
+func LoadFilter(prog []unix.SockFilter) error {
+	fprog := unix.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0)
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package health
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyTree copies a directory tree, preserving ownership, permissions,
+// timestamps, device nodes and extended attributes (so overlay whiteouts and
+// opaque directories survive the copy). Hard links are copied as separate
+// files.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			return err
+		}
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			// Device nodes (eg. whiteouts), fifos and sockets.
+			if err := unix.Mknod(target, st.Mode, int(st.Rdev)); err != nil {
+				return err
+			}
+		}
+
+		return copyMetadata(path, target, &st)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.ReadFrom(in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// copyMetadata copies the ownership, permissions, extended attributes and
+// timestamps of src to dst.
+func copyMetadata(src, dst string, st *unix.Stat_t) error {
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFLNK {
+		// After chown, which clears the setuid and setgid bits.
+		if err := unix.Chmod(dst, st.Mode&07777); err != nil {
+			return err
+		}
+	}
+
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+
+	times := []unix.Timespec{st.Atim, st.Mtim}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(src, buf); err != nil {
+		return err
+	}
+
+	for _, name := range splitNames(buf[:size]) {
+		n, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return err
+		}
+
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(src, name, value); err != nil {
+			return err
+		}
+
+		if err := unix.Lsetxattr(dst, name, value[:n], 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitNames splits a NUL separated list of extended attribute names.
+func splitNames(buf []byte) []string {
+	var names []string
+
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package health implements boot health checking: each boot is recorded as
+// pending on the data filesystem until it's marked healthy, and if too many
+// consecutive boots are never marked healthy, the overlay upper layers are
+// rolled back to a snapshot taken when the system was last healthy.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/failure"
+)
+
+const (
+	// PendingPath is the path (relative to the data filesystem) of the count
+	// of consecutive boots that haven't been marked healthy.
+	PendingPath = ".matchstick/boot-pending"
+	// SnapshotDir is the directory (relative to the data filesystem) holding
+	// the snapshot of the upper layers taken when the system was last
+	// healthy.
+	SnapshotDir = ".matchstick/snapshot"
+)
+
+// Pending returns the number of consecutive boots (on the data filesystem
+// mounted at mount) that haven't been marked healthy.
+func Pending(mount string) int {
+	return failure.ReadCount(filepath.Join(mount, PendingPath))
+}
+
+// MarkPending records that another boot has started (and hasn't yet been
+// marked healthy), returning the number of consecutive pending boots.
+func MarkPending(mount string) (int, error) {
+	path := filepath.Join(mount, PendingPath)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+
+	n := failure.ReadCount(path) + 1
+	return n, failure.WriteCount(path, n)
+}
+
+// MarkHealthy marks the current boot as healthy.
+func MarkHealthy(mount string) error {
+	err := os.Remove(filepath.Join(mount, PendingPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Snapshot replaces the snapshot with a copy of the upper directories (which
+// must be within the data filesystem mounted at mount).
+func Snapshot(mount string, upperDirs []string) error {
+	dir := filepath.Join(mount, SnapshotDir)
+	tmp := dir + ".tmp"
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	for _, upperDir := range upperDirs {
+		// An upper directory within another has already been copied with it.
+		if within(upperDir, upperDirs) {
+			continue
+		}
+
+		rel, err := relative(mount, upperDir)
+		if err != nil {
+			return err
+		}
+
+		if err := copyTree(upperDir, filepath.Join(tmp, rel)); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", upperDir, err)
+		}
+	}
+
+	// Ensure an (empty) snapshot is still taken if nothing is overlaid.
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dir)
+}
+
+// within returns true if dir is below one of dirs.
+func within(dir string, dirs []string) bool {
+	for _, d := range dirs {
+		if rel, err := filepath.Rel(d, dir); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasSnapshot returns true if a snapshot has been taken on the data
+// filesystem mounted at mount.
+func HasSnapshot(mount string) bool {
+	fi, err := os.Stat(filepath.Join(mount, SnapshotDir))
+	return err == nil && fi.IsDir()
+}
+
+// Restore rolls back an upper directory (and clears its work directory) from
+// the snapshot. It returns false (without changing anything) if the upper
+// directory isn't in the snapshot. The overlay must not be mounted.
+func Restore(mount, upperDir, workDir string) (bool, error) {
+	rel, err := relative(mount, upperDir)
+	if err != nil {
+		return false, err
+	}
+
+	src := filepath.Join(mount, SnapshotDir, rel)
+	if _, err := os.Lstat(src); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	// Copy the snapshot alongside the upper directory first, so an
+	// interrupted restore leaves the upper directory intact.
+	tmp := upperDir + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return false, err
+	}
+
+	if err := copyTree(src, tmp); err != nil {
+		return false, err
+	}
+
+	if err := os.RemoveAll(upperDir); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(tmp, upperDir); err != nil {
+		return false, err
+	}
+
+	if workDir != "" {
+		if err := os.RemoveAll(workDir); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// relative returns the path of dir relative to the data filesystem mounted at
+// mount.
+func relative(mount, dir string) (string, error) {
+	rel, err := filepath.Rel(mount, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || strings.HasPrefix(rel, ".matchstick") {
+		return "", fmt.Errorf("%s is not an upper directory on the data filesystem %s", dir, mount)
+	}
+
+	return rel, nil
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package health_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/health"
+	"golang.org/x/sys/unix"
+)
+
+func TestPending(t *testing.T) {
+	mount := t.TempDir()
+
+	if n := health.Pending(mount); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+
+	for want := 1; want <= 2; want++ {
+		n, err := health.MarkPending(mount)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != want {
+			t.Errorf("MarkPending() = %d, want %d", n, want)
+		}
+	}
+
+	if err := health.MarkHealthy(mount); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := health.Pending(mount); n != 0 {
+		t.Errorf("Pending() = %d after MarkHealthy, want 0", n)
+	}
+
+	// Marking an already healthy boot is a no-op.
+	if err := health.MarkHealthy(mount); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	mount := t.TempDir()
+	upper := filepath.Join(mount, "etc")
+	work := filepath.Join(mount, ".etc-work")
+
+	mustWrite(t, filepath.Join(upper, "hostname"), "good\n", 0o644)
+	mustWrite(t, filepath.Join(upper, "app/secret"), "s3cret\n", 0o600)
+	if err := os.Symlink("hostname", filepath.Join(upper, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An overlay whiteout (creating one requires CAP_MKNOD).
+	whiteout := unix.Mknod(filepath.Join(upper, "removed"), unix.S_IFCHR, 0) == nil
+
+	if health.HasSnapshot(mount) {
+		t.Error("unexpected snapshot")
+	}
+
+	if err := health.Snapshot(mount, []string{upper}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !health.HasSnapshot(mount) {
+		t.Fatal("expected a snapshot")
+	}
+
+	// Break things.
+	mustWrite(t, filepath.Join(upper, "hostname"), "bad\n", 0o644)
+	mustWrite(t, filepath.Join(upper, "broken"), "", 0o644)
+	mustWrite(t, filepath.Join(work, "work/stale"), "", 0o644)
+
+	restored, err := health.Restore(mount, upper, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !restored {
+		t.Fatal("expected the upper directory to be restored")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(upper, "hostname")); err != nil || string(data) != "good\n" {
+		t.Errorf("hostname = %q (%v), want good", data, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(upper, "app/secret")); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected secret: %v %v", fi, err)
+	}
+
+	if link, err := os.Readlink(filepath.Join(upper, "link")); err != nil || link != "hostname" {
+		t.Errorf("link = %q (%v), want hostname", link, err)
+	}
+
+	if fi, err := os.Lstat(filepath.Join(upper, "removed")); whiteout && (err != nil || fi.Mode()&os.ModeCharDevice == 0) {
+		t.Errorf("expected whiteout to be restored: %v %v", fi, err)
+	}
+
+	for _, path := range []string{filepath.Join(upper, "broken"), work} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed: %v", path, err)
+		}
+	}
+
+	// The snapshot is kept for later rollbacks.
+	if !health.HasSnapshot(mount) {
+		t.Error("expected the snapshot to be kept")
+	}
+
+	// Directories that weren't overlaid when the snapshot was taken are left
+	// alone.
+	if restored, err := health.Restore(mount, filepath.Join(mount, "srv"), ""); err != nil || restored {
+		t.Errorf("Restore() = %v, %v, want false", restored, err)
+	}
+
+	if err := health.Snapshot(mount, []string{"/etc"}); err == nil {
+		t.Error("expected error for an upper directory outside the data filesystem")
+	}
+}
+
+func TestSnapshotNested(t *testing.T) {
+	mount := t.TempDir()
+	parent := filepath.Join(mount, "var")
+	child := filepath.Join(parent, "lib")
+
+	mustWrite(t, filepath.Join(child, "state"), "ok\n", 0o644)
+
+	// The child's upper directory is copied along with its parent's.
+	for _, upperDirs := range [][]string{{parent, child}, {child, parent}} {
+		if err := health.Snapshot(mount, upperDirs); err != nil {
+			t.Fatalf("Snapshot(%v): %v", upperDirs, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(mount, health.SnapshotDir, "var/lib/state"))
+		if err != nil || string(data) != "ok\n" {
+			t.Errorf("state = %q (%v), want ok", data, err)
+		}
+	}
+}
+
+func mustWrite(t *testing.T, path, data string, perm os.FileMode) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(data), perm); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package hooks runs integrator supplied executables at fixed points during
+// boot.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/plan"
+)
+
+// DefaultDir is the default directory containing hook directories.
+const DefaultDir = "/etc/matchstick/hooks"
+
+// Stage identifies when a hook is run.
+type Stage string
+
+const (
+	// PreMount hooks run before the data filesystem is mounted.
+	PreMount Stage = "pre-mount"
+	// PostMount hooks run after the overlays have been mounted, immediately
+	// before init is executed.
+	PostMount Stage = "post-mount"
+)
+
+// validName matches the hook names that will be run (following run-parts),
+// so that editor backups and package manager leftovers are ignored.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Find returns the executables in the stage's hook directory (eg.
+// /etc/matchstick/hooks/pre-mount.d) in lexical order.
+func Find(dir string, stage Stage) ([]string, error) {
+	stageDir := filepath.Join(dir, string(stage)+".d")
+
+	entries, err := os.ReadDir(stageDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var hooks []string
+	for _, e := range entries {
+		if !validName.MatchString(e.Name()) {
+			continue
+		}
+
+		path := filepath.Join(stageDir, e.Name())
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		hooks = append(hooks, path)
+	}
+
+	sort.Strings(hooks)
+
+	return hooks, nil
+}
+
+// Stash copies the hooks into dir (which is created, and only accessible by
+// root), preserving their order, and returns the paths of the copies. This
+// pins the hooks as they were at the time, eg. before a writable overlay is
+// mounted on top of the directory containing them.
+func Stash(hooks []string, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	stashed := make([]string, len(hooks))
+	for i, hook := range hooks {
+		data, err := os.ReadFile(hook)
+		if err != nil {
+			return nil, err
+		}
+
+		stashed[i] = filepath.Join(dir, fmt.Sprintf("%02d-%s", i, filepath.Base(hook)))
+		if err := os.WriteFile(stashed[i], data, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	return stashed, nil
+}
+
+// Run executes each of the hooks in order, stopping at the first failure.
+func Run(ctx context.Context, stage Stage, hooks []string, env []string) error {
+	for _, hook := range hooks {
+		slog.Info("Running hook", slog.String("stage", string(stage)), slog.String("hook", hook))
+
+		cmd := exec.CommandContext(ctx, hook)
+		cmd.Env = append(append([]string{}, env...), "MATCHSTICK_STAGE="+string(stage))
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", stage, hook, err)
+		}
+	}
+
+	return nil
+}
+
+// Environ describes the resolved configuration to hooks. MATCHSTICK_NEW_ROOT
+// is where the root filesystem init will see is mounted (if it isn't "/"),
+// when running from an initramfs or overlaying the whole root filesystem.
+func Environ(opts *config.Options) []string {
+	var newRoot string
+	switch {
+	case opts.OverlayRoot:
+		newRoot = plan.RootOverlayDir
+	case opts.Root != "":
+		newRoot = opts.NewRoot
+	}
+
+	return []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"MATCHSTICK_DATA=" + opts.Data,
+		"MATCHSTICK_DATAFSTYPE=" + opts.DataFSType,
+		"MATCHSTICK_MOUNT=" + opts.Mount,
+		"MATCHSTICK_DIRS=" + strings.Join(opts.Dirs, ","),
+		"MATCHSTICK_VOLATILE=" + strconv.FormatBool(opts.Volatile),
+		"MATCHSTICK_CMD=" + opts.Cmd,
+		"MATCHSTICK_NEW_ROOT=" + newRoot,
+	}
+}
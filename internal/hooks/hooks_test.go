@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package hooks_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/hooks"
+)
+
+func TestFindAndRun(t *testing.T) {
+	dir := t.TempDir()
+	stageDir := filepath.Join(dir, "post-mount.d")
+	out := filepath.Join(dir, "out")
+
+	if err := os.MkdirAll(stageDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mode := range map[string]os.FileMode{
+		"20-second":      0o755,
+		"10-first":       0o755,
+		"30-not-exec":    0o644,
+		"40-backup.dpkg": 0o755,
+	} {
+		script := "#!/bin/sh\necho \"$MATCHSTICK_STAGE $MATCHSTICK_MOUNT $0\" >> " + out + "\n"
+		if err := os.WriteFile(filepath.Join(stageDir, name), []byte(script), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := hooks.Find(dir, hooks.PostMount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(stageDir, "10-first"), filepath.Join(stageDir, "20-second")}
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("Find() = %v, want %v", found, want)
+	}
+
+	env := hooks.Environ(&config.Options{Mount: "/mnt/data"})
+	if err := hooks.Run(context.Background(), hooks.PostMount, found, env); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "post-mount /mnt/data "+want[0] {
+		t.Errorf("unexpected hook output: %q", lines)
+	}
+
+	if found, err := hooks.Find(dir, hooks.PreMount); err != nil || len(found) != 0 {
+		t.Errorf("expected no pre-mount hooks, got %v (%v)", found, err)
+	}
+}
+
+func TestStash(t *testing.T) {
+	dir := t.TempDir()
+
+	hook := filepath.Join(dir, "hook")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	stashed, err := hooks.Stash([]string{hook, hook}, filepath.Join(dir, "stash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{filepath.Join(dir, "stash/00-hook"), filepath.Join(dir, "stash/01-hook")}; !reflect.DeepEqual(stashed, want) {
+		t.Errorf("stashed = %v, want %v", stashed, want)
+	}
+
+	// Changing the original doesn't affect the stashed copy.
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hooks.Run(context.Background(), hooks.PostMount, stashed, nil); err != nil {
+		t.Errorf("expected the stashed hooks to succeed: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package hostname validates and persists the system hostname.
+package hostname
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPath is where the hostname is persisted.
+const DefaultPath = "/etc/hostname"
+
+// maxLen is the maximum length of a hostname (HOST_NAME_MAX).
+const maxLen = 64
+
+// Validate checks that name is a valid hostname (a sequence of dot separated
+// labels of letters, digits and dashes).
+func Validate(name string) error {
+	if name == "" || len(name) > maxLen {
+		return fmt.Errorf("invalid hostname %q: must be between 1 and %d characters", name, maxLen)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("invalid hostname %q", name)
+		}
+
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("invalid hostname %q: invalid character %q", name, r)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Write persists name to path (eg. /etc/hostname), leaving the file untouched
+// if it is already up to date.
+func Write(path, name string) error {
+	data := []byte(name + "\n")
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
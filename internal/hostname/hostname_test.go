@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package hostname_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/hostname"
+)
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"thin01", "node-1.example.com", "A1"} {
+		if err := hostname.Validate(name); err != nil {
+			t.Errorf("expected %q to be valid: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "-node", "node-", "node..example", "node_1", "node 1", strings.Repeat("a", 65)} {
+		if err := hostname.Validate(name); err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hostname")
+
+	if err := os.WriteFile(path, []byte("localhost\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hostname.Write(path, "thin01"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "thin01\n" {
+		t.Errorf("expected thin01, got %q", data)
+	}
+}
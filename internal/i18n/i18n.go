@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package i18n provides a small message catalog for operator-facing console
+// messages. Log output is intentionally not localized.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultLang is the language used when no (supported) language is selected.
+const DefaultLang = "en"
+
+// Message identifies a localizable console message.
+type Message int
+
+const (
+	// MsgBootFailed is the headline of the failure summary.
+	MsgBootFailed Message = iota
+	// MsgFailureReason introduces the (untranslated) reason for a failure.
+	MsgFailureReason
+	// MsgSeeKernelLog points the operator towards the kernel log.
+	MsgSeeKernelLog
+	// MsgEmergencyShell announces the emergency shell.
+	MsgEmergencyShell
+	// MsgRetryingBoot announces that boot will be retried.
+	MsgRetryingBoot
+	// MsgRebooting announces that the system will reboot after a delay.
+	MsgRebooting
+	// MsgInitExited announces that a supervised init exited, and an
+	// emergency shell will be started.
+	MsgInitExited
+	// MsgRecovery announces that the recovery system will be booted.
+	MsgRecovery
+)
+
+var catalog = map[string]map[Message]string{
+	"en": {
+		MsgBootFailed:     "matchstick was unable to prepare the system for boot.",
+		MsgFailureReason:  "Reason: %s",
+		MsgSeeKernelLog:   "See the kernel log (dmesg) for details.",
+		MsgEmergencyShell: "Starting an emergency shell, exit the shell to retry booting.",
+		MsgRetryingBoot:   "Retrying boot...",
+		MsgRebooting:      "Rebooting in %v...",
+		MsgInitExited:     "Init %s, starting an emergency shell. Exit the shell to restart init.",
+		MsgRecovery:       "Booting the recovery system after %d failed boots...",
+	},
+	"de": {
+		MsgBootFailed:     "matchstick konnte das System nicht für den Start vorbereiten.",
+		MsgFailureReason:  "Ursache: %s",
+		MsgSeeKernelLog:   "Details finden Sie im Kernel-Protokoll (dmesg).",
+		MsgEmergencyShell: "Eine Notfall-Shell wird gestartet, beenden Sie die Shell, um den Start erneut zu versuchen.",
+		MsgRetryingBoot:   "Start wird erneut versucht...",
+		MsgRebooting:      "Neustart in %v...",
+		MsgInitExited:     "Init wurde beendet (%s), eine Notfall-Shell wird gestartet. Beenden Sie die Shell, um Init neu zu starten.",
+		MsgRecovery:       "Das Wiederherstellungssystem wird nach %d fehlgeschlagenen Starts gestartet...",
+	},
+	"es": {
+		MsgBootFailed:     "matchstick no pudo preparar el sistema para el arranque.",
+		MsgFailureReason:  "Motivo: %s",
+		MsgSeeKernelLog:   "Consulte el registro del kernel (dmesg) para más detalles.",
+		MsgEmergencyShell: "Iniciando un shell de emergencia, salga del shell para reintentar el arranque.",
+		MsgRetryingBoot:   "Reintentando el arranque...",
+		MsgRebooting:      "Reiniciando en %v...",
+		MsgInitExited:     "Init terminó (%s), iniciando un shell de emergencia. Salga del shell para reiniciar init.",
+		MsgRecovery:       "Arrancando el sistema de recuperación tras %d arranques fallidos...",
+	},
+	"fr": {
+		MsgBootFailed:     "matchstick n'a pas pu préparer le système pour le démarrage.",
+		MsgFailureReason:  "Cause : %s",
+		MsgSeeKernelLog:   "Consultez le journal du noyau (dmesg) pour plus de détails.",
+		MsgEmergencyShell: "Démarrage d'un shell d'urgence, quittez le shell pour relancer le démarrage.",
+		MsgRetryingBoot:   "Nouvelle tentative de démarrage...",
+		MsgRebooting:      "Redémarrage dans %v...",
+		MsgInitExited:     "Init s'est arrêté (%s), démarrage d'un shell d'urgence. Quittez le shell pour relancer init.",
+		MsgRecovery:       "Démarrage du système de récupération après %d démarrages échoués...",
+	},
+}
+
+// Printer formats messages in a particular language.
+type Printer struct {
+	lang string
+}
+
+// NewPrinter returns a printer for the given language. Locale style names
+// (eg. "de_DE.UTF-8") are accepted. Unsupported languages fall back to
+// English.
+func NewPrinter(lang string) *Printer {
+	return &Printer{lang: normalize(lang)}
+}
+
+// Lang returns the language that the printer will use.
+func (p *Printer) Lang() string {
+	return p.lang
+}
+
+// Sprintf formats the given message.
+func (p *Printer) Sprintf(msg Message, args ...any) string {
+	format, ok := catalog[p.lang][msg]
+	if !ok {
+		format = catalog[DefaultLang][msg]
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+func normalize(lang string) string {
+	lang = strings.ToLower(lang)
+
+	if i := strings.IndexAny(lang, "_-.@"); i != -1 {
+		lang = lang[:i]
+	}
+
+	if _, ok := catalog[lang]; !ok {
+		return DefaultLang
+	}
+
+	return lang
+}
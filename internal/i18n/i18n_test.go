@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package i18n_test
+
+import (
+	"testing"
+
+	"github.com/immutos/matchstick/internal/i18n"
+)
+
+func TestNewPrinter(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "en"},
+		{in: "de", want: "de"},
+		{in: "de_DE.UTF-8", want: "de"},
+		{in: "FR-ca", want: "fr"},
+		{in: "tlh", want: "en"},
+	} {
+		if got := i18n.NewPrinter(tt.in).Lang(); got != tt.want {
+			t.Errorf("NewPrinter(%q).Lang() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogComplete(t *testing.T) {
+	en := i18n.NewPrinter("en")
+
+	for _, lang := range []string{"de", "es", "fr"} {
+		p := i18n.NewPrinter(lang)
+		for _, msg := range []i18n.Message{i18n.MsgBootFailed, i18n.MsgFailureReason, i18n.MsgSeeKernelLog, i18n.MsgEmergencyShell, i18n.MsgRetryingBoot, i18n.MsgRebooting, i18n.MsgInitExited, i18n.MsgRecovery} {
+			if p.Sprintf(msg, "x") == en.Sprintf(msg, "x") {
+				t.Errorf("message %d is not translated for %q", msg, lang)
+			}
+		}
+	}
+}
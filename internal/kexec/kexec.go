@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package kexec loads and boots into another kernel (eg. a recovery system)
+// without going through the firmware.
+package kexec
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultCmdlinePath is where the current kernel command line is read from.
+const DefaultCmdlinePath = "/proc/cmdline"
+
+// Load loads the kernel (and initrd, if set) to be booted by Exec.
+func Load(kernel, initrd, cmdline string) error {
+	kf, err := os.Open(kernel)
+	if err != nil {
+		return fmt.Errorf("failed to open kernel: %w", err)
+	}
+	defer kf.Close()
+
+	initrdFd, flags := -1, unix.KEXEC_FILE_NO_INITRAMFS
+	if initrd != "" {
+		f, err := os.Open(initrd)
+		if err != nil {
+			return fmt.Errorf("failed to open initrd: %w", err)
+		}
+		defer f.Close()
+
+		initrdFd, flags = int(f.Fd()), 0
+	}
+
+	if err := kexecFileLoad(int(kf.Fd()), initrdFd, cmdline, flags); err != nil {
+		return fmt.Errorf("failed to load kernel: %w", err)
+	}
+
+	return nil
+}
+
+// Exec boots the loaded kernel, it only returns on failure.
+func Exec() error {
+	unix.Sync()
+
+	return unix.Reboot(unix.LINUX_REBOOT_CMD_KEXEC)
+}
+
+// CurrentCmdline returns the current kernel command line (read from path),
+// with the given parameters appended.
+func CurrentCmdline(path string, extra ...string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append(strings.Fields(string(data)), extra...), " "), nil
+}
@@ -0,0 +1,29 @@
+//go:build linux && (386 || mips || mipsle || mips64 || mips64le)
+
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kexec
+
+import "golang.org/x/sys/unix"
+
+// kexecFileLoad fails, as kexec_file_load(2) isn't available on this
+// architecture.
+func kexecFileLoad(kernelFd, initrdFd int, cmdline string, flags int) error {
+	return unix.ENOSYS
+}
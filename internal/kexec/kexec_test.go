@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kexec_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/kexec"
+)
+
+func TestCurrentCmdline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline")
+
+	if err := os.WriteFile(path, []byte("console=ttyS0  root=/dev/vda1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdline, err := kexec.CurrentCmdline(path, "matchstick.recovery=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "console=ttyS0 root=/dev/vda1 matchstick.recovery=1"; cmdline != want {
+		t.Errorf("CurrentCmdline() = %q, want %q", cmdline, want)
+	}
+}
+
+func TestLoadMissingKernel(t *testing.T) {
+	err := kexec.Load(filepath.Join(t.TempDir(), "vmlinuz"), "", "")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
@@ -21,88 +21,328 @@ package kmsg
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// DefaultMaxLen is the default maximum length of a record (excluding the
+// priority header). The kernel rejects writes longer than LOG_LINE_MAX (992
+// bytes on most kernels), so leave some headroom.
+const DefaultMaxLen = 976
+
 var _ slog.Handler = (*KmsgHandler)(nil)
 
+// Options configures a KmsgHandler.
+type Options struct {
+	slog.HandlerOptions
+	// Prefix is prepended to every record (eg. "matchstick: ").
+	Prefix string
+	// Time specifies whether the wall-clock time of each record should be
+	// included (the kernel timestamps records relative to boot).
+	Time bool
+	// MaxLen is the maximum length of a record (excluding the priority
+	// header), defaults to DefaultMaxLen.
+	MaxLen int
+	// Truncate specifies whether lines exceeding MaxLen should be truncated,
+	// rather than split over several records.
+	Truncate bool
+	// RateLimit is the sustained number of records per second that will be
+	// written, zero is unlimited. Records exceeding the limit are dropped, and
+	// the number dropped is reported once records are written again.
+	RateLimit float64
+	// Burst is the number of records that can be written in excess of the
+	// rate limit, defaults to 10.
+	Burst int
+}
+
 // KmsgHandler is a slog.Handler that writes log messages to the kernel log.
+// Each write to w must correspond to a single kernel log record.
 type KmsgHandler struct {
-	f     *os.File
-	level slog.Leveler
-	group string
-	attr  map[string]slog.Attr
+	w       io.Writer
+	opts    Options
+	mu      *sync.Mutex
+	limiter *limiter
+	// groups are the groups opened with WithGroup.
+	groups []string
+	// attrs are the attributes added with WithAttrs, preformatted.
+	attrs string
 }
 
-func NewKmsgHandler(f *os.File, opts *slog.HandlerOptions) *KmsgHandler {
-	return &KmsgHandler{
-		f:     f,
-		level: opts.Level,
-		attr:  make(map[string]slog.Attr),
+// NewKmsgHandler returns a handler that writes log records to w (typically
+// /dev/kmsg). If opts is nil the default options are used.
+func NewKmsgHandler(w io.Writer, opts *Options) *KmsgHandler {
+	kh := &KmsgHandler{w: w, mu: &sync.Mutex{}}
+	if opts != nil {
+		kh.opts = *opts
 	}
+
+	if kh.opts.MaxLen <= 0 {
+		kh.opts.MaxLen = DefaultMaxLen
+	}
+
+	if kh.opts.RateLimit > 0 {
+		burst := kh.opts.Burst
+		if burst <= 0 {
+			burst = 10
+		}
+
+		kh.limiter = &limiter{rate: kh.opts.RateLimit, burst: float64(burst), tokens: float64(burst), now: time.Now}
+	}
+
+	return kh
 }
 
 func (kh *KmsgHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= kh.level.Level()
+	minLevel := slog.LevelInfo
+	if kh.opts.Level != nil {
+		minLevel = kh.opts.Level.Level()
+	}
+
+	return level >= minLevel
 }
 
 func (kh *KmsgHandler) Handle(_ context.Context, r slog.Record) error {
 	var sb strings.Builder
-	if kh.group != "" {
-		sb.WriteString(kh.group)
-		sb.WriteString(": ")
-	}
 
-	sb.WriteString(r.Message)
+	if kh.opts.Time && !r.Time.IsZero() {
+		kh.appendAttr(&sb, nil, slog.Time(slog.TimeKey, r.Time))
+	}
 
-	for _, attr := range kh.attr {
-		fmt.Fprintf(&sb, " %s=%v", attr.Key, attr.Value)
+	if kh.opts.AddSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		f, _ := frames.Next()
+		kh.appendAttr(&sb, nil, slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", f.File, f.Line)))
 	}
 
+	sb.WriteString(kh.attrs)
+
 	r.Attrs(func(attr slog.Attr) bool {
-		fmt.Fprintf(&sb, " %s=%v", attr.Key, attr.Value)
+		kh.appendAttr(&sb, kh.groups, attr)
 		return true
 	})
 
-	if err := kh.writeString(r.Level, sb.String()); err != nil {
-		return err
+	// Each line of the message is written as a separate record, with the
+	// attributes following the first line.
+	lines := strings.Split(strings.TrimRight(r.Message, "\n"), "\n")
+	lines[0] += sb.String()
+
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	if kh.limiter != nil {
+		allowed, suppressed := kh.limiter.allow()
+		if !allowed {
+			return nil
+		}
+
+		if suppressed > 0 {
+			msg := fmt.Sprintf("%d log messages suppressed by rate limiting", suppressed)
+			if err := kh.write(slog.LevelWarn, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, line := range lines {
+		for _, rec := range kh.split(line) {
+			if err := kh.write(r.Level, rec); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
 func (kh *KmsgHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	newHandler := *kh
-	newHandler.attr = make(map[string]slog.Attr, len(kh.attr))
+	if len(attrs) == 0 {
+		return kh
+	}
 
-	for key, attr := range kh.attr {
-		newHandler.attr[key] = attr
+	var sb strings.Builder
+	sb.WriteString(kh.attrs)
+
+	for _, attr := range attrs {
+		kh.appendAttr(&sb, kh.groups, attr)
 	}
 
-	return kh
+	newHandler := *kh
+	newHandler.attrs = sb.String()
+
+	return &newHandler
 }
 
 func (kh *KmsgHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return kh
+	}
+
 	newHandler := *kh
-	newHandler.attr = make(map[string]slog.Attr, len(kh.attr))
+	newHandler.groups = append(kh.groups[:len(kh.groups):len(kh.groups)], name)
+
+	return &newHandler
+}
 
-	for key, attr := range kh.attr {
-		newHandler.attr[key] = attr
+// appendAttr formats attr as a key=value pair, qualifying the key with the
+// names of its enclosing groups.
+func (kh *KmsgHandler) appendAttr(sb *strings.Builder, groups []string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+
+	if kh.opts.ReplaceAttr != nil && attr.Value.Kind() != slog.KindGroup {
+		attr = kh.opts.ReplaceAttr(groups, attr)
+		attr.Value = attr.Value.Resolve()
 	}
 
-	newHandler.group = name
-	return &newHandler
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		groupAttrs := attr.Value.Group()
+		if len(groupAttrs) == 0 {
+			return
+		}
+
+		// Attributes of a group with an empty key are inlined.
+		if attr.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], attr.Key)
+		}
+
+		for _, ga := range groupAttrs {
+			kh.appendAttr(sb, groups, ga)
+		}
+
+		return
+	}
+
+	sb.WriteByte(' ')
+
+	for _, g := range groups {
+		sb.WriteString(g)
+		sb.WriteByte('.')
+	}
+
+	sb.WriteString(attr.Key)
+	sb.WriteByte('=')
+	sb.WriteString(formatValue(attr.Value))
 }
 
-func (kh *KmsgHandler) writeString(level slog.Level, msg string) error {
-	_, err := kh.f.WriteString(fmt.Sprintf("<%d>%s", toKLogLevel(level), msg))
-	if err != nil {
-		return err
+// split splits a line into records no longer than MaxLen (or truncates it).
+func (kh *KmsgHandler) split(line string) []string {
+	maxLen := kh.opts.MaxLen - len(kh.opts.Prefix)
+	if maxLen < 16 {
+		maxLen = 16
 	}
 
-	return nil
+	if len(line) <= maxLen {
+		return []string{line}
+	}
+
+	if kh.opts.Truncate {
+		const ellipsis = "..."
+		return []string{truncate(line, maxLen-len(ellipsis)) + ellipsis}
+	}
+
+	var records []string
+	for len(line) > maxLen {
+		chunk := truncate(line, maxLen)
+		records = append(records, chunk)
+		line = line[len(chunk):]
+	}
+
+	return append(records, line)
+}
+
+func (kh *KmsgHandler) write(level slog.Level, msg string) error {
+	_, err := io.WriteString(kh.w, fmt.Sprintf("<%d>%s%s", toKLogLevel(level), kh.opts.Prefix, msg))
+	return err
+}
+
+// truncate returns the longest prefix of s no longer than n bytes that
+// doesn't split a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+// formatValue formats a value, quoting it if it would otherwise be ambiguous
+// or contain non-printable characters (eg. newlines).
+func formatValue(v slog.Value) string {
+	var s string
+	if v.Kind() == slog.KindTime {
+		s = v.Time().Format(time.RFC3339Nano)
+	} else {
+		s = v.String()
+	}
+
+	if needsQuoting(s) {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limiter is a token bucket rate limiter.
+type limiter struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	suppressed int
+	now        func() time.Time
+}
+
+// allow returns whether a record may be written, and if so, the number of
+// records suppressed since the last one that was.
+func (l *limiter) allow() (bool, int) {
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+
+	l.tokens--
+
+	suppressed := l.suppressed
+	l.suppressed = 0
+
+	return true, suppressed
 }
 
 // KLogLevel represents the log levels for kernel logging.
@@ -121,16 +361,14 @@ const (
 )
 
 func toKLogLevel(level slog.Level) KLogLevel {
-	switch level {
-	case slog.LevelDebug:
+	switch {
+	case level < slog.LevelInfo:
 		return KLogDebug
-	case slog.LevelInfo:
+	case level < slog.LevelWarn:
 		return KLogInfo
-	case slog.LevelWarn:
+	case level < slog.LevelError:
 		return KLogWarning
-	case slog.LevelError:
-		return KLogError
 	default:
-		return KLogInfo
+		return KLogError
 	}
 }
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kmsg
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+	"unicode/utf8"
+)
+
+// recorder captures each write as a separate record.
+type recorder struct {
+	records []string
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.records = append(r.records, string(p))
+	return len(p), nil
+}
+
+func TestSlogtest(t *testing.T) {
+	var rec *recorder
+
+	slogtest.Run(t, func(t *testing.T) slog.Handler {
+		rec = &recorder{}
+		return NewKmsgHandler(rec, &Options{Time: true})
+	}, func(t *testing.T) map[string]any {
+		if len(rec.records) != 1 {
+			t.Fatalf("expected 1 record, got %q", rec.records)
+		}
+
+		m, err := parseRecord(rec.records[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return m
+	})
+}
+
+func TestHandlerPrefix(t *testing.T) {
+	var rec recorder
+	logger := slog.New(NewKmsgHandler(&rec, &Options{Prefix: "matchstick: "}))
+
+	logger.With(slog.String("device", "/dev/vda")).WithGroup("overlay").Warn("Failed to mount", slog.String("dir", "/srv/my dir"))
+
+	expected := `<4>matchstick: Failed to mount device=/dev/vda overlay.dir="/srv/my dir"`
+	if len(rec.records) != 1 || rec.records[0] != expected {
+		t.Errorf("expected %q, got %q", expected, rec.records)
+	}
+}
+
+func TestHandlerMultiline(t *testing.T) {
+	var rec recorder
+	logger := slog.New(NewKmsgHandler(&rec, nil))
+
+	logger.Info("first\nsecond\n", slog.String("output", "a\nb"))
+
+	expected := []string{`<6>first output="a\nb"`, "<6>second"}
+	if fmt.Sprint(rec.records) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, rec.records)
+	}
+}
+
+func TestHandlerLongLines(t *testing.T) {
+	msg := strings.Repeat("é", 40)
+
+	var rec recorder
+	slog.New(NewKmsgHandler(&rec, &Options{MaxLen: 32})).Info(msg)
+
+	var joined string
+	for _, r := range rec.records {
+		r = strings.TrimPrefix(r, "<6>")
+		if len(r) > 32 || !utf8.ValidString(r) {
+			t.Errorf("invalid record %q", r)
+		}
+		joined += r
+	}
+
+	if joined != msg {
+		t.Errorf("expected the split records to join to the message, got %q", joined)
+	}
+
+	rec = recorder{}
+	slog.New(NewKmsgHandler(&rec, &Options{MaxLen: 32, Truncate: true})).Info(msg)
+
+	if len(rec.records) != 1 || !strings.HasSuffix(rec.records[0], "...") || len(rec.records[0]) > 32+3 {
+		t.Errorf("expected a single truncated record, got %q", rec.records)
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	now := time.Now()
+
+	var rec recorder
+	h := NewKmsgHandler(&rec, &Options{RateLimit: 1, Burst: 2})
+	h.limiter.now = func() time.Time { return now }
+
+	logger := slog.New(h)
+	for i := 0; i < 5; i++ {
+		logger.Info("message " + strconv.Itoa(i))
+	}
+
+	if len(rec.records) != 2 {
+		t.Fatalf("expected 2 records within the burst, got %q", rec.records)
+	}
+
+	now = now.Add(time.Second)
+	logger.Info("later")
+
+	expected := []string{"<4>3 log messages suppressed by rate limiting", "<6>later"}
+	if fmt.Sprint(rec.records[2:]) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, rec.records[2:])
+	}
+}
+
+// parseRecord parses a record into the nested map expected by slogtest.
+func parseRecord(record string) (map[string]any, error) {
+	end := strings.IndexByte(record, '>')
+	if !strings.HasPrefix(record, "<") || end < 0 {
+		return nil, fmt.Errorf("missing priority: %q", record)
+	}
+
+	m := map[string]any{slog.LevelKey: record[1:end]}
+	record = record[end+1:]
+
+	msg, rest, _ := strings.Cut(record, " ")
+	m[slog.MessageKey] = msg
+
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed attribute: %q", rest)
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, err
+			}
+
+			rest = strings.TrimPrefix(value[len(quoted):], " ")
+			value, _ = strconv.Unquote(quoted)
+		} else {
+			value, rest, _ = strings.Cut(value, " ")
+		}
+
+		parts := strings.Split(key, ".")
+		group := m
+		for _, g := range parts[:len(parts)-1] {
+			sub, ok := group[g].(map[string]any)
+			if !ok {
+				sub = map[string]any{}
+				group[g] = sub
+			}
+			group = sub
+		}
+		group[parts[len(parts)-1]] = value
+	}
+
+	return m, nil
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Format is the format of log records written to a stream (eg. stderr or a
+// serial console).
+type Format string
+
+const (
+	// FormatText writes records as logfmt style key=value pairs.
+	FormatText Format = "text"
+	// FormatJSON writes records as one JSON object per line.
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a log format, an empty string selects FormatText.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", s)
+	}
+}
+
+// NewHandler returns a handler that writes records to w in the given format.
+func NewHandler(w io.Writer, format Format, opts *slog.HandlerOptions) slog.Handler {
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.NewTextHandler(w, opts)
+}
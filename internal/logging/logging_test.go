@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/logging"
+)
+
+func TestParseFormat(t *testing.T) {
+	for s, want := range map[string]logging.Format{
+		"":     logging.FormatText,
+		"text": logging.FormatText,
+		"json": logging.FormatJSON,
+	} {
+		format, err := logging.ParseFormat(s)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", s, err)
+		}
+
+		if format != want {
+			t.Errorf("expected %q to parse as %q, got %q", s, want, format)
+		}
+	}
+
+	if _, err := logging.ParseFormat("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestNewHandlerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewHandler(&buf, logging.FormatJSON, nil))
+
+	logger.Info("Mounting overlay filesystem", slog.String("dir", "/etc"))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a JSON record, got %q: %v", buf.String(), err)
+	}
+
+	if record["msg"] != "Mounting overlay filesystem" || record["dir"] != "/etc" {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
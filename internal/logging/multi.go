@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+var _ slog.Handler = (*MultiHandler)(nil)
+
+// MultiHandler is a slog.Handler that fans records out to several handlers
+// (eg. the kernel log, and a serial console).
+type MultiHandler struct {
+	handlers []slog.Handler
+}
+
+// NewMultiHandler returns a handler that writes records to each of handlers.
+func NewMultiHandler(handlers ...slog.Handler) *MultiHandler {
+	return &MultiHandler{handlers: handlers}
+}
+
+func (mh *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range mh.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle writes the record to every handler that is enabled for its level,
+// a failing handler doesn't prevent the record reaching the others.
+func (mh *MultiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range mh.handlers {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+
+		if err := h.Handle(ctx, r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (mh *MultiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(mh.handlers))
+	for i, h := range mh.handlers {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+
+	return &MultiHandler{handlers: handlers}
+}
+
+func (mh *MultiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(mh.handlers))
+	for i, h := range mh.handlers {
+		handlers[i] = h.WithGroup(name)
+	}
+
+	return &MultiHandler{handlers: handlers}
+}
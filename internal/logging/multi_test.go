@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/logging"
+)
+
+func TestMultiHandler(t *testing.T) {
+	var info, warn bytes.Buffer
+
+	logger := slog.New(logging.NewMultiHandler(
+		slog.NewTextHandler(&info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(&warn, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	)).With(slog.String("dir", "/etc"))
+
+	logger.Info("Mounting overlay filesystem")
+	logger.Warn("Failed to mount overlay filesystem")
+
+	if got := strings.Count(info.String(), "dir=/etc"); got != 2 {
+		t.Errorf("expected 2 records in the info destination, got %d: %q", got, info.String())
+	}
+
+	if strings.Contains(warn.String(), "Mounting overlay") || !strings.Contains(warn.String(), "dir=/etc") {
+		t.Errorf("unexpected records in the warn destination: %q", warn.String())
+	}
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of sensitive attributes.
+const Redacted = "<redacted>"
+
+// secretNamePattern matches the names of options (and attributes) that
+// likely hold secrets, eg. crypt_key, chap_secret or config_token.
+var secretNamePattern = regexp.MustCompile(`(?i)(passw(or)?d|passphrase|secret|token|credential|private|api_?key|access_?key|(^|[._-])key$)`)
+
+var (
+	// assignmentPattern matches name=value pairs (eg. on the kernel command
+	// line), the value is redacted if the name is secret-like.
+	assignmentPattern = regexp.MustCompile(`([\w.-]+)=("[^"]*"|\S+)`)
+	// bearerPattern matches HTTP bearer tokens.
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer\s+)\S+`)
+	// userinfoPattern matches the password in a URL's userinfo.
+	userinfoPattern = regexp.MustCompile(`(://[^/\s:@]*:)[^/\s@]+@`)
+)
+
+// Redactor masks sensitive values, those of attributes with a secret-like (or
+// configured) name, and any that look like they embed a secret. It's safe to
+// use concurrently, and names can be added once options have been decoded.
+type Redactor struct {
+	mu    sync.RWMutex
+	names []string
+}
+
+// NewRedactor returns a redactor that additionally masks the values of the
+// named options (or attributes).
+func NewRedactor(names ...string) *Redactor {
+	r := &Redactor{}
+	r.AddNames(names...)
+	return r
+}
+
+// AddNames additionally masks the values of the named options (or
+// attributes). Names are matched case-insensitively, with or without an
+// option prefix (eg. matchstick.), and dashes are equivalent to underscores.
+func (r *Redactor) AddNames(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		name = normalizeName(name)
+		if name != "" && !slices.Contains(r.names, name) {
+			r.names = append(r.names, name)
+		}
+	}
+}
+
+// IsSecret returns true if the values of the named option (or attribute)
+// are masked.
+func (r *Redactor) IsSecret(name string) bool {
+	if secretNamePattern.MatchString(name) {
+		return true
+	}
+
+	name = normalizeName(name)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.ContainsFunc(r.names, func(n string) bool {
+		return name == n || strings.HasSuffix(name, "."+n)
+	})
+}
+
+// String masks any secrets embedded in s, eg. the values of secret-like
+// name=value pairs, bearer tokens and URL passwords.
+func (r *Redactor) String(s string) string {
+	if !strings.ContainsAny(s, "=:") && !strings.Contains(strings.ToLower(s), "bearer") {
+		return s
+	}
+
+	s = assignmentPattern.ReplaceAllStringFunc(s, func(kv string) string {
+		name, _, _ := strings.Cut(kv, "=")
+		if r.IsSecret(name) {
+			return name + "=" + Redacted
+		}
+
+		return kv
+	})
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Redacted)
+
+	return userinfoPattern.ReplaceAllString(s, "${1}"+Redacted+"@")
+}
+
+// Attr returns a with its value masked if it's sensitive.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	if a.Key != "" && r.IsSecret(a.Key) && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.String(a.Value.String()))
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = r.Attr(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			if s := v.Error(); r.String(s) != s {
+				a.Value = slog.StringValue(r.String(s))
+			}
+		case []string:
+			redacted := make([]string, len(v))
+			for i, s := range v {
+				redacted[i] = r.String(s)
+			}
+			a.Value = slog.AnyValue(redacted)
+		}
+	}
+
+	return a
+}
+
+func normalizeName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+}
+
+var _ slog.Handler = (*RedactHandler)(nil)
+
+// RedactHandler is a slog.Handler that masks sensitive values (in the message
+// and attributes) before passing records on to another handler.
+type RedactHandler struct {
+	handler  slog.Handler
+	redactor *Redactor
+}
+
+// NewRedactHandler returns a handler that masks sensitive values with
+// redactor, before passing records on to handler.
+func NewRedactHandler(handler slog.Handler, redactor *Redactor) *RedactHandler {
+	return &RedactHandler{handler: handler, redactor: redactor}
+}
+
+func (rh *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return rh.handler.Enabled(ctx, level)
+}
+
+func (rh *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, rh.redactor.String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rh.redactor.Attr(a))
+		return true
+	})
+
+	return rh.handler.Handle(ctx, redacted)
+}
+
+func (rh *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = rh.redactor.Attr(a)
+	}
+
+	return &RedactHandler{handler: rh.handler.WithAttrs(redacted), redactor: rh.redactor}
+}
+
+func (rh *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{handler: rh.handler.WithGroup(name), redactor: rh.redactor}
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// Ring buffers the most recent log records in memory, so they can be replayed
+// to destinations that become available later (eg. the kernel log once /dev
+// has been mounted).
+type Ring struct {
+	mu      sync.Mutex
+	entries []ringEntry
+	next    int
+	full    bool
+	dropped int
+}
+
+type ringEntry struct {
+	ops []handlerOp
+	r   slog.Record
+}
+
+// handlerOp records a WithAttrs or WithGroup call, so it can be reapplied to
+// the destination handler when replaying.
+type handlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewRing returns a ring buffer holding up to size records.
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]ringEntry, size)}
+}
+
+// Handler returns a handler that records to the ring buffer.
+func (rb *Ring) Handler(level slog.Leveler) slog.Handler {
+	return &ringHandler{ring: rb, level: level}
+}
+
+// Dropped returns the number of records that were overwritten.
+func (rb *Ring) Dropped() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	return rb.dropped
+}
+
+// Replay writes the buffered records, oldest first, to h.
+func (rb *Ring) Replay(ctx context.Context, h slog.Handler) error {
+	rb.mu.Lock()
+	var entries []ringEntry
+	if rb.full {
+		entries = append(entries, rb.entries[rb.next:]...)
+	}
+	entries = append(entries, rb.entries[:rb.next]...)
+	rb.mu.Unlock()
+
+	var errs []error
+	for _, e := range entries {
+		dst := h
+		for _, op := range e.ops {
+			if op.group != "" {
+				dst = dst.WithGroup(op.group)
+			} else {
+				dst = dst.WithAttrs(op.attrs)
+			}
+		}
+
+		if !dst.Enabled(ctx, e.r.Level) {
+			continue
+		}
+
+		if err := dst.Handle(ctx, e.r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (rb *Ring) add(ops []handlerOp, r slog.Record) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if len(rb.entries) == 0 {
+		rb.dropped++
+		return
+	}
+
+	if rb.full {
+		rb.dropped++
+	}
+
+	rb.entries[rb.next] = ringEntry{ops: ops, r: r.Clone()}
+	rb.next = (rb.next + 1) % len(rb.entries)
+	if rb.next == 0 {
+		rb.full = true
+	}
+}
+
+type ringHandler struct {
+	ring  *Ring
+	level slog.Leveler
+	ops   []handlerOp
+}
+
+func (rh *ringHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= rh.level.Level()
+}
+
+func (rh *ringHandler) Handle(_ context.Context, r slog.Record) error {
+	rh.ring.add(rh.ops, r)
+	return nil
+}
+
+func (rh *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return rh
+	}
+
+	return rh.with(handlerOp{attrs: attrs})
+}
+
+func (rh *ringHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return rh
+	}
+
+	return rh.with(handlerOp{group: name})
+}
+
+func (rh *ringHandler) with(op handlerOp) *ringHandler {
+	ops := make([]handlerOp, len(rh.ops), len(rh.ops)+1)
+	copy(ops, rh.ops)
+
+	return &ringHandler{ring: rh.ring, level: rh.level, ops: append(ops, op)}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/logging"
+)
+
+func TestRing(t *testing.T) {
+	ring := logging.NewRing(2)
+
+	logger := slog.New(ring.Handler(slog.LevelInfo)).WithGroup("matchstick").With(slog.String("dir", "/etc"))
+
+	logger.Debug("Ignored")
+	logger.Info("First")
+	logger.Info("Second")
+	logger.Warn("Third")
+
+	if dropped := ring.Dropped(); dropped != 1 {
+		t.Errorf("expected 1 dropped record, got %d", dropped)
+	}
+
+	var buf bytes.Buffer
+	if err := ring.Replay(context.Background(), slog.NewTextHandler(&buf, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", buf.String())
+	}
+
+	if !strings.Contains(lines[0], "msg=Second") || !strings.Contains(lines[1], "msg=Third") {
+		t.Errorf("expected records to be replayed oldest first, got %q", buf.String())
+	}
+
+	if !strings.Contains(lines[0], "matchstick.dir=/etc") {
+		t.Errorf("expected groups and attributes to be preserved, got %q", lines[0])
+	}
+}
+
+func TestRingNotFull(t *testing.T) {
+	ring := logging.NewRing(8)
+
+	slog.New(ring.Handler(slog.LevelInfo)).Info("Only")
+
+	var buf bytes.Buffer
+	if err := ring.Replay(context.Background(), slog.NewTextHandler(&buf, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("expected 1 record, got %q", buf.String())
+	}
+}
@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package modules loads kernel modules (and their dependencies) without
+// relying on modprobe.
+package modules
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSysModuleDir is where the kernel lists loaded (and built-in) modules.
+const DefaultSysModuleDir = "/sys/module"
+
+// DefaultDir returns the module directory of the running kernel.
+func DefaultDir() (string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "", err
+	}
+
+	return filepath.Join("/lib/modules", unix.ByteSliceToString(uts.Release[:])), nil
+}
+
+// Loader loads modules from a kernel module directory.
+type Loader struct {
+	// Dir is the kernel module directory (eg. /lib/modules/6.1.0-18-amd64).
+	Dir string
+	// SysModuleDir is where loaded modules are listed.
+	SysModuleDir string
+
+	// deps maps module names to their path and dependencies (parsed lazily).
+	deps map[string]module
+	// builtin is the set of modules built into the kernel.
+	builtin map[string]bool
+	// aliases maps (exact) aliases, eg. "fs-nfs4", to module names.
+	aliases map[string]string
+	// loaded is the set of modules loaded by the loader.
+	loaded map[string]bool
+	// load loads a single module file.
+	load func(path string) error
+}
+
+type module struct {
+	path string
+	deps []string
+}
+
+// NewLoader returns a loader for the given kernel module directory.
+func NewLoader(dir string) *Loader {
+	return &Loader{
+		Dir:          dir,
+		SysModuleDir: DefaultSysModuleDir,
+		load:         finitModule,
+		loaded:       make(map[string]bool),
+	}
+}
+
+// Load loads the named module (or alias, eg. "fs-nfs4"), after its
+// dependencies. Modules that are already loaded, or are built into the kernel,
+// are skipped.
+func (l *Loader) Load(name string) error {
+	paths, err := l.Resolve(name)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		slog.Debug("Loading kernel module", slog.String("path", path))
+
+		if err := l.load(path); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+
+		l.loaded[moduleName(path)] = true
+	}
+
+	return nil
+}
+
+// Resolve returns the paths of the module files that need to be loaded for
+// the named module (or alias), in order (dependencies first).
+func (l *Loader) Resolve(name string) ([]string, error) {
+	if err := l.parse(); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		name = normalize(name)
+		if alias, ok := l.aliases[name]; ok {
+			name = alias
+		}
+
+		if seen[name] || l.builtin[name] || l.isLoaded(name) {
+			return nil
+		}
+		seen[name] = true
+
+		m, ok := l.deps[name]
+		if !ok {
+			return fmt.Errorf("module %s not found in %s", name, l.Dir)
+		}
+
+		// Dependencies are listed in modules.dep in the reverse of the order
+		// they need to be loaded.
+		for i := len(m.deps) - 1; i >= 0; i-- {
+			if err := visit(moduleName(m.deps[i])); err != nil {
+				return err
+			}
+		}
+
+		paths = append(paths, filepath.Join(l.Dir, m.path))
+		return nil
+	}
+
+	if err := visit(name); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// parse reads modules.dep and modules.builtin (once).
+func (l *Loader) parse() error {
+	if l.deps != nil {
+		return nil
+	}
+
+	deps := make(map[string]module)
+	err := readLines(filepath.Join(l.Dir, "modules.dep"), func(line string) {
+		path, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			return
+		}
+
+		deps[moduleName(path)] = module{path: path, deps: strings.Fields(rest)}
+	})
+	if err != nil {
+		return err
+	}
+
+	builtin := make(map[string]bool)
+	err = readLines(filepath.Join(l.Dir, "modules.builtin"), func(line string) {
+		builtin[moduleName(line)] = true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	aliases := make(map[string]string)
+	err = readLines(filepath.Join(l.Dir, "modules.alias"), func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "alias" && !strings.ContainsAny(fields[1], "*?[") {
+			aliases[normalize(fields[1])] = normalize(fields[2])
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	l.deps, l.builtin, l.aliases = deps, builtin, aliases
+	return nil
+}
+
+func (l *Loader) isLoaded(name string) bool {
+	if l.loaded[name] {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join(l.SysModuleDir, name))
+	return err == nil
+}
+
+// moduleName returns the name of a module from its path (eg.
+// "kernel/fs/overlayfs/overlay.ko.zst" is "overlay").
+func moduleName(path string) string {
+	name := filepath.Base(path)
+	if i := strings.Index(name, ".ko"); i >= 0 {
+		name = name[:i]
+	}
+
+	return normalize(name)
+}
+
+// normalize normalizes a module name (dashes and underscores are
+// interchangeable).
+func normalize(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// finitModule loads a module file with finit_module(2), letting the kernel
+// decompress it if necessary.
+func finitModule(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var flags int
+	if !strings.HasSuffix(path, ".ko") {
+		flags |= unix.MODULE_INIT_COMPRESSED_FILE
+	}
+
+	return unix.FinitModule(int(f.Fd()), "", flags)
+}
+
+func readLines(path string, fn func(line string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fn(line)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	sysModuleDir := t.TempDir()
+
+	modulesDep := `kernel/fs/overlayfs/overlay.ko.zst:
+kernel/drivers/md/dm-crypt.ko.xz: kernel/crypto/encrypted-keys.ko.xz kernel/drivers/md/dm-mod.ko.xz
+kernel/crypto/encrypted-keys.ko.xz: kernel/crypto/trusted.ko.xz
+kernel/crypto/trusted.ko.xz:
+kernel/drivers/md/dm-mod.ko.xz:
+kernel/fs/ext4/ext4.ko: kernel/fs/mbcache.ko kernel/fs/jbd2/jbd2.ko
+kernel/fs/mbcache.ko:
+kernel/fs/jbd2/jbd2.ko:
+`
+	if err := os.WriteFile(filepath.Join(dir, "modules.dep"), []byte(modulesDep), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modulesAlias := "alias fs-ext4 ext4\nalias pci:v00008086d*sv*sd*bc*sc*i* e1000e\n"
+	if err := os.WriteFile(filepath.Join(dir, "modules.alias"), []byte(modulesAlias), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "modules.builtin"), []byte("kernel/fs/mbcache.ko\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// dm-mod is already loaded.
+	if err := os.Mkdir(filepath.Join(sysModuleDir, "dm_mod"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded []string
+	l := NewLoader(dir)
+	l.SysModuleDir = sysModuleDir
+	l.load = func(path string) error {
+		rel, _ := filepath.Rel(dir, path)
+		loaded = append(loaded, rel)
+		return nil
+	}
+
+	for _, name := range []string{"dm-crypt", "fs-ext4", "ext4"} {
+		if err := l.Load(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"kernel/crypto/trusted.ko.xz",
+		"kernel/crypto/encrypted-keys.ko.xz",
+		"kernel/drivers/md/dm-crypt.ko.xz",
+		"kernel/fs/jbd2/jbd2.ko",
+		"kernel/fs/ext4/ext4.ko",
+	}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("expected %v, got %v", expected, loaded)
+	}
+
+	if err := l.Load("btrfs"); err == nil {
+		t.Error("expected an error loading an unknown module")
+	}
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package mountinfo parses the mount table (/proc/self/mountinfo).
+package mountinfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultPath is the mount table of the calling process.
+const DefaultPath = "/proc/self/mountinfo"
+
+// Mount is an entry in the mount table.
+type Mount struct {
+	Source  string
+	Target  string
+	FSType  string
+	Options string
+	// SuperOptions are the per superblock options (eg. an overlay's
+	// lowerdir, upperdir and workdir).
+	SuperOptions string
+}
+
+// Read reads a mount table.
+func Read(path string) ([]Mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Find returns the last (ie. topmost) mount on target, or nil if nothing is
+// mounted on it.
+func Find(mounts []Mount, target string) *Mount {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if mounts[i].Target == target {
+			return &mounts[i]
+		}
+	}
+
+	return nil
+}
+
+// Parse parses a /proc/self/mountinfo style mount table.
+func Parse(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		// The optional fields are terminated by a single hyphen.
+		before, after, ok := strings.Cut(line, " - ")
+		if !ok {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+
+		fields, super := strings.Fields(before), strings.Fields(after)
+		if len(fields) < 6 || len(super) < 2 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+
+		m := Mount{
+			Target:  unescape(fields[4]),
+			Options: fields[5],
+			FSType:  super[0],
+			Source:  unescape(super[1]),
+		}
+		if len(super) > 2 {
+			m.SuperOptions = super[2]
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts, sc.Err()
+}
+
+// unescape decodes the octal escapes (eg. \040 for a space) in a mountinfo
+// field.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mountinfo_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/mountinfo"
+)
+
+func TestParse(t *testing.T) {
+	table := "21 1 0:21 / /mnt/data rw,relatime shared:1 - ext4 /dev/vda2 rw\n" +
+		`22 1 0:22 / /srv/my\040dir rw - tmpfs tmpfs rw` + "\n" +
+		"23 1 0:23 / /etc rw - overlay overlay rw,lowerdir=/etc,upperdir=/mnt/data/etc,workdir=/mnt/data/.etc-work\n" +
+		"24 1 0:24 / /etc rw - tmpfs tmpfs rw\n"
+
+	mounts, err := mountinfo.Parse(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mounts) != 4 {
+		t.Fatalf("expected 4 mounts, got %d", len(mounts))
+	}
+
+	if m := mounts[0]; m.Source != "/dev/vda2" || m.FSType != "ext4" || m.Options != "rw,relatime" {
+		t.Errorf("unexpected mount: %+v", m)
+	}
+
+	if mountinfo.Find(mounts, "/srv/my dir") == nil {
+		t.Error("expected escaped mountpoint to be decoded")
+	}
+
+	if m := mountinfo.Find(mounts, "/etc"); m == nil || m.FSType != "tmpfs" {
+		t.Errorf("expected the topmost mount, got %+v", m)
+	}
+
+	if _, err := mountinfo.Parse(strings.NewReader("21 1 0:21 / /mnt rw\n")); err == nil {
+		t.Error("expected error for a malformed line")
+	}
+}
@@ -0,0 +1,383 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package netconf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DHCP message types.
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+)
+
+// DHCP options.
+const (
+	optPad          = 0
+	optSubnetMask   = 1
+	optRouter       = 3
+	optDNS          = 6
+	optHostname     = 12
+	optDomainName   = 15
+	optRequestedIP  = 50
+	optLeaseTime    = 51
+	optMessageType  = 53
+	optServerID     = 54
+	optParamRequest = 55
+	optNTP          = 42
+	optEnd          = 255
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// Lease is an address configuration (obtained with DHCP, or static).
+type Lease struct {
+	IP        net.IP
+	Netmask   net.IPMask
+	Gateway   net.IP
+	DNS       []net.IP
+	NTP       []net.IP
+	Hostname  string
+	Domain    string
+	ServerID  net.IP
+	LeaseTime time.Duration
+}
+
+// dhcpMessage is a (BOOTP format) DHCP message.
+type dhcpMessage struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+func (m *dhcpMessage) marshal() []byte {
+	b := make([]byte, 240, 300)
+	b[0] = m.op
+	b[1] = 1 // Ethernet
+	b[2] = byte(len(m.chaddr))
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	copy(b[12:16], m.ciaddr.To4())
+	copy(b[16:20], m.yiaddr.To4())
+	copy(b[28:44], m.chaddr)
+	copy(b[236:240], magicCookie)
+
+	// The message type must come first.
+	if v, ok := m.options[optMessageType]; ok {
+		b = append(b, optMessageType, byte(len(v)))
+		b = append(b, v...)
+	}
+
+	for code, v := range m.options {
+		if code == optMessageType {
+			continue
+		}
+
+		b = append(b, code, byte(len(v)))
+		b = append(b, v...)
+	}
+
+	return append(b, optEnd)
+}
+
+func parseDHCPMessage(b []byte) (*dhcpMessage, error) {
+	if len(b) < 240 || string(b[236:240]) != string(magicCookie) {
+		return nil, errors.New("invalid DHCP message")
+	}
+
+	hlen := int(b[2])
+	if hlen > 16 {
+		return nil, errors.New("invalid DHCP hardware address length")
+	}
+
+	m := &dhcpMessage{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		yiaddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		chaddr:  net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...)),
+		options: make(map[byte][]byte),
+	}
+
+	opts := b[240:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == optEnd {
+			break
+		}
+
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, errors.New("truncated DHCP option")
+		}
+
+		n := int(opts[1])
+		m.options[code] = append(m.options[code], opts[2:2+n]...)
+		opts = opts[2+n:]
+	}
+
+	return m, nil
+}
+
+func (m *dhcpMessage) messageType() byte {
+	if v := m.options[optMessageType]; len(v) == 1 {
+		return v[0]
+	}
+
+	return 0
+}
+
+// lease extracts the lease from an ACK.
+func (m *dhcpMessage) lease() *Lease {
+	l := &Lease{
+		IP:       m.yiaddr,
+		Gateway:  firstIP(m.options[optRouter]),
+		DNS:      ipList(m.options[optDNS]),
+		NTP:      ipList(m.options[optNTP]),
+		Hostname: string(m.options[optHostname]),
+		Domain:   string(m.options[optDomainName]),
+		ServerID: firstIP(m.options[optServerID]),
+	}
+
+	if v := m.options[optSubnetMask]; len(v) == 4 {
+		l.Netmask = net.IPMask(v)
+	} else {
+		l.Netmask = l.IP.DefaultMask()
+	}
+
+	if v := m.options[optLeaseTime]; len(v) == 4 {
+		l.LeaseTime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+
+	return l
+}
+
+func firstIP(b []byte) net.IP {
+	if ips := ipList(b); len(ips) > 0 {
+		return ips[0]
+	}
+
+	return nil
+}
+
+func ipList(b []byte) []net.IP {
+	var ips []net.IP
+	for ; len(b) >= 4; b = b[4:] {
+		ips = append(ips, net.IP(append([]byte(nil), b[:4]...)))
+	}
+
+	return ips
+}
+
+// transport sends and receives DHCP messages.
+type transport interface {
+	Send(b []byte) error
+	// Receive returns the next message, or an error once the deadline passes.
+	Receive(deadline time.Time) ([]byte, error)
+}
+
+// dhcpRetransmit is the initial retransmission timeout, it doubles with each
+// retransmission (up to a minute).
+var dhcpRetransmit = 2 * time.Second
+
+// exchange performs a DISCOVER, OFFER, REQUEST, ACK exchange.
+func exchange(ctx context.Context, t transport, mac net.HardwareAddr, xid uint32) (*Lease, error) {
+	discover := &dhcpMessage{
+		op:     1,
+		xid:    xid,
+		flags:  0x8000, // Ask for broadcast replies, as we have no address yet.
+		chaddr: mac,
+		options: map[byte][]byte{
+			optMessageType:  {dhcpDiscover},
+			optParamRequest: {optSubnetMask, optRouter, optDNS, optHostname, optDomainName, optNTP, optLeaseTime},
+		},
+	}
+
+	offer, err := roundTrip(ctx, t, discover, dhcpOffer)
+	if err != nil {
+		return nil, fmt.Errorf("no DHCP offer: %w", err)
+	}
+
+	request := &dhcpMessage{
+		op:     1,
+		xid:    xid,
+		flags:  0x8000,
+		chaddr: mac,
+		options: map[byte][]byte{
+			optMessageType:  {dhcpRequest},
+			optRequestedIP:  offer.yiaddr.To4(),
+			optServerID:     offer.options[optServerID],
+			optParamRequest: discover.options[optParamRequest],
+		},
+	}
+
+	ack, err := roundTrip(ctx, t, request, dhcpAck)
+	if err != nil {
+		return nil, fmt.Errorf("no DHCP acknowledgement: %w", err)
+	}
+
+	return ack.lease(), nil
+}
+
+// roundTrip sends msg (retransmitting with backoff) until a reply of the
+// expected type, with a matching transaction ID, is received.
+func roundTrip(ctx context.Context, t transport, msg *dhcpMessage, expected byte) (*dhcpMessage, error) {
+	timeout := dhcpRetransmit
+	for {
+		if err := t.Send(msg.marshal()); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+
+		for {
+			b, err := t.Receive(deadline)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					return nil, err
+				}
+
+				break
+			}
+
+			reply, err := parseDHCPMessage(b)
+			if err != nil || reply.op != 2 || reply.xid != msg.xid {
+				continue
+			}
+
+			switch reply.messageType() {
+			case expected:
+				return reply, nil
+			case dhcpNak:
+				return nil, errors.New("DHCP request rejected")
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		slog.Debug("Retransmitting DHCP message", slog.Int("type", int(msg.messageType())), slog.Duration("timeout", timeout))
+
+		timeout = min(timeout*2, time.Minute)
+	}
+}
+
+// udpTransport broadcasts DHCP messages from an interface that has no
+// address yet.
+type udpTransport struct {
+	conn net.PacketConn
+}
+
+func newUDPTransport(iface string) (*udpTransport, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.IPPROTO_UDP)
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "dhcp")
+	defer f.Close()
+
+	for _, opt := range []int{unix.SO_REUSEADDR, unix.SO_BROADCAST} {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, opt, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface); err != nil {
+		return nil, fmt.Errorf("failed to bind to %s: %w", iface, err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrInet4{Port: 68}); err != nil {
+		return nil, err
+	}
+
+	// FilePacketConn dups the socket.
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpTransport{conn: conn}, nil
+}
+
+func (t *udpTransport) Send(b []byte) error {
+	_, err := t.conn.WriteTo(b, &net.UDPAddr{IP: net.IPv4bcast, Port: 67})
+	return err
+}
+
+func (t *udpTransport) Receive(deadline time.Time) ([]byte, error) {
+	if err := t.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 1500)
+	n, _, err := t.conn.ReadFrom(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b[:n], nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// RequestLease obtains a lease for the interface with DHCPv4.
+func RequestLease(ctx context.Context, iface *net.Interface) (*Lease, error) {
+	t, err := newUDPTransport(iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	var xid [4]byte
+	if _, err := rand.Read(xid[:]); err != nil {
+		return nil, err
+	}
+
+	return exchange(ctx, t, iface.HardwareAddr, binary.BigEndian.Uint32(xid[:]))
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package netconf
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeServer is a DHCP server that replies to each message it receives.
+type fakeServer struct {
+	t        *testing.T
+	replies  [][]byte
+	requests []*dhcpMessage
+	// dropFirst drops the first discover, to test retransmission.
+	dropFirst bool
+}
+
+func (s *fakeServer) Send(b []byte) error {
+	msg, err := parseDHCPMessage(b)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.requests = append(s.requests, msg)
+
+	if s.dropFirst {
+		s.dropFirst = false
+		return nil
+	}
+
+	reply := &dhcpMessage{
+		op:     2,
+		xid:    msg.xid,
+		yiaddr: net.IPv4(10, 0, 0, 42),
+		chaddr: msg.chaddr,
+		options: map[byte][]byte{
+			optServerID:   {10, 0, 0, 1},
+			optSubnetMask: {255, 255, 0, 0},
+			optRouter:     {10, 0, 0, 1},
+			optDNS:        {10, 0, 0, 2, 10, 0, 0, 3},
+			optHostname:   []byte("thin01"),
+			optLeaseTime:  {0, 0, 0x0e, 0x10},
+		},
+	}
+
+	switch msg.messageType() {
+	case dhcpDiscover:
+		reply.options[optMessageType] = []byte{dhcpOffer}
+	case dhcpRequest:
+		reply.options[optMessageType] = []byte{dhcpAck}
+	}
+
+	// A reply for another client should be ignored.
+	other := *reply
+	other.xid++
+
+	s.replies = append(s.replies, other.marshal(), reply.marshal())
+	return nil
+}
+
+func (s *fakeServer) Receive(deadline time.Time) ([]byte, error) {
+	if len(s.replies) == 0 {
+		time.Sleep(time.Until(deadline))
+		return nil, os.ErrDeadlineExceeded
+	}
+
+	b := s.replies[0]
+	s.replies = s.replies[1:]
+	return b, nil
+}
+
+func TestExchange(t *testing.T) {
+	dhcpRetransmit = 10 * time.Millisecond
+
+	server := &fakeServer{t: t, dropFirst: true}
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease, err := exchange(ctx, server, mac, 0xdeadbeef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Lease{
+		IP:        net.IPv4(10, 0, 0, 42).To4(),
+		Netmask:   net.IPv4Mask(255, 255, 0, 0),
+		Gateway:   net.IPv4(10, 0, 0, 1).To4(),
+		DNS:       []net.IP{net.IPv4(10, 0, 0, 2).To4(), net.IPv4(10, 0, 0, 3).To4()},
+		Hostname:  "thin01",
+		ServerID:  net.IPv4(10, 0, 0, 1).To4(),
+		LeaseTime: time.Hour,
+	}
+	if !reflect.DeepEqual(lease, expected) {
+		t.Errorf("expected %+v, got %+v", expected, lease)
+	}
+
+	if len(server.requests) != 3 {
+		t.Fatalf("expected 2 discovers and a request, got %d messages", len(server.requests))
+	}
+
+	request := server.requests[2]
+	if request.messageType() != dhcpRequest || net.IP(request.options[optRequestedIP]).String() != "10.0.0.42" {
+		t.Errorf("unexpected request: %+v", request)
+	}
+
+	if request.chaddr.String() != mac.String() {
+		t.Errorf("expected the hardware address %s, got %s", mac, request.chaddr)
+	}
+}
+
+func TestExchangeTimeout(t *testing.T) {
+	dhcpRetransmit = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	server := &silentServer{}
+	if _, err := exchange(ctx, server, net.HardwareAddr{0, 1, 2, 3, 4, 5}, 1); err == nil {
+		t.Fatal("expected an error without a DHCP server")
+	}
+
+	if server.sent < 2 {
+		t.Errorf("expected the discover to be retransmitted, sent %d", server.sent)
+	}
+}
+
+type silentServer struct {
+	sent int
+}
+
+func (s *silentServer) Send([]byte) error {
+	s.sent++
+	return nil
+}
+
+func (s *silentServer) Receive(deadline time.Time) ([]byte, error) {
+	time.Sleep(time.Until(deadline))
+	return nil, os.ErrDeadlineExceeded
+}
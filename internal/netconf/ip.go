@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package netconf brings up a network interface early in boot (with DHCPv4
+// or a static configuration), so that network-backed data filesystems and
+// remote config fetching work without an initramfs.
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Autoconf is the autoconfiguration protocol.
+type Autoconf string
+
+const (
+	// AutoconfOff uses the static configuration.
+	AutoconfOff Autoconf = "off"
+	// AutoconfDHCP uses DHCPv4.
+	AutoconfDHCP Autoconf = "dhcp"
+)
+
+// Config is a network configuration, in the form of the kernel's ip=
+// parameter.
+type Config struct {
+	ClientIP net.IP
+	ServerIP net.IP
+	Gateway  net.IP
+	Netmask  net.IPMask
+	Hostname string
+	// Device is the interface to configure (the first with a carrier if
+	// empty).
+	Device   string
+	Autoconf Autoconf
+	DNS      []net.IP
+	NTP      []net.IP
+}
+
+// ParseIP parses a configuration in the kernel's ip= syntax, either a bare
+// autoconfiguration protocol (eg. "dhcp") or:
+//
+//	<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>:<dns0-ip>:<dns1-ip>:<ntp0-ip>
+//
+// Only DHCP autoconfiguration is supported (bootp and rarp are not). An
+// empty string, "off" or "none" returns nil (no network configuration).
+func ParseIP(s string) (*Config, error) {
+	if !strings.Contains(s, ":") {
+		autoconf, err := parseAutoconf(s)
+		if err != nil || autoconf == AutoconfOff {
+			return nil, err
+		}
+
+		return &Config{Autoconf: autoconf}, nil
+	}
+
+	fields := strings.Split(s, ":")
+	if len(fields) > 10 {
+		return nil, fmt.Errorf("too many fields in ip=%s", s)
+	}
+
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+
+		return ""
+	}
+
+	var conf Config
+	var err error
+
+	for i, dst := range []*net.IP{&conf.ClientIP, &conf.ServerIP, &conf.Gateway} {
+		if *dst, err = parseIPv4(field(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	if netmask := field(3); netmask != "" {
+		ip, err := parseIPv4(netmask)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.Netmask = net.IPMask(ip.To4())
+	}
+
+	conf.Hostname = field(4)
+	conf.Device = field(5)
+
+	// If no client address is given, the kernel defaults to autoconfiguration.
+	autoconf := field(6)
+	if autoconf == "" && conf.ClientIP == nil {
+		autoconf = string(AutoconfDHCP)
+	}
+
+	if conf.Autoconf, err = parseAutoconf(autoconf); err != nil {
+		return nil, err
+	}
+
+	if conf.Autoconf == AutoconfOff && conf.ClientIP == nil {
+		return nil, fmt.Errorf("a client address is required without autoconfiguration: ip=%s", s)
+	}
+
+	for i := 7; i <= 8; i++ {
+		if ip, err := parseIPv4(field(i)); err != nil {
+			return nil, err
+		} else if ip != nil {
+			conf.DNS = append(conf.DNS, ip)
+		}
+	}
+
+	if ip, err := parseIPv4(field(9)); err != nil {
+		return nil, err
+	} else if ip != nil {
+		conf.NTP = append(conf.NTP, ip)
+	}
+
+	return &conf, nil
+}
+
+func parseAutoconf(s string) (Autoconf, error) {
+	switch s {
+	case "", "off", "none":
+		return AutoconfOff, nil
+	case "on", "any", "dhcp":
+		return AutoconfDHCP, nil
+	default:
+		return "", fmt.Errorf("unsupported autoconfiguration protocol %q", s)
+	}
+}
+
+func parseIPv4(s string) (net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %q", s)
+	}
+
+	return ip, nil
+}
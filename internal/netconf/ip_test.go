@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package netconf_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/netconf"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected *netconf.Config
+	}{
+		{"", nil},
+		{"off", nil},
+		{"dhcp", &netconf.Config{Autoconf: netconf.AutoconfDHCP}},
+		{"on", &netconf.Config{Autoconf: netconf.AutoconfDHCP}},
+		{
+			"192.168.1.10::192.168.1.1:255.255.255.0:thin01:eth0:off:192.168.1.2:192.168.1.3:192.168.1.4",
+			&netconf.Config{
+				ClientIP: net.IPv4(192, 168, 1, 10).To4(),
+				Gateway:  net.IPv4(192, 168, 1, 1).To4(),
+				Netmask:  net.IPv4Mask(255, 255, 255, 0),
+				Hostname: "thin01",
+				Device:   "eth0",
+				Autoconf: netconf.AutoconfOff,
+				DNS:      []net.IP{net.IPv4(192, 168, 1, 2).To4(), net.IPv4(192, 168, 1, 3).To4()},
+				NTP:      []net.IP{net.IPv4(192, 168, 1, 4).To4()},
+			},
+		},
+		{
+			":::::eth1:dhcp",
+			&netconf.Config{Device: "eth1", Autoconf: netconf.AutoconfDHCP},
+		},
+		{
+			// Without a client address, autoconfiguration is the default.
+			":::::eth1",
+			&netconf.Config{Device: "eth1", Autoconf: netconf.AutoconfDHCP},
+		},
+	}
+
+	for _, tt := range tests {
+		conf, err := netconf.ParseIP(tt.in)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", tt.in, err)
+		}
+
+		if !reflect.DeepEqual(conf, tt.expected) {
+			t.Errorf("parsing %q: expected %+v, got %+v", tt.in, tt.expected, conf)
+		}
+	}
+
+	for _, in := range []string{"rarp", "not-an-ip::::::off", ":::::eth0:off", "1:2:3:4:5:6:7:8:9:10:11"} {
+		if _, err := netconf.ParseIP(in); err == nil {
+			t.Errorf("expected an error parsing %q", in)
+		}
+	}
+}
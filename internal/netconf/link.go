@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSysClassNet is where the kernel lists network interfaces.
+const DefaultSysClassNet = "/sys/class/net"
+
+// SelectInterface returns the named interface, or if name is empty, the first
+// (non-loopback) Ethernet interface to report a carrier.
+func SelectInterface(ctx context.Context, name string) (*net.Interface, error) {
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setLinkUp(iface.Index); err != nil {
+			return nil, fmt.Errorf("failed to bring up %s: %w", iface.Name, err)
+		}
+
+		return iface, waitCarrier(ctx, DefaultSysClassNet, []string{iface.Name}, func(string) {})
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+
+		if err := setLinkUp(iface.Index); err != nil {
+			continue
+		}
+
+		names = append(names, iface.Name)
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("no network interfaces found")
+	}
+
+	var selected string
+	if err := waitCarrier(ctx, DefaultSysClassNet, names, func(name string) { selected = name }); err != nil {
+		return nil, err
+	}
+
+	return net.InterfaceByName(selected)
+}
+
+// waitCarrier waits until one of the named interfaces reports a carrier,
+// calling found with its name.
+func waitCarrier(ctx context.Context, sysClassNet string, names []string, found func(name string)) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range names {
+			carrier, err := os.ReadFile(filepath.Join(sysClassNet, name, "carrier"))
+			if err == nil && strings.TrimSpace(string(carrier)) == "1" {
+				found(name)
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for a carrier on %s: %w", strings.Join(names, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// setLinkUp brings up an interface.
+func setLinkUp(index int) error {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(msg[8:12], unix.IFF_UP)
+	binary.NativeEndian.PutUint32(msg[12:16], unix.IFF_UP)
+
+	return netlinkRequest(unix.RTM_NEWLINK, 0, msg)
+}
+
+// addAddress assigns an IPv4 address to an interface.
+func addAddress(index int, ip net.IP, mask net.IPMask) error {
+	ones, _ := mask.Size()
+
+	msg := make([]byte, unix.SizeofIfAddrmsg)
+	msg[0] = unix.AF_INET
+	msg[1] = byte(ones)
+	msg[3] = unix.RT_SCOPE_UNIVERSE
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(index))
+
+	broadcast := make(net.IP, 4)
+	for i := range broadcast {
+		broadcast[i] = ip.To4()[i] | ^mask[i]
+	}
+
+	msg = appendAttr(msg, unix.IFA_LOCAL, ip.To4())
+	msg = appendAttr(msg, unix.IFA_ADDRESS, ip.To4())
+	msg = appendAttr(msg, unix.IFA_BROADCAST, broadcast)
+
+	return netlinkRequest(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg)
+}
+
+// addDefaultRoute adds a default route via gateway.
+func addDefaultRoute(index int, gateway net.IP) error {
+	msg := make([]byte, unix.SizeofRtMsg)
+	msg[0] = unix.AF_INET
+	msg[4] = unix.RT_TABLE_MAIN
+	msg[5] = unix.RTPROT_BOOT
+	msg[6] = unix.RT_SCOPE_UNIVERSE
+	msg[7] = unix.RTN_UNICAST
+
+	oif := make([]byte, 4)
+	binary.NativeEndian.PutUint32(oif, uint32(index))
+
+	msg = appendAttr(msg, unix.RTA_GATEWAY, gateway.To4())
+	msg = appendAttr(msg, unix.RTA_OIF, oif)
+
+	return netlinkRequest(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg)
+}
+
+func appendAttr(b []byte, attrType uint16, data []byte) []byte {
+	hdr := make([]byte, unix.SizeofRtAttr)
+	binary.NativeEndian.PutUint16(hdr[0:2], uint16(unix.SizeofRtAttr+len(data)))
+	binary.NativeEndian.PutUint16(hdr[2:4], attrType)
+
+	b = append(b, hdr...)
+	b = append(b, data...)
+
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// netlinkRequest sends a route netlink request, and waits for it to be
+// acknowledged. Requests creating something that already exists succeed.
+func netlinkRequest(msgType uint16, flags uint16, payload []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	const seq = 1
+
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.SizeofNlMsghdr+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, payload...)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+
+			if len(m.Data) < 4 {
+				return errors.New("truncated netlink acknowledgement")
+			}
+
+			errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4]))
+			if errno == 0 || unix.Errno(errno) == unix.EEXIST {
+				return nil
+			}
+
+			return unix.Errno(errno)
+		}
+	}
+}
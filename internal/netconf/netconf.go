@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package netconf
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Configure brings up an interface and assigns it an address (statically or
+// using DHCP), returning the interface and its configuration.
+func Configure(ctx context.Context, conf *Config) (*net.Interface, *Lease, error) {
+	iface, err := SelectInterface(ctx, conf.Device)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.Info("Configuring network interface", slog.String("interface", iface.Name),
+		slog.String("autoconf", string(conf.Autoconf)))
+
+	var lease *Lease
+	if conf.Autoconf == AutoconfDHCP {
+		if lease, err = RequestLease(ctx, iface); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		lease = &Lease{IP: conf.ClientIP, Netmask: conf.Netmask, Gateway: conf.Gateway}
+		if lease.Netmask == nil {
+			lease.Netmask = lease.IP.DefaultMask()
+		}
+	}
+
+	// Statically configured values take precedence over DHCP.
+	if conf.Hostname != "" {
+		lease.Hostname = conf.Hostname
+	}
+
+	if len(conf.DNS) > 0 {
+		lease.DNS = conf.DNS
+	}
+
+	if len(conf.NTP) > 0 {
+		lease.NTP = conf.NTP
+	}
+
+	if err := addAddress(iface.Index, lease.IP, lease.Netmask); err != nil {
+		return nil, nil, fmt.Errorf("failed to assign address: %w", err)
+	}
+
+	if lease.Gateway != nil {
+		if err := addDefaultRoute(iface.Index, lease.Gateway); err != nil {
+			return nil, nil, fmt.Errorf("failed to add default route: %w", err)
+		}
+	}
+
+	ones, _ := lease.Netmask.Size()
+	slog.Info("Configured network interface", slog.String("interface", iface.Name),
+		slog.String("address", fmt.Sprintf("%s/%d", lease.IP, ones)), slog.Any("gateway", lease.Gateway),
+		slog.Any("dns", lease.DNS))
+
+	return iface, lease, nil
+}
+
+// UseDNS points the (pure Go) resolver at the lease's DNS servers, as
+// /etc/resolv.conf is part of the read-only image.
+func UseDNS(lease *Lease) {
+	if len(lease.DNS) == 0 {
+		return
+	}
+
+	servers := lease.DNS
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			var err error
+			for _, server := range servers {
+				var conn net.Conn
+				if conn, err = d.DialContext(ctx, network, net.JoinHostPort(server.String(), "53")); err == nil {
+					return conn, nil
+				}
+			}
+
+			return nil, err
+		},
+	}
+}
+
+// WriteResolvConf writes the lease's DNS configuration in resolv.conf format.
+func WriteResolvConf(path string, lease *Lease) error {
+	var sb strings.Builder
+	if lease.Domain != "" {
+		fmt.Fprintf(&sb, "search %s\n", lease.Domain)
+	}
+
+	for _, server := range lease.DNS {
+		fmt.Fprintf(&sb, "nameserver %s\n", server)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
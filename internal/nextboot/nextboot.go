@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package nextboot reads and writes the marker on the data filesystem that
+// switches between a volatile and a persistent data mount for the next boot
+// (only).
+package nextboot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path is the path of the marker, relative to the data filesystem.
+const Path = ".matchstick/next-boot"
+
+// Mode is the data mount requested for the next boot.
+type Mode string
+
+const (
+	// Volatile requests a volatile (tmpfs) data mount.
+	Volatile Mode = "volatile"
+	// Persistent requests the persistent data device be mounted.
+	Persistent Mode = "persistent"
+)
+
+// ParseMode parses a next boot mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case Volatile, Persistent:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown next boot mode %q", s)
+	}
+}
+
+// Read returns the mode requested by the marker on the data filesystem
+// mounted at mount (empty if there is no marker).
+func Read(mount string) (Mode, error) {
+	data, err := os.ReadFile(filepath.Join(mount, Path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return ParseMode(strings.TrimSpace(string(data)))
+}
+
+// Write writes the marker requesting mode to the data filesystem mounted at
+// mount.
+func Write(mount string, mode Mode) error {
+	path := filepath.Join(mount, Path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(string(mode)+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Clear removes the marker (if any) from the data filesystem mounted at
+// mount.
+func Clear(mount string) error {
+	err := os.Remove(filepath.Join(mount, Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
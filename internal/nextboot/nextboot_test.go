@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package nextboot_test
+
+import (
+	"testing"
+
+	"github.com/immutos/matchstick/internal/nextboot"
+)
+
+func TestMarker(t *testing.T) {
+	mount := t.TempDir()
+
+	if mode, err := nextboot.Read(mount); err != nil || mode != "" {
+		t.Fatalf("Read() = %q, %v, want no marker", mode, err)
+	}
+
+	if err := nextboot.Write(mount, nextboot.Volatile); err != nil {
+		t.Fatal(err)
+	}
+
+	if mode, err := nextboot.Read(mount); err != nil || mode != nextboot.Volatile {
+		t.Errorf("Read() = %q, %v, want volatile", mode, err)
+	}
+
+	if err := nextboot.Clear(mount); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nextboot.Clear(mount); err != nil {
+		t.Errorf("expected clearing a missing marker to succeed: %v", err)
+	}
+
+	if _, err := nextboot.ParseMode("sometimes"); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package nofollow opens files within trees that may be modified by
+// unprivileged users (eg. the upper directories of the overlays), without
+// following symlinks.
+package nofollow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenDir opens the directory rel (below root), creating any missing
+// directories. Symlinks (and anything else that isn't a directory) below root
+// are refused.
+func OpenDir(root, rel string) (*os.File, error) {
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	path := root
+	for _, name := range strings.Split(strings.Trim(filepath.Clean("/"+rel), "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		path = filepath.Join(path, name)
+
+		if err := unix.Mkdirat(fd, name, 0o755); err != nil && !errors.Is(err, unix.EEXIST) {
+			unix.Close(fd)
+			return nil, &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+
+		next, err := unix.Openat(fd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Openat opens name in the directory dirfd (creating it with mode, if O_CREAT
+// is set), without following a symlink. Opening a symlink fails with ELOOP,
+// and with O_DIRECTORY anything other than a directory fails with ENOTDIR.
+func Openat(dirfd int, name string, flags int, mode uint32) (*os.File, error) {
+	fd, err := unix.Openat(dirfd, name, flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package overlay probes how overlay filesystems can be mounted, so that
+// overlays can be set up in (possibly unprivileged) containers.
+package overlay
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// DefaultFuseOverlayfs is the default path of the fuse-overlayfs binary.
+const DefaultFuseOverlayfs = "/usr/bin/fuse-overlayfs"
+
+// Mode is how overlays are mounted.
+type Mode string
+
+const (
+	// Auto probes for the first mode that works.
+	Auto Mode = "auto"
+	// Kernel uses the kernel's overlay filesystem (which requires
+	// CAP_SYS_ADMIN in the initial user namespace).
+	Kernel Mode = "kernel"
+	// UserXattr uses the kernel's overlay filesystem with the userxattr
+	// option, which can be mounted within a user namespace (Linux 5.11+).
+	UserXattr Mode = "userxattr"
+	// Fuse uses fuse-overlayfs, which only requires access to /dev/fuse.
+	Fuse Mode = "fuse"
+)
+
+// ParseMode parses an overlay mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case Auto, Kernel, UserXattr, Fuse:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown overlay mode %q", s)
+	}
+}
+
+// Options returns the kernel overlay mount options for mode.
+func Options(mode Mode, data string) string {
+	if mode == UserXattr {
+		return data + ",userxattr"
+	}
+
+	return data
+}
+
+// Mount mounts an overlay on target in the given mode with m. data are the
+// kernel overlay mount options (lowerdir, upperdir and workdir).
+func Mount(m mounter.Mounter, mode Mode, fuseOverlayfs, target, data string) error {
+	switch mode {
+	case Kernel, UserXattr:
+		return m.Mount("overlay", target, "overlay", 0, Options(mode, data))
+	case Fuse:
+		// fuse-overlayfs daemonizes once the overlay is mounted.
+		out, err := exec.Command(fuseOverlayfs, "-o", data, target).CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("fuse-overlayfs failed: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+
+		// The mount is made by fuse-overlayfs, rather than m.
+		mounter.Record(m, mounter.Op{Kind: "mount", Source: "fuse-overlayfs", Target: target,
+			FSType: "fuse.fuse-overlayfs", Data: data, Err: err})
+
+		return err
+	default:
+		return fmt.Errorf("unsupported overlay mode %q", mode)
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package overlay_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/overlay"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+func TestParseMode(t *testing.T) {
+	if m, err := overlay.ParseMode("UserXattr"); err != nil || m != overlay.UserXattr {
+		t.Errorf("ParseMode() = %q, %v, want userxattr", m, err)
+	}
+
+	if _, err := overlay.ParseMode("aufs"); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	data := "lowerdir=/etc,upperdir=/mnt/data/etc,workdir=/mnt/data/.etc-work"
+
+	if got := overlay.Options(overlay.Kernel, data); got != data {
+		t.Errorf("Options(kernel) = %q, want %q", got, data)
+	}
+
+	if got, want := overlay.Options(overlay.UserXattr, data), data+",userxattr"; got != want {
+		t.Errorf("Options(userxattr) = %q, want %q", got, want)
+	}
+}
+
+func TestMountFuse(t *testing.T) {
+	dir := t.TempDir()
+
+	// A stand-in for fuse-overlayfs that records its arguments.
+	args := filepath.Join(dir, "args")
+	fake := filepath.Join(dir, "fuse-overlayfs")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\necho \"$@\" > "+args+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := mounter.NewRecorder(mounter.NewFake("/etc"))
+
+	if err := overlay.Mount(m, overlay.Fuse, fake, "/etc", "lowerdir=/etc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops := m.Ops(); len(ops) != 1 || ops[0].FSType != "fuse.fuse-overlayfs" || ops[0].Target != "/etc" {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "-o lowerdir=/etc /etc\n"; string(data) != want {
+		t.Errorf("fuse-overlayfs arguments = %q, want %q", data, want)
+	}
+
+	if err := overlay.Mount(m, overlay.Fuse, filepath.Join(dir, "missing"), "/etc", "lowerdir=/etc"); err == nil {
+		t.Error("expected error when fuse-overlayfs is missing")
+	}
+}
+
+func TestMountKernel(t *testing.T) {
+	m := mounter.NewFake("/etc")
+
+	if err := overlay.Mount(m, overlay.UserXattr, "", "/etc", "lowerdir=/etc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Ops) != 1 || m.Ops[0].FSType != "overlay" || m.Ops[0].Data != "lowerdir=/etc,userxattr" {
+		t.Errorf("unexpected operations: %+v", m.Ops)
+	}
+}
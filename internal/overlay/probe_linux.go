@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+// Probe returns the first mode in which an overlay can be mounted, by
+// mounting (and unmounting) a test overlay within dir, which is removed
+// afterwards. dir should be on the filesystem the upper directories will be
+// on, as not every filesystem supports user extended attributes.
+func Probe(m mounter.Mounter, dir, fuseOverlayfs string) (Mode, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	lower, upper, work, merged := filepath.Join(dir, "lower"), filepath.Join(dir, "upper"),
+		filepath.Join(dir, "work"), filepath.Join(dir, "merged")
+	for _, d := range []string{lower, upper, work, merged} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			return "", err
+		}
+	}
+
+	data := "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work
+
+	var errs []error
+	for _, mode := range []Mode{Kernel, UserXattr, Fuse} {
+		err := Mount(m, mode, fuseOverlayfs, merged, data)
+		if err == nil {
+			if err := m.Unmount(merged, unix.MNT_DETACH); err != nil {
+				return "", fmt.Errorf("failed to unmount test overlay: %w", err)
+			}
+
+			return mode, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", mode, err))
+	}
+
+	return "", fmt.Errorf("overlays can't be mounted: %w", errors.Join(errs...))
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/config"
+)
+
+// Passthrough is which of the arguments matchstick was executed with are
+// passed through to init.
+type Passthrough string
+
+const (
+	// PassthroughNone passes no arguments through.
+	PassthroughNone Passthrough = "none"
+	// PassthroughPositional passes through the arguments that aren't
+	// matchstick's own flags (eg. "single", as passed by the kernel).
+	PassthroughPositional Passthrough = "positional"
+	// PassthroughAll passes through every argument, including matchstick's own
+	// flags.
+	PassthroughAll Passthrough = "all"
+)
+
+// ParsePassthrough parses a passthrough policy.
+func ParsePassthrough(s string) (Passthrough, error) {
+	switch p := Passthrough(strings.ToLower(s)); p {
+	case PassthroughNone, PassthroughPositional, PassthroughAll:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown init args passthrough policy %q", s)
+	}
+}
+
+// Argv returns the argument vector init will be executed with: cmd, cmd_args,
+// then the passed through arguments.
+func Argv(opts *config.Options, args []string) []string {
+	argv := append([]string{opts.Cmd}, opts.Args()...)
+	return append(argv, args...)
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// ParseAttrs parses the permissions (in octal, eg. "0750"), owner ("uid" or
+// "uid:gid", numerically as the image's user database isn't necessarily
+// available yet) and security label of a directory. If all are empty, nil is
+// returned, leaving the directory as created.
+func ParseAttrs(mode, owner, label string) (*mounter.Attrs, error) {
+	if mode == "" && owner == "" && label == "" {
+		return nil, nil
+	}
+
+	attrs := &mounter.Attrs{UID: -1, GID: -1, Label: label}
+
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m == 0 || m > 0o7777 {
+			return nil, fmt.Errorf("invalid mode %q", mode)
+		}
+
+		attrs.Mode = uint32(m)
+	}
+
+	if owner != "" {
+		uid, gid, hasGID := strings.Cut(owner, ":")
+
+		var err error
+		if attrs.UID, err = parseID(uid); err != nil {
+			return nil, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+
+		if hasGID {
+			if attrs.GID, err = parseID(gid); err != nil {
+				return nil, fmt.Errorf("invalid owner %q: %w", owner, err)
+			}
+		}
+	}
+
+	return attrs, nil
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || id == 1<<32-1 {
+		return 0, fmt.Errorf("expected a numeric ID, got %q", s)
+	}
+
+	return int(id), nil
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultInitFallbacks are tried (in order) if init doesn't exist, as the
+// kernel does.
+var DefaultInitFallbacks = []string{"/sbin/init", "/etc/init", "/bin/init", "/bin/sh"}
+
+// FindInit returns the first of the candidates that is executable.
+func FindInit(candidates []string) (string, error) {
+	var errs []error
+	for _, path := range candidates {
+		err := Executable(path)
+		if err == nil {
+			return path, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return "", fmt.Errorf("no init found: %w", errors.Join(errs...))
+}
+
+// Executable returns an error if path isn't an executable regular file.
+func Executable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	if fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountFlags map the generic mount options (as for mount(8)) to the flags
+// that set (or with a zero value, are the default for) them.
+var mountFlags = map[string]uintptr{
+	"defaults":    0,
+	"rw":          0,
+	"ro":          unix.MS_RDONLY,
+	"suid":        0,
+	"nosuid":      unix.MS_NOSUID,
+	"dev":         0,
+	"nodev":       unix.MS_NODEV,
+	"exec":        0,
+	"noexec":      unix.MS_NOEXEC,
+	"async":       0,
+	"sync":        unix.MS_SYNCHRONOUS,
+	"atime":       0,
+	"noatime":     unix.MS_NOATIME,
+	"nodiratime":  unix.MS_NODIRATIME,
+	"relatime":    unix.MS_RELATIME,
+	"strictatime": unix.MS_STRICTATIME,
+}
+
+// hardeningFlags map the hardening options to the flag they set (or, for the
+// negated options, clear).
+var hardeningFlags = map[string]struct {
+	flag uintptr
+	set  bool
+}{
+	"nosuid": {unix.MS_NOSUID, true},
+	"suid":   {unix.MS_NOSUID, false},
+	"nodev":  {unix.MS_NODEV, true},
+	"dev":    {unix.MS_NODEV, false},
+	"noexec": {unix.MS_NOEXEC, true},
+	"exec":   {unix.MS_NOEXEC, false},
+}
+
+// Harden applies the hardening options (nosuid, nodev and noexec, or their
+// negations) to flags, in order.
+func Harden(flags uintptr, options []string) (uintptr, error) {
+	for _, opt := range options {
+		h, ok := hardeningFlags[strings.ToLower(strings.TrimSpace(opt))]
+		if !ok {
+			return 0, fmt.Errorf("unknown hardening option %q", opt)
+		}
+
+		if h.set {
+			flags |= h.flag
+		} else {
+			flags &^= h.flag
+		}
+	}
+
+	return flags, nil
+}
+
+// ParseMounts parses extra mount specifications, each of the form
+// source:target[:fstype[:options]], where the source may be a device tag (eg.
+// LABEL=scratch) and the options are comma separated (as for fstab). As
+// list options are split on commas, a value that doesn't start a new
+// specification (its second field isn't an absolute path) is taken to be a
+// continuation of the previous one's options. The targets are relative to
+// root.
+func ParseMounts(specs []string, root string) ([]ExtraMount, error) {
+	var joined []string
+	for _, spec := range specs {
+		_, rest, _ := strings.Cut(spec, ":")
+		if len(joined) > 0 && !strings.HasPrefix(rest, "/") {
+			joined[len(joined)-1] += "," + spec
+			continue
+		}
+
+		joined = append(joined, spec)
+	}
+
+	var mounts []ExtraMount
+	for _, spec := range joined {
+		fields := strings.SplitN(spec, ":", 4)
+		if len(fields) < 2 || fields[0] == "" || !filepath.IsAbs(fields[1]) {
+			return nil, fmt.Errorf("invalid mount %q, expected source:target[:fstype[:options]]", spec)
+		}
+
+		target := filepath.Clean(fields[1])
+		if target == "/" {
+			return nil, fmt.Errorf("invalid mount %q, can't mount on the root directory", spec)
+		}
+
+		em := ExtraMount{
+			Mount: Mount{
+				Source: ResolveDevice(fields[0]),
+				Target: filepath.Join(root, target),
+			},
+		}
+
+		if len(fields) > 2 {
+			em.FSType = fields[2]
+		}
+
+		if len(fields) > 3 {
+			var data []string
+			for _, opt := range strings.Split(fields[3], ",") {
+				if opt == "" {
+					continue
+				}
+
+				if flag, ok := mountFlags[opt]; ok {
+					em.Flags |= flag
+				} else if opt == "nofail" {
+					em.NoFail = true
+				} else {
+					data = append(data, opt)
+				}
+			}
+
+			em.Data = strings.Join(data, ",")
+		}
+
+		mounts = append(mounts, em)
+	}
+
+	return mounts, nil
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package plan computes the mount operations required to set up the data
+// filesystem and overlays, without performing them.
+package plan
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/devices"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// ProviderExisting is the provider of a data filesystem that is already
+// mounted (eg. a volume provided by a container runtime).
+const ProviderExisting = "existing"
+
+// Mount is a single mount operation.
+type Mount struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	FSType string  `json:"fstype"`
+	Flags  uintptr `json:"flags,omitempty"`
+	Data   string  `json:"data,omitempty"`
+	// Propagation is the propagation type set on the mount once it's mounted
+	// (eg. unix.MS_SHARED), zero leaves it as the kernel sets it.
+	Propagation uintptr `json:"propagation,omitempty"`
+	// Attrs are set on the root directory of the mounted filesystem (for an
+	// overlay, on its upper and work directories before it's mounted), nil
+	// leaves it as created.
+	Attrs *mounter.Attrs `json:"attrs,omitempty"`
+}
+
+// Overlay is an overlay filesystem mounted on top of a directory.
+type Overlay struct {
+	// Dir is the directory that will be overlaid (and used as the lowerdir).
+	Dir string `json:"dir"`
+	// UpperDir is the directory on the data filesystem receiving writes.
+	UpperDir string `json:"upperdir"`
+	// WorkDir is the overlayfs work directory on the data filesystem.
+	WorkDir string `json:"workdir"`
+	// Mount is the overlay mount operation.
+	Mount Mount `json:"mount"`
+}
+
+// ExtraMount is an additional filesystem, mounted after the overlays.
+type ExtraMount struct {
+	Mount
+	// NoFail causes a failure to mount it to be logged, rather than handled
+	// by the failure policy.
+	NoFail bool `json:"nofail,omitempty"`
+}
+
+// Plan is the full set of operations matchstick will perform before
+// executing init.
+type Plan struct {
+	// Root is the real root filesystem mount, when running from an initramfs.
+	// The data filesystem and overlays are mounted relative to it.
+	Root *Mount `json:"root,omitempty"`
+	// Provider is the name of the provider that will set up the data filesystem.
+	Provider string `json:"provider,omitempty"`
+	// Data is the data filesystem mount (nil if it's already mounted, eg. in
+	// a container, or disabled).
+	Data *Mount `json:"data,omitempty"`
+	// Overlays are the overlay mounts. Overlays may be mounted concurrently,
+	// but never before the overlay of a parent directory (see Parent).
+	Overlays []Overlay `json:"overlays,omitempty"`
+	// Mounts are the extra mounts, performed (in order) after the overlays.
+	Mounts []ExtraMount `json:"mounts,omitempty"`
+	// Skipped lists configured directories that will not be overlaid as they
+	// don't exist.
+	Skipped []string `json:"skipped,omitempty"`
+	// Argv is the argument vector init will be executed with.
+	Argv []string `json:"argv"`
+}
+
+// NestedDir is the directory (within the data filesystem) holding the upper
+// and work directories of overlays nested below another overlaid directory.
+const NestedDir = ".nested"
+
+// Parent returns the directory of the closest overlay that dir is below, or
+// an empty string if there is none. The parent must be mounted first, as
+// mounting it afterwards would hide the overlay on dir.
+func (p *Plan) Parent(dir string) string {
+	var parent string
+	for _, o := range p.Overlays {
+		if o.Dir != dir && strings.HasPrefix(dir, strings.TrimSuffix(o.Dir, "/")+"/") && len(o.Dir) > len(parent) {
+			parent = o.Dir
+		}
+	}
+
+	return parent
+}
+
+// RootOverlayDir is where the overlay of the whole root filesystem is
+// mounted, before pivoting into it.
+const RootOverlayDir = "/run/matchstick/root"
+
+// DeviceTag returns the (upper case) tag and its value, if device is given as
+// a tag (eg. LABEL=data).
+func DeviceTag(device string) (string, string, bool) {
+	return devices.Tag(device)
+}
+
+// DevicePath returns the path of a device given either as a path, or as a
+// tag (eg. LABEL=data).
+func DevicePath(device string) string {
+	return devices.Path(device)
+}
+
+// ResolveDevice resolves any tags and symlinks (eg. /dev/disk/by-label/...)
+// in the device path. If the device can't be resolved, it is returned
+// unchanged.
+func ResolveDevice(device string) string {
+	device = DevicePath(device)
+
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		return resolved
+	}
+
+	return device
+}
+
+// Print writes the plan to w as indented JSON.
+func (p *Plan) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
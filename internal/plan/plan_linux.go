@@ -0,0 +1,313 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/bootloader"
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/failure"
+	"github.com/immutos/matchstick/internal/fstrim"
+	"github.com/immutos/matchstick/internal/provider"
+	"golang.org/x/sys/unix"
+)
+
+// New computes the plan for the given options. args are the (passed through)
+// arguments to be passed to init.
+func New(opts *config.Options, args []string) (*Plan, error) {
+	p := &Plan{
+		Argv: Argv(opts, args),
+	}
+
+	// When running from an initramfs everything is mounted within the new
+	// root, before switching to it.
+	var root string
+	if opts.Root != "" {
+		root = opts.NewRoot
+
+		p.Root = &Mount{
+			Source: ResolveDevice(opts.Root),
+			Target: opts.NewRoot,
+			FSType: opts.RootFSType,
+			Flags:  unix.MS_RDONLY,
+			Data:   opts.RootOptions,
+		}
+
+		// The image is booted writable (for debugging).
+		if opts.Disable && opts.DisableRW {
+			p.Root.Flags = 0
+		}
+	}
+
+	// The bootloader only reads its state from the boot device (and the image
+	// is read-only).
+	kind, err := bootloader.ParseKind(opts.Bootloader)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != bootloader.None && opts.BootDevice == "" {
+		return nil, fmt.Errorf("boot_device must be specified with the %s bootloader", kind)
+	}
+
+	// The failed boot count is kept on the recovery device, so that it's
+	// reachable without the data filesystem.
+	if opts.RecoveryKernel != "" && opts.RecoveryDevice == "" {
+		return nil, errors.New("recovery_device must be specified with recovery_kernel")
+	}
+
+	// When disabled, the image is booted as-is.
+	if opts.Disable {
+		return p, nil
+	}
+
+	mount := filepath.Join(root, opts.Mount)
+
+	dataPropagation, err := ParsePropagation(opts.DataPropagation)
+	if err != nil {
+		return nil, fmt.Errorf("data propagation: %w", err)
+	}
+
+	propagation, err := ParsePropagation(opts.Propagation)
+	if err != nil {
+		return nil, err
+	}
+
+	dataFlags, err := Harden(0, opts.DataFlags)
+	if err != nil {
+		return nil, fmt.Errorf("data flags: %w", err)
+	}
+
+	overlayFlags, err := Harden(0, opts.OverlayFlags)
+	if err != nil {
+		return nil, fmt.Errorf("overlay flags: %w", err)
+	}
+
+	dataAttrs, err := ParseAttrs(opts.DataMode, opts.DataOwner, opts.DataLabel)
+	if err != nil {
+		return nil, fmt.Errorf("data: %w", err)
+	}
+
+	p.Provider = opts.Provider
+	if p.Provider == "" {
+		p.Provider = "block"
+		if opts.Volatile {
+			p.Provider = "tmpfs"
+		} else if provider.IsNFS(opts.DataFSType) {
+			p.Provider = "nfs"
+		}
+	}
+
+	switch p.Provider {
+	case ProviderExisting:
+		// There is nothing to mount.
+	case "tmpfs":
+		p.Data = &Mount{
+			Source: "tmpfs",
+			Target: mount,
+			FSType: "tmpfs",
+		}
+	case "block":
+		// The filesystem type is detected when mounting, if not specified.
+		if opts.Data == "" {
+			return nil, errors.New("data must be specified")
+		}
+
+		trim, err := fstrim.ParsePolicy(opts.Trim)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := failure.ParseCorrupt(opts.DataOnCorrupt); err != nil {
+			return nil, err
+		}
+
+		data := opts.DataOptions
+		if trim == fstrim.Discard {
+			data = strings.TrimPrefix(data+",discard", ",")
+		}
+
+		p.Data = &Mount{
+			Source: ResolveDevice(opts.Data),
+			Target: mount,
+			FSType: opts.DataFSType,
+			Data:   data,
+		}
+	case "nfs":
+		if _, _, err := provider.ParseNFSSource(opts.Data); err != nil {
+			return nil, err
+		}
+
+		// The server's address is resolved when mounting.
+		p.Data = &Mount{
+			Source: opts.Data,
+			Target: mount,
+			FSType: opts.DataFSType,
+			Data:   opts.DataOptions,
+		}
+	default:
+		// External providers resolve the device themselves.
+		p.Data = &Mount{
+			Source: opts.Data,
+			Target: mount,
+			FSType: opts.DataFSType,
+			Data:   opts.DataOptions,
+		}
+	}
+
+	if p.Data != nil {
+		p.Data.Flags |= dataFlags
+		p.Data.Propagation = dataPropagation
+		p.Data.Attrs = dataAttrs
+	}
+
+	// With the whole root filesystem overlaid, the extra mounts are made
+	// within the overlay (which becomes the root filesystem).
+	mountsRoot := root
+	if opts.OverlayRoot {
+		mountsRoot = RootOverlayDir
+	}
+
+	if p.Mounts, err = ParseMounts(opts.Mounts, mountsRoot); err != nil {
+		return nil, err
+	}
+
+	if opts.OverlayRoot {
+		o := rootOverlay(root, mount)
+		if err := o.Mount.applyDirOptions(opts.DirOptions["/"], overlayFlags, propagation); err != nil {
+			return nil, fmt.Errorf("directory /: %w", err)
+		}
+
+		p.Overlays = []Overlay{o}
+		return p, nil
+	}
+
+	for _, dir := range opts.Dirs {
+		lowerDir := filepath.Join(root, dir)
+
+		if _, err := os.Stat(lowerDir); os.IsNotExist(err) {
+			if opts.DirOptions[dir].Required {
+				return nil, fmt.Errorf("required directory %s does not exist", dir)
+			}
+
+			p.Skipped = append(p.Skipped, dir)
+			continue
+		}
+
+		upperDir, workDir := overlayDirs(mount, dir, opts.Dirs)
+
+		o := Overlay{
+			Dir:      dir,
+			UpperDir: upperDir,
+			WorkDir:  workDir,
+			Mount: Mount{
+				Source: "overlay",
+				Target: lowerDir,
+				FSType: "overlay",
+				Data:   overlayOptions(lowerDir, workDir, upperDir),
+			},
+		}
+
+		if err := o.Mount.applyDirOptions(opts.DirOptions[dir], overlayFlags, propagation); err != nil {
+			return nil, fmt.Errorf("directory %s: %w", dir, err)
+		}
+
+		p.Overlays = append(p.Overlays, o)
+	}
+
+	return p, nil
+}
+
+// applyDirOptions sets the flags (the overlay flags, hardened with those of
+// the directory), propagation and attributes of an overlay's mount from the
+// options of its directory.
+func (m *Mount) applyDirOptions(do config.DirOptions, flags, propagation uintptr) error {
+	var err error
+	if do.Propagation != "" {
+		if propagation, err = ParsePropagation(do.Propagation); err != nil {
+			return err
+		}
+	}
+
+	if m.Flags, err = Harden(flags, do.Flags); err != nil {
+		return err
+	}
+
+	if m.Attrs, err = ParseAttrs(do.Mode, do.Owner, do.Label); err != nil {
+		return err
+	}
+
+	m.Propagation = propagation
+
+	return nil
+}
+
+// overlayDirs returns the upper and work directories of the overlay on dir.
+// These are named after dir, unless dir is below another of the overlaid
+// dirs: its upper directory would then be inside the (live) upper directory
+// of the parent overlay, so it's given a separate (escaped) name within
+// NestedDir instead.
+func overlayDirs(mount, dir string, dirs []string) (string, string) {
+	name := strings.TrimPrefix(dir, "/")
+
+	for _, d := range dirs {
+		if d != dir && strings.HasPrefix(dir, strings.TrimSuffix(d, "/")+"/") {
+			mount = filepath.Join(mount, NestedDir)
+			name = url.QueryEscape(name)
+			break
+		}
+	}
+
+	return filepath.Join(mount, name), filepath.Join(mount, "."+name+"-work")
+}
+
+// overlayOptions returns the options of an overlay mount. Backslashes, commas
+// and colons in the paths are escaped, as overlayfs would otherwise take them
+// as separators.
+func overlayOptions(lowerDir, workDir, upperDir string) string {
+	escape := strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`).Replace
+
+	return "lowerdir=" + escape(lowerDir) + ",workdir=" + escape(workDir) + ",upperdir=" + escape(upperDir)
+}
+
+// rootOverlay returns the overlay of the whole root filesystem (root, or "/"
+// if not running from an initramfs).
+func rootOverlay(root, mount string) Overlay {
+	lowerDir := filepath.Join("/", root)
+	upperDir := filepath.Join(mount, "rootfs")
+	workDir := filepath.Join(mount, ".rootfs-work")
+
+	return Overlay{
+		Dir:      "/",
+		UpperDir: upperDir,
+		WorkDir:  workDir,
+		Mount: Mount{
+			Source: "overlay",
+			Target: RootOverlayDir,
+			FSType: "overlay",
+			Data:   overlayOptions(lowerDir, workDir, upperDir),
+		},
+	}
+}
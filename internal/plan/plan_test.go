@@ -0,0 +1,647 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package plan_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+func TestNew(t *testing.T) {
+	root := t.TempDir()
+	etc := filepath.Join(root, "etc")
+	missing := filepath.Join(root, "missing")
+
+	if err := os.MkdirAll(etc, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{
+		Data:       "/dev/vda2",
+		DataFSType: "ext4",
+		Mount:      "/mnt/data",
+		Dirs:       []string{etc, missing},
+		Cmd:        "/lib/systemd/systemd",
+	}
+
+	p, err := plan.New(opts, []string{"--log-level=debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data == nil || p.Data.Source != "/dev/vda2" || p.Data.FSType != "ext4" || p.Data.Target != "/mnt/data" {
+		t.Errorf("unexpected data mount: %+v", p.Data)
+	}
+
+	if len(p.Overlays) != 1 {
+		t.Fatalf("expected 1 overlay, got %d", len(p.Overlays))
+	}
+
+	o := p.Overlays[0]
+	wantUpper := filepath.Join("/mnt/data", etc)
+	wantWork := filepath.Join("/mnt/data", "."+etc[1:]+"-work")
+	if o.UpperDir != wantUpper || o.WorkDir != wantWork {
+		t.Errorf("unexpected overlay dirs: %+v", o)
+	}
+
+	if want := "lowerdir=" + etc + ",workdir=" + wantWork + ",upperdir=" + wantUpper; o.Mount.Data != want {
+		t.Errorf("overlay options = %q, want %q", o.Mount.Data, want)
+	}
+
+	if !reflect.DeepEqual(p.Skipped, []string{missing}) {
+		t.Errorf("Skipped = %v, want [%s]", p.Skipped, missing)
+	}
+
+	if !reflect.DeepEqual(p.Argv, []string{"/lib/systemd/systemd", "--log-level=debug"}) {
+		t.Errorf("unexpected argv: %v", p.Argv)
+	}
+}
+
+func TestNewMissingData(t *testing.T) {
+	if _, err := plan.New(&config.Options{Mount: "/mnt/data"}, nil); err == nil {
+		t.Error("expected error when data device is not specified")
+	}
+
+	// The filesystem type is detected when mounting.
+	p, err := plan.New(&config.Options{Data: "/dev/vda2", Mount: "/mnt/data"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Provider != "block" || p.Data.FSType != "" {
+		t.Errorf("unexpected data mount: %s %+v", p.Provider, p.Data)
+	}
+}
+
+func TestNewBootloader(t *testing.T) {
+	opts := &config.Options{Volatile: true, Mount: "/mnt/data", Bootloader: "grub"}
+
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error when the boot device is not specified")
+	}
+
+	opts.BootDevice = "LABEL=ESP"
+	if _, err := plan.New(opts, nil); err != nil {
+		t.Error(err)
+	}
+
+	opts.Bootloader = "lilo"
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an unknown bootloader")
+	}
+}
+
+func TestNewRecovery(t *testing.T) {
+	opts := &config.Options{Volatile: true, Mount: "/mnt/data", RecoveryKernel: "/recovery/vmlinuz"}
+
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error when the recovery device is not specified")
+	}
+
+	opts.RecoveryDevice = "LABEL=ESP"
+	if _, err := plan.New(opts, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewExisting(t *testing.T) {
+	opts := &config.Options{
+		Provider: plan.ProviderExisting,
+		Mount:    "/mnt/data",
+		Dirs:     []string{"/etc"},
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data != nil || len(p.Overlays) != 1 || p.Overlays[0].UpperDir != "/mnt/data/etc" {
+		t.Errorf("unexpected plan: %+v", p)
+	}
+}
+
+func TestNewRequiredDir(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	opts := &config.Options{
+		Volatile:   true,
+		Mount:      "/mnt/data",
+		Dirs:       []string{missing},
+		DirOptions: map[string]config.DirOptions{missing: {Required: true}},
+	}
+
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for missing required directory")
+	}
+}
+
+func TestNewDiscard(t *testing.T) {
+	opts := &config.Options{Data: "/dev/vda2", DataOptions: "noatime", Mount: "/mnt/data", Trim: "discard"}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data.Data != "noatime,discard" {
+		t.Errorf("data options = %q, want noatime,discard", p.Data.Data)
+	}
+
+	opts.DataOptions = ""
+
+	if p, err = plan.New(opts, nil); err != nil || p.Data.Data != "discard" {
+		t.Errorf("data options = %q (%v), want discard", p.Data.Data, err)
+	}
+
+	opts.Trim = "sometimes"
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an unknown trim policy")
+	}
+
+	opts.Trim, opts.DataOnCorrupt = "", "fsck"
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an unknown corrupt data filesystem policy")
+	}
+}
+
+func TestNewPropagation(t *testing.T) {
+	opts := &config.Options{
+		Volatile:        true,
+		Mount:           "/mnt/data",
+		Dirs:            []string{"/etc", "/var"},
+		DirOptions:      map[string]config.DirOptions{"/var": {Propagation: "private"}},
+		DataPropagation: "slave",
+		Propagation:     "RShared",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data.Propagation != unix.MS_SLAVE {
+		t.Errorf("data propagation = %#x, want MS_SLAVE", p.Data.Propagation)
+	}
+
+	if p.Overlays[0].Mount.Propagation != unix.MS_SHARED|unix.MS_REC || p.Overlays[1].Mount.Propagation != unix.MS_PRIVATE {
+		t.Errorf("unexpected overlay propagation: %+v", p.Overlays)
+	}
+
+	opts.DirOptions["/var"] = config.DirOptions{Propagation: "unbindable"}
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an unknown propagation type")
+	}
+}
+
+func TestParseMounts(t *testing.T) {
+	mounts, err := plan.ParseMounts([]string{
+		"/dev/vda1:/boot/efi:vfat:ro,umask=0077",
+		"nofail",
+		"tmpfs:/scratch:tmpfs:size=1G",
+		"context=system_u:object_r:tmp_t:s0",
+		"/dev/vdb1:/srv/",
+	}, "/sysroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []plan.ExtraMount{
+		{Mount: plan.Mount{Source: "/dev/vda1", Target: "/sysroot/boot/efi", FSType: "vfat", Flags: unix.MS_RDONLY, Data: "umask=0077"}, NoFail: true},
+		{Mount: plan.Mount{Source: "tmpfs", Target: "/sysroot/scratch", FSType: "tmpfs", Data: "size=1G,context=system_u:object_r:tmp_t:s0"}},
+		{Mount: plan.Mount{Source: "/dev/vdb1", Target: "/sysroot/srv"}},
+	}
+
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("ParseMounts() = %+v, want %+v", mounts, want)
+	}
+
+	for _, invalid := range []string{"/dev/vdb1", ":/srv", "/dev/vdb1:srv", "/dev/vdb1:/"} {
+		if _, err := plan.ParseMounts([]string{invalid}, ""); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func FuzzParseMounts(f *testing.F) {
+	f.Add("/dev/vda1:/boot/efi:vfat:ro,umask=0077", "nofail")
+	f.Add("tmpfs:/scratch:tmpfs:size=1G", "context=system_u:object_r:tmp_t:s0")
+	f.Add("LABEL=cache:/srv/../srv/cache", "")
+
+	f.Fuzz(func(t *testing.T, spec, cont string) {
+		mounts, err := plan.ParseMounts([]string{spec, cont}, "/sysroot")
+		if err != nil {
+			return
+		}
+
+		// Nothing may be mounted on (or outside of) the root.
+		for _, em := range mounts {
+			if !strings.HasPrefix(em.Target, "/sysroot/") || em.Source == "" {
+				t.Errorf("invalid mount parsed from %q, %q: %+v", spec, cont, em)
+			}
+		}
+	})
+}
+
+func TestNewHardening(t *testing.T) {
+	opts := &config.Options{
+		Volatile:     true,
+		Mount:        "/mnt/data",
+		Dirs:         []string{"/etc", "/var"},
+		DirOptions:   map[string]config.DirOptions{"/var": {Flags: []string{"noexec", "dev"}}},
+		DataFlags:    []string{"nosuid", "nodev"},
+		OverlayFlags: []string{"nodev"},
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data.Flags != unix.MS_NOSUID|unix.MS_NODEV {
+		t.Errorf("data flags = %#x, want MS_NOSUID|MS_NODEV", p.Data.Flags)
+	}
+
+	if p.Overlays[0].Mount.Flags != unix.MS_NODEV || p.Overlays[1].Mount.Flags != unix.MS_NOEXEC {
+		t.Errorf("unexpected overlay flags: %+v", p.Overlays)
+	}
+
+	opts.OverlayFlags = []string{"nosetuid"}
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an unknown hardening option")
+	}
+}
+
+func TestNewAttrs(t *testing.T) {
+	opts := &config.Options{
+		Volatile:   true,
+		Mount:      "/mnt/data",
+		Dirs:       []string{"/etc", "/home"},
+		DirOptions: map[string]config.DirOptions{"/home": {Mode: "0755", Owner: "1000", Label: "security.SMACK64=_"}},
+		DataMode:   "0700",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (mounter.Attrs{Mode: 0o700, UID: -1, GID: -1}); p.Data.Attrs == nil || *p.Data.Attrs != want {
+		t.Errorf("data attrs = %+v, want %+v", p.Data.Attrs, want)
+	}
+
+	if p.Overlays[0].Mount.Attrs != nil {
+		t.Errorf("expected no attrs for /etc, got %+v", p.Overlays[0].Mount.Attrs)
+	}
+
+	home := p.Overlays[1].Mount.Attrs
+	if want := (mounter.Attrs{Mode: 0o755, UID: 1000, GID: -1, Label: "security.SMACK64=_"}); home == nil || *home != want {
+		t.Errorf("/home attrs = %+v, want %+v", home, want)
+	}
+
+	if name, value := home.LabelXattr(); name != "security.SMACK64" || value != "_" {
+		t.Errorf("unexpected label xattr %s=%s", name, value)
+	}
+
+	for _, invalid := range []config.DirOptions{{Mode: "rwx"}, {Mode: "0"}, {Owner: "root"}, {Owner: "0:wheel"}} {
+		opts.DirOptions["/home"] = invalid
+		if _, err := plan.New(opts, nil); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
+}
+
+func TestNewNFS(t *testing.T) {
+	opts := &config.Options{
+		Data:        "server:/export/client01",
+		DataFSType:  "nfs4",
+		DataOptions: "rsize=65536",
+		Mount:       "/mnt/data",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Provider != "nfs" {
+		t.Errorf("provider = %q, want nfs", p.Provider)
+	}
+
+	if p.Data.Source != opts.Data || p.Data.Data != "rsize=65536" {
+		t.Errorf("unexpected data mount: %+v", p.Data)
+	}
+
+	opts.Data = "/dev/vda2"
+	if _, err := plan.New(opts, nil); err == nil {
+		t.Error("expected error for an invalid NFS source")
+	}
+}
+
+func TestNewInitramfs(t *testing.T) {
+	newRoot := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(newRoot, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{
+		Root:     "LABEL=root",
+		NewRoot:  newRoot,
+		Volatile: true,
+		Mount:    "/mnt/data",
+		Dirs:     []string{"/etc", "/srv"},
+		Cmd:      "/lib/systemd/systemd",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Root == nil || p.Root.Source != "/dev/disk/by-label/root" || p.Root.Target != newRoot {
+		t.Errorf("unexpected root mount: %+v", p.Root)
+	}
+
+	mount := filepath.Join(newRoot, "mnt/data")
+	if p.Data.Target != mount {
+		t.Errorf("data target = %q, want %q", p.Data.Target, mount)
+	}
+
+	if len(p.Overlays) != 1 {
+		t.Fatalf("expected 1 overlay, got %d", len(p.Overlays))
+	}
+
+	o := p.Overlays[0]
+	if o.Dir != "/etc" || o.Mount.Target != filepath.Join(newRoot, "etc") || o.UpperDir != filepath.Join(mount, "etc") {
+		t.Errorf("unexpected overlay: %+v", o)
+	}
+
+	if !reflect.DeepEqual(p.Skipped, []string{"/srv"}) {
+		t.Errorf("Skipped = %v, want [/srv]", p.Skipped)
+	}
+}
+
+func TestDevicePath(t *testing.T) {
+	for _, tt := range []struct {
+		device, want string
+	}{
+		{"/dev/vda2", "/dev/vda2"},
+		{"UUID=0a1b", "/dev/disk/by-uuid/0a1b"},
+		{"label=data", "/dev/disk/by-label/data"},
+		{"PARTUUID=abcd-02", "/dev/disk/by-partuuid/abcd-02"},
+		{"PARTLABEL=root", "/dev/disk/by-partlabel/root"},
+		{"server:/export", "server:/export"},
+	} {
+		if got := plan.DevicePath(tt.device); got != tt.want {
+			t.Errorf("DevicePath(%q) = %q, want %q", tt.device, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceTag(t *testing.T) {
+	if tag, value, ok := plan.DeviceTag("label=data"); !ok || tag != "LABEL" || value != "data" {
+		t.Errorf("DeviceTag() = %q, %q, %v, want LABEL, data", tag, value, ok)
+	}
+
+	for _, device := range []string{"/dev/vda2", "rbd=pool/image"} {
+		if _, _, ok := plan.DeviceTag(device); ok {
+			t.Errorf("DeviceTag(%q) unexpectedly found a tag", device)
+		}
+	}
+}
+
+func TestNewOverlayRoot(t *testing.T) {
+	opts := &config.Options{
+		Volatile:    true,
+		Mount:       "/mnt/data",
+		Dirs:        []string{"/etc", "/var"},
+		OverlayRoot: true,
+		Cmd:         "/lib/systemd/systemd",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Overlays) != 1 {
+		t.Fatalf("expected 1 overlay, got %d", len(p.Overlays))
+	}
+
+	o := p.Overlays[0]
+	if o.Dir != "/" || o.Mount.Target != plan.RootOverlayDir {
+		t.Errorf("unexpected root overlay: %+v", o)
+	}
+
+	if want := "lowerdir=/,workdir=/mnt/data/.rootfs-work,upperdir=/mnt/data/rootfs"; o.Mount.Data != want {
+		t.Errorf("overlay options = %q, want %q", o.Mount.Data, want)
+	}
+
+	// The options of the root directory apply to the root overlay.
+	opts.DirOptions = map[string]config.DirOptions{"/": {Flags: []string{"nosuid"}, Mode: "0755"}}
+
+	p, err = plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o := p.Overlays[0]; o.Mount.Flags != unix.MS_NOSUID || o.Mount.Attrs == nil || o.Mount.Attrs.Mode != 0o755 {
+		t.Errorf("root directory options not applied: %+v", o.Mount)
+	}
+
+	opts.DirOptions = nil
+
+	// From an initramfs, the real root filesystem is the lowerdir.
+	opts.Root = "/dev/vda1"
+	opts.NewRoot = "/sysroot"
+
+	p, err = plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "lowerdir=/sysroot,workdir=/sysroot/mnt/data/.rootfs-work,upperdir=/sysroot/mnt/data/rootfs"; p.Overlays[0].Mount.Data != want {
+		t.Errorf("overlay options = %q, want %q", p.Overlays[0].Mount.Data, want)
+	}
+}
+
+func TestNewEscapedDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, `a,b:c\d`)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{
+		Volatile: true,
+		Mount:    "/mnt/data",
+		Dirs:     []string{dir},
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escaped := filepath.Join(root, `a\,b\:c\\d`)
+	want := "lowerdir=" + escaped +
+		",workdir=" + filepath.Join("/mnt/data", "."+escaped[1:]+"-work") +
+		",upperdir=" + filepath.Join("/mnt/data", escaped)
+	if got := p.Overlays[0].Mount.Data; got != want {
+		t.Errorf("overlay options = %q, want %q", got, want)
+	}
+}
+
+func TestNewNested(t *testing.T) {
+	root := t.TempDir()
+	parent := filepath.Join(root, "var")
+	child := filepath.Join(parent, "lib")
+
+	if err := os.MkdirAll(child, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.Options{
+		Volatile: true,
+		Mount:    "/mnt/data",
+		Dirs:     []string{parent, child},
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Overlays) != 2 {
+		t.Fatalf("expected 2 overlays, got %d", len(p.Overlays))
+	}
+
+	if want := filepath.Join("/mnt/data", parent); p.Overlays[0].UpperDir != want {
+		t.Errorf("parent upperdir = %q, want %q", p.Overlays[0].UpperDir, want)
+	}
+
+	// The child's upper directory mustn't be within the parent's.
+	o := p.Overlays[1]
+	if strings.HasPrefix(o.UpperDir, p.Overlays[0].UpperDir+"/") || strings.HasPrefix(o.WorkDir, p.Overlays[0].UpperDir+"/") {
+		t.Errorf("nested overlay dirs are within the parent upperdir: %+v", o)
+	}
+
+	if dir := filepath.Join("/mnt/data", plan.NestedDir); filepath.Dir(o.UpperDir) != dir || filepath.Dir(o.WorkDir) != dir {
+		t.Errorf("nested overlay dirs aren't in %s: %+v", dir, o)
+	}
+}
+
+func TestParent(t *testing.T) {
+	p := &plan.Plan{Overlays: []plan.Overlay{{Dir: "/var/lib/app"}, {Dir: "/var"}, {Dir: "/var/lib"}, {Dir: "/variant"}}}
+
+	for _, tt := range []struct {
+		dir, want string
+	}{
+		{"/var", ""},
+		{"/variant", ""},
+		{"/var/lib", "/var"},
+		{"/var/lib/app", "/var/lib"},
+	} {
+		if got := p.Parent(tt.dir); got != tt.want {
+			t.Errorf("Parent(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestArgv(t *testing.T) {
+	opts := &config.Options{Cmd: "/usr/bin/app", CmdArgs: `--name 'my app'`}
+
+	if argv, want := plan.Argv(opts, []string{"single"}), []string{"/usr/bin/app", "--name", "my app", "single"}; !reflect.DeepEqual(argv, want) {
+		t.Errorf("Argv() = %q, want %q", argv, want)
+	}
+
+	if _, err := plan.ParsePassthrough("some"); err == nil {
+		t.Error("expected error for an unknown passthrough policy")
+	}
+}
+
+func TestFindInit(t *testing.T) {
+	dir := t.TempDir()
+
+	notExecutable := filepath.Join(dir, "init")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sh := filepath.Join(dir, "sh")
+	if err := os.WriteFile(sh, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := plan.FindInit([]string{filepath.Join(dir, "missing"), notExecutable, dir, sh})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != sh {
+		t.Errorf("FindInit() = %q, want %q", got, sh)
+	}
+
+	if _, err := plan.FindInit([]string{notExecutable}); err == nil {
+		t.Error("expected error when no candidate is executable")
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	opts := &config.Options{
+		Data:    "/dev/vda2",
+		Mount:   "/mnt/data",
+		Dirs:    []string{"/etc"},
+		Disable: true,
+		Cmd:     "/lib/systemd/systemd",
+	}
+
+	p, err := plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Data != nil || len(p.Overlays) != 0 {
+		t.Errorf("unexpected mounts when disabled: %+v", p)
+	}
+
+	// From an initramfs, the root filesystem is still mounted.
+	opts.Root = "/dev/vda1"
+	opts.NewRoot = "/sysroot"
+	opts.DisableRW = true
+
+	p, err = plan.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Root == nil || p.Root.Flags != 0 {
+		t.Errorf("unexpected root mount: %+v", p.Root)
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// propagationTypes map the propagation types (as for mount(8)'s --make-*
+// options) to mount flags. The "r" prefixed types also apply to the mounts
+// below the mountpoint.
+var propagationTypes = map[string]uintptr{
+	"shared":   unix.MS_SHARED,
+	"slave":    unix.MS_SLAVE,
+	"private":  unix.MS_PRIVATE,
+	"rshared":  unix.MS_SHARED | unix.MS_REC,
+	"rslave":   unix.MS_SLAVE | unix.MS_REC,
+	"rprivate": unix.MS_PRIVATE | unix.MS_REC,
+}
+
+// ParsePropagation parses a mount propagation type (eg. "rshared") into the
+// flags that set it. An empty string returns zero, leaving the propagation of
+// the mount as the kernel sets it.
+func ParsePropagation(s string) (uintptr, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	flags, ok := propagationTypes[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown mount propagation type %q", s)
+	}
+
+	return flags, nil
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/devices"
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// mounter returns the mounter used by built-in providers.
+func (s *Spec) mounter() mounter.Mounter {
+	if s.Mounter == nil {
+		return mounter.System{}
+	}
+
+	return s.Mounter
+}
+
+func init() {
+	register(&Block{})
+	register(&Tmpfs{})
+}
+
+// Block mounts a local block device.
+type Block struct{}
+
+func (*Block) Name() string {
+	return "block"
+}
+
+// Resolve resolves any tag (eg. LABEL=data) and symlinks (eg.
+// /dev/disk/by-label/...) in the device path.
+func (*Block) Resolve(_ context.Context, spec *Spec) (string, error) {
+	if spec.Data == "" {
+		return "", errors.New("data must be specified")
+	}
+
+	return filepath.EvalSymlinks(devices.Path(spec.Data))
+}
+
+// Prepare detects the filesystem type of the device, if it wasn't specified.
+func (*Block) Prepare(_ context.Context, spec *Spec, device string) error {
+	if spec.FSType != "" {
+		return nil
+	}
+
+	fstype, err := fsprobe.Probe(device)
+	if err != nil {
+		return err
+	}
+
+	spec.FSType = fstype
+
+	return nil
+}
+
+func (*Block) Mount(_ context.Context, spec *Spec, device string) error {
+	return spec.mounter().Mount(device, spec.Mount, spec.FSType, spec.Flags, spec.Options)
+}
+
+// Tmpfs mounts a volatile tmpfs.
+type Tmpfs struct{}
+
+func (*Tmpfs) Name() string {
+	return "tmpfs"
+}
+
+func (*Tmpfs) Resolve(_ context.Context, _ *Spec) (string, error) {
+	return "tmpfs", nil
+}
+
+func (*Tmpfs) Prepare(_ context.Context, _ *Spec, _ string) error {
+	return nil
+}
+
+func (*Tmpfs) Mount(_ context.Context, spec *Spec, _ string) error {
+	return spec.mounter().Mount("tmpfs", spec.Mount, "tmpfs", spec.Flags, spec.Options)
+}
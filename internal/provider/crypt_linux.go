@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/devices"
+	"github.com/immutos/matchstick/internal/dmcrypt"
+	"github.com/immutos/matchstick/internal/fstools"
+)
+
+func init() {
+	register(&StatelessEncrypted{})
+}
+
+// MapperName is the name of the dm-crypt mapping of the data device.
+const MapperName = "matchstick-data"
+
+// DefaultStatelessFSType is the filesystem created by StatelessEncrypted if
+// no filesystem type is specified.
+const DefaultStatelessFSType = "ext4"
+
+// StatelessEncrypted maps a local block device with dm-crypt, using a random
+// key generated each boot (and never stored), and creates a fresh filesystem
+// on it. Writes are kept on the device (rather than in memory, as with
+// tmpfs), but are unrecoverable after a reboot.
+type StatelessEncrypted struct{}
+
+func (*StatelessEncrypted) Name() string {
+	return "stateless-encrypted"
+}
+
+// Resolve resolves any tag (eg. LABEL=data) and symlinks (eg.
+// /dev/disk/by-label/...) in the device path.
+func (*StatelessEncrypted) Resolve(_ context.Context, spec *Spec) (string, error) {
+	if spec.Data == "" {
+		return "", errors.New("data must be specified")
+	}
+
+	return filepath.EvalSymlinks(devices.Path(spec.Data))
+}
+
+// Prepare maps the device with a random key, and creates a filesystem on the
+// mapped device.
+func (*StatelessEncrypted) Prepare(ctx context.Context, spec *Spec, device string) error {
+	if spec.FSType == "" {
+		spec.FSType = DefaultStatelessFSType
+	}
+
+	mapped, err := dmcrypt.OpenEphemeral(MapperName, device)
+	if err != nil {
+		return fmt.Errorf("failed to map %s: %w", device, err)
+	}
+
+	if err := fstools.Mkfs(ctx, spec.FSType, mapped, fstools.Identity{}); err != nil {
+		return errors.Join(err, dmcrypt.Close(MapperName))
+	}
+
+	return nil
+}
+
+func (*StatelessEncrypted) Mount(_ context.Context, spec *Spec, _ string) error {
+	return spec.mounter().Mount(dmcrypt.Path(MapperName), spec.Mount, spec.FSType, spec.Flags, spec.Options)
+}
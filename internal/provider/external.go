@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Request is written (as JSON) to the standard input of external providers.
+type Request struct {
+	Spec
+	// Device is the resolved device (not set for the resolve operation).
+	Device string `json:"device,omitempty"`
+}
+
+// Response is read (as JSON) from the standard output of external providers.
+type Response struct {
+	// Device is the resolved device (only used by the resolve operation).
+	Device string `json:"device,omitempty"`
+	// Error describes why the operation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// External is a provider implemented by an executable. The executable is
+// invoked with the operation ("resolve", "prepare" or "mount") as its only
+// argument, a Request on its standard input, and must write a Response to
+// its standard output.
+type External struct {
+	name string
+	path string
+}
+
+func (p *External) Name() string {
+	return p.name
+}
+
+func (p *External) Resolve(ctx context.Context, spec *Spec) (string, error) {
+	resp, err := p.call(ctx, "resolve", &Request{Spec: *spec})
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Device == "" {
+		return "", fmt.Errorf("provider %s did not resolve a device", p.name)
+	}
+
+	return resp.Device, nil
+}
+
+func (p *External) Prepare(ctx context.Context, spec *Spec, device string) error {
+	_, err := p.call(ctx, "prepare", &Request{Spec: *spec, Device: device})
+	return err
+}
+
+func (p *External) Mount(ctx context.Context, spec *Spec, device string) error {
+	_, err := p.call(ctx, "mount", &Request{Spec: *spec, Device: device})
+	return err
+}
+
+func (p *External) call(ctx context.Context, op string, req *Request) (*Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.path, op)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	runErr := cmd.Run()
+
+	var resp Response
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return nil, fmt.Errorf("provider %s returned an invalid response to %s: %w", p.name, op, err)
+		}
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("provider %s failed to %s: %w", p.name, op, errors.New(strings.TrimSpace(resp.Error)))
+	}
+
+	if runErr != nil {
+		return nil, fmt.Errorf("provider %s failed to %s: %w", p.name, op, runErr)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsNFS returns true if fstype is an NFS filesystem type.
+func IsNFS(fstype string) bool {
+	return fstype == "nfs" || fstype == "nfs4"
+}
+
+// ParseNFSSource splits an NFS source ("host:/path", or "[addr]:/path" for
+// IPv6 addresses) into its host and path.
+func ParseNFSSource(source string) (string, string, error) {
+	host, path, ok := strings.Cut(source, ":/")
+	if !ok || host == "" {
+		return "", "", fmt.Errorf("invalid NFS source %q (expected host:/path)", source)
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), "/" + path, nil
+}
+
+// NFSOptions constructs the options for an in-kernel NFS mount (which, unlike
+// mount.nfs, needs to be told the server's address). Options in extra
+// override the defaults.
+func NFSOptions(fstype, addr, extra string) string {
+	defaults := [][2]string{{"addr", addr}}
+	if fstype == "nfs4" {
+		// The kernel negotiates the highest supported minor version.
+		defaults = append(defaults, [2]string{"vers", "4"})
+	} else {
+		// There's no rpc.statd running, so locks can't be recovered.
+		defaults = append(defaults, [2]string{"vers", "3"}, [2]string{"proto", "tcp"}, [2]string{"nolock", ""})
+	}
+
+	overridden := make(map[string]bool)
+	var extraOpts []string
+	if extra != "" {
+		extraOpts = strings.Split(extra, ",")
+
+		for _, opt := range extraOpts {
+			key, _, _ := strings.Cut(opt, "=")
+			overridden[flagName(key)] = true
+		}
+	}
+
+	var opts []string
+	for _, kv := range defaults {
+		if overridden[flagName(kv[0])] {
+			continue
+		}
+
+		if kv[1] == "" {
+			opts = append(opts, kv[0])
+		} else {
+			opts = append(opts, kv[0]+"="+kv[1])
+		}
+	}
+
+	return strings.Join(append(opts, extraOpts...), ",")
+}
+
+// flagName returns the name of a boolean option, without its negation (eg.
+// "nolock" and "lock" are both "lock").
+func flagName(key string) string {
+	return strings.TrimPrefix(key, "no")
+}
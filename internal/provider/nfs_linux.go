@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+func init() {
+	register(&NFS{})
+}
+
+// NFS mounts an NFS export (eg. "server:/export/client01"), for diskless
+// clients. The network must already be configured.
+type NFS struct{}
+
+func (*NFS) Name() string {
+	return "nfs"
+}
+
+// Resolve resolves the server's address, returning the source with the
+// server replaced by its address (the kernel can't resolve host names).
+func (*NFS) Resolve(ctx context.Context, spec *Spec) (string, error) {
+	host, path, err := ParseNFSSource(spec.Data)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve NFS server %s: %w", host, err)
+	}
+
+	// Prefer IPv4 addresses.
+	addr := addrs[0]
+	for _, a := range addrs {
+		if a.To4() != nil {
+			addr = a
+			break
+		}
+	}
+
+	if addr.To4() == nil {
+		return "[" + addr.String() + "]:" + path, nil
+	}
+
+	return addr.String() + ":" + path, nil
+}
+
+func (*NFS) Prepare(_ context.Context, _ *Spec, _ string) error {
+	return nil
+}
+
+func (*NFS) Mount(_ context.Context, spec *Spec, device string) error {
+	addr, _, err := ParseNFSSource(device)
+	if err != nil {
+		return err
+	}
+
+	return spec.mounter().Mount(device, spec.Mount, spec.FSType, spec.Flags, NFSOptions(spec.FSType, addr, spec.Options))
+}
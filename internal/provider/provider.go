@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package provider abstracts the setup of the data filesystem, so that
+// exotic storage backends can be supported by out-of-tree executables.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// DefaultDir is the default directory searched for external providers.
+const DefaultDir = "/usr/lib/matchstick/providers"
+
+// Spec describes the data filesystem to be set up.
+type Spec struct {
+	// Data is the (unresolved) data device.
+	Data string `json:"data"`
+	// FSType is the filesystem type of the data device.
+	FSType string `json:"fstype"`
+	// Mount is the mountpoint of the data filesystem.
+	Mount string `json:"mount"`
+	// Flags are the mount flags.
+	Flags uintptr `json:"flags,omitempty"`
+	// Options is the filesystem specific mount options string.
+	Options string `json:"options,omitempty"`
+	// Mounter performs the mounts of the built-in providers (defaults to the
+	// running system).
+	Mounter mounter.Mounter `json:"-"`
+}
+
+// Provider sets up the data filesystem.
+type Provider interface {
+	// Name returns the name of the provider.
+	Name() string
+	// Resolve resolves the data device, returning the path to be mounted.
+	Resolve(ctx context.Context, spec *Spec) (string, error)
+	// Prepare readies the resolved device for mounting (eg. unlocking or
+	// checking it).
+	Prepare(ctx context.Context, spec *Spec, device string) error
+	// Mount mounts the resolved device on the data mountpoint.
+	Mount(ctx context.Context, spec *Spec, device string) error
+}
+
+var builtin = map[string]Provider{}
+
+func register(p Provider) {
+	builtin[p.Name()] = p
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Get returns the named provider. Built-in providers take precedence over
+// external providers (executables named after the provider in dir).
+func Get(name, dir string) (Provider, error) {
+	if p, ok := builtin[name]; ok {
+		return p, nil
+	}
+
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid provider name %q", name)
+	}
+
+	path := filepath.Join(dir, name)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unknown provider %q: %w", name, err)
+	}
+
+	if !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+		return nil, fmt.Errorf("provider %s is not executable", path)
+	}
+
+	return &External{name: name, path: path}, nil
+}
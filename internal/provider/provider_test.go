@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provider_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/provider"
+)
+
+const fakeProvider = `#!/bin/sh
+input=$(cat)
+case "$1" in
+  resolve) echo '{"device": "/dev/rbd0"}' ;;
+  prepare) echo "$input" > "$(dirname "$0")/prepared" ;;
+  mount) echo '{"error": "no route to cluster"}'; exit 1 ;;
+esac
+`
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"block", "tmpfs", "stateless-encrypted"} {
+		p, err := provider.Get(name, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Name() != name {
+			t.Errorf("Name() = %q, want %q", p.Name(), name)
+		}
+	}
+
+	if _, err := provider.Get("../../bin/sh", "/usr/lib"); err == nil {
+		t.Error("expected invalid provider name to be rejected")
+	}
+
+	if _, err := provider.Get("missing", t.TempDir()); err == nil {
+		t.Error("expected missing provider to be rejected")
+	}
+}
+
+func TestBlockDetect(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "vda2")
+
+	// An (otherwise empty) XFS superblock.
+	if err := os.WriteFile(device, append([]byte("XFSB"), make([]byte, 4096)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := provider.Get("block", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &provider.Spec{Data: device, Mount: "/mnt/data"}
+	if err := p.Prepare(context.Background(), spec, device); err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.FSType != "xfs" {
+		t.Errorf("FSType = %q, want xfs", spec.FSType)
+	}
+
+	// A specified filesystem type is left alone.
+	spec.FSType = "ext4"
+	if err := p.Prepare(context.Background(), spec, device); err != nil || spec.FSType != "ext4" {
+		t.Errorf("Prepare() = %v, FSType = %q, want ext4", err, spec.FSType)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "rbd"), []byte(fakeProvider), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := provider.Get("rbd", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	spec := &provider.Spec{Data: "rbd:pool/image", FSType: "ext4", Mount: "/mnt/data"}
+
+	device, err := p.Resolve(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if device != "/dev/rbd0" {
+		t.Errorf("Resolve() = %q, want %q", device, "/dev/rbd0")
+	}
+
+	if err := p.Prepare(ctx, spec, device); err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := os.ReadFile(filepath.Join(dir, "prepared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `{"data":"rbd:pool/image","fstype":"ext4","mount":"/mnt/data","device":"/dev/rbd0"}` + "\n"; string(prepared) != want {
+		t.Errorf("unexpected request: %s", prepared)
+	}
+
+	if err := p.Mount(ctx, spec, device); err == nil || err.Error() != "provider rbd failed to mount: no route to cluster" {
+		t.Errorf("unexpected mount error: %v", err)
+	}
+}
+
+func TestParseNFSSource(t *testing.T) {
+	for source, want := range map[string][2]string{
+		"server:/export/client01": {"server", "/export/client01"},
+		"10.0.0.1:/":              {"10.0.0.1", "/"},
+		"[fd00::1]:/export":       {"fd00::1", "/export"},
+	} {
+		host, path, err := provider.ParseNFSSource(source)
+		if err != nil {
+			t.Fatalf("ParseNFSSource(%q): %v", source, err)
+		}
+
+		if host != want[0] || path != want[1] {
+			t.Errorf("ParseNFSSource(%q) = %q, %q, want %q, %q", source, host, path, want[0], want[1])
+		}
+	}
+
+	for _, source := range []string{"/dev/vda1", "server", ":/export"} {
+		if _, _, err := provider.ParseNFSSource(source); err == nil {
+			t.Errorf("ParseNFSSource(%q): expected an error", source)
+		}
+	}
+}
+
+func TestNFSOptions(t *testing.T) {
+	tests := []struct {
+		fstype, extra, want string
+	}{
+		{"nfs4", "", "addr=10.0.0.1,vers=4"},
+		{"nfs4", "vers=4.1,rsize=65536", "addr=10.0.0.1,vers=4.1,rsize=65536"},
+		{"nfs", "", "addr=10.0.0.1,vers=3,proto=tcp,nolock"},
+		{"nfs", "lock,proto=udp", "addr=10.0.0.1,vers=3,lock,proto=udp"},
+	}
+
+	for _, tt := range tests {
+		if got := provider.NFSOptions(tt.fstype, "10.0.0.1", tt.extra); got != tt.want {
+			t.Errorf("NFSOptions(%q, %q) = %q, want %q", tt.fstype, tt.extra, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package provision fetches, verifies and applies a remote provisioning
+// configuration, allowing a generic image to configure itself at first boot.
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/immutos/matchstick/internal/nofollow"
+	"github.com/immutos/matchstick/internal/plan"
+	"golang.org/x/sys/unix"
+)
+
+// maxDocumentSize is the maximum size of a provisioning document.
+const maxDocumentSize = 1 << 20
+
+// Config is a provisioning configuration document.
+type Config struct {
+	// Hostname is the hostname to assign to the system.
+	Hostname string `json:"hostname,omitempty"`
+	// Dirs is a list of additional directories to overlay.
+	Dirs []string `json:"dirs,omitempty"`
+	// Files is a list of files to seed into the data filesystem.
+	Files []File `json:"files,omitempty"`
+}
+
+// File is a file to be seeded into the data filesystem.
+type File struct {
+	// Path is the absolute path of the file (as seen by the booted system).
+	Path string `json:"path"`
+	// Mode is the octal file mode, defaults to 0644.
+	Mode string `json:"mode,omitempty"`
+	// Contents is the contents of the file.
+	Contents string `json:"contents"`
+	// Encoding is the encoding of the contents, either "" or "base64".
+	Encoding string `json:"encoding,omitempty"`
+	// Overwrite specifies whether an existing file should be replaced.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// Verification pins the expected identity of a provisioning document.
+type Verification struct {
+	// SHA256 is the expected hex encoded SHA-256 digest of the document.
+	SHA256 string
+	// PublicKey is a base64 encoded ed25519 public key. The detached (base64
+	// encoded) signature is fetched from the document URL with a ".sig" suffix.
+	PublicKey string
+}
+
+// Fetch retrieves and verifies the provisioning document at the given URL.
+// At least one form of verification must be provided.
+func Fetch(ctx context.Context, url string, v Verification) (*Config, error) {
+	if v.SHA256 == "" && v.PublicKey == "" {
+		return nil, errors.New("refusing to use an unverified provisioning config")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	doc, err := get(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.SHA256 != "" {
+		sum := sha256.Sum256(doc)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), v.SHA256) {
+			return nil, errors.New("provisioning config digest mismatch")
+		}
+	}
+
+	if v.PublicKey != "" {
+		sig, err := get(ctx, client, url+".sig")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+
+		if err := verifySignature(doc, sig, v.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	var conf Config
+	if err := json.Unmarshal(doc, &conf); err != nil {
+		return nil, fmt.Errorf("failed to decode provisioning config: %w", err)
+	}
+
+	return &conf, nil
+}
+
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxDocumentSize {
+		return nil, fmt.Errorf("%s exceeds the maximum size of %d bytes", url, maxDocumentSize)
+	}
+
+	return body, nil
+}
+
+func verifySignature(doc, sig []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid provisioning public key")
+	}
+
+	rawSig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid provisioning config signature: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), doc, rawSig) {
+		return errors.New("provisioning config signature verification failed")
+	}
+
+	return nil
+}
+
+// SeedFiles writes the configured files into the upper directories of the
+// overlays, so that they will be visible once the overlays are mounted. Each
+// file must reside within one of the overlaid directories.
+func (c *Config) SeedFiles(overlays []plan.Overlay) error {
+	for _, f := range c.Files {
+		if err := seedFile(f, overlays); err != nil {
+			return fmt.Errorf("failed to seed %s: %w", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func seedFile(f File, overlays []plan.Overlay) error {
+	path := filepath.Clean(f.Path)
+	if !filepath.IsAbs(path) {
+		return errors.New("path must be absolute")
+	}
+
+	o, ok := overlayOf(path, overlays)
+	if !ok {
+		return errors.New("path is not within an overlaid directory")
+	}
+
+	mode := os.FileMode(0o644)
+	if f.Mode != "" {
+		m, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode %q: %w", f.Mode, err)
+		}
+		mode = os.FileMode(m)
+	}
+
+	var contents []byte
+	switch f.Encoding {
+	case "":
+		contents = []byte(f.Contents)
+	case "base64":
+		var err error
+		contents, err = base64.StdEncoding.DecodeString(f.Contents)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported encoding %q", f.Encoding)
+	}
+
+	rel := strings.TrimPrefix(path, strings.TrimSuffix(o.Dir, "/"))
+
+	if err := os.MkdirAll(o.UpperDir, 0o755); err != nil {
+		return err
+	}
+
+	// The upper directory is persistent (and writable by unprivileged
+	// services), so symlinks within it are never followed.
+	parent, err := nofollow.OpenDir(o.UpperDir, filepath.Dir(rel))
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	flags := unix.O_WRONLY | unix.O_CREAT | unix.O_TRUNC
+	if !f.Overwrite {
+		flags |= unix.O_EXCL
+	}
+
+	out, err := nofollow.Openat(int(parent.Fd()), filepath.Base(rel), flags, uint32(mode.Perm()))
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := out.Chmod(mode); err != nil {
+		return err
+	}
+
+	if _, err := out.Write(contents); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// overlayOf returns the overlay whose directory path is (most closely) within.
+func overlayOf(path string, overlays []plan.Overlay) (plan.Overlay, bool) {
+	var found plan.Overlay
+	var ok bool
+	for _, o := range overlays {
+		dir := filepath.Clean(o.Dir)
+		if path != dir && strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") && len(dir) >= len(found.Dir) {
+			found, ok = o, true
+		}
+	}
+
+	return found, ok
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package provision_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/provision"
+)
+
+const doc = `{"hostname":"kiosk-1","dirs":["/opt"],"files":[{"path":"/etc/motd","contents":"hello\n"}]}`
+
+func TestFetch(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(doc))
+	})
+	mux.HandleFunc("/config.json.sig", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(doc)))))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	sum := sha256.Sum256([]byte(doc))
+
+	t.Run("Digest", func(t *testing.T) {
+		conf, err := provision.Fetch(context.Background(), srv.URL+"/config.json", provision.Verification{
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if conf.Hostname != "kiosk-1" {
+			t.Errorf("Hostname = %q, want %q", conf.Hostname, "kiosk-1")
+		}
+	})
+
+	t.Run("Signature", func(t *testing.T) {
+		_, err := provision.Fetch(context.Background(), srv.URL+"/config.json", provision.Verification{
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		_, err := provision.Fetch(context.Background(), srv.URL+"/config.json", provision.Verification{
+			SHA256: hex.EncodeToString(make([]byte, sha256.Size)),
+		})
+		if err == nil {
+			t.Fatal("expected digest mismatch")
+		}
+	})
+
+	t.Run("Unverified", func(t *testing.T) {
+		if _, err := provision.Fetch(context.Background(), srv.URL+"/config.json", provision.Verification{}); err == nil {
+			t.Fatal("expected unverified config to be rejected")
+		}
+	})
+}
+
+func TestSeedFiles(t *testing.T) {
+	mount := t.TempDir()
+	overlays := []plan.Overlay{{Dir: "/etc", UpperDir: filepath.Join(mount, "etc")}}
+
+	conf := &provision.Config{
+		Files: []provision.File{
+			{Path: "/etc/motd", Contents: "hello\n"},
+			{Path: "/etc/ssh/sshd_config.d/matchstick.conf", Contents: "UGFzc3dvcmRBdXRoZW50aWNhdGlvbiBubwo=", Encoding: "base64", Mode: "0600"},
+		},
+	}
+
+	if err := conf.SeedFiles(overlays); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(mount, "etc/ssh/sshd_config.d/matchstick.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "PasswordAuthentication no\n" {
+		t.Errorf("unexpected contents %q", data)
+	}
+
+	// Existing files are kept, unless overwriting.
+	conf.Files = []provision.File{{Path: "/etc/motd", Contents: "changed\n"}}
+	if err := conf.SeedFiles(overlays); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(mount, "etc/motd")); string(data) != "hello\n" {
+		t.Errorf("existing file overwritten: %q", data)
+	}
+
+	// Symlinks in the upper directory are never followed.
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(mount, "etc/cron.d")); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Files = []provision.File{{Path: "/etc/cron.d/job", Contents: "x"}}
+	if err := conf.SeedFiles(overlays); err == nil {
+		t.Error("expected error seeding file through a symlink")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "job")); err == nil {
+		t.Error("file written through a symlink")
+	}
+
+	conf.Files = []provision.File{{Path: "/usr/bin/evil", Contents: "x"}}
+	if err := conf.SeedFiles(overlays); err == nil {
+		t.Error("expected error seeding file outside overlaid directories")
+	}
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package randomseed persists a random seed across boots, so the kernel's
+// random number generator is initialized early (and headless machines don't
+// block waiting for entropy).
+package randomseed
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Size is the size of the seed, in bytes (the size of the kernel's pool).
+const Size = 512
+
+// DefaultDevice is the device the seed is written to.
+const DefaultDevice = "/dev/urandom"
+
+// UncreditableSuffix is appended to the seed's path to mark it as
+// uncreditable, as it was generated before the kernel's random number
+// generator was initialized.
+const UncreditableSuffix = ".uncreditable"
+
+// Load writes the seed at path into the kernel's random pool. If credit is
+// true, the seed is credited as entropy (which is only safe if the seed
+// isn't shared with other machines, eg. through a cloned image), unless it
+// was marked uncreditable when it was generated. A missing seed is not an
+// error.
+func Load(path, device string, credit bool) error {
+	seed, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(seed) == 0 {
+		return nil
+	}
+
+	if len(seed) > Size {
+		seed = seed[:Size]
+	}
+
+	if _, err := os.Stat(path + UncreditableSuffix); err == nil {
+		credit = false
+	}
+
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !credit {
+		_, err := f.Write(seed)
+		return err
+	}
+
+	// struct rand_pool_info { int entropy_count; int buf_size; __u32 buf[]; }
+	info := make([]byte, 8+len(seed))
+	binary.NativeEndian.PutUint32(info[0:4], uint32(len(seed)*8))
+	binary.NativeEndian.PutUint32(info[4:8], uint32(len(seed)))
+	copy(info[8:], seed)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.RNDADDENTROPY, uintptr(unsafe.Pointer(&info[0])))
+	if errno != 0 {
+		return fmt.Errorf("failed to credit random seed: %w", errno)
+	}
+
+	return nil
+}
+
+// Refresh replaces the seed at path with fresh random data, so that the same
+// seed is never used twice. If the kernel's random number generator hasn't
+// been initialized yet, the seed is marked as uncreditable (it's still mixed
+// into the pool on the next boot, but never credited as entropy).
+func Refresh(path string) error {
+	seed := make([]byte, Size)
+	creditable, err := getrandom(seed)
+	if err != nil {
+		return err
+	}
+
+	// The mark is only removed once the replacement seed is in place.
+	if !creditable {
+		if err := os.WriteFile(path+UncreditableSuffix, nil, 0o600); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(seed)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if creditable {
+		if err := os.Remove(path + UncreditableSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// getrandom fills b with random data, without blocking. It returns false if
+// the pool hasn't been initialized, in which case the data isn't suitable to
+// be credited as entropy (but is mixed with any existing seed by the kernel
+// when it is next loaded, so this is no worse than not refreshing).
+func getrandom(b []byte) (bool, error) {
+	creditable := true
+	for len(b) > 0 {
+		flags := unix.GRND_NONBLOCK
+		if !creditable {
+			flags = unix.GRND_INSECURE
+		}
+
+		n, err := unix.Getrandom(b, flags)
+		if creditable && errors.Is(err, unix.EAGAIN) {
+			creditable = false
+			continue
+		}
+
+		if errors.Is(err, unix.EINVAL) && !creditable {
+			// Kernels older than 5.6 don't support GRND_INSECURE (reading
+			// /dev/urandom is equivalent).
+			n, err = readURandom(b)
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("failed to generate random seed: %w", err)
+		}
+
+		b = b[n:]
+	}
+
+	return creditable, nil
+}
+
+func readURandom(b []byte) (int, error) {
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return f.Read(b)
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
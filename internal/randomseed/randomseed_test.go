@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package randomseed_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/randomseed"
+)
+
+func TestRefreshAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seed")
+	device := filepath.Join(dir, "urandom")
+
+	if err := os.WriteFile(device, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing seed is fine.
+	if err := randomseed.Load(path, device, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := randomseed.Refresh(path); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != randomseed.Size {
+		t.Fatalf("expected a %d byte seed, got %d bytes", randomseed.Size, len(first))
+	}
+
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o600 {
+		t.Errorf("expected the seed to be private: %v %v", st.Mode(), err)
+	}
+
+	if err := randomseed.Load(path, device, false); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := os.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(loaded, first) {
+		t.Error("expected the seed to be written to the device")
+	}
+
+	if err := randomseed.Refresh(path); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(first, second) {
+		t.Error("expected the seed to change when refreshed")
+	}
+}
+
+func TestLoadUncreditable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seed")
+	device := filepath.Join(dir, "urandom")
+
+	if err := os.WriteFile(device, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, bytes.Repeat([]byte{1}, randomseed.Size), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crediting needs the random device (it fails with a regular file).
+	if err := randomseed.Load(path, device, true); err == nil {
+		t.Fatal("expected crediting the seed to fail")
+	}
+
+	if err := os.WriteFile(path+randomseed.UncreditableSuffix, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := randomseed.Load(path, device, true); err != nil {
+		t.Errorf("expected an uncreditable seed to be written without crediting: %v", err)
+	}
+
+	// Once initialized (as it is when running the tests), the refreshed seed
+	// is creditable.
+	if err := randomseed.Refresh(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + randomseed.UncreditableSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the uncreditable mark to be removed: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package resources applies the resource policy (rlimits, oom_score_adj and
+// cgroup placement) inherited by init.
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultCgroupRoot is where the cgroup v2 hierarchy is mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+var resources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// Rlimit is a resource limit.
+type Rlimit struct {
+	// Name is the name of the resource (eg. "nofile").
+	Name     string
+	Resource int
+	Limit    syscall.Rlimit
+}
+
+// ParseRlimit parses a resource limit of the form "name=soft[:hard]" (eg.
+// "nofile=1024:65536"). Limits are integers or "unlimited", and if the hard
+// limit is omitted it is the same as the soft limit.
+func ParseRlimit(s string) (Rlimit, error) {
+	name, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return Rlimit{}, fmt.Errorf("invalid rlimit %q: expected name=soft[:hard]", s)
+	}
+
+	name = strings.TrimPrefix(strings.ToLower(name), "rlimit_")
+
+	resource, ok := resources[name]
+	if !ok {
+		return Rlimit{}, fmt.Errorf("unknown rlimit %q", name)
+	}
+
+	softValue, hardValue, ok := strings.Cut(value, ":")
+	if !ok {
+		hardValue = softValue
+	}
+
+	soft, err := parseLimit(softValue)
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("invalid rlimit %q: %w", s, err)
+	}
+
+	hard, err := parseLimit(hardValue)
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("invalid rlimit %q: %w", s, err)
+	}
+
+	if soft > hard {
+		return Rlimit{}, fmt.Errorf("invalid rlimit %q: soft limit exceeds hard limit", s)
+	}
+
+	return Rlimit{Name: name, Resource: resource, Limit: syscall.Rlimit{Cur: soft, Max: hard}}, nil
+}
+
+func parseLimit(s string) (uint64, error) {
+	switch strings.ToLower(s) {
+	case "unlimited", "infinity":
+		return unix.RLIM_INFINITY, nil
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// Apply sets the resource limit of the current process (and so of any
+// programs it executes).
+func (r Rlimit) Apply() error {
+	// The syscall package is used as it otherwise restores the original
+	// nofile limit on exec.
+	if err := syscall.Setrlimit(r.Resource, &r.Limit); err != nil {
+		return fmt.Errorf("failed to set rlimit %s: %w", r.Name, err)
+	}
+
+	return nil
+}
+
+// SetOOMScoreAdj sets the oom_score_adj (between -1000 and 1000) of the
+// current process.
+func SetOOMScoreAdj(adj int) error {
+	if adj < -1000 || adj > 1000 {
+		return fmt.Errorf("oom_score_adj %d is out of range", adj)
+	}
+
+	return os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(adj)), 0o644)
+}
+
+// JoinCgroup moves the current process into the cgroup (creating it if
+// necessary), relative to the root of the cgroup v2 hierarchy.
+func JoinCgroup(root, cgroup string) error {
+	dir := filepath.Join(root, filepath.Clean("/"+cgroup))
+	if dir == filepath.Clean(root) {
+		return errors.New("cgroup must not be the root cgroup")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
+
+// Cgroup2Mounted returns true if the cgroup v2 hierarchy is mounted at root.
+func Cgroup2Mounted(root string) bool {
+	var st unix.Statfs_t
+	return unix.Statfs(root, &st) == nil && st.Type == unix.CGROUP2_SUPER_MAGIC
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package resources_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/resources"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRlimit(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want syscall.Rlimit
+	}{
+		{"nofile=1024:65536", syscall.Rlimit{Cur: 1024, Max: 65536}},
+		{"core=0", syscall.Rlimit{Cur: 0, Max: 0}},
+		{"RLIMIT_MEMLOCK=unlimited", syscall.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}},
+	} {
+		r, err := resources.ParseRlimit(tt.s)
+		if err != nil {
+			t.Errorf("ParseRlimit(%q): %v", tt.s, err)
+			continue
+		}
+
+		if r.Limit != tt.want {
+			t.Errorf("ParseRlimit(%q) = %+v, want %+v", tt.s, r.Limit, tt.want)
+		}
+	}
+
+	for _, s := range []string{"nofile", "files=1024", "nofile=lots", "nofile=65536:1024"} {
+		if _, err := resources.ParseRlimit(s); err == nil {
+			t.Errorf("ParseRlimit(%q): expected error", s)
+		}
+	}
+}
+
+func TestJoinCgroup(t *testing.T) {
+	root := t.TempDir()
+
+	if err := resources.JoinCgroup(root, "app.slice/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "app.slice/app/cgroup.procs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("cgroup.procs = %q, want %d", data, os.Getpid())
+	}
+
+	if err := resources.JoinCgroup(root, "/"); err == nil {
+		t.Error("expected error for the root cgroup")
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package retry retries operations that fail with transient errors, as is
+// common for storage (and network backed) devices immediately after probe.
+package retry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Policy configures how operations are retried.
+type Policy struct {
+	// Attempts is the maximum number of attempts (values below one are
+	// treated as a single attempt).
+	Attempts int
+	// Delay is the delay before the first retry, it doubles with each
+	// subsequent retry.
+	Delay time.Duration
+	// MaxDelay caps the delay between retries (zero is uncapped).
+	MaxDelay time.Duration
+}
+
+// Do calls fn until it succeeds, fails with a non-transient error, or the
+// maximum number of attempts is reached.
+func Do(ctx context.Context, p Policy, name string, fn func() error) error {
+	delay := p.Delay
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !IsTransient(err) {
+			return err
+		}
+
+		slog.Warn("Transient error, retrying", slog.String("operation", name),
+			slog.Int("attempt", attempt), slog.Duration("delay", delay), slog.Any("error", err))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// IsTransient returns true if the error may resolve itself given time (eg.
+// a device node that hasn't appeared yet, or a device that is still
+// settling).
+func IsTransient(err error) bool {
+	for _, errno := range transient {
+
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
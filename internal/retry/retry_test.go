@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/retry"
+	"golang.org/x/sys/unix"
+)
+
+func TestDo(t *testing.T) {
+	p := retry.Policy{Attempts: 3, Delay: time.Millisecond}
+
+	t.Run("Transient", func(t *testing.T) {
+		var calls int
+		err := retry.Do(context.Background(), p, "test", func() error {
+			calls++
+			if calls < 3 {
+				return &os.PathError{Op: "mount", Path: "/dev/sda1", Err: unix.ENODEV}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls != 3 {
+			t.Errorf("calls = %d, want 3", calls)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		var calls int
+		err := retry.Do(context.Background(), p, "test", func() error {
+			calls++
+			return fmt.Errorf("mount failed: %w", unix.EIO)
+		})
+		if !errors.Is(err, unix.EIO) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if calls != 3 {
+			t.Errorf("calls = %d, want 3", calls)
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		var calls int
+		err := retry.Do(context.Background(), p, "test", func() error {
+			calls++
+			return unix.EINVAL
+		})
+		if !errors.Is(err, unix.EINVAL) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if calls != 1 {
+			t.Errorf("calls = %d, want 1", calls)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package retry
+
+import "golang.org/x/sys/unix"
+
+// transient are the errors that may resolve themselves given time.
+var transient = []error{
+	unix.ENOENT,
+	unix.ENODEV,
+	unix.ENXIO,
+	unix.EIO,
+	unix.EBUSY,
+	unix.EAGAIN,
+	unix.ENOMEDIUM,
+	unix.ETIMEDOUT,
+	// The network, or an NFS server, may not be ready yet.
+	unix.ECONNREFUSED,
+	unix.EHOSTUNREACH,
+	unix.ENETUNREACH,
+}
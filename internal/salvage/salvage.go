@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package salvage copies what can still be read from a damaged filesystem.
+package salvage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxErrors is the number of errors returned by Copy (the rest are counted).
+const maxErrors = 10
+
+// Copy copies the directories, regular files and symlinks below src into
+// dst, preserving their ownership, permissions and modification times.
+// Unlike a regular copy it carries on past entries that can't be read (or
+// written, eg. once dst is full), returning the number of files copied along
+// with the errors encountered.
+func Copy(src, dst string) (int, error) {
+	var (
+		copied  int
+		errs    []error
+		dropped int
+	)
+
+	record := func(err error) {
+		if len(errs) < maxErrors {
+			errs = append(errs, err)
+		} else {
+			dropped++
+		}
+	}
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The rest of an unreadable directory is skipped.
+			record(err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if err := copyEntry(path, filepath.Join(dst, rel), d); err != nil {
+			record(err)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		} else if d.Type().IsRegular() {
+			copied++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+
+	if dropped > 0 {
+		errs = append(errs, fmt.Errorf("and %d more errors", dropped))
+	}
+
+	return copied, errors.Join(errs...)
+}
+
+func copyEntry(src, dst string, d fs.DirEntry) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(src, &st); err != nil {
+		return &os.PathError{Op: "lstat", Path: src, Err: err}
+	}
+
+	switch {
+	case d.IsDir():
+		if err := os.MkdirAll(dst, 0o700); err != nil {
+			return err
+		}
+	case d.Type().IsRegular():
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	case d.Type()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	default:
+		// Device nodes, fifos and sockets aren't worth salvaging.
+		return nil
+	}
+
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFLNK {
+		if err := unix.Chmod(dst, st.Mode&0o7777); err != nil {
+			return &os.PathError{Op: "chmod", Path: dst, Err: err}
+		}
+	}
+
+	times := []unix.Timespec{st.Atim, st.Mtim}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimes", Path: dst, Err: err}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.ReadFrom(in); err != nil {
+		_ = out.Close()
+		// Don't leave a partial copy behind.
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package salvage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/salvage"
+)
+
+func TestCopy(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+
+	if err := os.MkdirAll(filepath.Join(src, "etc/app"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "etc/app/app.conf"), []byte("config"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("app/app.conf", filepath.Join(src, "etc/app.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination already has a file of the same name, which is an error
+	// that doesn't stop the rest of the copy.
+	if err := os.MkdirAll(filepath.Join(dst, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dst, "etc/app.conf"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := salvage.Copy(src, dst)
+	if err == nil {
+		t.Error("expected an error copying onto an existing file")
+	}
+
+	if copied != 1 {
+		t.Errorf("copied %d files, want 1", copied)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dst, "etc/app/app.conf")); err != nil || string(data) != "config" {
+		t.Errorf("unexpected copy: %q, %v", data, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dst, "etc/app")); err != nil || fi.Mode().Perm() != 0o750 {
+		t.Errorf("unexpected directory: %v, %v", fi, err)
+	}
+
+	if _, err := salvage.Copy(filepath.Join(src, "missing"), dst); err == nil {
+		t.Error("expected error for a missing source")
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package scrub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest file in the root of the data filesystem.
+const ManifestName = ".matchstick-manifest"
+
+// Entry is the recorded state of a single file.
+type Entry struct {
+	// Checksum is the hex encoded SHA-256 digest of the file contents.
+	Checksum string
+	// Size is the size of the file in bytes.
+	Size int64
+	// ModTime is the modification time of the file when it was checksummed.
+	ModTime time.Time
+}
+
+// Manifest maps paths (relative to the data filesystem root) to their
+// recorded state.
+type Manifest map[string]Entry
+
+// ReadManifest reads a manifest from the given path. A missing manifest is
+// not an error, an empty manifest will be returned.
+func ReadManifest(path string) (Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Manifest{}, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseManifest(f)
+}
+
+// parseManifest parses lines of the form "<sha256> <size> <mtime> <path>".
+func parseManifest(r io.Reader) (Manifest, error) {
+	m := Manifest{}
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("malformed manifest line %d", lineno)
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed size on manifest line %d: %w", lineno, err)
+		}
+
+		mtime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed mtime on manifest line %d: %w", lineno, err)
+		}
+
+		path, err := strconv.Unquote(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("malformed path on manifest line %d: %w", lineno, err)
+		}
+
+		m[path] = Entry{
+			Checksum: fields[0],
+			Size:     size,
+			ModTime:  time.Unix(0, mtime),
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// WriteManifest atomically replaces the manifest at the given path.
+func WriteManifest(path string, m Manifest) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	w := bufio.NewWriter(f)
+	for _, p := range paths {
+		e := m[p]
+		fmt.Fprintf(w, "%s %d %d %s\n", e.Checksum, e.Size, e.ModTime.UnixNano(), strconv.Quote(p))
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
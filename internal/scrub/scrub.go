@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package scrub verifies the contents of the data filesystem against a
+// manifest of previously recorded checksums, to surface silent corruption on
+// media that lacks built-in checksumming.
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options configures a scrub.
+type Options struct {
+	// Root is the root of the data filesystem.
+	Root string
+	// Rate is the maximum read rate in bytes per second (zero is unlimited).
+	Rate int64
+}
+
+// Result summarizes a completed scrub.
+type Result struct {
+	// Verified is the number of files whose contents matched the manifest.
+	Verified int
+	// Updated is the number of new or legitimately modified files recorded.
+	Updated int
+	// Corrupted lists files whose contents changed without their size or
+	// modification time changing.
+	Corrupted []string
+}
+
+// Scrub walks the data filesystem, verifying each regular file against the
+// manifest. Files that are new, or that have been modified since they were
+// last recorded, have their checksums (re)recorded. Files that have been
+// removed are dropped from the manifest.
+func Scrub(ctx context.Context, opts Options) (*Result, error) {
+	manifestPath := filepath.Join(opts.Root, ManifestName)
+
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var res Result
+	seen := make(map[string]bool, len(manifest))
+
+	err = filepath.WalkDir(opts.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(opts.Root, path)
+		if err != nil {
+			return err
+		}
+
+		// Hidden top-level entries (the manifest, overlay work directories)
+		// belong to matchstick.
+		if rel != "." && !strings.Contains(rel, string(filepath.Separator)) && strings.HasPrefix(rel, ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		seen[rel] = true
+
+		checksum, err := checksumFile(ctx, path, opts.Rate)
+		if err != nil {
+			slog.Warn("Failed to checksum file", slog.String("path", rel), slog.Any("error", err))
+			return nil
+		}
+
+		// Was the file modified while we were reading it?
+		if after, err := os.Stat(path); err != nil || !sameFileState(fi, after) {
+			return nil
+		}
+
+		if e, ok := manifest[rel]; ok && e.Size == fi.Size() && e.ModTime.Equal(fi.ModTime()) {
+			if e.Checksum == checksum {
+				res.Verified++
+				return nil
+			}
+
+			slog.Error("Detected silent corruption", slog.String("path", rel),
+				slog.String("expected", e.Checksum), slog.String("actual", checksum))
+
+			res.Corrupted = append(res.Corrupted, rel)
+
+			// Keep the known good checksum so the corruption is reported again.
+			return nil
+		}
+
+		manifest[rel] = Entry{
+			Checksum: checksum,
+			Size:     fi.Size(),
+			ModTime:  fi.ModTime(),
+		}
+		res.Updated++
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for p := range manifest {
+		if !seen[p] {
+			delete(manifest, p)
+		}
+	}
+
+	if err := WriteManifest(manifestPath, manifest); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return &res, nil
+}
+
+func sameFileState(a, b fs.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+func checksumFile(ctx context.Context, path string, rate int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, &throttledReader{ctx: ctx, r: f, rate: rate}); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// throttledReader limits the rate at which an underlying reader is consumed.
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if tr.rate <= 0 {
+		return tr.r.Read(p)
+	}
+
+	if tr.start.IsZero() {
+		tr.start = time.Now()
+	}
+
+	// Read at most a tenth of a second's worth at a time.
+	if chunk := tr.rate / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := tr.r.Read(p)
+	tr.read += int64(n)
+
+	expected := time.Duration(float64(tr.read) / float64(tr.rate) * float64(time.Second))
+	if delay := expected - time.Since(tr.start); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-tr.ctx.Done():
+			return n, tr.ctx.Err()
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package scrub_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/scrub"
+)
+
+func TestScrub(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, ".etc-work"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(root, "etc", "hostname")
+	if err := os.WriteFile(path, []byte("matchstick\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, ".etc-work", "ignored"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := scrub.Scrub(context.Background(), scrub.Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Updated != 1 || res.Verified != 0 || len(res.Corrupted) != 0 {
+		t.Fatalf("unexpected result on first scrub: %+v", res)
+	}
+
+	res, err = scrub.Scrub(context.Background(), scrub.Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Updated != 0 || res.Verified != 1 || len(res.Corrupted) != 0 {
+		t.Fatalf("unexpected result on second scrub: %+v", res)
+	}
+
+	// Flip some bits without changing the size or modification time.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("matchstock\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = scrub.Scrub(context.Background(), scrub.Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Corrupted) != 1 || res.Corrupted[0] != filepath.Join("etc", "hostname") {
+		t.Fatalf("expected corruption to be detected: %+v", res)
+	}
+}
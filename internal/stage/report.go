@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package stage
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Report is a machine-readable summary of the boot setup stages.
+type Report struct {
+	// Start is the time that boot setup started.
+	Start time.Time `json:"start"`
+	// StartSinceBoot is when boot setup started, relative to kernel boot.
+	StartSinceBoot Duration `json:"start_since_boot"`
+	// Total is the time spent on boot setup.
+	Total Duration `json:"total"`
+	// Stages are the individual stages.
+	Stages []StageReport `json:"stages"`
+}
+
+// StageReport is the summary of a single stage.
+type StageReport struct {
+	Name string `json:"name"`
+	// Offset is when the stage started, relative to the start of boot setup.
+	Offset   Duration `json:"offset"`
+	Duration Duration `json:"duration"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Duration marshals as (fractional) milliseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(d) / float64(time.Millisecond))
+}
+
+// NewTracker returns a tracker that reports stage offsets relative to start.
+func NewTracker(start time.Time) *Tracker {
+	return &Tracker{start: start}
+}
+
+// Mark records an instantaneous event (eg. executing init).
+func (t *Tracker) Mark(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stages = append(t.stages, Stage{Name: name, Start: time.Now()})
+}
+
+// Report summarizes the recorded stages.
+func (t *Tracker) Report() *Report {
+	now := time.Now()
+
+	r := &Report{
+		Start:          t.start,
+		StartSinceBoot: Duration(sinceBoot() - now.Sub(t.start)),
+		Total:          Duration(now.Sub(t.start)),
+	}
+
+	for _, s := range t.Stages() {
+		sr := StageReport{
+			Name:     s.Name,
+			Offset:   Duration(s.Start.Sub(t.start)),
+			Duration: Duration(s.Duration),
+		}
+
+		if s.Err != nil {
+			sr.Error = s.Err.Error()
+		}
+
+		r.Stages = append(r.Stages, sr)
+	}
+
+	return r
+}
+
+// Log writes a one line summary of the stage timings.
+func (r *Report) Log() {
+	attrs := []any{
+		slog.Duration("total", time.Duration(r.Total)),
+		slog.Duration("since_boot", time.Duration(r.StartSinceBoot)),
+	}
+
+	for _, s := range r.Stages {
+		attrs = append(attrs, slog.Duration(s.Name, time.Duration(s.Duration)))
+	}
+
+	slog.Info("Boot setup timings", attrs...)
+}
+
+// Write atomically writes the report as JSON to path.
+func (r *Report) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package stage times the stages of boot setup, and enforces per-stage
+// timeouts so that a hung device or mount doesn't hang boot forever.
+package stage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when a stage exceeds its timeout.
+var ErrTimeout = errors.New("stage timed out")
+
+// Stage is the record of a completed (or abandoned) stage.
+type Stage struct {
+	// Name is the name of the stage.
+	Name string
+	// Start is when the stage started.
+	Start time.Time
+	// Duration is how long the stage took (or until it timed out).
+	Duration time.Duration
+	// Err is the error the stage failed with (if any).
+	Err error
+}
+
+// Tracker runs and records stages.
+type Tracker struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []Stage
+	// running counts the stage functions that haven't returned (including
+	// those abandoned on timeout).
+	running sync.WaitGroup
+}
+
+// Run runs fn as the named stage. If timeout is non-zero and fn doesn't
+// return in time, ErrTimeout is returned. As blocking system calls can't be
+// interrupted, fn may continue to run in the background; it is passed a
+// context that is cancelled on timeout.
+func (t *Tracker) Run(ctx context.Context, name string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+
+	done := make(chan error, 1)
+	t.running.Add(1)
+	go func() {
+		defer t.running.Done()
+		done <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %s exceeded %v", ErrTimeout, name, timeout)
+		} else {
+			err = ctx.Err()
+		}
+	}
+
+	s := Stage{
+		Name:     name,
+		Start:    start,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+
+	t.mu.Lock()
+	t.stages = append(t.stages, s)
+	t.mu.Unlock()
+
+	if err != nil {
+		slog.Warn("Stage failed", slog.String("stage", name), slog.Duration("duration", s.Duration), slog.Any("error", err))
+	} else {
+		slog.Info("Stage completed", slog.String("stage", name), slog.Duration("duration", s.Duration))
+	}
+
+	return err
+}
+
+// Done returns a channel that is closed once every stage function has
+// returned, including those that were abandoned on timeout (which may still
+// be running helper processes).
+func (t *Tracker) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+// Stages returns the recorded stages, in the order they completed.
+func (t *Tracker) Stages() []Stage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Stage(nil), t.stages...)
+}
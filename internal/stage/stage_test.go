@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package stage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/stage"
+)
+
+func TestRun(t *testing.T) {
+	var tr stage.Tracker
+
+	if err := tr.Run(context.Background(), "fast", time.Second, func(ctx context.Context) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	hung := make(chan struct{})
+
+	err := tr.Run(context.Background(), "hung", 10*time.Millisecond, func(ctx context.Context) error {
+		<-hung
+		return nil
+	})
+	if !errors.Is(err, stage.ErrTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	stages := tr.Stages()
+	if len(stages) != 2 || stages[0].Name != "fast" || stages[1].Name != "hung" {
+		t.Fatalf("unexpected stages: %+v", stages)
+	}
+
+	if stages[0].Err != nil || !errors.Is(stages[1].Err, stage.ErrTimeout) {
+		t.Errorf("unexpected stage errors: %+v", stages)
+	}
+
+	// The abandoned stage is still running.
+	done := tr.Done()
+	select {
+	case <-done:
+		t.Error("Done() closed while a stage is still running")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(hung)
+	<-done
+}
+
+func TestReport(t *testing.T) {
+	start := time.Now()
+	tr := stage.NewTracker(start)
+
+	if err := tr.Run(context.Background(), "options", 0, func(ctx context.Context) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = tr.Run(context.Background(), "data-mount", 0, func(ctx context.Context) error {
+		return errors.New("no such device")
+	})
+
+	tr.Mark("exec")
+
+	r := tr.Report()
+	if len(r.Stages) != 3 {
+		t.Fatalf("expected 3 stages, got %d", len(r.Stages))
+	}
+
+	if r.Stages[1].Error != "no such device" || r.Stages[2].Name != "exec" {
+		t.Errorf("unexpected stages: %+v", r.Stages)
+	}
+
+	path := filepath.Join(t.TempDir(), "matchstick", "stages.json")
+	if err := r.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := decoded["total"].(float64); !ok {
+		t.Errorf("expected total to be a number of milliseconds: %s", data)
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package supervisor runs init as a child process (rather than executing
+// it), reaping orphaned processes and forwarding signals to it. It's intended
+// for minimal appliances whose "init" is a single application.
+package supervisor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy determines what happens when the supervised init exits.
+type Policy string
+
+const (
+	// Restart restarts init (with an exponential backoff between
+	// consecutive restarts).
+	Restart Policy = "restart"
+	// Reboot reboots the machine.
+	Reboot Policy = "reboot"
+	// Poweroff powers off the machine.
+	Poweroff Policy = "poweroff"
+	// Shell starts an emergency shell on the console, restarting init once
+	// it exits.
+	Shell Policy = "shell"
+)
+
+// ParsePolicy parses a policy name.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case Restart, Reboot, Poweroff, Shell:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown exit policy %q", s)
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package supervisor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+// Forwarded are the signals forwarded to init.
+var Forwarded = []os.Signal{
+	unix.SIGTERM,
+	unix.SIGINT,
+	unix.SIGHUP,
+	unix.SIGQUIT,
+	unix.SIGUSR1,
+	unix.SIGUSR2,
+	unix.SIGPWR,
+}
+
+// Exit describes how init exited.
+type Exit struct {
+	// Status is the wait status of init.
+	Status unix.WaitStatus
+	// Signal is the last of SIGTERM or SIGINT forwarded to init (if any),
+	// indicating a shutdown (or reboot) was requested.
+	Signal os.Signal
+}
+
+// String returns a description of how init exited.
+func (e *Exit) String() string {
+	if e.Status.Signaled() {
+		return "killed by " + e.Status.Signal().String()
+	}
+
+	return fmt.Sprintf("exited with status %d", e.Status.ExitStatus())
+}
+
+// Supervisor runs init as a child process.
+type Supervisor struct {
+	// Path is the path of the init executable.
+	Path string
+	// Argv is the argument vector of init (including argv[0]).
+	Argv []string
+	// Env is the environment of init.
+	Env []string
+	// Helpers, if set, is closed once matchstick's own child processes (eg.
+	// hooks) have exited. Until then only init is reaped, so that the exit
+	// statuses of helpers aren't stolen from them.
+	Helpers <-chan struct{}
+}
+
+// Run starts init and waits for it to exit, reaping any other (orphaned)
+// processes in the meantime and forwarding signals to init.
+func (s *Supervisor) Run() (*Exit, error) {
+	// Orphaned processes are reparented to PID 1, unless we're a subreaper.
+	if os.Getpid() != 1 {
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			slog.Warn("Failed to become a subreaper", slog.Any("error", err))
+		}
+	}
+
+	// Subscribe before starting init so no signals are missed.
+	sigs := make(chan os.Signal, 32)
+	signal.Notify(sigs, append([]os.Signal{unix.SIGCHLD}, Forwarded...)...)
+	defer signal.Stop(sigs)
+
+	proc, err := os.StartProcess(s.Path, s.Argv, &os.ProcAttr{
+		Env:   s.Env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Started init", slog.String("path", s.Path), slog.Int("pid", proc.Pid))
+
+	exit := &Exit{}
+
+	helpers := s.Helpers
+
+	for {
+		if status, ok := reap(proc.Pid, helpers == nil); ok {
+			exit.Status = status
+			return exit, nil
+		}
+
+		var sig os.Signal
+		select {
+		case sig = <-sigs:
+		case <-helpers:
+			// Reap any orphans that exited in the meantime.
+			helpers = nil
+			continue
+		}
+
+		if sig == unix.SIGCHLD {
+			continue
+		}
+
+		slog.Debug("Forwarding signal to init", slog.String("signal", sig.String()))
+
+		if err := proc.Signal(sig); err != nil {
+			slog.Warn("Failed to forward signal", slog.String("signal", sig.String()), slog.Any("error", err))
+		}
+
+		if sig == unix.SIGTERM || sig == unix.SIGINT {
+			exit.Signal = sig
+		}
+	}
+}
+
+// reap reaps exited children (all of them, or only pid unless all is set),
+// returning the wait status of pid if it was one of them.
+func reap(pid int, all bool) (status unix.WaitStatus, exited bool) {
+	wait := pid
+	if all {
+		wait = -1
+	}
+
+	for {
+		var ws unix.WaitStatus
+		wpid, err := unix.Wait4(wait, &ws, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || wpid <= 0 {
+			return status, exited
+		}
+
+		if wpid == pid {
+			status, exited = ws, true
+		} else {
+			slog.Debug("Reaped orphaned process", slog.Int("pid", wpid))
+		}
+	}
+}
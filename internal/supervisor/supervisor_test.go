@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package supervisor_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/immutos/matchstick/internal/supervisor"
+	"golang.org/x/sys/unix"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"restart", "reboot", "poweroff", "Shell"} {
+		if _, err := supervisor.ParsePolicy(s); err != nil {
+			t.Errorf("ParsePolicy(%q): %v", s, err)
+		}
+	}
+
+	if _, err := supervisor.ParsePolicy("panic"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestRun(t *testing.T) {
+	sh := shell(t)
+
+	// The background sleep is orphaned (and so must be reaped by us).
+	s := &supervisor.Supervisor{Path: sh, Argv: []string{"sh", "-c", "sleep 0.1 & exit 3"}}
+
+	exit, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exit.Status.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %s", exit)
+	}
+
+	if exit.Signal != nil {
+		t.Errorf("expected no signal to have been forwarded, got %v", exit.Signal)
+	}
+}
+
+func TestRunHelpers(t *testing.T) {
+	sh := shell(t)
+
+	helpers := make(chan struct{})
+	s := &supervisor.Supervisor{Path: sh, Argv: []string{"sh", "-c", "sleep 0.5; exit 3"}, Helpers: helpers}
+
+	// A helper exiting while init runs must still be waited for by its
+	// caller.
+	helperErr := make(chan error, 1)
+	go func() {
+		defer close(helpers)
+
+		time.Sleep(100 * time.Millisecond)
+		helperErr <- exec.Command(sh, "-c", "exit 5").Run()
+	}()
+
+	exit, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exit.Status.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %s", exit)
+	}
+
+	var exitErr *exec.ExitError
+	if err := <-helperErr; !errors.As(err, &exitErr) || exitErr.ExitCode() != 5 {
+		t.Errorf("expected helper to exit with status 5, got %v", err)
+	}
+}
+
+func TestRunForwardsSignals(t *testing.T) {
+	sh := shell(t)
+
+	s := &supervisor.Supervisor{Path: sh, Argv: []string{"sh", "-c", `trap "exit 7" TERM; while :; do sleep 0.01; done`}}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		_ = unix.Kill(os.Getpid(), unix.SIGTERM)
+	}()
+
+	exit, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exit.Status.ExitStatus() != 7 {
+		t.Errorf("expected exit status 7, got %s", exit)
+	}
+
+	if exit.Signal != unix.SIGTERM {
+		t.Errorf("expected SIGTERM to have been forwarded, got %v", exit.Signal)
+	}
+}
+
+func shell(t *testing.T) string {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	return sh
+}
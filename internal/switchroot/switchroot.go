@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package switchroot moves from an initramfs to the real root filesystem,
+// in the manner of busybox's switch_root.
+package switchroot
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// DefaultNewRoot is where the real root filesystem is mounted.
+const DefaultNewRoot = "/sysroot"
+
+// DefaultFilesystemsPath lists the filesystem types supported by the kernel.
+const DefaultFilesystemsPath = "/proc/filesystems"
+
+// APIMounts are the filesystems moved into the new root.
+var APIMounts = []string{"/dev", "/proc", "/sys", "/run"}
+
+// Filesystems returns the filesystem types (that require a device) listed
+// in a /proc/filesystems style file.
+func Filesystems(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fstypes []string
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 1 {
+			// Filesystems that don't require a device are prefixed with "nodev".
+			continue
+		}
+
+		fstypes = append(fstypes, fields[0])
+	}
+
+	return fstypes, sc.Err()
+}
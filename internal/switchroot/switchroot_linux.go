@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package switchroot
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+// IsInitramfs returns true if root is an initramfs (ie. a ramfs or tmpfs).
+func IsInitramfs(root string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err != nil {
+		return false
+	}
+
+	return uint32(st.Type) == unix.RAMFS_MAGIC || uint32(st.Type) == unix.TMPFS_MAGIC
+}
+
+// Mount mounts the real root filesystem. If fstype is empty, each of the
+// (block device backed) filesystem types supported by the kernel is tried in
+// turn, as the kernel does for rootfstype=.
+func Mount(m mounter.Mounter, source, target, fstype string, flags uintptr, data string) error {
+	if err := m.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+
+	if fstype != "" {
+		return m.Mount(source, target, fstype, flags, data)
+	}
+
+	fstypes, err := Filesystems(DefaultFilesystemsPath)
+	if err != nil {
+		return fmt.Errorf("failed to list filesystem types: %w", err)
+	}
+
+	var errs []error
+	for _, fstype := range fstypes {
+		err := m.Mount(source, target, fstype, flags, data)
+		if err == nil {
+			slog.Debug("Mounted root filesystem", slog.String("fstype", fstype))
+			return nil
+		}
+
+		// Transient errors (eg. the device not existing yet) won't be fixed
+		// by trying another type.
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENXIO) {
+			return err
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", fstype, err))
+	}
+
+	return fmt.Errorf("no filesystem type could mount %s: %w", source, errors.Join(errs...))
+}
+
+// Switch makes newRoot the root filesystem. The API filesystems are moved
+// into the new root, the new root is moved on top of "/" and chrooted into,
+// and the contents of the old root (an initramfs) are deleted to free the
+// memory they occupy. The mounts are performed with m.
+func Switch(m mounter.Mounter, newRoot string) error {
+	for _, api := range APIMounts {
+		target := filepath.Join(newRoot, api)
+		if err := m.MkdirAll(target, 0o755); err != nil {
+			slog.Warn("Failed to create mountpoint", slog.String("path", target), slog.Any("error", err))
+		}
+
+		if err := m.Mount(api, target, "", unix.MS_MOVE, ""); err != nil {
+			slog.Warn("Failed to move mount, unmounting it", slog.String("path", api), slog.Any("error", err))
+
+			if err := m.Unmount(api, unix.MNT_DETACH); err != nil {
+				slog.Debug("Failed to unmount", slog.String("path", api), slog.Any("error", err))
+			}
+		}
+	}
+
+	oldRoot, err := os.Open("/")
+	if err != nil {
+		return err
+	}
+	defer oldRoot.Close()
+
+	if err := unix.Chdir(newRoot); err != nil {
+		return err
+	}
+
+	if err := m.Mount(newRoot, "/", "", unix.MS_MOVE, ""); err != nil {
+		return fmt.Errorf("failed to move %s to /: %w", newRoot, err)
+	}
+
+	if err := unix.Chroot("."); err != nil {
+		return fmt.Errorf("failed to chroot: %w", err)
+	}
+
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+
+	// Never delete anything from a persistent filesystem.
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(oldRoot.Fd()), &st); err != nil {
+		return err
+	}
+
+	if uint32(st.Type) != unix.RAMFS_MAGIC && uint32(st.Type) != unix.TMPFS_MAGIC {
+		slog.Warn("Old root is not an initramfs, not deleting its contents")
+		return nil
+	}
+
+	var oldSt unix.Stat_t
+	if err := unix.Fstat(int(oldRoot.Fd()), &oldSt); err != nil {
+		return err
+	}
+
+	if err := removeContents(int(oldRoot.Fd()), uint64(oldSt.Dev)); err != nil {
+		slog.Warn("Failed to delete initramfs contents", slog.Any("error", err))
+	}
+
+	return nil
+}
+
+// oldRootDir is where the old root is placed (within the new root) while
+// pivoting.
+const oldRootDir = ".oldroot"
+
+// Pivot makes newRoot (a mountpoint) the root filesystem using pivot_root,
+// moving the given mounts (eg. the API filesystems) from the old root into
+// it. The rest of the old root is detached. Unlike Switch, the old root may
+// be a persistent filesystem (and is never deleted). The mounts are performed
+// with m.
+func Pivot(m mounter.Mounter, newRoot string, mounts []string) error {
+	// pivot_root refuses to work with shared mounts.
+	if err := m.Mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	putOld := filepath.Join(newRoot, oldRootDir)
+	if err := os.MkdirAll(putOld, 0o700); err != nil {
+		return err
+	}
+
+	if err := unix.PivotRoot(newRoot, putOld); err != nil {
+		return fmt.Errorf("failed to pivot to %s: %w", newRoot, err)
+	}
+
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+
+	for _, target := range mounts {
+		if err := m.MkdirAll(target, 0o755); err != nil {
+			slog.Warn("Failed to create mountpoint", slog.String("path", target), slog.Any("error", err))
+		}
+
+		if err := m.Mount(filepath.Join("/", oldRootDir, target), target, "", unix.MS_MOVE, ""); err != nil {
+			slog.Warn("Failed to move mount", slog.String("path", target), slog.Any("error", err))
+		}
+	}
+
+	if err := m.Unmount("/"+oldRootDir, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %w", err)
+	}
+
+	return os.Remove("/" + oldRootDir)
+}
+
+// removeContents recursively removes the contents of the directory dirfd,
+// without crossing into other filesystems (than dev).
+func removeContents(dirfd int, dev uint64) error {
+	// The directory is read through a duplicate, so closing it doesn't close
+	// dirfd.
+	dupfd, err := unix.Dup(dirfd)
+	if err != nil {
+		return err
+	}
+
+	dir := os.NewFile(uintptr(dupfd), "")
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range names {
+		var st unix.Stat_t
+		if err := unix.Fstatat(dirfd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if uint64(st.Dev) != dev {
+			continue
+		}
+
+		if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+			fd, err := unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			err = removeContents(fd, dev)
+			_ = unix.Close(fd)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			if err := unix.Unlinkat(dirfd, name, unix.AT_REMOVEDIR); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+
+			continue
+		}
+
+		if err := unix.Unlinkat(dirfd, name, 0); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package switchroot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFilesystems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filesystems")
+
+	data := "nodev\tsysfs\nnodev\ttmpfs\n\text4\nnodev\toverlay\n\tvfat\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fstypes, err := Filesystems(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"ext4", "vfat"}; !reflect.DeepEqual(fstypes, want) {
+		t.Errorf("Filesystems() = %v, want %v", fstypes, want)
+	}
+}
+
+func TestRemoveContents(t *testing.T) {
+	root := t.TempDir()
+
+	for _, dir := range []string{"bin", "lib/modules/6.1"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range []string{"init", "bin/sh", "lib/modules/6.1/modules.dep"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink("/bin/sh", filepath.Join(root, "sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeContents(int(f.Fd()), st.Dev); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("expected the directory to be empty, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package teardown unmounts the overlays and data filesystem set up by
+// matchstick (as recorded in the boot report) at shutdown, in the reverse of
+// the order they were mounted.
+package teardown
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+// Targets returns the overlays, followed by the data filesystem, that are
+// still mounted, most recently mounted first.
+func Targets(r *bootreport.Report, mounts []mountinfo.Mount) []string {
+	data := filepath.Clean(r.String("mount"))
+
+	var targets []string
+
+	seen := make(map[string]bool)
+	for i := len(r.Mounts) - 1; i >= 0; i-- {
+		m := r.Mounts[i]
+		target := r.Path(m.Target)
+
+		if m.Error != "" || seen[target] || target == "/" {
+			continue
+		}
+
+		if m.FSType != "overlay" && !strings.HasPrefix(m.FSType, "fuse") && target != data {
+			continue
+		}
+
+		if mountinfo.Find(mounts, target) == nil {
+			continue
+		}
+
+		seen[target] = true
+		targets = append(targets, target)
+	}
+
+	// The data filesystem must be unmounted after the overlays using it.
+	for i, target := range targets {
+		if target == data {
+			targets = append(append(targets[:i:i], targets[i+1:]...), data)
+			break
+		}
+	}
+
+	return targets
+}
+
+// Unmount unmounts each of the targets in order. Targets that are busy (eg.
+// as a process is still running from them) are remounted read-only instead,
+// so that they are at least left clean.
+func Unmount(m mounter.Mounter, targets []string) error {
+	var errs []error
+	for _, target := range targets {
+		err := m.Unmount(target, 0)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, unix.EBUSY) {
+			errs = append(errs, fmt.Errorf("failed to unmount %s: %w", target, err))
+			continue
+		}
+
+		if err := m.Mount("", target, "", unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remount %s read-only: %w", target, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
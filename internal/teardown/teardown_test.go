@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package teardown_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/mountinfo"
+	"github.com/immutos/matchstick/internal/teardown"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+func TestTargets(t *testing.T) {
+	r := &bootreport.Report{
+		Options: map[string]any{"mount": "/mnt/data", "root": "/dev/vda1", "new_root": "/sysroot"},
+		Mounts: []bootreport.Mount{
+			{Source: "/dev/vda1", Target: "/sysroot", FSType: "ext4"},
+			{Source: "/dev/vda2", Target: "/sysroot/mnt/data", FSType: "ext4"},
+			{Source: "overlay", Target: "/sysroot/etc", FSType: "overlay"},
+			{Source: "overlay", Target: "/sysroot/srv", FSType: "overlay", Error: "no such file or directory"},
+			{Source: "proc", Target: "/proc", FSType: "proc"},
+			{Source: "overlay", Target: "/sysroot/var", FSType: "overlay"},
+			{Source: "overlay", Target: "/sysroot/opt", FSType: "overlay"},
+		},
+	}
+
+	// /opt was unmounted already.
+	mounts := []mountinfo.Mount{
+		{Target: "/", FSType: "ext4"},
+		{Target: "/proc", FSType: "proc"},
+		{Target: "/mnt/data", FSType: "ext4"},
+		{Target: "/etc", FSType: "overlay"},
+		{Target: "/var", FSType: "overlay"},
+	}
+
+	if got, want := teardown.Targets(r, mounts), []string{"/var", "/etc", "/mnt/data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Targets() = %v, want %v", got, want)
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	m := mounter.NewFake("/mnt/data", "/etc", "/var")
+
+	for _, target := range []string{"/mnt/data", "/etc", "/var"} {
+		if err := m.Mount("overlay", target, "overlay", 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.Fail("unmount", "/etc", unix.EBUSY)
+
+	if err := teardown.Unmount(m, []string{"/var", "/etc", "/mnt/data"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// /etc is busy, so is remounted read-only instead.
+	if got, want := m.Mounts(), []string{"/etc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Mounts() = %v, want %v", got, want)
+	}
+
+	last := m.Ops[len(m.Ops)-1]
+	if last.Kind != "unmount" || last.Target != "/mnt/data" {
+		t.Errorf("expected the data filesystem to be unmounted last, got %s", last)
+	}
+
+	remount := m.Ops[len(m.Ops)-2]
+	if remount.Target != "/etc" || remount.Flags != unix.MS_REMOUNT|unix.MS_RDONLY {
+		t.Errorf("expected /etc to be remounted read-only, got %s", remount)
+	}
+
+	if err := teardown.Unmount(m, []string{"/srv"}); err == nil {
+		t.Error("expected error unmounting a target that isn't mounted")
+	}
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tmpfiles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/nofollow"
+	"golang.org/x/sys/unix"
+)
+
+// Apply applies the entries within root (with user and group names looked
+// up in the root's /etc/passwd and /etc/group). Every entry is attempted,
+// and the errors are returned together.
+func Apply(root string, entries []Entry) error {
+	var errs []error
+	for _, e := range entries {
+		if err := apply(root, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", e.Type, e.Path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func apply(root string, e Entry) error {
+	uid, err := lookupID(filepath.Join(root, "etc/passwd"), e.User)
+	if err != nil {
+		return err
+	}
+
+	gid, err := lookupID(filepath.Join(root, "etc/group"), e.Group)
+	if err != nil {
+		return err
+	}
+
+	// The entry's directory is opened without following symlinks, as the
+	// writable trees can be modified by unprivileged users (eg. a service
+	// owning /var/log/app could plant a symlink to /etc/shadow).
+	parent, err := nofollow.OpenDir(root, filepath.Dir(e.Path))
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+
+	dirfd := int(parent.Fd())
+	name := filepath.Base(e.Path)
+
+	var st unix.Stat_t
+	exists := unix.Fstatat(dirfd, name, &st, unix.AT_SYMLINK_NOFOLLOW) == nil
+
+	switch e.Type {
+	case Directory:
+		mode := e.Mode
+		if mode == -1 {
+			mode = 0o755
+		}
+
+		if !exists {
+			if err := unix.Mkdirat(dirfd, name, uint32(mode)&0o777); err != nil {
+				return err
+			}
+		}
+
+		f, err := nofollow.Openat(dirfd, name, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return setAttrs(f, mode, uid, gid)
+	case Symlink, ReplaceSymlink:
+		if exists && e.Type == Symlink {
+			return nil
+		}
+
+		if exists {
+			// The parent is pinned by its descriptor, so this can't be
+			// redirected elsewhere.
+			if err := os.RemoveAll(fdPath(dirfd, name)); err != nil {
+				return err
+			}
+		}
+
+		if err := unix.Symlinkat(e.Argument, dirfd, name); err != nil {
+			return err
+		}
+
+		return unix.Fchownat(dirfd, name, uid, gid, unix.AT_SYMLINK_NOFOLLOW)
+	case Copy:
+		if exists {
+			return nil
+		}
+
+		return copyFile(filepath.Join(root, e.Argument), dirfd, name, e.Mode, uid, gid)
+	}
+
+	return nil
+}
+
+// fdPath returns the path of name in the directory dirfd.
+func fdPath(dirfd int, name string) string {
+	return fmt.Sprintf("/proc/self/fd/%d/%s", dirfd, name)
+}
+
+// copyFile copies the regular file src to name in the directory dirfd, with
+// the mode of src unless mode is specified.
+func copyFile(src string, dirfd int, name string, mode, uid, gid int) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+
+	if mode == -1 {
+		mode = int(fi.Mode().Perm())
+	}
+
+	out, err := nofollow.Openat(dirfd, name, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := setAttrs(out, mode, uid, gid); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// setAttrs sets the mode (including the setuid, setgid and sticky bits) and
+// ownership of the open file f, leaving the owner or group unchanged if -1.
+// The mode is set last, as changing the owner clears the setuid and setgid
+// bits.
+func setAttrs(f *os.File, mode, uid, gid int) error {
+	if err := unix.Fchown(int(f.Fd()), uid, gid); err != nil {
+		return &os.PathError{Op: "chown", Path: f.Name(), Err: err}
+	}
+
+	if err := unix.Fchmod(int(f.Fd()), uint32(mode)&0o7777); err != nil {
+		return &os.PathError{Op: "chmod", Path: f.Name(), Err: err}
+	}
+
+	return nil
+}
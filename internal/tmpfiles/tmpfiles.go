@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package tmpfiles creates the skeleton structure (directories, symlinks and
+// files) declared by an image, using a minimal subset of the tmpfiles.d(5)
+// format. It's applied once the overlays have been mounted, so required
+// directories exist even in volatile trees.
+package tmpfiles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDir is the default directory containing the configuration files.
+const DefaultDir = "/etc/matchstick/tmpfiles.d"
+
+// Type is the kind of entry.
+type Type string
+
+const (
+	// Directory creates a directory (and its parents), and adjusts the mode
+	// and ownership of an existing one.
+	Directory Type = "d"
+	// Symlink creates a symlink to the argument, if the path doesn't exist.
+	Symlink Type = "L"
+	// ReplaceSymlink creates a symlink to the argument, replacing whatever
+	// exists at the path.
+	ReplaceSymlink Type = "L+"
+	// Copy copies the file named by the argument, if the path doesn't exist.
+	Copy Type = "C"
+)
+
+// Entry is a single line of a configuration file.
+type Entry struct {
+	Type Type
+	Path string
+	// Mode is the permissions (including the setuid, setgid and sticky bits),
+	// or -1 for the default.
+	Mode int
+	// User and Group are names or numeric IDs, empty to leave the owner (or
+	// group) unchanged.
+	User  string
+	Group string
+	// Argument is the target of a symlink, or the source of a copy.
+	Argument string
+}
+
+// Read reads the configuration files (named *.conf) in dir, in lexical order.
+// A missing directory has no entries.
+func Read(dir string) ([]Entry, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var entries []Entry
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		fileEntries, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		entries = append(entries, fileEntries...)
+	}
+
+	return entries, nil
+}
+
+// Parse parses a configuration file, eg:
+//
+//	# Type Path         Mode User Group Age Argument
+//	d      /var/log/app 0750 app  app   -   -
+//	L      /run/lock    -    -    -     -   /var/lock
+//	C      /etc/app.conf -   -    -     -   /usr/share/app/app.conf
+//
+// Trailing fields may be omitted, and "-" selects the default. The age field
+// is accepted for compatibility, but ignored.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a type and path", lineno)
+		}
+
+		// Every field is optional beyond the path.
+		field := func(i int) string {
+			if i < len(fields) && fields[i] != "-" {
+				return fields[i]
+			}
+			return ""
+		}
+
+		e := Entry{
+			Type:  Type(fields[0]),
+			Path:  filepath.Clean(fields[1]),
+			Mode:  -1,
+			User:  field(3),
+			Group: field(4),
+		}
+
+		if !filepath.IsAbs(e.Path) {
+			return nil, fmt.Errorf("line %d: path %q must be absolute", lineno, fields[1])
+		}
+
+		if mode := field(2); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || m > 0o7777 {
+				return nil, fmt.Errorf("line %d: invalid mode %q", lineno, mode)
+			}
+
+			e.Mode = int(m)
+		}
+
+		// The argument is the rest of the line (and may contain spaces).
+		if len(fields) > 6 {
+			e.Argument = strings.Join(fields[6:], " ")
+		}
+
+		switch e.Type {
+		case Directory:
+		case Symlink, ReplaceSymlink, Copy:
+			if e.Argument == "" {
+				return nil, fmt.Errorf("line %d: %s requires an argument", lineno, e.Type)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported type %q", lineno, fields[0])
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// lookupID returns the numeric ID of a user or group name, as listed in a
+// passwd or group file. Numeric IDs are returned as-is, and an empty name
+// returns -1.
+func lookupID(file, name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return -1, err
+	}
+
+	return -1, fmt.Errorf("unknown name %q in %s", name, file)
+}
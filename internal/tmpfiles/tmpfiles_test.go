@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tmpfiles
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	entries, err := Parse(strings.NewReader(`
+# Type Path Mode User Group Age Argument
+d /var/log/app/ 0750 app app
+L /run/lock - - - - /var/lock
+C /etc/app.conf - - - - /usr/share/app/default app.conf
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	if e := entries[0]; e.Path != "/var/log/app" || e.Mode != 0o750 || e.User != "app" || e.Group != "app" {
+		t.Errorf("unexpected directory: %+v", e)
+	}
+
+	if e := entries[1]; e.Type != Symlink || e.Mode != -1 || e.User != "" || e.Argument != "/var/lock" {
+		t.Errorf("unexpected symlink: %+v", e)
+	}
+
+	if e := entries[2]; e.Argument != "/usr/share/app/default app.conf" {
+		t.Errorf("unexpected argument: %q", e.Argument)
+	}
+
+	for _, invalid := range []string{"d", "d var", "d /var 0999", "L /run/lock", "f /etc/motd"} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "usr/share/app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "usr/share/app/app.conf"), []byte("default"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "var/tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []Entry{
+		{Type: Directory, Path: "/var/log/app", Mode: 0o750},
+		{Type: Directory, Path: "/var/tmp", Mode: 0o1777},
+		{Type: Symlink, Path: "/run/lock", Mode: -1, Argument: "/var/lock"},
+		{Type: Copy, Path: "/etc/app.conf", Mode: -1, Argument: "/usr/share/app/app.conf"},
+	}
+
+	// Applying twice is harmless.
+	for i := 0; i < 2; i++ {
+		if err := Apply(root, entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "var/log/app")); err != nil || fi.Mode() != os.ModeDir|0o750 {
+		t.Errorf("unexpected directory: %v, %v", fi, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "var/tmp")); err != nil || fi.Mode() != os.ModeDir|os.ModeSticky|0o777 {
+		t.Errorf("mode of existing directory not adjusted: %v, %v", fi, err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(root, "run/lock")); err != nil || target != "/var/lock" {
+		t.Errorf("unexpected symlink: %q, %v", target, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "etc/app.conf")); err != nil || fi.Mode() != 0o640 {
+		t.Errorf("unexpected copy: %v, %v", fi, err)
+	}
+
+	// Existing files aren't overwritten, but failures don't stop the others.
+	if err := os.WriteFile(filepath.Join(root, "etc/app.conf"), []byte("changed"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Apply(root, []Entry{
+		{Type: Copy, Path: "/etc/missing.conf", Mode: -1, Argument: "/usr/share/app/missing.conf"},
+		{Type: Copy, Path: "/etc/app.conf", Mode: -1, Argument: "/usr/share/app/app.conf"},
+		{Type: ReplaceSymlink, Path: "/etc/app.conf", Mode: -1, User: "nobody", Argument: "/dev/null"},
+		{Type: ReplaceSymlink, Path: "/run/lock", Mode: -1, Argument: "/run/lock.d"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing.conf") || !strings.Contains(err.Error(), "L+ /etc/app.conf") {
+		t.Errorf("expected errors for the missing source and user, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(root, "etc/app.conf")); string(data) != "changed" {
+		t.Errorf("existing file was overwritten: %q", data)
+	}
+
+	if target, _ := os.Readlink(filepath.Join(root, "run/lock")); target != "/run/lock.d" {
+		t.Errorf("symlink not replaced: %q", target)
+	}
+}
+
+func TestApplySymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	secret := filepath.Join(outside, "shadow")
+	if err := os.WriteFile(secret, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "var/log"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Planted by an unprivileged user in a writable tree.
+	if err := os.Symlink(secret, filepath.Join(root, "var/log/app")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "var/lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Apply(root, []Entry{
+		{Type: Directory, Path: "/var/log/app", Mode: 0o777},
+		{Type: Directory, Path: "/var/lib/app", Mode: 0o777},
+		{Type: Copy, Path: "/var/lib/app.conf", Mode: 0o644, Argument: "/var/log/app"},
+	})
+	if err == nil {
+		t.Fatal("expected errors for entries through symlinks")
+	}
+
+	if fi, err := os.Stat(secret); err != nil || fi.Mode() != 0o600 {
+		t.Errorf("symlink target was modified: %v, %v", fi, err)
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("expected nothing to be created outside the root, got %v", entries)
+	}
+}
+
+func TestLookupID(t *testing.T) {
+	passwd := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/sh\napp:x:998:998::/var/lib/app:/usr/sbin/nologin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want int
+	}{
+		{"", -1},
+		{"app", 998},
+		{"1000", 1000},
+	} {
+		if got, err := lookupID(passwd, tt.name); err != nil || got != tt.want {
+			t.Errorf("lookupID(%q) = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := lookupID(passwd, "nobody"); err == nil {
+		t.Error("expected error for an unknown name")
+	}
+}
@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package tpm implements the (small) subset of the TPM 2.0 command set
+// needed to measure data into PCRs.
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"   // For the SHA-1 PCR bank.
+	_ "crypto/sha256" // For the SHA-256 PCR bank.
+	_ "crypto/sha512" // For the SHA-384 and SHA-512 PCR banks.
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultDevice is the (kernel resource managed) TPM device.
+const DefaultDevice = "/dev/tpmrm0"
+
+const (
+	tagNoSessions = 0x8001
+	tagSessions   = 0x8002
+
+	ccPCRExtend     = 0x00000182
+	ccGetCapability = 0x0000017A
+
+	capPCRs = 0x00000005
+
+	rsPW = 0x40000009
+
+	// maxResponseSize is the maximum size of a TPM response.
+	maxResponseSize = 4096
+)
+
+// alg is a TPM hash algorithm identifier.
+type alg struct {
+	id   uint16
+	hash crypto.Hash
+}
+
+// algs are the supported hash algorithms.
+var algs = []alg{
+	{0x0004, crypto.SHA1},
+	{0x000B, crypto.SHA256},
+	{0x000C, crypto.SHA384},
+	{0x000D, crypto.SHA512},
+}
+
+// TPM is a TPM 2.0 device.
+type TPM struct {
+	rw io.ReadWriter
+}
+
+// Open opens a TPM device.
+func Open(path string) (*TPM, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(f), nil
+}
+
+// New returns a TPM that exchanges commands over rw (which must return each
+// response in a single read, as the TPM devices do).
+func New(rw io.ReadWriter) *TPM {
+	return &TPM{rw: rw}
+}
+
+// Close closes the TPM device.
+func (t *TPM) Close() error {
+	if c, ok := t.rw.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Banks returns the hash algorithms of the allocated PCR banks.
+func (t *TPM) Banks() ([]crypto.Hash, error) {
+	var params bytes.Buffer
+	_ = binary.Write(&params, binary.BigEndian, []uint32{capPCRs, 0, 1})
+
+	resp, err := t.run(tagNoSessions, ccGetCapability, params.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// moreData (1), capability (4), TPML_PCR_SELECTION.
+	r := bytes.NewReader(resp)
+
+	var hdr struct {
+		MoreData   uint8
+		Capability uint32
+		Count      uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("malformed capability response: %w", err)
+	}
+
+	var banks []crypto.Hash
+	for i := uint32(0); i < hdr.Count; i++ {
+		var sel struct {
+			Alg  uint16
+			Size uint8
+		}
+		if err := binary.Read(r, binary.BigEndian, &sel); err != nil {
+			return nil, fmt.Errorf("malformed capability response: %w", err)
+		}
+
+		bitmap := make([]byte, sel.Size)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return nil, fmt.Errorf("malformed capability response: %w", err)
+		}
+
+		// Only banks with PCRs allocated are active.
+		if bytes.Count(bitmap, []byte{0}) == len(bitmap) {
+			continue
+		}
+
+		for _, a := range algs {
+			if a.id == sel.Alg {
+				banks = append(banks, a.hash)
+			}
+		}
+	}
+
+	return banks, nil
+}
+
+// Extend extends a PCR with the given digest (for each bank).
+func (t *TPM) Extend(pcr int, digests map[crypto.Hash][]byte) error {
+	var params bytes.Buffer
+	_ = binary.Write(&params, binary.BigEndian, uint32(pcr))
+
+	// A password session with an empty password.
+	_ = binary.Write(&params, binary.BigEndian, uint32(9))
+	_ = binary.Write(&params, binary.BigEndian, uint32(rsPW))
+	_ = binary.Write(&params, binary.BigEndian, uint16(0))
+	_ = binary.Write(&params, binary.BigEndian, uint8(0))
+	_ = binary.Write(&params, binary.BigEndian, uint16(0))
+
+	var values bytes.Buffer
+	var count uint32
+	for _, a := range algs {
+		digest, ok := digests[a.hash]
+		if !ok {
+			continue
+		}
+
+		if len(digest) != a.hash.Size() {
+			return fmt.Errorf("invalid %s digest size %d", a.hash, len(digest))
+		}
+
+		_ = binary.Write(&values, binary.BigEndian, a.id)
+		values.Write(digest)
+		count++
+	}
+
+	if count == 0 {
+		return errors.New("no supported digests")
+	}
+
+	_ = binary.Write(&params, binary.BigEndian, count)
+	params.Write(values.Bytes())
+
+	_, err := t.run(tagSessions, ccPCRExtend, params.Bytes())
+	return err
+}
+
+// run sends a command and returns the parameters of its response.
+func (t *TPM) run(tag uint16, cc uint32, params []byte) ([]byte, error) {
+	cmd := make([]byte, 10, 10+len(params))
+	binary.BigEndian.PutUint16(cmd[0:], tag)
+	binary.BigEndian.PutUint32(cmd[2:], uint32(10+len(params)))
+	binary.BigEndian.PutUint32(cmd[6:], cc)
+	cmd = append(cmd, params...)
+
+	if _, err := t.rw.Write(cmd); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, maxResponseSize)
+	n, err := t.rw.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp = resp[:n]
+
+	if len(resp) < 10 {
+		return nil, errors.New("short TPM response")
+	}
+
+	if rc := binary.BigEndian.Uint32(resp[6:]); rc != 0 {
+		return nil, fmt.Errorf("TPM error 0x%x", rc)
+	}
+
+	resp = resp[10:]
+
+	// Responses to commands with sessions have a parameter size, and trailing
+	// session data.
+	if tag == tagSessions && len(resp) >= 4 {
+		size := binary.BigEndian.Uint32(resp)
+		if int(size) > len(resp)-4 {
+			return nil, errors.New("malformed TPM response")
+		}
+		resp = resp[4 : 4+size]
+	}
+
+	return resp, nil
+}
+
+// Digests returns the digest of the data read from r, for each hash.
+func Digests(hashes []crypto.Hash, r io.Reader) (map[crypto.Hash][]byte, error) {
+	writers := make([]io.Writer, len(hashes))
+	hs := make(map[crypto.Hash]interface{ Sum([]byte) []byte }, len(hashes))
+
+	for i, hash := range hashes {
+		h := hash.New()
+		writers[i] = h
+		hs[hash] = h
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	digests := make(map[crypto.Hash][]byte, len(hashes))
+	for hash, h := range hs {
+		digests[hash] = h.Sum(nil)
+	}
+
+	return digests, nil
+}
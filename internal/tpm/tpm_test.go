@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tpm_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/tpm"
+)
+
+// fakeTPM records commands, and replies with canned responses.
+type fakeTPM struct {
+	commands  [][]byte
+	responses [][]byte
+}
+
+func (f *fakeTPM) Write(p []byte) (int, error) {
+	f.commands = append(f.commands, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (f *fakeTPM) Read(p []byte) (int, error) {
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	return copy(p, resp), nil
+}
+
+func response(tag uint16, rc uint32, params []byte) []byte {
+	resp := make([]byte, 10)
+	binary.BigEndian.PutUint16(resp, tag)
+	binary.BigEndian.PutUint32(resp[2:], uint32(10+len(params)))
+	binary.BigEndian.PutUint32(resp[6:], rc)
+	return append(resp, params...)
+}
+
+func TestBanks(t *testing.T) {
+	params := []byte{
+		0,          // moreData
+		0, 0, 0, 5, // TPM_CAP_PCRS
+		0, 0, 0, 3, // count
+		0, 0x04, 3, 0, 0, 0, // SHA-1, unallocated
+		0, 0x0B, 3, 0xff, 0xff, 0xff, // SHA-256
+		0, 0x0C, 3, 0xff, 0xff, 0xff, // SHA-384
+	}
+
+	f := &fakeTPM{responses: [][]byte{response(0x8001, 0, params)}}
+
+	banks, err := tpm.New(f).Banks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(banks) != 2 || banks[0] != crypto.SHA256 || banks[1] != crypto.SHA384 {
+		t.Errorf("unexpected banks: %v", banks)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	f := &fakeTPM{responses: [][]byte{
+		response(0x8002, 0, []byte{0, 0, 0, 0, 0, 0, 1, 0, 0}),
+		response(0x8001, 0x0184, nil),
+	}}
+
+	digests, err := tpm.Digests([]crypto.Hash{crypto.SHA256}, strings.NewReader("matchstick"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256([]byte("matchstick"))
+	if !bytes.Equal(digests[crypto.SHA256], want[:]) {
+		t.Fatalf("unexpected digest: %x", digests[crypto.SHA256])
+	}
+
+	tp := tpm.New(f)
+	if err := tp.Extend(15, digests); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := hex.EncodeToString(f.commands[0])
+	wantCmd := "8002" + "00000041" + "00000182" + "0000000f" +
+		"00000009" + "40000009" + "0000" + "00" + "0000" +
+		"00000001" + "000b" + hex.EncodeToString(want[:])
+	if cmd != wantCmd {
+		t.Errorf("command = %s, want %s", cmd, wantCmd)
+	}
+
+	if err := tp.Extend(15, digests); err == nil {
+		t.Error("expected an error for a TPM error response")
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package trace wraps system calls that modify the system, logging each of
+// them (and their result) at debug level to make field debugging possible.
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mountFlag is the name of a mount flag.
+type mountFlag struct {
+	flag uintptr
+	name string
+}
+
+// MountFlags formats mount flags symbolically (eg. "MS_NOSUID|MS_NODEV"),
+// any unknown flags are printed in hex.
+func MountFlags(flags uintptr) string {
+	if flags == 0 {
+		return "0"
+	}
+
+	var names []string
+	for _, f := range mountFlags {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+
+	if flags != 0 {
+		names = append(names, "0x"+strconv.FormatUint(uint64(flags), 16))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// ParseMountFlags parses mount flags formatted by MountFlags (an empty string
+// is zero).
+func ParseMountFlags(s string) (uintptr, error) {
+	var flags uintptr
+	if s == "" || s == "0" {
+		return flags, nil
+	}
+
+names:
+	for _, name := range strings.Split(s, "|") {
+		for _, f := range mountFlags {
+			if f.name == name {
+				flags |= f.flag
+				continue names
+			}
+		}
+
+		hex, ok := strings.CutPrefix(name, "0x")
+		if !ok {
+			return 0, fmt.Errorf("unknown mount flag %q", name)
+		}
+
+		v, err := strconv.ParseUint(hex, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid mount flags %q: %w", name, err)
+		}
+
+		flags |= uintptr(v)
+	}
+
+	return flags, nil
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trace
+
+import (
+	"log/slog"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mount calls mount(2), logging the call and its result.
+func Mount(source, target, fstype string, flags uintptr, data string) error {
+	err := unix.Mount(source, target, fstype, flags, data)
+
+	slog.Debug("mount(2)",
+		slog.String("source", source),
+		slog.String("target", target),
+		slog.String("fstype", fstype),
+		slog.String("flags", MountFlags(flags)),
+		slog.String("data", data),
+		slog.Any("error", err))
+
+	return err
+}
+
+// Exec calls execve(2), logging the call (it only returns on failure).
+func Exec(argv0 string, argv, envv []string) error {
+	slog.Debug("execve(2)", slog.String("path", argv0), slog.Any("argv", argv), slog.Int("envc", len(envv)))
+
+	err := unix.Exec(argv0, argv, envv)
+
+	slog.Debug("execve(2) failed", slog.String("path", argv0), slog.Any("error", err))
+
+	return err
+}
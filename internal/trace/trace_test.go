@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trace_test
+
+import (
+	"testing"
+
+	"github.com/immutos/matchstick/internal/trace"
+	"golang.org/x/sys/unix"
+)
+
+func TestMountFlags(t *testing.T) {
+	for flags, expected := range map[uintptr]string{
+		0:                              "0",
+		unix.MS_NOSUID | unix.MS_NODEV: "MS_NOSUID|MS_NODEV",
+		unix.MS_BIND | unix.MS_REC:     "MS_BIND|MS_REC",
+		unix.MS_RDONLY | 1<<40:         "MS_RDONLY|0x10000000000",
+	} {
+		if got := trace.MountFlags(flags); got != expected {
+			t.Errorf("expected %#x to format as %q, got %q", flags, expected, got)
+		}
+
+		if got, err := trace.ParseMountFlags(expected); err != nil || got != flags {
+			t.Errorf("ParseMountFlags(%q) = %#x, %v, want %#x", expected, got, err, flags)
+		}
+	}
+
+	if _, err := trace.ParseMountFlags("MS_FROBNICATE"); err == nil {
+		t.Error("expected error for an unknown flag")
+	}
+}
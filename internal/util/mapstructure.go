@@ -48,3 +48,42 @@ func StringToBooleanHookFunc() mapstructure.DecodeHookFunc {
 		return false, fmt.Errorf("invalid boolean value: %q", data)
 	}
 }
+
+// StringToSliceHookFunc splits strings into slices on sep. Unlike the
+// mapstructure equivalent, separators (and backslashes) can be escaped with a
+// backslash so that they may appear within an element.
+func StringToSliceHookFunc(sep rune) mapstructure.DecodeHookFunc {
+	return func(f, t reflect.Type, data any) (interface{}, error) {
+		if f.Kind() != reflect.String || t.Kind() != reflect.Slice {
+			return data, nil
+		}
+
+		return SplitEscaped(data.(string), sep), nil
+	}
+}
+
+// SplitEscaped splits s on every unescaped occurrence of sep.
+func SplitEscaped(s string, sep rune) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	var elems []string
+	var elem strings.Builder
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; {
+		case c == '\\' && i+1 < len(runes) && (runes[i+1] == sep || runes[i+1] == '\\'):
+			elem.WriteRune(runes[i+1])
+			i++
+		case c == sep:
+			elems = append(elems, elem.String())
+			elem.Reset()
+		default:
+			elem.WriteRune(c)
+		}
+	}
+
+	return append(elems, elem.String())
+}
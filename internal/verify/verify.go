@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package verify checks files (eg. the init binary) against pinned digests
+// and detached signatures, so tampered files are never executed.
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffix is appended to the path of a file to find its detached
+// signature.
+const SignatureSuffix = ".sig"
+
+// Digest verifies that the SHA-256 digest of the file at path matches the
+// given hex encoded digest.
+func Digest(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s digest mismatch: got %s, want %s", path, got, want)
+	}
+
+	return nil
+}
+
+// Signature verifies the file at path against its detached (base64 encoded)
+// ed25519 signature, using the given (base64 encoded) public key.
+func Signature(path, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	sig, err := os.ReadFile(path + SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+
+	rawSig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), data, rawSig) {
+		return fmt.Errorf("%s signature verification failed", path)
+	}
+
+	return nil
+}
+
+// Manifest verifies each of the files listed in a sha256sum style manifest
+// (lines of "<digest>  <path>"), with paths relative to root.
+func Manifest(root, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var errs []error
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		digest, file, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("%s:%d: malformed line", path, n)
+		}
+
+		// sha256sum marks binary mode files with a "*".
+		file = strings.TrimPrefix(strings.TrimLeft(file, " "), "*")
+
+		if err := Digest(filepath.Join(root, file), digest); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package verify_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/verify"
+)
+
+func TestDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "init")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("#!/bin/sh\n"))
+	if err := verify.Digest(path, hex.EncodeToString(sum[:])); err != nil {
+		t.Error(err)
+	}
+
+	if err := verify.Digest(path, hex.EncodeToString(make([]byte, 32))); err == nil {
+		t.Error("expected a digest mismatch")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "init")
+	data := []byte("#!/bin/sh\n")
+
+	if err := os.WriteFile(path, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	if err := verify.Signature(path, key); err == nil {
+		t.Error("expected an error without a signature")
+	}
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	if err := os.WriteFile(path+".sig", []byte(sig+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify.Signature(path, key); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(path, []byte("#!/bin/bash\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify.Signature(path, key); err == nil {
+		t.Error("expected verification of a modified file to fail")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "usr/bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{"usr/bin/app": "app", "etc-default": "config"}
+
+	manifest := "# critical files\n"
+	for file, contents := range files {
+		if err := os.WriteFile(filepath.Join(root, file), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256([]byte(contents))
+		manifest += hex.EncodeToString(sum[:]) + " */" + file + "\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "manifest")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify.Manifest(root, path); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "usr/bin/app"), []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify.Manifest(root, path); err == nil {
+		t.Error("expected verification of a tampered file to fail")
+	}
+}
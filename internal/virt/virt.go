@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package virt detects whether matchstick is running in a container, without
+// relying on external tools (eg. systemd-detect-virt) that may be missing
+// from minimal images.
+package virt
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cgroupMarkers are cgroup path components used by container managers.
+var cgroupMarkers = []string{"docker", "lxc", "kubepods", "containerd", "libpod", "crio", "garden"}
+
+// DetectContainer returns true (and the reason) if running in a container.
+// root is the root of the filesystem (normally "/"), and environ the
+// environment of the process.
+//
+// Detection follows systemd's container interface: the container manager
+// sets the "container" environment variable (or /run/systemd/container).
+// Others are detected by the cgroup the process is in, and Docker and Podman
+// by the marker files they create. As the marker files are left in images
+// exported from a container, they are only trusted outside of the initial PID
+// namespace (which requires /proc to be mounted).
+func DetectContainer(root string, environ []string) (string, bool) {
+	for _, kv := range environ {
+		if value, ok := strings.CutPrefix(kv, "container="); ok && value != "" {
+			return "container=" + value, true
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "run/systemd/container")); err == nil {
+		if value := strings.TrimSpace(string(data)); value != "" {
+			return "/run/systemd/container=" + value, true
+		}
+	}
+
+	if marker, ok := cgroupMarker(filepath.Join(root, "proc/self/cgroup")); ok {
+		return "cgroup " + marker, true
+	}
+
+	if initialPIDNamespace(root) {
+		return "", false
+	}
+
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(filepath.Join(root, marker)); err == nil {
+			return marker, true
+		}
+	}
+
+	return "", false
+}
+
+// initialPIDNamespace returns true if the process is (or may be) in the
+// initial PID namespace, where kthreadd is always PID 2. Kernel threads are
+// never visible within a container's PID namespace.
+func initialPIDNamespace(root string) bool {
+	if _, err := os.Stat(filepath.Join(root, "proc/self")); err != nil {
+		// Without /proc, it's impossible to tell.
+		return true
+	}
+
+	comm, err := os.ReadFile(filepath.Join(root, "proc/2/comm"))
+	return err == nil && strings.TrimSpace(string(comm)) == "kthreadd"
+}
+
+// cgroupMarker looks for container manager cgroups, eg. "/docker/<id>". With
+// cgroup namespaces the cgroup is simply "/", so this is a last resort.
+func cgroupMarker(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are of the form "hierarchy-ID:controller-list:cgroup-path".
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		for _, component := range strings.Split(fields[2], "/") {
+			for _, marker := range cgroupMarkers {
+				if strings.HasPrefix(component, marker) {
+					return fields[2], true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
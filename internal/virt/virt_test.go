@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package virt_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immutos/matchstick/internal/virt"
+)
+
+func TestDetectContainer(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "proc/self"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cgroup := filepath.Join(root, "proc/self/cgroup")
+	if err := os.WriteFile(cgroup, []byte("0::/init.scope\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, ok := virt.DetectContainer(root, []string{"HOME=/", "TERM=linux"}); ok {
+		t.Errorf("unexpectedly detected container: %s", reason)
+	}
+
+	if reason, ok := virt.DetectContainer(root, []string{"container=lxc"}); !ok || reason != "container=lxc" {
+		t.Errorf("DetectContainer() = %q, %v, want container=lxc", reason, ok)
+	}
+
+	if err := os.WriteFile(cgroup, []byte("12:pids:/docker/0123abcd\n0::/docker/0123abcd\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, ok := virt.DetectContainer(root, nil); !ok || reason != "cgroup /docker/0123abcd" {
+		t.Errorf("DetectContainer() = %q, %v, want cgroup", reason, ok)
+	}
+
+	if err := os.WriteFile(cgroup, []byte("0::/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, ".dockerenv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, ok := virt.DetectContainer(root, nil); !ok || reason != "/.dockerenv" {
+		t.Errorf("DetectContainer() = %q, %v, want /.dockerenv", reason, ok)
+	}
+
+	// In the initial PID namespace, the marker was left in an exported image.
+	if err := os.MkdirAll(filepath.Join(root, "proc/2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "proc/2/comm"), []byte("kthreadd\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, ok := virt.DetectContainer(root, nil); ok {
+		t.Errorf("unexpectedly detected container: %s", reason)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package watchdog arms a hardware watchdog while the system is being set
+// up, so that a hung boot resets the machine rather than leaving it dead.
+package watchdog
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultDevice is the default watchdog device.
+const DefaultDevice = "/dev/watchdog"
+
+// Watchdog is an open (and hence armed) watchdog device.
+type Watchdog struct {
+	f       *os.File
+	timeout time.Duration
+	ping    func() error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newWatchdog(f *os.File, timeout time.Duration, ping func() error) *Watchdog {
+	return &Watchdog{
+		f:       f,
+		timeout: timeout,
+		ping:    ping,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Timeout returns the watchdog's timeout.
+func (w *Watchdog) Timeout() time.Duration {
+	return w.timeout
+}
+
+// Start pets the watchdog (at half its timeout) until limit has elapsed or
+// the watchdog is stopped. Once the limit elapses, the machine will be reset
+// unless the watchdog is handed off (or disarmed) in time.
+func (w *Watchdog) Start(limit time.Duration) {
+	deadline := time.Now().Add(limit)
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.timeout / 2)
+		defer ticker.Stop()
+
+		for {
+			if err := w.ping(); err != nil {
+				slog.Warn("Failed to pet watchdog", slog.Any("error", err))
+			}
+
+			select {
+			case <-w.stop:
+				return
+			case now := <-ticker.C:
+				if limit > 0 && now.After(deadline) {
+					slog.Error("Setup exceeded the watchdog limit, no longer petting the watchdog",
+						slog.Duration("limit", limit))
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Handoff pets the watchdog a final time and closes it without disarming it,
+// so init must open the device (eg. systemd's RuntimeWatchdogSec=) within the
+// timeout.
+func (w *Watchdog) Handoff() error {
+	w.stopPetting()
+
+	if err := w.ping(); err != nil {
+		return err
+	}
+
+	return w.f.Close()
+}
+
+// Disarm stops the watchdog, using the "magic close" feature.
+func (w *Watchdog) Disarm() error {
+	w.stopPetting()
+
+	if _, err := w.f.Write([]byte("V")); err != nil {
+		return fmt.Errorf("failed to disarm watchdog: %w", err)
+	}
+
+	return w.f.Close()
+}
+
+func (w *Watchdog) stopPetting() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package watchdog
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open opens (and so arms) the watchdog device. If timeout is non-zero, the
+// watchdog's timeout is set to it (the device may round it).
+func Open(path string, timeout time.Duration) (*Watchdog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := int(f.Fd())
+
+	if timeout > 0 {
+		if err := unix.IoctlSetPointerInt(fd, unix.WDIOC_SETTIMEOUT, int(timeout.Seconds())); err != nil {
+			slog.Warn("Failed to set watchdog timeout", slog.Duration("timeout", timeout), slog.Any("error", err))
+		}
+	}
+
+	secs, err := unix.IoctlGetInt(fd, unix.WDIOC_GETTIMEOUT)
+	if err != nil || secs <= 0 {
+		// Assume the (conservative) default of most drivers.
+		secs = 30
+	}
+
+	return newWatchdog(f, time.Duration(secs)*time.Second, func() error {
+		_, err := unix.IoctlGetInt(fd, unix.WDIOC_KEEPALIVE)
+		return err
+	}), nil
+}
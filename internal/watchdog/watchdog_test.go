@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchdog")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pings atomic.Int32
+	w := newWatchdog(f, 20*time.Millisecond, func() error {
+		pings.Add(1)
+		return nil
+	})
+
+	w.Start(0)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := w.Disarm(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pings.Load(); n < 3 {
+		t.Errorf("expected the watchdog to be petted periodically, got %d pings", n)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "V" {
+		t.Errorf("expected the magic close character, got %q", data)
+	}
+}
+
+func TestWatchdogLimit(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "watchdog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pings atomic.Int32
+	w := newWatchdog(f, 20*time.Millisecond, func() error {
+		pings.Add(1)
+		return nil
+	})
+
+	w.Start(30 * time.Millisecond)
+
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected petting to stop once the limit elapsed")
+	}
+
+	before := pings.Load()
+
+	if err := w.Handoff(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pings.Load() != before+1 {
+		t.Error("expected a final ping on handoff")
+	}
+}
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/emergency"
+	"github.com/immutos/matchstick/internal/kmsg"
+	"github.com/immutos/matchstick/internal/logging"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// earlyLogPath is where buffered early log records are written.
+const earlyLogPath = "/run/matchstick/early.log"
+
+// earlyLogSize is the maximum number of early log records buffered.
+const earlyLogSize = 512
+
+var (
+	// logLevel is the minimum level of log records.
+	logLevel = new(slog.LevelVar)
+	// handlerOpts are the options shared by all log handlers.
+	handlerOpts = &slog.HandlerOptions{
+		Level: logLevel,
+	}
+	// logFormat is the format of records written to streams (eg. stderr).
+	logFormat = logging.FormatText
+	// logFiles are the open log destinations (other than stderr).
+	logFiles []*os.File
+	// primaryHandler writes to the kernel log, or stderr if unavailable.
+	primaryHandler slog.Handler
+	// kmsgAvailable is true if primaryHandler writes to the kernel log.
+	kmsgAvailable bool
+	// logHandlers are the additional handlers records are fanned out to.
+	logHandlers []slog.Handler
+	// earlyLogs buffers records until they have been flushed.
+	earlyLogs = logging.NewRing(earlyLogSize)
+	// redactor masks sensitive values before records reach any handler.
+	redactor = logging.NewRedactor()
+)
+
+// setupLogging logs to the kernel log if available, otherwise to stderr.
+// Records are also buffered until flushEarlyLogs is called.
+func setupLogging() {
+	if !openKmsg() {
+		primaryHandler = logging.NewHandler(os.Stderr, logFormat, handlerOpts)
+	}
+
+	updateLogger()
+}
+
+// openKmsg switches the primary handler to the kernel log, returning false
+// if it is unavailable.
+func openKmsg() bool {
+	f, err := os.OpenFile("/dev/kmsg", os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+
+	logFiles = append(logFiles, f)
+	primaryHandler = kmsg.NewKmsgHandler(f, &kmsg.Options{
+		HandlerOptions: *handlerOpts,
+		Prefix:         "matchstick: ",
+	})
+	kmsgAvailable = true
+
+	return true
+}
+
+// retryKmsg attempts to log to the kernel log, if it was unavailable when
+// logging was set up (eg. because /dev wasn't mounted yet). Buffered records
+// are replayed to it.
+func retryKmsg() {
+	if kmsgAvailable || earlyLogs == nil || !openKmsg() {
+		return
+	}
+
+	if err := earlyLogs.Replay(context.Background(), primaryHandler); err != nil {
+		slog.Warn("Failed to replay early logs to the kernel log", slog.Any("error", err))
+	}
+
+	updateLogger()
+}
+
+// flushEarlyLogs writes the buffered records to the runtime directory (where
+// they are available after init starts), and stops buffering.
+func flushEarlyLogs() {
+	if earlyLogs == nil {
+		return
+	}
+
+	ring := earlyLogs
+	earlyLogs = nil
+	updateLogger()
+
+	if dropped := ring.Dropped(); dropped > 0 {
+		slog.Warn("Early log records were dropped", slog.Int("dropped", dropped))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(earlyLogPath), 0o755); err != nil {
+		slog.Warn("Failed to write early logs", slog.String("path", earlyLogPath), slog.Any("error", err))
+		return
+	}
+
+	f, err := os.OpenFile(earlyLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		slog.Warn("Failed to write early logs", slog.String("path", earlyLogPath), slog.Any("error", err))
+		return
+	}
+	defer f.Close()
+
+	if err := ring.Replay(context.Background(), logging.NewHandler(f, logFormat, handlerOpts)); err != nil {
+		slog.Warn("Failed to write early logs", slog.String("path", earlyLogPath), slog.Any("error", err))
+	}
+}
+
+// configureLogging applies the logging options, once they have been decoded.
+func configureLogging(opts *config.Options) {
+	redactor.AddNames(opts.Redact...)
+
+	if opts.Debug {
+		logLevel.Set(slog.LevelDebug)
+	} else if opts.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(opts.LogLevel)); err != nil {
+			slog.Warn("Invalid log level, using info", slog.Any("error", err))
+		} else {
+			logLevel.Set(level)
+		}
+	}
+
+	format, err := logging.ParseFormat(opts.LogFormat)
+	if err != nil {
+		slog.Warn("Invalid log format, using text", slog.Any("error", err))
+	} else if format != logFormat {
+		logFormat = format
+
+		// Switch the stderr handler (if any) to the new format.
+		if !kmsgAvailable {
+			primaryHandler = logging.NewHandler(os.Stderr, logFormat, handlerOpts)
+			updateLogger()
+		}
+	}
+
+	if opts.LogConsole {
+		addLogDestination(emergency.DefaultConsole, os.O_WRONLY|unix.O_NOCTTY)
+	}
+
+	if opts.LogSerial != "" && opts.LogSerial != emergency.DefaultConsole {
+		addLogDestination(opts.LogSerial, os.O_WRONLY|unix.O_NOCTTY)
+	}
+}
+
+// logToDataFile additionally logs to a file on the data filesystem (once it
+// has been mounted).
+func logToDataFile(opts *config.Options) {
+	if opts.LogFile == "" {
+		return
+	}
+
+	// The path must be within the data filesystem.
+	if !filepath.IsLocal(opts.LogFile) {
+		slog.Warn("Ignoring log file outside of the data filesystem", slog.String("path", opts.LogFile))
+		return
+	}
+
+	path := filepath.Join(opts.Mount, opts.LogFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		slog.Warn("Failed to create log file directory", slog.String("path", path), slog.Any("error", err))
+		return
+	}
+
+	addLogDestination(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+// addLogDestination opens path and adds it as a log destination.
+func addLogDestination(path string, flag int) {
+	f, err := os.OpenFile(path, flag, 0o640)
+	if err != nil {
+		slog.Warn("Failed to open log destination", slog.String("path", path), slog.Any("error", err))
+		return
+	}
+
+	logFiles = append(logFiles, f)
+	addLogHandler(logging.NewHandler(f, logFormat, handlerOpts))
+
+	slog.Debug("Added log destination", slog.String("path", path))
+}
+
+// addLogHandler adds a handler to the default logger.
+func addLogHandler(h slog.Handler) {
+	logHandlers = append(logHandlers, h)
+	updateLogger()
+}
+
+// updateLogger sets the default logger to fan out records to each of the
+// configured handlers.
+func updateLogger() {
+	handlers := append([]slog.Handler{primaryHandler}, logHandlers...)
+	if earlyLogs != nil {
+		handlers = append(handlers, earlyLogs.Handler(handlerOpts.Level))
+	}
+
+	// Redact records before they are fanned out (or buffered), so that
+	// secrets never reach any destination.
+	if len(handlers) == 1 {
+		slog.SetDefault(slog.New(logging.NewRedactHandler(handlers[0], redactor)))
+	} else {
+		slog.SetDefault(slog.New(logging.NewRedactHandler(logging.NewMultiHandler(handlers...), redactor)))
+	}
+}
+
+// closeLogging flushes and closes the log destinations.
+func closeLogging() {
+	for _, f := range logFiles {
+		_ = f.Sync()
+		_ = f.Close()
+	}
+}
+
+// redactOptions returns a copy of options with the values of secret-bearing
+// options (and any secrets embedded in other values) masked, as the reports
+// written by matchstick outlive the boot.
+func redactOptions(options map[string]any) map[string]any {
+	redacted := make(map[string]any, len(options))
+	for name, value := range options {
+		if redactor.IsSecret(name) {
+			redacted[name] = logging.Redacted
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			// Eg. a token in the query of config_url.
+			value = redactor.String(v)
+		case []string:
+			// Eg. credentials in the options of a mount.
+			elems := make([]string, len(v))
+			for i, s := range v {
+				elems[i] = redactor.String(s)
+			}
+
+			value = elems
+		}
+
+		redacted[name] = value
+	}
+
+	return redacted
+}
@@ -19,225 +19,475 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
-
-	"github.com/immutos/matchstick/internal/cmdline"
-	"github.com/immutos/matchstick/internal/kmsg"
-	"github.com/immutos/matchstick/internal/util"
-	"github.com/mitchellh/mapstructure"
-	"github.com/spf13/pflag"
+	"time"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/fstrim"
+	"github.com/immutos/matchstick/internal/hooks"
+	"github.com/immutos/matchstick/internal/netconf"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/provision"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/switchroot"
+	"github.com/immutos/matchstick/internal/tmpfiles"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"github.com/immutos/matchstick/pkg/storage"
 	"golang.org/x/sys/unix"
 )
 
-const optionsPrefix = "matchstick"
-
-type Options struct {
-	// Data is the device to which write operations will be redirected.
-	Data string `cmdline:"data"`
-	// DataFSType is the filesystem type of the data device.
-	DataFSType string `cmdline:"datafstype"`
-	// The mountpoint to be used for the data filesystem.
-	Mount string `cmdline:"mount"`
-	// Dirs is a list of directories to overlay on top of the data filesystem.
-	Dirs []string `cmdline:"dirs"`
-	// Cmd is the init process to be executed after the filesystem has been setup.
-	Cmd string `cmdline:"cmd"`
-	// Volatile specifies whether the data filesystem should be volatile.
-	Volatile bool `cmdline:"volatile"`
-}
-
 func main() {
-	handlerOpts := &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}
+	tracker := stage.NewTracker(time.Now())
+
+	setupLogging()
+	defer closeLogging()
+
+	// Are we being invoked as a subcommand? As PID 1, the arguments are from
+	// the kernel command line (eg. a bare "check"), and are passed to init.
+	if len(os.Args) > 1 && os.Getpid() != 1 {
+		switch os.Args[1] {
+		case "scrub":
+			if err := runScrub(os.Args[2:]); err != nil {
+				slog.Error("Failed to scrub data filesystem", slog.Any("error", err))
+				os.Exit(1)
+			}
 
-	// Log to the kernel log (if available).
-	if f, err := os.OpenFile("/dev/kmsg", os.O_WRONLY, 0); err == nil {
-		defer func() {
-			_ = f.Sync()
-			_ = f.Close()
-		}()
+			return
+		case "bless":
+			if err := runBless(os.Args[2:]); err != nil {
+				slog.Error("Failed to mark boot as successful", slog.Any("error", err))
+				os.Exit(1)
+			}
 
-		slog.SetDefault(slog.New(kmsg.NewKmsgHandler(f, handlerOpts)).WithGroup("matchstick"))
-	} else {
-		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)))
-	}
+			return
+		case "trim":
+			if err := runTrim(os.Args[2:]); err != nil {
+				slog.Error("Failed to trim data filesystem", slog.Any("error", err))
+				os.Exit(1)
+			}
 
-	// Are we running in a container?
-	container := runningInContainer()
+			return
+		case "check":
+			if err := runCheck(os.Args[2:]); err != nil {
+				slog.Error("Check failed", slog.Any("error", err))
+				os.Exit(1)
+			}
 
-	var fs pflag.FlagSet
-	fs.Init(os.Args[0], pflag.ContinueOnError)
+			return
+		}
+	}
 
-	var opts Options
-	fs.StringVar(&opts.Data, "data", "", "The device to which write operations will be redirected")
-	fs.StringVar(&opts.DataFSType, "datafstype", "", "The filesystem type of the data device")
-	fs.StringVar(&opts.Mount, "mount", "/mnt/data", "The mountpoint to be used for the data filesystem")
-	fs.StringSliceVar(&opts.Dirs, "dirs", []string{"/etc", "/home", "/root", "/srv", "/var"},
-		"A list of directories to overlay on top of the data filesystem")
-	fs.StringVar(&opts.Cmd, "cmd", "/lib/systemd/systemd",
-		"The init process to be executed after the filesystem has been setup")
-	fs.BoolVar(&opts.Volatile, "volatile", false, "Whether the data filesystem should be volatile")
+	var opts config.Options
+	fs := config.NewFlagSet(os.Args[0], &opts)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
-		slog.Error("Failed to parse command line", slog.Any("error", err))
-		os.Exit(1)
+		fatal("Failed to parse command line", slog.Any("error", err))
 	}
 
-	if !container {
-		// Mount the /proc filesystem (so that we can read the kernel command line).
-		if _, err := os.Stat("/proc/cmdline"); os.IsNotExist(err) {
-			slog.Info("Mounting /proc")
+	ignoreDryRun(&opts)
 
-			if err := unix.Mount("proc", "/proc", "proc", 0, ""); err != nil {
-				slog.Error("Failed to mount /proc", slog.Any("error", err))
-				os.Exit(1)
-			}
+	// Are we running in a container? As PID 1, /proc may be yet to be
+	// mounted.
+	if os.Getpid() == 1 {
+		mountProc()
+	}
+
+	container := runningInContainer(&opts, fs)
+
+	if !container && !opts.DryRun {
+		// Mount the API filesystems (eg. /proc so that we can read the kernel
+		// command line, and /dev for the data device).
+		if err := mountEarly(); err != nil {
+			fatal("Failed to mount early filesystems", slog.Any("error", err))
 		}
 
-		// Make sure the overlay filesystem module is loaded (if necessary).
-		if err := modprobe("overlay"); err != nil {
-			slog.Warn("Failed to load overlay fs module", slog.Any("error", err))
-			// Maybe it's compiled into the kernel?
+		retryKmsg()
+	}
+
+	// In lockdown, only the (signed) trusted config is honored.
+	lockdown = lockedDown(container)
+
+	var violations []string
+	err := tracker.Run(context.Background(), "options", 0, func(ctx context.Context) (err error) {
+		if lockdown {
+			violations, err = decodeTrustedOptions(&opts, fs)
+			return err
 		}
 
-		// Parse the kernel command line.
-		cl := cmdline.NewCmdLine()
-		if cl.Err != nil {
-			slog.Error("Error reading /proc/cmdline", slog.Any("error", cl.Err))
-			os.Exit(1)
+		return config.Load(&opts, container)
+	})
+	if err != nil {
+		fatal("Failed to decode options", slog.Any("error", err))
+	}
+
+	configureLogging(&opts)
+	setFailureOptions(&opts)
+	ignoreDryRun(&opts)
+
+	var setupTimeout *time.Timer
+	if opts.Timeout > 0 && !opts.DryRun {
+		setupTimeout = time.AfterFunc(opts.Timeout, func() {
+			fatal("Boot setup exceeded the global timeout", slog.Duration("timeout", opts.Timeout))
+		})
+	}
+
+	if lockdown {
+		enforceLockdown(&opts, violations)
+	}
+
+	// The arguments passed through to init.
+	args := passthroughArgs(&opts, fs)
+	failureArgs = args
+
+	slog.Debug("Resolved options", slog.Any("options", &opts))
+
+	// Arm the watchdog (if configured) so that a hung setup resets the
+	// machine.
+	if opts.Watchdog != "" && !container && !opts.DryRun {
+		armWatchdog(&opts)
+	}
+
+	var lease *netconf.Lease
+
+	// Make sure the overlay, data filesystem (and network) modules are loaded
+	// (if necessary).
+	if !container && !opts.DryRun {
+		// A sane clock is needed for TLS (and meaningful timestamps).
+		if opts.Clock {
+			sanitizeClock(tracker)
 		}
 
-		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-			Result:           &opts,
-			TagName:          "cmdline",
-			WeaklyTypedInput: true,
-			DecodeHook: mapstructure.ComposeDecodeHookFunc(
-				mapstructure.StringToSliceHookFunc(","),
-				util.StringToBooleanHookFunc(),
-			),
-			MatchName: func(mapKey, fieldName string) bool {
-				return strings.EqualFold(strings.TrimPrefix(strings.ReplaceAll(mapKey, "-", "_"), optionsPrefix+"."), fieldName)
-			},
+		loadModules(tracker, &opts)
+
+		// Bring up the network (if configured) for network-backed data
+		// filesystems and remote config fetching.
+		lease = setupNetwork(tracker, &opts)
+	}
+
+	var provisionConf *provision.Config
+	if opts.ConfigURL != "" && !container {
+		slog.Info("Fetching provisioning config", slog.String("url", opts.ConfigURL))
+
+		err := tracker.Run(context.Background(), "provision", 0, func(ctx context.Context) (err error) {
+			provisionConf, err = provision.Fetch(ctx, opts.ConfigURL, provision.Verification{
+				SHA256:    opts.ConfigSHA256,
+				PublicKey: opts.ConfigPublicKey,
+			})
+			return err
 		})
 		if err != nil {
-			slog.Error("Error creating decoder", slog.Any("error", err))
-			os.Exit(1)
+			degrade("Failed to fetch provisioning config", slog.Any("error", err))
+		} else {
+			opts.Dirs = append(opts.Dirs, provisionConf.Dirs...)
 		}
+	}
 
-		if err := decoder.Decode(cl.AsMap); err != nil {
-			slog.Error("Error decoding command line", slog.Any("error", err))
-			os.Exit(1)
+	name := resolveHostname(&opts, provisionConf, lease)
+	if name != "" && !container && !opts.DryRun {
+		setHostname(name)
+	}
+
+	if opts.DryRun {
+		// The real root filesystem isn't mounted, so the dirs file is read
+		// from the current root.
+		if err := readDirsFile(&opts, "/"); err != nil {
+			fatal("Failed to read dirs file", slog.Any("error", err))
+		}
+
+		var p *plan.Plan
+		if container {
+			p, err = containerPlan(&opts, args)
+		} else {
+			p, err = plan.New(&opts, args)
+		}
+		if err != nil {
+			fatal("Failed to compute plan", slog.Any("error", err))
+		}
+
+		if container && !opts.ContainerOverlays {
+			p = &plan.Plan{Argv: p.Argv}
 		}
+
+		if err := p.Print(os.Stdout); err != nil {
+			fatal("Failed to print plan", slog.Any("error", err))
+		}
+
+		return
 	}
 
-	// If we're running in a container, we should immediately pass control to the init process.
+	// If we're running in a container, we should immediately pass control to the
+	// init process (once the overlays have been set up, if enabled).
 	if container {
+		if err := readDirsFile(&opts, "/"); err != nil {
+			fatal("Failed to read dirs file", slog.Any("error", err))
+		}
+
+		var skipped []bootreport.Skipped
+		if opts.ContainerOverlays {
+			skipped = containerOverlays(tracker, &opts, args)
+		}
+
+		resolveInit(&opts)
+		verifyInit(tracker, &opts)
+
 		slog.Info("Running in a container, passing control to init", slog.Any("cmd", opts.Cmd))
 
-		argv := []string{opts.Cmd}
-		argv = append(argv, os.Args[1:]...)
+		argv := plan.Argv(&opts, args)
+
+		stopSetupTimeout(setupTimeout)
 
-		if err := unix.Exec(opts.Cmd, argv, os.Environ()); err != nil {
-			slog.Error("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
-			os.Exit(1)
+		tracker.Mark("exec")
+		writeReport(tracker, &bootreport.Report{Container: true, Options: redactOptions(opts.AsMap()), Skipped: skipped, Argv: argv})
+		flushEarlyLogs()
+
+		applyResources(&opts, container)
+
+		if opts.Supervise {
+			supervise(tracker, &opts, argv)
 		}
+
+		env := initEnviron(&opts)
+
+		execInit(&opts, argv, env)
+	}
+
+	report := &bootreport.Report{Devices: map[string]string{}}
+
+	// When running from an initramfs, the real root filesystem is mounted
+	// first, so that everything else can be set up within it.
+	if opts.Root != "" {
+		if !switchroot.IsInitramfs("/") {
+			slog.Warn("Not running from an initramfs, ignoring root", slog.String("root", opts.Root))
+
+			opts.Root = ""
+		} else if err := mountRoot(context.Background(), tracker, &opts); err != nil {
+			fatal("Failed to mount root filesystem", slog.Any("error", err))
+		} else {
+			report.Devices["root"] = storage.ResolveDevice(opts.Root)
+		}
+	}
+
+	// The dirs file is read from the image (rather than the initramfs).
+	dirsRoot := "/"
+	if opts.Root != "" {
+		dirsRoot = opts.NewRoot
+	}
+
+	if err := readDirsFile(&opts, dirsRoot); err != nil {
+		fatal("Failed to read dirs file", slog.Any("error", err))
+	}
+
+	p, err := plan.New(&opts, args)
+	if err != nil {
+		fatal("Failed to compute plan", slog.Any("error", err))
+	}
+
+	// Until switching to it, the data filesystem is mounted relative to the
+	// new root.
+	mount := opts.Mount
+	if p.Root != nil && p.Data != nil {
+		opts.Mount = p.Data.Target
+		setFailureOptions(&opts)
+	}
+
+	// finalRoot is where the root filesystem init will see is, before
+	// switching (or pivoting) to it.
+	var finalRoot string
+	if opts.OverlayRoot && !opts.Disable {
+		finalRoot = plan.RootOverlayDir
+	} else if p.Root != nil {
+		finalRoot = p.Root.Target
+	}
+
+	slog.Debug("Computed plan", slog.String("provider", p.Provider),
+		slog.Int("overlays", len(p.Overlays)), slog.Any("skipped", p.Skipped), slog.Any("argv", p.Argv))
+
+	for _, dir := range p.Skipped {
+		report.Skipped = append(report.Skipped, bootreport.Skipped{Dir: dir, Reason: "directory does not exist"})
 	}
 
 	// Mount the /tmp filesystem (if necessary).
 	if f, err := os.Create("/tmp/.matchstick"); err == nil {
+		slog.Debug("/tmp is writable, not mounting it")
+
 		_ = f.Close()
 		_ = os.Remove(f.Name())
 	} else {
 		slog.Info("Mounting /tmp")
 
-		if err := unix.Mount("tmpfs", "/tmp", "tmpfs", 0, ""); err != nil {
-			slog.Error("Failed to mount /tmp", slog.Any("error", err))
-			os.Exit(1)
+		if err := sys.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+			fatal("Failed to mount /tmp", slog.Any("error", err))
 		}
 	}
 
-	if opts.Volatile {
-		slog.Info("Using volatile data mount")
+	// imageRoot is where the (read-only) image is, until switching to it.
+	var imageRoot string
+	if p.Root != nil {
+		imageRoot = p.Root.Target
+	}
 
-		if err := unix.Mount("tmpfs", opts.Mount, "tmpfs", 0, ""); err != nil {
-			slog.Error("Failed to mount data mount", slog.Any("error", err))
-			os.Exit(1)
-		}
+	// Bind sealed secrets (and attestation) to the configuration in use,
+	// before anything from the image is run, or the data filesystem is
+	// unlocked.
+	if opts.MeasurePCR > 0 {
+		measure(tracker, &opts, imageRoot)
+	}
+
+	runHooks(tracker, &opts, hooks.PreMount, opts.PreMountHooks)
+
+	// In lockdown, the post-mount hooks and the tmpfiles configuration are
+	// taken from the image before the writable overlays are mounted, so that
+	// changes persisted to them (eg. in /etc) can't run as PID 1.
+	var postMountHooks []string
+	var tmpfilesEntries []tmpfiles.Entry
+	pinned := lockdown && !opts.Disable
+	if pinned {
+		postMountHooks, _ = stashHooks(&opts, hooks.PostMount, opts.PostMountHooks)
+		tmpfilesEntries = readTmpfiles(&opts, imageRoot)
+	}
+
+	dataMounted := p.Data != nil
+	if opts.Disable {
+		slog.Warn("Matchstick is disabled, booting the image without the data filesystem or overlays")
+	} else if opts.Volatile {
+		slog.Info("Using volatile data mount")
 	} else {
 		slog.Info("Using persistent data mount", slog.Any("device", opts.Data))
+	}
+
+	if dataMounted {
+		device, err := mountDataNextBoot(context.Background(), tracker, &opts, p)
+		if err != nil {
+			degrade("Failed to mount data mount", slog.Any("error", err))
+
+			// Without the data filesystem there is nothing to overlay.
+			for _, o := range p.Overlays {
+				report.Skipped = append(report.Skipped, bootreport.Skipped{Dir: o.Dir, Reason: "data filesystem unavailable"})
+			}
+
+			dataMounted = false
+			p.Overlays = nil
+		} else {
+			report.Devices["data"] = device
+		}
+
+		report.DataRecovery = string(dataRecovery)
+	}
 
-		if opts.Data == "" || opts.DataFSType == "" {
-			slog.Error("data and data_fs_type must be specified")
-			os.Exit(1)
+	if dataMounted && !opts.Volatile {
+		logToDataFile(&opts)
+
+		if opts.RandomSeed {
+			loadRandomSeed(&opts)
 		}
 
-		if err := unix.Mount(opts.Data, opts.Mount, opts.DataFSType, 0, ""); err != nil {
-			slog.Error("Failed to mount data mount", slog.Any("error", err))
-			os.Exit(1)
+		if opts.RollbackAfter > 0 {
+			report.RolledBack = checkHealth(&opts, p)
 		}
 	}
 
-	for _, dir := range opts.Dirs {
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			continue
+	if provisionConf != nil && dataMounted {
+		slog.Info("Seeding files from provisioning config")
+
+		if err := provisionConf.SeedFiles(p.Overlays); err != nil {
+			degrade("Failed to seed files", slog.Any("error", err))
 		}
+	}
 
-		slog.Info("Mounting overlay filesystem", slog.Any("dir", dir))
+	err = tracker.Run(context.Background(), "overlays", opts.MountTimeout, func(ctx context.Context) error {
+		report.Skipped = append(report.Skipped, mountOverlays(ctx, tracker, &opts, p, overlay.Kernel)...)
+		return nil
+	})
+	if err != nil {
+		degrade("Failed to mount overlays", slog.Any("error", err))
+	}
 
-		// Create the upper and work directories
-		upperDir := filepath.Join(opts.Mount, strings.TrimPrefix(dir, "/"))
-		if err := os.MkdirAll(upperDir, 0o755); err != nil {
-			slog.Error("Failed to create upperDir", slog.Any("dir", upperDir), slog.Any("error", err))
-			os.Exit(1)
+	mountExtras(context.Background(), tracker, &opts, p)
+
+	if !opts.Disable {
+		if !pinned {
+			tmpfilesEntries = readTmpfiles(&opts, finalRoot)
 		}
 
-		workDir := filepath.Join(opts.Mount, "."+strings.TrimPrefix(dir, "/")+"-work")
-		if err := os.MkdirAll(workDir, 0o755); err != nil {
-			slog.Error("Failed to create workDir", slog.Any("dir", workDir), slog.Any("error", err))
-			os.Exit(1)
+		applyTmpfiles(tracker, finalRoot, tmpfilesEntries)
+	}
+
+	if name != "" {
+		persistHostname(finalRoot, name)
+	}
+
+	if pinned {
+		runFoundHooks(tracker, &opts, hooks.PostMount, postMountHooks)
+	} else {
+		runHooks(tracker, &opts, hooks.PostMount, opts.PostMountHooks)
+	}
+
+	clearFailureCount(&opts, dataMounted)
+
+	if p.Root != nil {
+		switchRoot(tracker, &opts)
+
+		opts.Mount = mount
+		setFailureOptions(&opts)
+	}
+
+	if opts.OverlayRoot && dataMounted {
+		pivotRoot(tracker, &opts, p)
+	}
+
+	// From an initramfs, the root filesystem was mounted read-write.
+	if opts.Disable && opts.DisableRW && p.Root == nil {
+		remountWritable()
+	}
+
+	if opts.Scrub && !opts.Volatile && dataMounted {
+		slog.Debug("Starting background scrub", slog.Int64("rate", opts.ScrubRate))
+
+		if err := startScrub(&opts); err != nil {
+			slog.Warn("Failed to start background scrub", slog.Any("error", err))
 		}
+	}
 
-		// Mount the overlay filesystem
-		overlayOptions := "lowerdir=" + dir + ",workdir=" + workDir + ",upperdir=" + upperDir
-		if err := unix.Mount("overlay", dir, "overlay", 0, overlayOptions); err != nil {
-			slog.Error("Failed to mount overlay filesystem", slog.Any("dir", dir), slog.Any("error", err))
-			os.Exit(1)
+	if trim, _ := fstrim.ParsePolicy(opts.Trim); trim == fstrim.Boot && p.Provider == "block" && dataMounted {
+		if err := startTrim(&opts); err != nil {
+			slog.Warn("Failed to start background trim", slog.Any("error", err))
 		}
 	}
 
-	slog.Info("Executing init", slog.Any("cmd", opts.Cmd))
+	resolveInit(&opts)
+	verifyInit(tracker, &opts)
+
+	markBootAttempt(&opts)
 
-	argv := []string{opts.Cmd}
-	argv = append(argv, os.Args[1:]...)
+	applyResources(&opts, false)
 
-	if err := unix.Exec(opts.Cmd, argv, os.Environ()); err != nil {
-		slog.Error("Failed to exec init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
-		os.Exit(1)
+	report.Options = redactOptions(opts.AsMap())
+	report.Argv = plan.Argv(&opts, args)
+
+	stopSetupTimeout(setupTimeout)
+
+	tracker.Mark("exec")
+	writeReport(tracker, report)
+
+	if opts.AuditLog != "" && dataMounted && !opts.Volatile {
+		appendAudit(&opts, report)
 	}
-}
 
-// runningInContainer returns true if the process is running in a container.
-func runningInContainer() bool {
-	cmd := exec.Command("/usr/bin/systemd-detect-virt", "--container")
-	cmd.Stderr = os.Stderr
+	flushEarlyLogs()
 
-	out, err := cmd.Output()
-	if err != nil {
-		return false
+	// A supervised init is unlikely to pet the watchdog itself.
+	releaseWatchdog(opts.WatchdogHandoff && !opts.Supervise)
+
+	if opts.Supervise {
+		supervise(tracker, &opts, plan.Argv(&opts, args))
 	}
 
-	return strings.TrimSpace(string(out)) != "none"
-}
+	slog.Info("Executing init", slog.Any("cmd", opts.Cmd))
 
-func modprobe(module string) error {
-	cmd := exec.Command("/sbin/modprobe", module)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	env := initEnviron(&opts)
 
-	return cmd.Run()
+	execInit(&opts, plan.Argv(&opts, args), env)
 }
@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/cmdline"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/tpm"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// measurementsPath is where the measurements are logged, so they can be
+// replayed (eg. for remote attestation).
+const measurementsPath = "/run/matchstick/measurements.json"
+
+// osReleasePath is read for the image identity, if not otherwise known.
+const osReleasePath = "/etc/os-release"
+
+// measurement is a single measurement extended into a PCR.
+type measurement struct {
+	PCR     int               `json:"pcr"`
+	Event   string            `json:"event"`
+	Data    string            `json:"data,omitempty"`
+	Digests map[string]string `json:"digests"`
+}
+
+// measure extends the PCR with the effective configuration, the kernel command
+// line, the identity of the image, and the init binary (relative to root), so
+// that sealed secrets (and attestation) are bound to them. It must be called
+// before the data filesystem is unlocked (or mounted).
+func measure(tracker *stage.Tracker, opts *config.Options, root string) {
+	var measurements []measurement
+
+	// The init binary is measured from the image, but if it's overlaid the
+	// one executed may come from the data filesystem instead, so the event
+	// records which was measured.
+	initData := opts.Cmd
+	if initOverlaid(opts) {
+		initData = "image:" + opts.Cmd
+		slog.Warn("Init is overlaid, only the image's init binary is measured", slog.String("cmd", opts.Cmd))
+	}
+
+	err := tracker.Run(context.Background(), "measure", 0, func(ctx context.Context) error {
+		t, err := tpm.Open(opts.TPMDevice)
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+
+		banks, err := t.Banks()
+		if err != nil {
+			return fmt.Errorf("failed to get PCR banks: %w", err)
+		}
+
+		conf, err := json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+
+		initf, err := os.Open(filepath.Join(root, opts.Cmd))
+		if err != nil {
+			return err
+		}
+		defer initf.Close()
+
+		cl := cmdline.FullCmdLine()
+		identity := imageIdentity(opts, root)
+
+		for _, m := range []struct {
+			event string
+			data  string
+			r     io.Reader
+		}{
+			{event: "matchstick-config", r: bytes.NewReader(conf)},
+			{event: "matchstick-cmdline", r: strings.NewReader(cl)},
+			{event: "matchstick-image", data: identity, r: strings.NewReader(identity)},
+			{event: "matchstick-init", data: initData, r: initf},
+		} {
+			digests, err := tpm.Digests(banks, m.r)
+			if err != nil {
+				return fmt.Errorf("failed to measure %s: %w", m.event, err)
+			}
+
+			if err := t.Extend(opts.MeasurePCR, digests); err != nil {
+				return fmt.Errorf("failed to extend PCR %d: %w", opts.MeasurePCR, err)
+			}
+
+			measurements = append(measurements, measurement{
+				PCR:     opts.MeasurePCR,
+				Event:   m.event,
+				Data:    m.data,
+				Digests: hexDigests(digests),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		degrade("Failed to measure boot", slog.Int("pcr", opts.MeasurePCR), slog.Any("error", err))
+		return
+	}
+
+	slog.Info("Measured boot", slog.Int("pcr", opts.MeasurePCR), slog.Int("measurements", len(measurements)))
+
+	data, err := json.MarshalIndent(measurements, "", "  ")
+	if err == nil {
+		err = os.WriteFile(measurementsPath, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		slog.Warn("Failed to log measurements", slog.String("path", measurementsPath), slog.Any("error", err))
+	}
+}
+
+// initOverlaid returns true if the init binary is within an overlaid
+// directory (or the whole root filesystem is overlaid).
+func initOverlaid(opts *config.Options) bool {
+	if opts.OverlayRoot {
+		return true
+	}
+
+	for _, dir := range opts.Dirs {
+		if strings.HasPrefix(opts.Cmd, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imageIdentity returns the identity of the image being booted: the
+// configured image ID, the kernel's roothash= parameter (for dm-verity
+// images), or the IMAGE_ID and IMAGE_VERSION from os-release (relative to
+// root).
+func imageIdentity(opts *config.Options, root string) string {
+	if opts.ImageID != "" {
+		return opts.ImageID
+	}
+
+	if cl := cmdline.NewCmdLine(); cl.Err == nil {
+		for _, p := range cl.Params {
+			if p.Key == "roothash" {
+				return "roothash=" + p.Value
+			}
+		}
+	}
+
+	f, err := os.Open(filepath.Join(root, osReleasePath))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var fields []string
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if ok && (key == "IMAGE_ID" || key == "IMAGE_VERSION") {
+			fields = append(fields, key+"="+strings.Trim(value, `"'`))
+		}
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// hexDigests hex encodes digests, keyed by the name of their hash.
+func hexDigests(digests map[crypto.Hash][]byte) map[string]string {
+	m := make(map[string]string, len(digests))
+	for hash, digest := range digests {
+		m[strings.ToLower(strings.ReplaceAll(hash.String(), "-", ""))] = hex.EncodeToString(digest)
+	}
+
+	return m
+}
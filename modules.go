@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/modules"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// loadModules loads the kernel modules required to mount the real root
+// filesystem, the data filesystem and overlays. Failing to load the overlay or
+// data filesystem modules is not an error (they may be built-in), but failing
+// to load an explicitly configured module is.
+func loadModules(tracker *stage.Tracker, opts *config.Options) {
+	dir, err := modules.DefaultDir()
+	if err != nil {
+		slog.Warn("Failed to find kernel module directory", slog.Any("error", err))
+		return
+	}
+
+	automatic := []string{"overlay"}
+	if opts.DataFSType != "" && !opts.Volatile {
+		automatic = append(automatic, "fs-"+opts.DataFSType)
+	}
+	if opts.Provider == "stateless-encrypted" && !opts.Volatile {
+		automatic = append(automatic, "dm_crypt")
+	}
+	if opts.Root != "" && opts.RootFSType != "" {
+		automatic = append(automatic, "fs-"+opts.RootFSType)
+	}
+
+	err = tracker.Run(context.Background(), "modules", 0, func(ctx context.Context) error {
+		loader := modules.NewLoader(dir)
+
+		for _, name := range automatic {
+			if err := loader.Load(name); err != nil {
+				// Maybe it's compiled into the kernel?
+				slog.Debug("Failed to load kernel module", slog.String("module", name), slog.Any("error", err))
+			}
+		}
+
+		var errs []error
+		for _, name := range opts.Modules {
+			slog.Info("Loading kernel module", slog.String("module", name))
+
+			if err := loader.Load(name); err != nil {
+				errs = append(errs, fmt.Errorf("module %s: %w", name, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		degrade("Failed to load kernel modules", slog.Any("error", err))
+	}
+}
+
+// loadFilesystemModule loads the kernel module for a filesystem type that
+// wasn't known when the modules were loaded (as it was detected). Failing to
+// load it is not an error, it may be built-in.
+func loadFilesystemModule(fstype string) {
+	dir, err := modules.DefaultDir()
+	if err != nil {
+		return
+	}
+
+	if err := modules.NewLoader(dir).Load("fs-" + fstype); err != nil {
+		slog.Debug("Failed to load kernel module", slog.String("module", "fs-"+fstype), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,538 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/immutos/matchstick/internal/bootreport"
+	"github.com/immutos/matchstick/internal/coldplug"
+	"github.com/immutos/matchstick/internal/dag"
+	"github.com/immutos/matchstick/internal/devices"
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/internal/hooks"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/retry"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/switchroot"
+	"github.com/immutos/matchstick/pkg/boot"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"github.com/immutos/matchstick/pkg/storage"
+	"golang.org/x/sys/unix"
+)
+
+// reportPath is where the stage timing report is written.
+const reportPath = "/run/matchstick/stages.json"
+
+// sys performs the filesystem operations (and executes init), recording them
+// for the boot report.
+var sys = mounter.NewRecorder(mounter.System{})
+
+// mountData sets up the data filesystem using the configured provider,
+// returning the resolved data device.
+func mountData(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan) (string, error) {
+	prov, err := storage.GetProvider(p.Provider, opts.ProvidersDir)
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := storage.NewSpec(opts, p)
+	if err != nil {
+		return "", err
+	}
+	spec.Mounter = sys
+
+	slog.Debug("Using provider", slog.String("provider", prov.Name()), slog.String("data", spec.Data),
+		slog.String("fstype", spec.FSType), slog.String("mount", spec.Mount))
+
+	var wait string
+	if !opts.Volatile {
+		wait = opts.Data
+	}
+
+	if err := coldplugDevices(ctx, tracker, opts, wait); err != nil {
+		return "", fmt.Errorf("failed to coldplug devices: %w", err)
+	}
+
+	var device string
+	err = tracker.Run(ctx, "data-device", opts.DeviceTimeout, func(ctx context.Context) error {
+		return retry.Do(ctx, retryPolicy(opts), "resolve data device", func() (err error) {
+			ensureDataNode(opts.Data)
+
+			device, err = prov.Resolve(ctx, spec)
+			return err
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve data device: %w", err)
+	}
+
+	slog.Info("Resolved data device", slog.String("provider", prov.Name()), slog.String("device", device))
+
+	err = tracker.Run(ctx, "data-prepare", opts.PrepareTimeout, func(ctx context.Context) error {
+		return prov.Prepare(ctx, spec, device)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare data device: %w", err)
+	}
+
+	if opts.DataFSType == "" && spec.FSType != "" {
+		slog.Info("Resolved data filesystem type", slog.String("fstype", spec.FSType))
+
+		opts.DataFSType = spec.FSType
+		p.Data.FSType = spec.FSType
+
+		loadFilesystemModule(spec.FSType)
+	}
+
+	err = tracker.Run(ctx, "data-mount", opts.MountTimeout, func(ctx context.Context) error {
+		return retry.Do(ctx, retryPolicy(opts), "mount data device", func() error {
+			return prov.Mount(ctx, spec, device)
+		})
+	})
+	if err != nil && p.Provider == "block" {
+		err = checkData(ctx, tracker, opts, prov, spec, device, err)
+	}
+	if err != nil {
+		return device, err
+	}
+
+	if err := mounter.Propagate(sys, p.Data.Target, p.Data.Propagation); err != nil {
+		return device, fmt.Errorf("failed to set data mount propagation: %w", err)
+	}
+
+	if err := mounter.Chattr(sys, p.Data.Target, p.Data.Attrs); err != nil {
+		return device, fmt.Errorf("failed to set data filesystem attributes: %w", err)
+	}
+
+	return device, nil
+}
+
+// mountOverlays mounts the planned overlays concurrently (an overlay is only
+// mounted once the overlay of any parent directory is), degrading (skipping
+// the overlay) on failure. Each overlay is recorded as a separate stage. The
+// skipped overlays are returned.
+func mountOverlays(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan, mode overlay.Mode) []bootreport.Skipped {
+	// Parents must be added to the graph before their children.
+	overlays := slices.Clone(p.Overlays)
+	slices.SortStableFunc(overlays, func(a, b plan.Overlay) int {
+		return strings.Count(a.Dir, "/") - strings.Count(b.Dir, "/")
+	})
+
+	g := dag.New()
+	for _, o := range overlays {
+		var deps []string
+		if parent := p.Parent(o.Dir); parent != "" {
+			deps = append(deps, parent)
+		}
+
+		err := g.Add(o.Dir, deps, func(ctx context.Context) error {
+			slog.Info("Mounting overlay filesystem", slog.Any("dir", o.Dir))
+
+			return tracker.Run(ctx, "overlay:"+o.Dir, 0, func(ctx context.Context) error {
+				return mountOverlay(ctx, opts, o, mode)
+			})
+		})
+		if err != nil {
+			fatal("Failed to plan overlay mounts", slog.Any("error", err))
+		}
+	}
+
+	errs := g.Run(ctx, opts.MountConcurrency)
+
+	var skipped []bootreport.Skipped
+	for _, o := range p.Overlays {
+		if err := errs[o.Dir]; err != nil {
+			degrade("Failed to mount overlay filesystem", slog.Any("dir", o.Dir), slog.Any("error", err))
+
+			skipped = append(skipped, bootreport.Skipped{Dir: o.Dir, Reason: err.Error()})
+		}
+	}
+
+	return skipped
+}
+
+// mountOverlay creates the upper and work directories of an overlay, and
+// mounts it in the given mode.
+func mountOverlay(ctx context.Context, opts *config.Options, o overlay.Overlay, mode overlay.Mode) error {
+	if err := overlay.Prepare(sys, o); err != nil {
+		return err
+	}
+
+	return retry.Do(ctx, retryPolicy(opts), "mount overlay", func() error {
+		return overlay.Apply(sys, o, mode, opts.FuseOverlayfs)
+	})
+}
+
+// mountExtras mounts the extra filesystems in order (as they may be nested),
+// each recorded as a separate stage. Failures are handled by the failure
+// policy, unless the mount is nofail.
+func mountExtras(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan) {
+	for _, em := range p.Mounts {
+		slog.Info("Mounting filesystem", slog.String("source", em.Source), slog.String("target", em.Target))
+
+		if em.FSType != "" {
+			loadFilesystemModule(em.FSType)
+		}
+
+		err := tracker.Run(ctx, "mount:"+em.Target, opts.MountTimeout, func(ctx context.Context) error {
+			return retry.Do(ctx, retryPolicy(opts), "mount "+em.Target, func() error {
+				if em.FSType == "" {
+					fstype, err := fsprobe.Probe(em.Source)
+					if err != nil {
+						return fmt.Errorf("failed to detect filesystem type: %w", err)
+					}
+
+					em.FSType = fstype
+					loadFilesystemModule(fstype)
+				}
+
+				return boot.MountExtra(sys, em)
+			})
+		})
+		if err != nil {
+			if em.NoFail {
+				slog.Warn("Failed to mount filesystem", slog.String("target", em.Target), slog.Any("error", err))
+				continue
+			}
+
+			degrade("Failed to mount filesystem", slog.String("target", em.Target), slog.Any("error", err))
+		}
+	}
+}
+
+// earlyMount is a filesystem needed before the data filesystem is mounted.
+type earlyMount struct {
+	source string
+	target string
+	fstype string
+	flags  uintptr
+	data   string
+	mode   os.FileMode
+	// required specifies whether boot fails if it can't be mounted.
+	required bool
+	// fallback (if set) is called if it can't be mounted.
+	fallback func() error
+}
+
+// earlyMounts are the API filesystems mounted (if they are missing) before
+// anything else, in order.
+var earlyMounts = []earlyMount{
+	// /proc is needed to read the kernel command line.
+	{"proc", "/proc", "proc", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC, "", 0o555, true, nil},
+	// /sys is needed to find devices if devtmpfs is unavailable.
+	{"sysfs", "/sys", "sysfs", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC, "", 0o555, false, nil},
+	{"devtmpfs", "/dev", "devtmpfs", unix.MS_NOSUID, "mode=0755", 0o755, false, staticDev},
+	// Runtime state written by matchstick needs to be preserved for init.
+	{"tmpfs", "/run", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, "mode=0755", 0o755, false, nil},
+}
+
+// mountEarly mounts each of the early filesystems that isn't already
+// mounted. It only returns an error if a required filesystem can't be
+// mounted.
+func mountEarly() error {
+	for _, m := range earlyMounts {
+		if err := m.mount(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mountProc mounts /proc (if it's missing) ahead of the other early
+// filesystems, as it's needed to detect a container (and read the kernel
+// command line, which can override the detection).
+func mountProc() {
+	if err := earlyMounts[0].mount(); err != nil {
+		slog.Debug("Failed to mount /proc before detecting a container", slog.Any("error", err))
+	}
+}
+
+// mount mounts the filesystem, unless it's already mounted. It only returns
+// an error if the filesystem is required.
+func (m earlyMount) mount() error {
+	mounted, err := sys.IsMountpoint(m.target)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to check mountpoint", slog.String("path", m.target), slog.Any("error", err))
+	}
+
+	if mounted {
+		slog.Debug("Already mounted", slog.String("path", m.target))
+		return nil
+	}
+
+	slog.Info("Mounting " + m.target)
+
+	err = sys.MkdirAll(m.target, m.mode)
+	if err == nil {
+		err = sys.Mount(m.source, m.target, m.fstype, m.flags, m.data)
+	}
+	if err != nil {
+		if m.required {
+			return fmt.Errorf("failed to mount %s: %w", m.target, err)
+		}
+
+		slog.Warn("Failed to mount "+m.target, slog.Any("error", err))
+
+		if m.fallback != nil {
+			if err := m.fallback(); err != nil {
+				slog.Warn("Fallback for "+m.target+" failed", slog.Any("error", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// coldplugged is set once devices have been coldplugged.
+var coldplugged bool
+
+// coldplugDevices (if enabled) coldplugs devices (once), and waits for the
+// given device (if any) to appear.
+func coldplugDevices(ctx context.Context, tracker *stage.Tracker, opts *config.Options, device string) error {
+	mode, err := coldplug.ParseMode(opts.Coldplug)
+	if err != nil {
+		return err
+	}
+
+	if mode == coldplug.None {
+		return nil
+	}
+
+	return tracker.Run(ctx, "coldplug", opts.DeviceTimeout, func(ctx context.Context) error {
+		if !coldplugged {
+			slog.Info("Coldplugging devices", slog.String("mode", string(mode)))
+
+			if err := coldplug.Run(ctx, mode); err != nil {
+				return err
+			}
+
+			coldplugged = true
+		}
+
+		if !strings.HasPrefix(device, "/dev/") {
+			return nil
+		}
+
+		slog.Info("Waiting for device", slog.String("device", device))
+
+		return coldplug.WaitFor(ctx, device)
+	})
+}
+
+// mountRoot mounts the real root filesystem (read-only, unless disabled with
+// disable_rw) when running from an initramfs.
+func mountRoot(ctx context.Context, tracker *stage.Tracker, opts *config.Options) error {
+	device := storage.DevicePath(opts.Root)
+
+	if err := coldplugDevices(ctx, tracker, opts, device); err != nil {
+		return fmt.Errorf("failed to coldplug devices: %w", err)
+	}
+
+	return tracker.Run(ctx, "root", opts.DeviceTimeout, func(ctx context.Context) error {
+		return retry.Do(ctx, retryPolicy(opts), "mount root", func() error {
+			ensureDataNode(device)
+
+			slog.Info("Mounting root filesystem", slog.String("device", storage.ResolveDevice(device)),
+				slog.String("target", opts.NewRoot))
+
+			return boot.MountRoot(sys, opts)
+		})
+	})
+}
+
+// pivotRoot pivots into the overlay of the whole root filesystem, moving the
+// API filesystems and the data filesystem into it. As pivoting makes every
+// mount private, the configured propagation is restored afterwards.
+func pivotRoot(tracker *stage.Tracker, opts *config.Options, p *plan.Plan) {
+	if mounted, _ := sys.IsMountpoint(plan.RootOverlayDir); !mounted {
+		// Mounting the overlay failed (and the failure policy is to continue).
+		slog.Warn("Root overlay is not mounted, not pivoting into it")
+		return
+	}
+
+	slog.Info("Pivoting into root overlay", slog.String("root", plan.RootOverlayDir))
+
+	err := tracker.Run(context.Background(), "pivot-root", 0, func(ctx context.Context) error {
+		return switchroot.Pivot(sys, plan.RootOverlayDir, append(slices.Clone(switchroot.APIMounts), opts.Mount))
+	})
+	if err != nil {
+		fatal("Failed to pivot into root overlay", slog.Any("error", err))
+	}
+
+	if err := mounter.Propagate(sys, "/", p.Overlays[0].Mount.Propagation); err != nil {
+		degrade("Failed to set root overlay propagation", slog.Any("error", err))
+	}
+
+	if err := mounter.Propagate(sys, opts.Mount, p.Data.Propagation); err != nil {
+		degrade("Failed to set data mount propagation", slog.Any("error", err))
+	}
+}
+
+// switchRoot switches to the real root filesystem.
+func switchRoot(tracker *stage.Tracker, opts *config.Options) {
+	slog.Info("Switching to root filesystem", slog.String("root", opts.NewRoot))
+
+	err := tracker.Run(context.Background(), "switch-root", 0, func(ctx context.Context) error {
+		return switchroot.Switch(sys, opts.NewRoot)
+	})
+	if err != nil {
+		fatal("Failed to switch root filesystem", slog.Any("error", err))
+	}
+}
+
+// staticDevNodes is set if device nodes are being created statically (as
+// devtmpfs is unavailable).
+var staticDevNodes bool
+
+// staticDev creates the essential device nodes in /dev, for kernels built
+// without devtmpfs. If /dev isn't writable, a tmpfs is mounted on it first.
+func staticDev() error {
+	slog.Info("Creating static device nodes")
+
+	if unix.Access("/dev", unix.W_OK) != nil {
+		if err := sys.Mount("tmpfs", "/dev", "tmpfs", unix.MS_NOSUID, "mode=0755"); err != nil {
+			return fmt.Errorf("failed to mount tmpfs on /dev: %w", err)
+		}
+	}
+
+	staticDevNodes = true
+
+	return devices.Create("/dev", devices.Essential)
+}
+
+// ensureDataNode creates the data (or root) device node (if device nodes are
+// being created statically, and the kernel knows about the device).
+func ensureDataNode(data string) {
+	if !staticDevNodes || !strings.HasPrefix(data, "/dev/") {
+		return
+	}
+
+	node, err := devices.LookupBlock(devices.DefaultSysDir, data)
+	if err != nil {
+		slog.Debug("Data device not found in sysfs", slog.String("device", data), slog.Any("error", err))
+		return
+	}
+
+	if err := devices.Create("/dev", []devices.Node{*node}); err != nil {
+		slog.Warn("Failed to create data device node", slog.String("device", data), slog.Any("error", err))
+	}
+}
+
+// writeReport logs a summary of the boot setup stages and writes the stage
+// report, and the boot report (completed with the mounts performed and the
+// stage timings), to the runtime directory.
+func writeReport(tracker *stage.Tracker, br *bootreport.Report) {
+	report := tracker.Report()
+	report.Log()
+
+	if err := report.Write(reportPath); err != nil {
+		slog.Warn("Failed to write stage report", slog.String("path", reportPath), slog.Any("error", err))
+	}
+
+	br.Mounts = bootreport.Mounts(sys.Ops())
+	br.Timings = report
+
+	if err := br.Write(bootreport.DefaultPath); err != nil {
+		slog.Warn("Failed to write boot report", slog.String("path", bootreport.DefaultPath), slog.Any("error", err))
+	}
+}
+
+// retryPolicy returns the policy for retrying operations that fail with
+// transient errors.
+func retryPolicy(opts *config.Options) retry.Policy {
+	return retry.Policy{
+		Attempts: opts.Retries,
+		Delay:    opts.RetryDelay,
+		MaxDelay: 10 * time.Second,
+	}
+}
+
+// hooksStashDir is where hooks are copied to, in lockdown.
+const hooksStashDir = "/run/matchstick/hooks"
+
+// runHooks runs the hooks found in the hooks directory for the given stage,
+// followed by any explicitly configured hooks.
+func runHooks(tracker *stage.Tracker, opts *config.Options, hookStage hooks.Stage, explicit []string) {
+	found, ok := findHooks(opts, hookStage, explicit)
+	if ok {
+		runFoundHooks(tracker, opts, hookStage, found)
+	}
+}
+
+// findHooks returns the hooks found in the hooks directory for the given
+// stage, followed by any explicitly configured hooks. It returns false if
+// they couldn't be found (and the failure policy is to continue).
+func findHooks(opts *config.Options, hookStage hooks.Stage, explicit []string) ([]string, bool) {
+	found, err := hooks.Find(opts.HooksDir, hookStage)
+	if err != nil {
+		degrade("Failed to find hooks", slog.String("stage", string(hookStage)), slog.Any("error", err))
+		return nil, false
+	}
+
+	return append(found, explicit...), true
+}
+
+// stashHooks finds the hooks for the given stage, and copies them to the
+// runtime directory, so that later changes (eg. to a writable overlay of
+// /etc) don't affect what is run.
+func stashHooks(opts *config.Options, hookStage hooks.Stage, explicit []string) ([]string, bool) {
+	found, ok := findHooks(opts, hookStage, explicit)
+	if !ok {
+		return nil, false
+	}
+
+	stashed, err := hooks.Stash(found, filepath.Join(hooksStashDir, string(hookStage)+".d"))
+	if err != nil {
+		degrade("Failed to stash hooks", slog.String("stage", string(hookStage)), slog.Any("error", err))
+		return nil, false
+	}
+
+	return stashed, true
+}
+
+// runFoundHooks runs the given hooks for a stage.
+func runFoundHooks(tracker *stage.Tracker, opts *config.Options, hookStage hooks.Stage, found []string) {
+	err := tracker.Run(context.Background(), string(hookStage)+"-hooks", opts.HooksTimeout, func(ctx context.Context) error {
+		return hooks.Run(ctx, hookStage, found, hooks.Environ(opts))
+	})
+	if err != nil {
+		degrade("Failed to run hooks", slog.String("stage", string(hookStage)), slog.Any("error", err))
+	}
+}
+
+// remountWritable remounts the root filesystem read-write (when disabled, for
+// debugging).
+func remountWritable() {
+	slog.Info("Remounting root filesystem read-write")
+
+	if err := sys.Mount("", "/", "", unix.MS_REMOUNT, ""); err != nil {
+		degrade("Failed to remount root filesystem read-write", slog.Any("error", err))
+	}
+}
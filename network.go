@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/netconf"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// resolvConfPath is where the DNS configuration obtained during network
+// setup is written (for init to pick up).
+const resolvConfPath = "/run/matchstick/resolv.conf"
+
+// setupNetwork (if configured) brings up a network interface, so that
+// network-backed data filesystems and remote config fetching work. It
+// returns the lease (nil if the network wasn't set up).
+func setupNetwork(tracker *stage.Tracker, opts *config.Options) *netconf.Lease {
+	conf, err := netconf.ParseIP(opts.IP)
+	if err != nil {
+		degrade("Invalid network configuration", slog.Any("error", err))
+		return nil
+	}
+
+	if conf == nil {
+		return nil
+	}
+
+	// The stage may be abandoned (and continue running) on timeout, so the
+	// lease is only received once it has succeeded.
+	leases := make(chan *netconf.Lease, 1)
+	err = tracker.Run(context.Background(), "network", opts.NetworkTimeout, func(ctx context.Context) error {
+		_, lease, err := netconf.Configure(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		netconf.UseDNS(lease)
+
+		if len(lease.DNS) > 0 {
+			if err := netconf.WriteResolvConf(resolvConfPath, lease); err != nil {
+				slog.Warn("Failed to write resolv.conf", slog.String("path", resolvConfPath), slog.Any("error", err))
+			}
+		}
+
+		leases <- lease
+
+		return nil
+	})
+	if err != nil {
+		degrade("Failed to set up network", slog.Any("error", err))
+		return nil
+	}
+
+	return <-leases
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/immutos/matchstick/internal/nextboot"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/storage"
+)
+
+// mountDataNextBoot mounts the data filesystem, honoring a marker (written by
+// matchstickctl) switching between a volatile and a persistent data mount for
+// this boot. It returns the resolved data device.
+func mountDataNextBoot(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan) (string, error) {
+	if opts.Volatile {
+		if device, ok := mountPersistentNextBoot(ctx, tracker, opts, p); ok {
+			return device, nil
+		}
+	}
+
+	device, err := mountData(ctx, tracker, opts, p)
+	if errors.Is(err, errDataCorrupt) {
+		return recoverData(ctx, tracker, opts, p, device, err)
+	}
+	if err != nil || opts.Volatile {
+		return device, err
+	}
+
+	mode, err := nextboot.Read(p.Data.Target)
+	if err != nil {
+		slog.Warn("Failed to read next boot marker", slog.Any("error", err))
+		return device, nil
+	}
+
+	if mode == "" {
+		return device, nil
+	}
+
+	// The marker is only honored once.
+	if err := nextboot.Clear(p.Data.Target); err != nil {
+		slog.Warn("Failed to clear next boot marker, ignoring it", slog.Any("error", err))
+		return device, nil
+	}
+
+	if mode != nextboot.Volatile {
+		return device, nil
+	}
+
+	if err := sys.Unmount(p.Data.Target, 0); err != nil {
+		slog.Warn("Failed to unmount data filesystem, ignoring next boot marker", slog.Any("error", err))
+		return device, nil
+	}
+
+	slog.Info("Using volatile data mount for this boot, as requested")
+
+	useVolatile(opts, p)
+
+	return mountData(ctx, tracker, opts, p)
+}
+
+// mountPersistentNextBoot checks the data device (if configured, and present)
+// for a marker requesting a persistent data mount for this boot, mounting it
+// if so.
+func mountPersistentNextBoot(ctx context.Context, tracker *stage.Tracker, opts *config.Options, p *plan.Plan) (string, bool) {
+	if opts.Data == "" {
+		return "", false
+	}
+
+	if _, err := os.Stat(storage.ResolveDevice(opts.Data)); err != nil {
+		return "", false
+	}
+
+	persistent := *opts
+	persistent.Volatile = false
+	persistent.Provider = ""
+	persistent.Retries = 1
+
+	pp := *p
+	pp.Provider = "block"
+	pp.Data = &plan.Mount{
+		Source:      storage.ResolveDevice(opts.Data),
+		Target:      p.Data.Target,
+		FSType:      opts.DataFSType,
+		Flags:       p.Data.Flags,
+		Data:        opts.DataOptions,
+		Propagation: p.Data.Propagation,
+		Attrs:       p.Data.Attrs,
+	}
+
+	device, err := mountData(ctx, tracker, &persistent, &pp)
+	if err != nil {
+		slog.Debug("Failed to mount data device to check for next boot marker", slog.Any("error", err))
+		return "", false
+	}
+
+	// The marker is only honored once.
+	mode, err := nextboot.Read(pp.Data.Target)
+	if err == nil && mode != "" {
+		err = nextboot.Clear(pp.Data.Target)
+	}
+
+	if err == nil && mode == nextboot.Persistent {
+		slog.Info("Using persistent data mount for this boot, as requested", slog.String("device", device))
+
+		opts.Volatile = false
+		opts.DataFSType = persistent.DataFSType
+		setFailureOptions(opts)
+		*p = pp
+
+		return device, true
+	}
+	if err != nil {
+		slog.Warn("Failed to check next boot marker", slog.Any("error", err))
+	}
+
+	if err := sys.Unmount(pp.Data.Target, 0); err != nil {
+		degrade("Failed to unmount data device", slog.Any("error", err))
+	}
+
+	return "", false
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+// passthroughArgs returns the arguments matchstick was executed with that are
+// passed through to init.
+func passthroughArgs(opts *config.Options, fs *pflag.FlagSet) []string {
+	policy, err := plan.ParsePassthrough(opts.InitArgsPassthrough)
+	if err != nil {
+		fatal("Invalid init args passthrough policy", slog.Any("error", err))
+	}
+
+	switch policy {
+	case plan.PassthroughAll:
+		return os.Args[1:]
+	case plan.PassthroughPositional:
+		return fs.Args()
+	default:
+		return nil
+	}
+}
+
+// readDirsFile reads the dirs file (if any) from the image, mounted on root.
+func readDirsFile(opts *config.Options, root string) error {
+	if opts.DirsFile == "" {
+		return nil
+	}
+
+	if err := config.ReadDirsFile(opts, filepath.Join(root, opts.DirsFile)); err != nil {
+		return fmt.Errorf("error reading dirs file: %w", err)
+	}
+
+	return nil
+}
+
+// ignoreDryRun disables a dry run when running as PID 1, as exiting (rather
+// than executing init) would panic the kernel.
+func ignoreDryRun(opts *config.Options) {
+	if opts.DryRun && os.Getpid() == 1 {
+		slog.Warn("Ignoring dry run, as running as PID 1")
+
+		opts.DryRun = false
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package boot provides the steps of matchstick's boot sequence that aren't
+// covered by the overlay and storage packages: mounting the real root and the
+// extra mounts, switching into the resulting root filesystem, and executing
+// init.
+//
+// Unlike the matchstick binary, failures are returned rather than handled
+// with a failure policy, and devices are expected to be present (no
+// coldplugging is done).
+package boot
+
+import (
+	"fmt"
+
+	"github.com/immutos/matchstick/internal/fsprobe"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+)
+
+// MountExtra mounts an extra filesystem with m, creating its mountpoint. If
+// the filesystem type isn't specified it's detected from the source.
+func MountExtra(m mounter.Mounter, em overlay.ExtraMount) error {
+	if em.FSType == "" {
+		fstype, err := fsprobe.Probe(em.Source)
+		if err != nil {
+			return fmt.Errorf("failed to detect filesystem type: %w", err)
+		}
+
+		em.FSType = fstype
+	}
+
+	if err := m.MkdirAll(em.Target, 0o755); err != nil {
+		return err
+	}
+
+	return m.Mount(em.Source, em.Target, em.FSType, em.Flags, em.Data)
+}
+
+// Exec replaces the current process with init (opts.Cmd) using m, executed
+// with the configured arguments, args, and the environment environ.
+func Exec(m mounter.Mounter, opts *config.Options, args, environ []string) error {
+	return m.Exec(opts.Cmd, plan.Argv(opts, args), environ)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package boot
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/immutos/matchstick/internal/switchroot"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"github.com/immutos/matchstick/pkg/storage"
+	"golang.org/x/sys/unix"
+)
+
+// IsInitramfs returns true if root is an initramfs (ie. a ramfs or tmpfs).
+func IsInitramfs(root string) bool {
+	return switchroot.IsInitramfs(root)
+}
+
+// MountRoot mounts the real root filesystem (opts.Root) on opts.NewRoot,
+// read-only unless disabled with disable_rw.
+func MountRoot(m mounter.Mounter, opts *config.Options) error {
+	flags := uintptr(unix.MS_RDONLY)
+	if opts.Disable && opts.DisableRW {
+		flags = 0
+	}
+
+	return switchroot.Mount(m, storage.ResolveDevice(opts.Root), opts.NewRoot, opts.RootFSType, flags, opts.RootOptions)
+}
+
+// SwitchRoot switches into the root filesystem set up for p: the real root
+// filesystem (when running from an initramfs), and then the overlay of the
+// whole root filesystem (if configured). The mounts are performed with m.
+func SwitchRoot(m mounter.Mounter, opts *config.Options, p *overlay.Plan) error {
+	if p.Root != nil {
+		if err := switchroot.Switch(m, p.Root.Target); err != nil {
+			return fmt.Errorf("failed to switch root filesystem: %w", err)
+		}
+	}
+
+	if opts.OverlayRoot && p.Data != nil {
+		mounts := append(slices.Clone(switchroot.APIMounts), opts.Mount)
+
+		if err := switchroot.Pivot(m, overlay.RootOverlayDir, mounts); err != nil {
+			return fmt.Errorf("failed to pivot into root overlay: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package boot_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/pkg/boot"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"golang.org/x/sys/unix"
+)
+
+func TestMountExtra(t *testing.T) {
+	opts := config.Defaults()
+	opts.Volatile = true
+	opts.Dirs = nil
+	opts.Mounts = []string{"tmpfs:/scratch:tmpfs:nodev", "size=1G"}
+
+	p, err := overlay.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := mounter.NewFake()
+
+	if err := boot.MountExtra(m, p.Mounts[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Mounts(); !reflect.DeepEqual(got, []string{"/scratch"}) {
+		t.Errorf("Mounts() = %v, want [/scratch]", got)
+	}
+
+	if op := m.Ops[len(m.Ops)-1]; op.Target != "/scratch" || op.Flags != unix.MS_NODEV || op.Data != "size=1G" {
+		t.Errorf("unexpected mount: %v", op)
+	}
+
+	m = mounter.NewFake()
+	m.Fail("mount", "/scratch", unix.ENOMEM)
+
+	if err := boot.MountExtra(m, p.Mounts[0]); !errors.Is(err, unix.ENOMEM) {
+		t.Errorf("expected ENOMEM, got %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	opts := config.Defaults()
+
+	m := mounter.NewFake()
+
+	if err := boot.Exec(m, opts, []string{"single"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if op := m.Ops[len(m.Ops)-1]; op.Kind != "exec" || !reflect.DeepEqual(op.Argv, []string{opts.Cmd, "single"}) {
+		t.Errorf("unexpected exec: %v", op)
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package config is the public interface to matchstick's configuration. It
+// exposes the options matchstick is configured with, their built-in defaults,
+// and how they are decoded from the SMBIOS OEM strings, the kernel command
+// line, the environment and config files.
+package config
+
+import (
+	"github.com/immutos/matchstick/internal/config"
+)
+
+// DefaultPrefix is the default prefix of options on the kernel command line.
+const DefaultPrefix = config.DefaultPrefix
+
+// Options are the matchstick options.
+type Options = config.Options
+
+// DirOptions are the per-directory options read from a dirs file.
+type DirOptions = config.DirOptions
+
+// SetPrefix sets the prefix of options on the kernel command line (and of
+// environment variables).
+func SetPrefix(prefix string) {
+	config.Prefix = prefix
+}
+
+// Prefixes returns the accepted option prefixes, the configured prefix first.
+func Prefixes() []string {
+	return config.Prefixes()
+}
+
+// Decode decodes options (keyed by their prefixed names) on top of opts.
+func Decode(opts *Options, m map[string]string) error {
+	return config.Decode(opts, m)
+}
+
+// DecodeMulti decodes options that may be repeated (eg. on the kernel command
+// line) on top of opts. Repeated list options are appended, otherwise the last
+// value wins.
+func DecodeMulti(opts *Options, m map[string][]string) error {
+	return config.DecodeMulti(opts, m)
+}
+
+// EnvironAsMap returns the options set in the environment (eg. os.Environ()),
+// keyed by their option names.
+func EnvironAsMap(environ []string) map[string]string {
+	return config.EnvironAsMap(environ)
+}
+
+// StripOptions returns a copy of environ without the variables that set
+// options.
+func StripOptions(environ []string) []string {
+	return config.StripOptions(environ)
+}
+
+// ReadOptionsFile reads a file of key=value options (eg. the trusted config).
+func ReadOptionsFile(path string) (map[string][]string, error) {
+	return config.ReadOptionsFile(path)
+}
+
+// OptionKeys returns the sorted keys of m that are options.
+func OptionKeys[V any](m map[string]V) []string {
+	return config.OptionKeys(m)
+}
+
+// ReadDirsFile reads the directories to overlay (and their options) from a
+// dirs file, replacing the configured directories.
+func ReadDirsFile(opts *Options, path string) error {
+	return config.ReadDirsFile(opts, path)
+}
+
+// NormalizeCmd splits any arguments included in the configured init command
+// into the init arguments.
+func NormalizeCmd(opts *Options) {
+	config.NormalizeCmd(opts)
+}
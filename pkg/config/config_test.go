@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+func TestDefaults(t *testing.T) {
+	opts := config.Defaults()
+
+	if opts.Mount != "/mnt/data" || opts.Cmd != "/lib/systemd/systemd" || opts.OnFailure != "shell" {
+		t.Errorf("unexpected defaults: %+v", opts)
+	}
+}
+
+func TestLoadContainer(t *testing.T) {
+	t.Setenv("MATCHSTICK_DIRS", "/etc,/var")
+	t.Setenv("MATCHSTICK_CMD", "/sbin/init --system")
+
+	opts := config.Defaults()
+	if err := config.Load(opts, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(opts.Dirs, []string{"/etc", "/var"}) {
+		t.Errorf("Dirs = %v, want [/etc /var]", opts.Dirs)
+	}
+
+	if opts.Cmd != "/sbin/init" || opts.CmdArgs != "'--system'" {
+		t.Errorf("Cmd = %q, CmdArgs = %q", opts.Cmd, opts.CmdArgs)
+	}
+}
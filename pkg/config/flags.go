@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"time"
+
+	"github.com/immutos/matchstick/internal/audit"
+	"github.com/immutos/matchstick/internal/coldplug"
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/failure"
+	"github.com/immutos/matchstick/internal/fstrim"
+	"github.com/immutos/matchstick/internal/harden"
+	"github.com/immutos/matchstick/internal/hooks"
+	"github.com/immutos/matchstick/internal/logging"
+	"github.com/immutos/matchstick/internal/overlay"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/provider"
+	"github.com/immutos/matchstick/internal/supervisor"
+	"github.com/immutos/matchstick/internal/switchroot"
+	"github.com/immutos/matchstick/internal/tmpfiles"
+	"github.com/immutos/matchstick/internal/tpm"
+	"github.com/immutos/matchstick/internal/watchdog"
+	"github.com/spf13/pflag"
+)
+
+// Defaults returns options with the built-in defaults applied.
+func Defaults() *Options {
+	var opts Options
+	_ = NewFlagSet("matchstick", &opts)
+
+	return &opts
+}
+
+// NewFlagSet returns a flag set (named name) that populates opts, with the
+// built-in defaults applied.
+func NewFlagSet(name string, opts *Options) *pflag.FlagSet {
+	var fs pflag.FlagSet
+	fs.Init(name, pflag.ContinueOnError)
+
+	fs.StringVar(&config.Prefix, "prefix", config.Prefix, "The prefix of options on the kernel command line")
+	fs.StringVar(&opts.Data, "data", "", "The device to which write operations will be redirected")
+	fs.StringVar(&opts.DataFSType, "datafstype", "", "The filesystem type of the data device")
+	fs.StringVar(&opts.DataOptions, "data-options", "", "Additional mount options for the data filesystem")
+	fs.StringVar(&opts.Root, "root", "", "The real root device, when running from an initramfs (defaults to the kernel's root=)")
+	fs.StringVar(&opts.RootFSType, "root-fstype", "", "The filesystem type of the real root device (defaults to the kernel's rootfstype=)")
+	fs.StringVar(&opts.RootOptions, "root-options", "", "Mount options for the real root device (defaults to the kernel's rootflags=)")
+	fs.StringVar(&opts.NewRoot, "new-root", switchroot.DefaultNewRoot, "Where the real root filesystem is mounted before switching to it")
+	fs.StringVar(&opts.Provider, "provider", "", "The provider used to set up the data filesystem")
+	fs.StringVar(&opts.ProvidersDir, "providers-dir", provider.DefaultDir, "The directory searched for external providers")
+	fs.StringVar(&opts.Mount, "mount", "/mnt/data", "The mountpoint to be used for the data filesystem")
+	fs.StringSliceVar(&opts.Dirs, "dirs", []string{"/etc", "/home", "/root", "/srv", "/var"},
+		"A list of directories to overlay on top of the data filesystem")
+	fs.BoolVar(&opts.OverlayRoot, "overlay-root", false, "Whether to overlay the whole root filesystem, rather than the configured directories")
+	fs.StringVar(&opts.DirsFile, "dirs-file", "", "A file listing the directories to overlay, and their options")
+	fs.StringVar(&opts.Coldplug, "coldplug", string(coldplug.None), "How devices are coldplugged before resolving the data device: none, trigger or udevd")
+	fs.BoolVar(&opts.Clock, "clock", true, "Whether to set the system clock from the RTC, or clamp it to the image build time")
+	fs.BoolVar(&opts.RandomSeed, "random-seed", true, "Whether to persist a random seed on the data filesystem")
+	fs.BoolVar(&opts.RandomSeedCredit, "random-seed-credit", false, "Whether to credit the random seed as entropy")
+	fs.StringVar(&opts.Hostname, "hostname", "", "The hostname to assign to the system")
+	fs.StringVar(&opts.IP, "ip", "", "The network configuration, in the kernel's ip= syntax")
+	fs.DurationVar(&opts.NetworkTimeout, "network-timeout", 30*time.Second, "The maximum time to spend setting up the network")
+	fs.StringSliceVar(&opts.Modules, "modules", nil, "Additional kernel modules to load before mounting")
+	fs.StringVar(&opts.Watchdog, "watchdog", "", "The hardware watchdog device to arm during setup (eg. "+watchdog.DefaultDevice+")")
+	fs.DurationVar(&opts.WatchdogTimeout, "watchdog-timeout", 0, "The timeout to set on the hardware watchdog")
+	fs.DurationVar(&opts.WatchdogLimit, "watchdog-limit", 10*time.Minute, "How long setup may take before the watchdog is no longer petted")
+	fs.BoolVar(&opts.WatchdogHandoff, "watchdog-handoff", true, "Whether to leave the watchdog armed for init, rather than disarming it")
+	fs.BoolVar(&opts.Disable, "disable", false, "Whether to skip the data filesystem and overlays, booting the image as-is")
+	fs.BoolVar(&opts.DisableRW, "disable-rw", false, "Whether to (re)mount the root filesystem read-write when disabled")
+	fs.StringVar(&opts.Cmd, "cmd", "/lib/systemd/systemd",
+		"The init process to be executed after the filesystem has been setup")
+	fs.StringVar(&opts.CmdArgs, "cmd-args", "", "The arguments init is executed with (with shell-like quoting)")
+	fs.StringSliceVar(&opts.InitFallbacks, "init-fallbacks", plan.DefaultInitFallbacks, "Executables tried (in order) if init doesn't exist")
+	fs.StringVar(&opts.InitArgsPassthrough, "init-args-passthrough", string(plan.PassthroughPositional),
+		"Which arguments are passed through to init: none, positional or all")
+	fs.StringSliceVar(&opts.Env, "env", nil, "Additional environment variables (NAME=value) to execute init with")
+	fs.BoolVar(&opts.EnvScrub, "env-scrub", false, "Whether to scrub the inherited environment before executing init")
+	fs.StringSliceVar(&opts.EnvSecrets, "env-secrets", nil, "The names of secret-bearing environment variables, whose values are never logged")
+	fs.StringVar(&opts.Container, "container", "auto", "Whether running in a container: auto (detected), true or false")
+	fs.BoolVar(&opts.ContainerOverlays, "container-overlays", false, "Whether to still set up the overlays when running in a container")
+	fs.StringVar(&opts.OverlayMode, "overlay-mode", string(overlay.Auto), "How overlays are mounted in a container: auto, kernel, userxattr or fuse")
+	fs.StringVar(&opts.FuseOverlayfs, "fuse-overlayfs", overlay.DefaultFuseOverlayfs, "The path of the fuse-overlayfs binary")
+	fs.BoolVar(&opts.Supervise, "supervise", false, "Whether to remain PID 1, running init as a supervised child process")
+	fs.StringVar(&opts.OnExit, "on-exit", string(supervisor.Restart), "The policy when a supervised init exits: restart, reboot, poweroff or shell")
+	fs.IntVar(&opts.MaxRestarts, "max-restarts", 5, "The maximum number of consecutive restarts of a supervised init")
+	fs.DurationVar(&opts.RestartDelay, "restart-delay", time.Second, "The delay before restarting a supervised init, doubling with each consecutive restart")
+	fs.StringVar(&opts.HooksDir, "hooks-dir", hooks.DefaultDir, "The directory containing the pre-mount.d and post-mount.d hook directories")
+	fs.StringVar(&opts.TmpfilesDir, "tmpfiles-dir", tmpfiles.DefaultDir, "The directory containing the tmpfiles configuration, applied once the overlays are mounted")
+	fs.StringSliceVar(&opts.PreMountHooks, "pre-mount-hooks", nil, "Additional executables to run before the data filesystem is mounted")
+	fs.StringSliceVar(&opts.Mounts, "mounts", nil, "Extra filesystems to mount after the overlays, each of the form source:target[:fstype[:options]]")
+	fs.StringSliceVar(&opts.PostMountHooks, "post-mount-hooks", nil, "Additional executables to run after the overlays have been mounted")
+	fs.BoolVar(&opts.Volatile, "volatile", false, "Whether the data filesystem should be volatile")
+	fs.BoolVar(&opts.Debug, "debug", false, "Whether to log at debug level (overrides --log-level)")
+	fs.StringVar(&opts.LogLevel, "log-level", "info", "The minimum level of log records: debug, info, warn or error")
+	fs.StringVar(&opts.LogFormat, "log-format", string(logging.FormatText), "The format of log records written to stderr, the console, serial tty and log file: text or json")
+	fs.BoolVar(&opts.LogConsole, "log-console", false, "Whether to also log to /dev/console")
+	fs.StringVar(&opts.LogSerial, "log-serial", "", "A serial tty to also log to")
+	fs.StringVar(&opts.LogFile, "log-file", "", "A file (relative to the data filesystem) to also log to")
+	fs.StringVar(&opts.AuditLog, "audit-log", "", "A file (relative to the data filesystem) to append a record of each boot to (eg. "+audit.DefaultPath+")")
+	fs.BoolVar(&opts.AuditChain, "audit-chain", false, "Whether audit records are hash-chained")
+	fs.StringSliceVar(&opts.Redact, "redact", nil, "The names of options whose values are never logged (in addition to those that look like secrets)")
+	fs.IntVar(&opts.Retries, "retries", 5, "The maximum number of attempts made to resolve devices and mount filesystems")
+	fs.DurationVar(&opts.RetryDelay, "retry-delay", 500*time.Millisecond, "The delay before the first retry, doubling with each subsequent retry")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "The maximum time boot setup may take in total")
+	fs.DurationVar(&opts.DeviceTimeout, "device-timeout", 90*time.Second, "The maximum time to wait for the data device")
+	fs.DurationVar(&opts.PrepareTimeout, "prepare-timeout", 0, "The maximum time to spend preparing the data device")
+	fs.DurationVar(&opts.MountTimeout, "mount-timeout", 0, "The maximum time to spend mounting the data filesystem, and the overlays")
+	fs.StringSliceVar(&opts.DataFlags, "data-flags", []string{"nosuid", "nodev"}, "Hardening options the data filesystem is mounted with (nosuid, nodev or noexec, or their negations)")
+	fs.StringSliceVar(&opts.OverlayFlags, "overlay-flags", nil, "Hardening options the overlays are mounted with (nosuid, nodev or noexec, or their negations)")
+	fs.StringVar(&opts.DataMode, "data-mode", "", "The permissions (in octal) of the root directory of the data filesystem")
+	fs.StringVar(&opts.DataOwner, "data-owner", "", "The owner (numeric uid[:gid]) of the root directory of the data filesystem")
+	fs.StringVar(&opts.DataLabel, "data-label", "", "The security label (an SELinux context, or xattr=value) of the root directory of the data filesystem")
+	fs.StringVar(&opts.DataPropagation, "data-propagation", "", "The propagation type of the data filesystem mount (shared, slave or private, prefixed with r to apply recursively)")
+	fs.StringVar(&opts.Propagation, "propagation", "", "The propagation type of the overlay mounts (shared, slave or private, prefixed with r to apply recursively)")
+	fs.IntVar(&opts.MountConcurrency, "mount-concurrency", 4, "The maximum number of overlays mounted at once (1 mounts them one at a time)")
+	fs.DurationVar(&opts.HooksTimeout, "hooks-timeout", 0, "The maximum time to spend running each stage's hooks")
+	fs.StringVar(&opts.OnFailure, "on-failure", string(failure.Shell), "The failure policy: shell, reboot, panic or continue")
+	fs.StringVar(&opts.DataOnCorrupt, "data-on-corrupt", string(failure.CorruptShell), "What to do if the data filesystem is corrupt: shell, volatile or reformat")
+	fs.DurationVar(&opts.RebootDelay, "reboot-delay", 10*time.Second, "The initial delay before rebooting with the reboot failure policy")
+	fs.StringVar(&opts.InitSHA256, "init-sha256", "", "The expected SHA-256 digest of init")
+	fs.StringVar(&opts.VerifyPublicKey, "verify-pubkey", "", "A base64 encoded ed25519 public key that init (and the manifest) must be signed with")
+	fs.StringVar(&opts.VerifyManifest, "verify-manifest", "", "A SHA-256 manifest of critical files to verify before executing init")
+	fs.StringVar(&opts.OnViolation, "on-violation", "ignore", "What to do when options are set outside of the trusted config: fail or ignore")
+	fs.StringSliceVar(&opts.Rlimits, "rlimits", nil, "Resource limits for init, each of the form name=soft[:hard]")
+	fs.IntVar(&opts.OOMScoreAdj, "oom-score-adj", 0, "The oom_score_adj of init (0 leaves it unchanged)")
+	fs.StringVar(&opts.Cgroup, "cgroup", "", "The cgroup v2 to place init in (eg. app.slice/app)")
+	fs.StringSliceVar(&opts.DropCapabilities, "drop-caps", nil, "Capabilities to drop from the bounding set before executing init")
+	fs.BoolVar(&opts.NoNewPrivs, "no-new-privs", false, "Whether to prevent init from gaining privileges (eg. with setuid binaries)")
+	fs.StringSliceVar(&opts.Securebits, "securebits", nil, "Securebits to set before executing init (eg. noroot)")
+	fs.StringVar(&opts.Seccomp, "seccomp", "", "A seccomp allowlist (syscall names, one per line) applied to init")
+	fs.StringVar(&opts.SeccompAction, "seccomp-action", string(harden.Errno), "The action taken on syscalls that aren't allowed: errno, kill or log")
+	fs.IntVar(&opts.MeasurePCR, "measure-pcr", 0, "The TPM PCR to measure the configuration, image identity and init into (0 disables measurements)")
+	fs.StringVar(&opts.TPMDevice, "tpm-device", tpm.DefaultDevice, "The TPM device used for measurements")
+	fs.StringVar(&opts.ImageID, "image-id", "", "The identity of the image being booted (eg. a verity root hash)")
+	fs.StringVar(&opts.Bootloader, "bootloader", "", "The bootloader to record boot attempts with: grub or systemd-boot")
+	fs.StringVar(&opts.BootDevice, "boot-device", "", "The device holding the GRUB environment block, or the ESP (required with a bootloader)")
+	fs.StringVar(&opts.BootFSType, "boot-fstype", "vfat", "The filesystem type of the boot device")
+	fs.StringVar(&opts.Grubenv, "grubenv", "/boot/grub/grubenv", "The path of the GRUB environment block (relative to the boot device)")
+	fs.StringVar(&opts.RecoveryKernel, "recovery-kernel", "", "A recovery kernel to boot with kexec after repeated boot failures")
+	fs.StringVar(&opts.RecoveryInitrd, "recovery-initrd", "", "The initrd of the recovery kernel")
+	fs.StringVar(&opts.RecoveryCmdline, "recovery-cmdline", "", "The command line of the recovery kernel (defaults to the current command line)")
+	fs.StringVar(&opts.RecoveryDevice, "recovery-device", "", "The device (eg. the ESP) holding the recovery kernel and initrd (required with a recovery kernel)")
+	fs.StringVar(&opts.RecoveryFSType, "recovery-fstype", "vfat", "The filesystem type of the recovery device")
+	fs.IntVar(&opts.RecoveryAfter, "recovery-after", 3, "The number of consecutive failed boots after which the recovery kernel is booted")
+	fs.IntVar(&opts.RollbackAfter, "rollback-after", 0, "The number of consecutive unhealthy boots after which the overlays are rolled back (zero disables it)")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Print the planned mount operations and exit without mounting anything")
+	fs.BoolVar(&opts.Scrub, "scrub", false, "Whether to start a background scrub of the data filesystem")
+	fs.Int64Var(&opts.ScrubRate, "scrub-rate", 4, "The maximum rate (in MiB/s) at which the scrub will read")
+	fs.StringVar(&opts.Trim, "trim", string(fstrim.Off), "How the data filesystem is trimmed: off, discard, boot or shutdown")
+	fs.DurationVar(&opts.TrimInterval, "trim-interval", 7*24*time.Hour, "The minimum interval between trims of the data filesystem")
+
+	return &fs
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/immutos/matchstick/internal/cmdline"
+	"github.com/immutos/matchstick/internal/config"
+	"github.com/immutos/matchstick/internal/dmi"
+	"github.com/immutos/matchstick/internal/switchroot"
+)
+
+// Load layers the SMBIOS OEM strings, the kernel command line and the
+// environment (in increasing order of precedence) on top of opts. In a
+// container, only the environment is consulted. The dirs file (if any) is in
+// the image, so isn't read until it's mounted (see ReadDirsFile).
+func Load(opts *Options, container bool) error {
+	if !container {
+		// SMBIOS OEM strings take precedence over the built-in defaults (but not
+		// the kernel command line).
+		if m, err := dmi.AsMap(); err == nil {
+			if err := Decode(opts, m); err != nil {
+				return fmt.Errorf("error decoding SMBIOS OEM strings: %w", err)
+			}
+		}
+
+		// Parse the kernel command line.
+		cl := cmdline.NewCmdLine()
+		if cl.Err != nil {
+			return fmt.Errorf("error reading /proc/cmdline: %w", cl.Err)
+		}
+
+		params := make(map[string][]string)
+		for _, p := range cl.Params {
+			params[p.Key] = append(params[p.Key], p.Value)
+		}
+
+		// systemd.volatile= is applied first, so matchstick's own options can
+		// refine it.
+		if values := params[config.SystemdVolatileParam]; len(values) > 0 {
+			if err := config.ApplySystemdVolatile(opts, values[len(values)-1]); err != nil {
+				return fmt.Errorf("error decoding command line: %w", err)
+			}
+		}
+
+		if err := DecodeMulti(opts, params); err != nil {
+			return fmt.Errorf("error decoding command line: %w", err)
+		}
+
+		// When running from an initramfs, the real root is described by the
+		// kernel's own parameters (unless overridden).
+		if switchroot.IsInitramfs("/") {
+			kernelRootParams(opts, params)
+		}
+	}
+
+	// Environment variables take precedence over the kernel command line (and
+	// are the only source of configuration when running in a container).
+	if err := Decode(opts, EnvironAsMap(os.Environ())); err != nil {
+		return fmt.Errorf("error decoding environment variables: %w", err)
+	}
+
+	NormalizeCmd(opts)
+
+	return nil
+}
+
+// kernelRootParams defaults the real root options to the kernel's root=,
+// rootfstype= and rootflags= parameters.
+func kernelRootParams(opts *Options, params map[string][]string) {
+	for _, p := range []struct {
+		key   string
+		value *string
+	}{
+		{"root", &opts.Root},
+		{"rootfstype", &opts.RootFSType},
+		{"rootflags", &opts.RootOptions},
+	} {
+		if values := params[p.key]; *p.value == "" && len(values) > 0 {
+			*p.value = values[len(values)-1]
+		}
+	}
+}
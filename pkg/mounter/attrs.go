@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mounter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultLabelXattr is the extended attribute a security label is stored in,
+// unless another is named.
+const DefaultLabelXattr = "security.selinux"
+
+// Attrs are the ownership, permissions and security label of a directory
+// created by matchstick (eg. an overlay upper directory).
+type Attrs struct {
+	// Mode is the permission bits (as for chmod(2)), zero leaves them
+	// unchanged.
+	Mode uint32 `json:"mode,omitempty"`
+	// UID and GID are the owner, -1 leaves them unchanged.
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	// Label is the security label, either an SELinux context or name=value
+	// to set another extended attribute (eg. security.SMACK64=_). Empty
+	// leaves it unchanged.
+	Label string `json:"label,omitempty"`
+}
+
+// LabelXattr returns the name and value of the extended attribute that
+// stores the label.
+func (a Attrs) LabelXattr() (string, string) {
+	if name, value, ok := strings.Cut(a.Label, "="); ok {
+		return name, value
+	}
+
+	return DefaultLabelXattr, a.Label
+}
+
+func (a Attrs) String() string {
+	s := fmt.Sprintf("mode=%#o,uid=%d,gid=%d", a.Mode, a.UID, a.GID)
+	if a.Label != "" {
+		s += ",label=" + a.Label
+	}
+
+	return s
+}
+
+// Chattr sets the attributes of the directory at path (those that differ),
+// it does nothing if attrs is nil.
+func Chattr(m Mounter, path string, attrs *Attrs) error {
+	if attrs == nil {
+		return nil
+	}
+
+	return m.Chattr(path, *attrs)
+}
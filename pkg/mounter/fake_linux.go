@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mounter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Fake is an in-memory Mounter, which records the operations performed. As
+// on a real system, mounting on (or creating a directory below) a directory
+// that doesn't exist fails.
+type Fake struct {
+	mu sync.Mutex
+	// Ops are the successful operations, in order.
+	Ops []Op
+	// dirs are the directories that exist.
+	dirs map[string]bool
+	// mounts are the mounted targets (a stack per target).
+	mounts map[string]int
+	// errs are the errors injected for operations (by kind and target).
+	errs map[string]error
+}
+
+// NewFake returns a fake in which the given directories (and "/") exist.
+func NewFake(dirs ...string) *Fake {
+	f := &Fake{
+		dirs:   map[string]bool{"/": true},
+		mounts: map[string]int{},
+		errs:   map[string]error{},
+	}
+
+	for _, dir := range dirs {
+		for dir = filepath.Clean(dir); !f.dirs[dir]; dir = filepath.Dir(dir) {
+			f.dirs[dir] = true
+		}
+	}
+
+	return f
+}
+
+// Fail makes operations of the given kind on target fail with err.
+func (f *Fake) Fail(kind, target string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errs[kind+" "+filepath.Clean(target)] = err
+}
+
+// Exists returns true if the directory exists.
+func (f *Fake) Exists(dir string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.dirs[filepath.Clean(dir)]
+}
+
+// Mounts returns the targets of the mounts performed (in order), ignoring
+// remounts and mounts that were later unmounted.
+func (f *Fake) Mounts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var targets []string
+	for _, op := range f.Ops {
+		switch {
+		case op.Kind == "mount" && op.Flags&changeFlags == 0:
+			targets = append(targets, op.Target)
+		case op.Kind == "unmount":
+			if i := slices.Index(targets, op.Target); i >= 0 {
+				targets = slices.Delete(targets, i, i+1)
+			}
+		}
+	}
+
+	return targets
+}
+
+// changeFlags are the mount flags that change an existing mount, rather than
+// creating a new one.
+const changeFlags = unix.MS_REMOUNT | unix.MS_SHARED | unix.MS_SLAVE | unix.MS_PRIVATE | unix.MS_UNBINDABLE
+
+func (f *Fake) Mount(source, target, fstype string, flags uintptr, data string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target = filepath.Clean(target)
+
+	if err := f.errs["mount "+target]; err != nil {
+		return err
+	}
+
+	if !f.dirs[target] {
+		return &os.PathError{Op: "mount", Path: target, Err: unix.ENOENT}
+	}
+
+	if flags&changeFlags != 0 && f.mounts[target] == 0 && target != "/" {
+		return &os.PathError{Op: "mount", Path: target, Err: unix.EINVAL}
+	}
+
+	if flags&changeFlags == 0 {
+		f.mounts[target]++
+	}
+
+	f.Ops = append(f.Ops, Op{Kind: "mount", Source: source, Target: target, FSType: fstype, Flags: flags, Data: data})
+
+	return nil
+}
+
+func (f *Fake) Unmount(target string, _ int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target = filepath.Clean(target)
+
+	if err := f.errs["unmount "+target]; err != nil {
+		return err
+	}
+
+	if f.mounts[target] == 0 {
+		return &os.PathError{Op: "umount", Path: target, Err: unix.EINVAL}
+	}
+
+	f.mounts[target]--
+	f.Ops = append(f.Ops, Op{Kind: "unmount", Target: target})
+
+	return nil
+}
+
+func (f *Fake) MkdirAll(path string, _ os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path = filepath.Clean(path)
+
+	if err := f.errs["mkdir "+path]; err != nil {
+		return err
+	}
+
+	if f.dirs[path] {
+		return nil
+	}
+
+	for dir := path; !f.dirs[dir]; dir = filepath.Dir(dir) {
+		f.dirs[dir] = true
+	}
+
+	f.Ops = append(f.Ops, Op{Kind: "mkdir", Target: path})
+
+	return nil
+}
+
+func (f *Fake) Chattr(path string, attrs Attrs) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path = filepath.Clean(path)
+
+	if err := f.errs["chattr "+path]; err != nil {
+		return err
+	}
+
+	if !f.dirs[path] {
+		return &os.PathError{Op: "stat", Path: path, Err: unix.ENOENT}
+	}
+
+	f.Ops = append(f.Ops, Op{Kind: "chattr", Target: path, Data: attrs.String()})
+
+	return nil
+}
+
+func (f *Fake) IsMountpoint(path string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path = filepath.Clean(path)
+
+	if !f.dirs[path] {
+		return false, &os.PathError{Op: "stat", Path: path, Err: unix.ENOENT}
+	}
+
+	return path == "/" || f.mounts[path] > 0, nil
+}
+
+// Exec records the exec, and returns (as if it had failed, but with a nil
+// error) unless an error has been injected.
+func (f *Fake) Exec(argv0 string, argv, envv []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.errs["exec "+filepath.Clean(argv0)]; err != nil {
+		return err
+	}
+
+	f.Ops = append(f.Ops, Op{Kind: "exec", Target: argv0, Argv: slices.Clone(argv)})
+
+	return nil
+}
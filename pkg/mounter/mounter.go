@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package mounter abstracts the system calls used to set up the filesystem
+// (and execute init), so that the planning and ordering of mount operations
+// can be tested (and fuzzed) without root or a virtual machine.
+package mounter
+
+import (
+	"fmt"
+	"os"
+)
+
+// Mounter performs filesystem operations.
+type Mounter interface {
+	// Mount mounts source on target (as for mount(2)).
+	Mount(source, target, fstype string, flags uintptr, data string) error
+	// Unmount unmounts target (as for umount2(2)).
+	Unmount(target string, flags int) error
+	// MkdirAll creates a directory, along with any missing parents.
+	MkdirAll(path string, perm os.FileMode) error
+	// Chattr sets the ownership, permissions and security label of a
+	// directory, where they differ from attrs.
+	Chattr(path string, attrs Attrs) error
+	// IsMountpoint returns true if path is a mountpoint.
+	IsMountpoint(path string) (bool, error)
+	// Exec replaces the current process (as for execve(2)), it only returns
+	// on failure.
+	Exec(argv0 string, argv, envv []string) error
+}
+
+// Propagate sets the propagation type of the mount on target, flags being
+// one of unix.MS_SHARED, unix.MS_SLAVE or unix.MS_PRIVATE (optionally with
+// unix.MS_REC). It does nothing if flags is zero.
+func Propagate(m Mounter, target string, flags uintptr) error {
+	if flags == 0 {
+		return nil
+	}
+
+	return m.Mount("", target, "", flags, "")
+}
+
+// Op is an operation recorded by a Fake (or a Recorder).
+type Op struct {
+	// Kind is the kind of operation: mount, unmount, mkdir, chattr or exec.
+	Kind   string
+	Source string
+	Target string
+	FSType string
+	Flags  uintptr
+	Data   string
+	// Argv is the argument vector of an exec.
+	Argv []string
+	// Err is the error the operation failed with (only recorded by a
+	// Recorder).
+	Err error
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case "mount":
+		return fmt.Sprintf("mount %s %s %s %#x %s", op.Source, op.Target, op.FSType, op.Flags, op.Data)
+	case "chattr":
+		return fmt.Sprintf("chattr %s %s", op.Target, op.Data)
+	case "exec":
+		return fmt.Sprintf("exec %s %q", op.Target, op.Argv)
+	default:
+		return op.Kind + " " + op.Target
+	}
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mounter_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/pkg/mounter"
+	"golang.org/x/sys/unix"
+)
+
+func TestSystemChattr(t *testing.T) {
+	dir := t.TempDir()
+
+	attrs := mounter.Attrs{Mode: 0o1750, UID: os.Getuid(), GID: -1}
+	if err := (mounter.System{}).Chattr(dir, attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o750 || info.Mode()&os.ModeSticky == 0 {
+		t.Errorf("mode = %v, want drwxr-x--T", info.Mode())
+	}
+
+	// Symlinks (and anything else that isn't a directory) are rejected.
+	link := dir + "/link"
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (mounter.System{}).Chattr(link, mounter.Attrs{Mode: 0o700, UID: -1, GID: -1}); !errors.Is(err, unix.ENOTDIR) {
+		t.Errorf("expected ENOTDIR for a symlink, got %v", err)
+	}
+
+	attrs.Label = "user.matchstick=test"
+	if err := (mounter.System{}).Chattr(dir, attrs); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("user extended attributes are unsupported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	if n, err := unix.Getxattr(dir, "user.matchstick", buf); err != nil || string(buf[:n]) != "test" {
+		t.Errorf("label = %q, %v, want test", buf[:n], err)
+	}
+}
+
+func TestFake(t *testing.T) {
+	m := mounter.NewFake("/mnt")
+
+	if err := m.Mount("tmpfs", "/mnt/data", "tmpfs", 0, ""); !errors.Is(err, unix.ENOENT) {
+		t.Errorf("expected ENOENT mounting on a missing directory, got %v", err)
+	}
+
+	if err := m.MkdirAll("/mnt/data/etc", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Exists("/mnt/data") {
+		t.Error("expected parent directories to be created")
+	}
+
+	if err := m.Mount("tmpfs", "/mnt/data", "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Mount("", "/mnt/data", "", unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mounter.Propagate(m, "/mnt/data", unix.MS_SHARED|unix.MS_REC); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mounter.Propagate(m, "/mnt/data/etc", unix.MS_SHARED); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL changing the propagation of a non-mountpoint, got %v", err)
+	}
+
+	if mounted, err := m.IsMountpoint("/mnt/data"); err != nil || !mounted {
+		t.Errorf("IsMountpoint() = %v, %v, want true", mounted, err)
+	}
+
+	if err := m.Unmount("/mnt/data", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Unmount("/mnt/data", 0); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL unmounting twice, got %v", err)
+	}
+
+	m.Fail("mount", "/mnt", unix.EBUSY)
+
+	if err := m.Mount("tmpfs", "/mnt", "tmpfs", 0, ""); !errors.Is(err, unix.EBUSY) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+
+	if err := m.Mount("tmpfs", "/mnt/data/etc", "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Mounts(); !reflect.DeepEqual(got, []string{"/mnt/data/etc"}) {
+		t.Errorf("Mounts() = %v, want [/mnt/data/etc]", got)
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mounter
+
+import (
+	"slices"
+	"sync"
+)
+
+// Recorder wraps a Mounter, recording the mounts, unmounts and execs
+// performed (including those that failed).
+type Recorder struct {
+	Mounter
+
+	mu  sync.Mutex
+	ops []Op
+}
+
+// NewRecorder returns a recorder wrapping m.
+func NewRecorder(m Mounter) *Recorder {
+	return &Recorder{Mounter: m}
+}
+
+// Ops returns the recorded operations, in order.
+func (r *Recorder) Ops() []Op {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.ops)
+}
+
+func (r *Recorder) record(op Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ops = append(r.ops, op)
+}
+
+func (r *Recorder) Mount(source, target, fstype string, flags uintptr, data string) error {
+	err := r.Mounter.Mount(source, target, fstype, flags, data)
+	r.record(Op{Kind: "mount", Source: source, Target: target, FSType: fstype, Flags: flags, Data: data, Err: err})
+
+	return err
+}
+
+func (r *Recorder) Unmount(target string, flags int) error {
+	err := r.Mounter.Unmount(target, flags)
+	r.record(Op{Kind: "unmount", Target: target, Err: err})
+
+	return err
+}
+
+// Record records op, an operation performed outside of m (eg. a FUSE
+// filesystem mounted by a helper), if m is a Recorder.
+func Record(m Mounter, op Op) {
+	if r, ok := m.(*Recorder); ok {
+		r.record(op)
+	}
+}
+
+// Exec records the exec before performing it (as it only returns on
+// failure).
+func (r *Recorder) Exec(argv0 string, argv, envv []string) error {
+	r.record(Op{Kind: "exec", Target: argv0, Argv: slices.Clone(argv)})
+
+	return r.Mounter.Exec(argv0, argv, envv)
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mounter
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/trace"
+	"golang.org/x/sys/unix"
+)
+
+// System performs the operations on the running system.
+type System struct{}
+
+func (System) Mount(source, target, fstype string, flags uintptr, data string) error {
+	return trace.Mount(source, target, fstype, flags, data)
+}
+
+func (System) Unmount(target string, flags int) error {
+	return unix.Unmount(target, flags)
+}
+
+func (System) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// IsMountpoint returns true if path is on a different filesystem to its
+// parent directory (or is the root directory).
+func (System) IsMountpoint(path string) (bool, error) {
+	var st, parent unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return false, err
+	}
+
+	if err := unix.Stat(filepath.Dir(path), &parent); err != nil {
+		return false, err
+	}
+
+	return st.Dev != parent.Dev || st.Ino == parent.Ino, nil
+}
+
+func (System) Exec(argv0 string, argv, envv []string) error {
+	return trace.Exec(argv0, argv, envv)
+}
+
+func (System) Chattr(path string, attrs Attrs) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+
+	// Chmod follows symlinks, so only directories are changed.
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return &os.PathError{Op: "chattr", Path: path, Err: unix.ENOTDIR}
+	}
+
+	chowned := (attrs.UID >= 0 && uint32(attrs.UID) != st.Uid) || (attrs.GID >= 0 && uint32(attrs.GID) != st.Gid)
+	if chowned {
+		if err := unix.Lchown(path, attrs.UID, attrs.GID); err != nil {
+			return &os.PathError{Op: "chown", Path: path, Err: err}
+		}
+	}
+
+	// Changing the owner clears the setuid and setgid bits, so the mode is
+	// set afterwards.
+	if attrs.Mode != 0 && (chowned || attrs.Mode != st.Mode&07777) {
+		if err := unix.Chmod(path, attrs.Mode); err != nil {
+			return &os.PathError{Op: "chmod", Path: path, Err: err}
+		}
+	}
+
+	if attrs.Label != "" {
+		name, value := attrs.LabelXattr()
+
+		buf := make([]byte, 256)
+		n, err := unix.Lgetxattr(path, name, buf)
+		if err == nil && string(bytes.TrimRight(buf[:n], "\x00")) == value {
+			return nil
+		}
+		if err != nil && !errors.Is(err, unix.ENODATA) && !errors.Is(err, unix.ERANGE) {
+			return &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package overlay is the public interface to matchstick's overlay planning
+// and mounting. A Plan describes every mount matchstick would perform for a
+// set of options (without performing them), and the overlays it contains can
+// be mounted individually, in any of the supported modes.
+package overlay
+
+import (
+	"fmt"
+
+	"github.com/immutos/matchstick/internal/overlay"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// RootOverlayDir is where the overlay of the whole root filesystem is
+// mounted, before pivoting into it.
+const RootOverlayDir = plan.RootOverlayDir
+
+// DefaultFuseOverlayfs is the default path of the fuse-overlayfs binary.
+const DefaultFuseOverlayfs = overlay.DefaultFuseOverlayfs
+
+// Plan is the full set of mount operations (and the argv of init) for a set
+// of options.
+type Plan = plan.Plan
+
+// Mount is a single mount operation.
+type Mount = plan.Mount
+
+// Overlay is an overlay filesystem mounted on top of a directory.
+type Overlay = plan.Overlay
+
+// ExtraMount is an additional filesystem, mounted after the overlays.
+type ExtraMount = plan.ExtraMount
+
+// Mode is how overlays are mounted.
+type Mode = overlay.Mode
+
+const (
+	// Auto probes for the first mode that works.
+	Auto = overlay.Auto
+	// Kernel uses the kernel's overlay filesystem.
+	Kernel = overlay.Kernel
+	// UserXattr uses the kernel's overlay filesystem with the userxattr
+	// option, which can be mounted within a user namespace.
+	UserXattr = overlay.UserXattr
+	// Fuse uses fuse-overlayfs.
+	Fuse = overlay.Fuse
+)
+
+// ParseMode parses an overlay mode.
+func ParseMode(s string) (Mode, error) {
+	return overlay.ParseMode(s)
+}
+
+// Prepare creates the upper and work directories of an overlay (and its
+// staging directory, for the root overlay), and sets their attributes (if
+// planned).
+func Prepare(m mounter.Mounter, o Overlay) error {
+	// The root overlay is mounted on a staging directory.
+	if o.Dir == "/" {
+		if err := m.MkdirAll(o.Mount.Target, 0o755); err != nil {
+			return fmt.Errorf("failed to create %q: %w", o.Mount.Target, err)
+		}
+	}
+
+	if err := m.MkdirAll(o.UpperDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create upperDir %q: %w", o.UpperDir, err)
+	}
+
+	if err := m.MkdirAll(o.WorkDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create workDir %q: %w", o.WorkDir, err)
+	}
+
+	// Directories from a previous boot are fixed up too.
+	for _, dir := range []string{o.UpperDir, o.WorkDir} {
+		if err := mounter.Chattr(m, dir, o.Mount.Attrs); err != nil {
+			return fmt.Errorf("failed to set attributes of %q: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply mounts a (prepared) overlay in the given mode. fuseOverlayfs is only
+// used in the Fuse mode (which mounts with fuse-overlayfs, recording the mount
+// if m is a Recorder).
+func Apply(m mounter.Mounter, o Overlay, mode Mode, fuseOverlayfs string) error {
+	var err error
+	switch mode {
+	case Kernel, UserXattr:
+		err = m.Mount(o.Mount.Source, o.Mount.Target, o.Mount.FSType, o.Mount.Flags, overlay.Options(mode, o.Mount.Data))
+	default:
+		err = overlay.Mount(m, mode, fuseOverlayfs, o.Mount.Target, o.Mount.Data)
+	}
+	if err != nil {
+		return err
+	}
+
+	return mounter.Propagate(m, o.Mount.Target, o.Mount.Propagation)
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package overlay
+
+import (
+	"github.com/immutos/matchstick/internal/overlay"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+)
+
+// New computes the plan for opts. args are the arguments passed through to
+// init. Directories that don't exist are skipped (unless required).
+func New(opts *config.Options, args []string) (*Plan, error) {
+	return plan.New(opts, args)
+}
+
+// Probe returns the first mode in which an overlay can be mounted with m,
+// using dir (on the data filesystem) as scratch space.
+func Probe(m mounter.Mounter, dir, fuseOverlayfs string) (Mode, error) {
+	return overlay.Probe(m, dir, fuseOverlayfs)
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package overlay_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"golang.org/x/sys/unix"
+)
+
+func TestPrepare(t *testing.T) {
+	mount := t.TempDir()
+	dir := t.TempDir()
+
+	opts := config.Defaults()
+	opts.Volatile = true
+	opts.Mount = mount
+	opts.Dirs = []string{dir}
+
+	p, err := overlay.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Overlays) != 1 {
+		t.Fatalf("expected 1 overlay, got %d", len(p.Overlays))
+	}
+
+	m := mounter.NewFake(mount, dir)
+
+	o := p.Overlays[0]
+	if err := overlay.Prepare(m, o); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{o.UpperDir, o.WorkDir} {
+		if !m.Exists(dir) {
+			t.Errorf("expected %q to be created", dir)
+		}
+	}
+
+	if o.UpperDir != filepath.Join(mount, dir) {
+		t.Errorf("upperdir %q is not on the data filesystem", o.UpperDir)
+	}
+}
+
+func TestPrepareAttrs(t *testing.T) {
+	o := overlay.Overlay{
+		Dir:      "/home",
+		UpperDir: "/mnt/data/home",
+		WorkDir:  "/mnt/data/.home-work",
+		Mount:    overlay.Mount{Attrs: &mounter.Attrs{Mode: 0o755, UID: 0, GID: 0}},
+	}
+
+	m := mounter.NewFake("/mnt/data")
+	if err := overlay.Prepare(m, o); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, op := range m.Ops {
+		got = append(got, op.String())
+	}
+
+	want := []string{
+		"mkdir /mnt/data/home",
+		"mkdir /mnt/data/.home-work",
+		"chattr /mnt/data/home mode=0755,uid=0,gid=0",
+		"chattr /mnt/data/.home-work mode=0755,uid=0,gid=0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ops = %q, want %q", got, want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	o := overlay.Overlay{
+		Dir: "/etc",
+		Mount: overlay.Mount{
+			Source: "overlay",
+			Target: "/etc",
+			FSType: "overlay",
+			Data:   "lowerdir=/etc,workdir=/mnt/data/.etc-work,upperdir=/mnt/data/etc",
+		},
+	}
+
+	m := mounter.NewFake("/etc")
+
+	if err := overlay.Apply(m, o, overlay.UserXattr, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := o.Mount.Data + ",userxattr"; m.Ops[0].Data != want {
+		t.Errorf("overlay options = %q, want %q", m.Ops[0].Data, want)
+	}
+
+	o.Mount.Propagation = unix.MS_SHARED
+
+	m = mounter.NewFake("/etc")
+
+	if err := overlay.Apply(m, o, overlay.Kernel, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Ops) != 2 || m.Ops[1].Flags != unix.MS_SHARED || m.Ops[1].Target != "/etc" {
+		t.Errorf("unexpected operations: %+v", m.Ops)
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package storage is the public interface to matchstick's device resolution
+// and data filesystem setup. The data filesystem is set up by a provider
+// (built-in, or an out-of-tree executable), which resolves the data device,
+// prepares it and mounts it.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/internal/provider"
+	"github.com/immutos/matchstick/internal/retry"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+)
+
+// DefaultProvidersDir is the default directory searched for external
+// providers.
+const DefaultProvidersDir = provider.DefaultDir
+
+// Spec describes the data filesystem to be set up.
+type Spec = provider.Spec
+
+// Provider sets up the data filesystem.
+type Provider = provider.Provider
+
+// GetProvider returns the named provider. Built-in providers take precedence
+// over external providers (executables named after the provider in dir).
+func GetProvider(name, dir string) (Provider, error) {
+	return provider.Get(name, dir)
+}
+
+// DevicePath returns the path of a device given either as a path, or as a
+// tag (eg. LABEL=data).
+func DevicePath(device string) string {
+	return plan.DevicePath(device)
+}
+
+// ResolveDevice resolves any tags and symlinks (eg. /dev/disk/by-label/...)
+// in the device path. If the device can't be resolved, it is returned
+// unchanged.
+func ResolveDevice(device string) string {
+	return plan.ResolveDevice(device)
+}
+
+// NewSpec returns the spec of the planned data filesystem.
+func NewSpec(opts *config.Options, p *overlay.Plan) (*Spec, error) {
+	if p.Data == nil {
+		return nil, fmt.Errorf("no data filesystem is planned")
+	}
+
+	return &Spec{
+		Data:    opts.Data,
+		FSType:  p.Data.FSType,
+		Mount:   p.Data.Target,
+		Flags:   p.Data.Flags,
+		Options: p.Data.Data,
+	}, nil
+}
+
+// MountData sets up the planned data filesystem with its provider, retrying
+// transient failures (as configured in opts). Built-in providers mount with
+// m. It returns the resolved device.
+func MountData(ctx context.Context, m mounter.Mounter, opts *config.Options, p *overlay.Plan) (string, error) {
+	prov, err := GetProvider(p.Provider, opts.ProvidersDir)
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := NewSpec(opts, p)
+	if err != nil {
+		return "", err
+	}
+	spec.Mounter = m
+
+	policy := retry.Policy{
+		Attempts: opts.Retries,
+		Delay:    opts.RetryDelay,
+		MaxDelay: 10 * time.Second,
+	}
+
+	var device string
+	err = retry.Do(ctx, policy, "resolve data device", func() (err error) {
+		device, err = prov.Resolve(ctx, spec)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve data device: %w", err)
+	}
+
+	if err := prov.Prepare(ctx, spec, device); err != nil {
+		return "", fmt.Errorf("failed to prepare data device: %w", err)
+	}
+
+	err = retry.Do(ctx, policy, "mount data device", func() error {
+		return prov.Mount(ctx, spec, device)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to mount data device: %w", err)
+	}
+
+	if err := mounter.Propagate(m, p.Data.Target, p.Data.Propagation); err != nil {
+		return "", fmt.Errorf("failed to set data mount propagation: %w", err)
+	}
+
+	if err := mounter.Chattr(m, p.Data.Target, p.Data.Attrs); err != nil {
+		return "", fmt.Errorf("failed to set data filesystem attributes: %w", err)
+	}
+
+	return device, nil
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/immutos/matchstick/pkg/mounter"
+	"github.com/immutos/matchstick/pkg/overlay"
+	"github.com/immutos/matchstick/pkg/storage"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewSpec(t *testing.T) {
+	opts := config.Defaults()
+	opts.Data = "LABEL=data"
+	opts.DataFSType = "ext4"
+	opts.Dirs = nil
+
+	p, err := overlay.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := storage.NewSpec(opts, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Data != "LABEL=data" || spec.FSType != "ext4" || spec.Mount != "/mnt/data" {
+		t.Errorf("unexpected spec: %+v", spec)
+	}
+
+	if _, err := storage.NewSpec(opts, &overlay.Plan{}); err == nil {
+		t.Error("expected error when no data filesystem is planned")
+	}
+}
+
+func TestGetProvider(t *testing.T) {
+	prov, err := storage.GetProvider("block", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prov.Name() != "block" {
+		t.Errorf("Name() = %q, want block", prov.Name())
+	}
+
+	if _, err := storage.GetProvider("missing", t.TempDir()); err == nil {
+		t.Error("expected error for a missing provider")
+	}
+}
+
+func TestMountData(t *testing.T) {
+	opts := config.Defaults()
+	opts.Volatile = true
+	opts.Dirs = nil
+
+	p, err := overlay.New(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := mounter.NewFake("/mnt/data")
+
+	if _, err := storage.MountData(context.Background(), m, opts, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Mounts(); !reflect.DeepEqual(got, []string{"/mnt/data"}) {
+		t.Errorf("Mounts() = %v, want [/mnt/data]", got)
+	}
+
+	// The data filesystem is mounted nosuid and nodev by default.
+	if op := m.Ops[len(m.Ops)-1]; op.FSType != "tmpfs" || op.Flags != unix.MS_NOSUID|unix.MS_NODEV {
+		t.Errorf("unexpected mount: %v", op)
+	}
+
+	// The data mountpoint doesn't exist.
+	opts.Retries = 1
+
+	if _, err := storage.MountData(context.Background(), mounter.NewFake(), opts, p); !errors.Is(err, unix.ENOENT) {
+		t.Errorf("expected ENOENT, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/i18n"
+	"github.com/immutos/matchstick/internal/kexec"
+	"github.com/immutos/matchstick/internal/plan"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// recoveryMount is where the recovery device is mounted.
+const recoveryMount = "/run/matchstick/recovery"
+
+// bootRecovery boots the recovery kernel with kexec (if configured, and
+// there have been enough consecutive failed boots). It only returns if the
+// recovery kernel can't be booted.
+func bootRecovery(failures int) {
+	if failureOpts.RecoveryKernel == "" || failures < failureOpts.RecoveryAfter {
+		return
+	}
+
+	slog.Warn("Booting recovery kernel", slog.Int("failures", failures),
+		slog.String("kernel", failureOpts.RecoveryKernel))
+	printConsole(printer.Sprintf(i18n.MsgRecovery, failures))
+
+	if err := loadRecovery(); err != nil {
+		slog.Error("Failed to load recovery kernel", slog.Any("error", err))
+		return
+	}
+
+	if err := kexec.Exec(); err != nil {
+		slog.Error("Failed to boot recovery kernel", slog.Any("error", err))
+	}
+}
+
+// loadRecovery loads the recovery kernel and initrd from the recovery device.
+func loadRecovery() error {
+	return withRecoveryDevice(&failureOpts, unix.MS_RDONLY, loadRecoveryKernel)
+}
+
+// loadRecoveryKernel loads the recovery kernel and initrd (relative to root).
+func loadRecoveryKernel(root string) error {
+	cmdline := failureOpts.RecoveryCmdline
+	if cmdline == "" {
+		var err error
+		if cmdline, err = kexec.CurrentCmdline(kexec.DefaultCmdlinePath); err != nil {
+			return fmt.Errorf("failed to read kernel command line: %w", err)
+		}
+	}
+
+	var initrd string
+	if failureOpts.RecoveryInitrd != "" {
+		initrd = filepath.Join(root, failureOpts.RecoveryInitrd)
+	}
+
+	return kexec.Load(filepath.Join(root, failureOpts.RecoveryKernel), initrd, cmdline)
+}
+
+// withRecoveryDevice calls fn with the root of the recovery device, which is
+// mounted (with flags) for the duration.
+func withRecoveryDevice(opts *config.Options, flags uintptr, fn func(root string) error) error {
+	if opts.RecoveryDevice == "" {
+		return errors.New("no recovery device is configured")
+	}
+
+	if err := sys.MkdirAll(recoveryMount, 0o755); err != nil {
+		return err
+	}
+
+	device := plan.ResolveDevice(opts.RecoveryDevice)
+	if err := sys.Mount(device, recoveryMount, opts.RecoveryFSType, flags|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount recovery device: %w", err)
+	}
+	defer func() {
+		if err := sys.Unmount(recoveryMount, 0); err != nil {
+			slog.Warn("Failed to unmount recovery device", slog.Any("error", err))
+		}
+	}()
+
+	return fn(recoveryMount)
+}
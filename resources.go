@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/resources"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// applyResources applies the resource policy (rlimits, oom_score_adj and
+// cgroup placement), which is inherited by init (whether it is executed or
+// supervised).
+func applyResources(opts *config.Options, container bool) {
+	var errs []error
+
+	for _, s := range opts.Rlimits {
+		r, err := resources.ParseRlimit(s)
+		if err == nil {
+			err = r.Apply()
+		}
+		errs = append(errs, err)
+	}
+
+	if opts.OOMScoreAdj != 0 {
+		if err := resources.SetOOMScoreAdj(opts.OOMScoreAdj); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set oom_score_adj: %w", err))
+		}
+	}
+
+	if opts.Cgroup != "" {
+		errs = append(errs, joinCgroup(opts.Cgroup, container))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		degrade("Failed to apply resource policy", slog.Any("error", err))
+	}
+}
+
+func joinCgroup(cgroup string, container bool) error {
+	// The container runtime is responsible for mounting cgroups.
+	if !container && !resources.Cgroup2Mounted(resources.DefaultCgroupRoot) {
+		slog.Info("Mounting " + resources.DefaultCgroupRoot)
+
+		err := sys.Mount("cgroup2", resources.DefaultCgroupRoot, "cgroup2", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "nsdelegate")
+		if err != nil {
+			return fmt.Errorf("failed to mount cgroup2: %w", err)
+		}
+	}
+
+	if err := resources.JoinCgroup(resources.DefaultCgroupRoot, cgroup); err != nil {
+		return fmt.Errorf("failed to join cgroup %s: %w", cgroup, err)
+	}
+
+	slog.Debug("Joined cgroup", slog.String("cgroup", cgroup))
+
+	return nil
+}
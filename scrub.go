@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/immutos/matchstick/internal/scrub"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioClassIdle    = 3
+	ioprioClassShift   = 13
+	ioprioWhoProcess   = 1
+	lowestNicePriority = 19
+)
+
+// runScrub implements the scrub subcommand.
+func runScrub(args []string) error {
+	var fs pflag.FlagSet
+	fs.Init("scrub", pflag.ContinueOnError)
+
+	mount := fs.String("mount", "/mnt/data", "The mountpoint of the data filesystem")
+	rate := fs.Int64("rate", 4, "The maximum rate (in MiB/s) at which files will be read")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	slog.Info("Scrubbing data filesystem", slog.String("mount", *mount))
+
+	res, err := scrub.Scrub(context.Background(), scrub.Options{
+		Root: *mount,
+		Rate: *rate * 1024 * 1024,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Finished scrubbing data filesystem",
+		slog.Int("verified", res.Verified), slog.Int("updated", res.Updated),
+		slog.Int("corrupted", len(res.Corrupted)))
+
+	if len(res.Corrupted) > 0 {
+		return fmt.Errorf("detected %d corrupted files", len(res.Corrupted))
+	}
+
+	return nil
+}
+
+// startScrub starts the scrub subcommand as a low priority background process
+// that will outlive the exec of init.
+func startScrub(opts *config.Options) error {
+	return startBackground("scrub", "--mount="+opts.Mount, "--rate="+strconv.FormatInt(opts.ScrubRate, 10))
+}
+
+// startBackground starts a subcommand as a low priority background process
+// that will outlive the exec of init.
+func startBackground(subcommand string, args ...string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(self, append([]string{subcommand}, args...)...)
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	pid := cmd.Process.Pid
+
+	slog.Info("Started background "+subcommand, slog.Int("pid", pid))
+
+	if err := unix.Setpriority(unix.PRIO_PROCESS, pid, lowestNicePriority); err != nil {
+		slog.Warn("Failed to lower "+subcommand+" CPU priority", slog.Any("error", err))
+	}
+
+	ioprio := ioprioClassIdle << ioprioClassShift
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(ioprio)); errno != 0 {
+		slog.Warn("Failed to lower "+subcommand+" I/O priority", slog.Any("error", errno))
+	}
+
+	// Init will inherit (and reap) the process.
+	return cmd.Process.Release()
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/randomseed"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// randomSeedName is the name of the file (in the root of the data
+// filesystem) that persists the random seed.
+const randomSeedName = ".matchstick-random-seed"
+
+// loadRandomSeed loads the persisted random seed into the kernel's random
+// pool, and replaces it with a fresh one for the next boot.
+func loadRandomSeed(opts *config.Options) {
+	path := filepath.Join(opts.Mount, randomSeedName)
+
+	slog.Debug("Loading random seed", slog.String("path", path), slog.Bool("credit", opts.RandomSeedCredit))
+
+	if err := randomseed.Load(path, randomseed.DefaultDevice, opts.RandomSeedCredit); err != nil {
+		slog.Warn("Failed to load random seed", slog.Any("error", err))
+	}
+
+	if err := randomseed.Refresh(path); err != nil {
+		slog.Warn("Failed to refresh random seed", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/immutos/matchstick/internal/emergency"
+	"github.com/immutos/matchstick/internal/failure"
+	"github.com/immutos/matchstick/internal/i18n"
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/supervisor"
+	"github.com/immutos/matchstick/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// stableAfter is how long a supervised init must run for before it's no
+// longer considered to be restarting consecutively.
+const stableAfter = time.Minute
+
+// supervise runs init as a child process, applying the exit policy each time
+// it exits. Orphans are only reaped once the boot stages (and so any helper
+// processes they started) have finished. It never returns.
+func supervise(tracker *stage.Tracker, opts *config.Options, argv []string) {
+	// A supervised init is started from an arbitrary thread, so can't be
+	// hardened.
+	if hardeningConfigured(opts) {
+		initUntrusted = true
+		fatal("Privilege hardening is not supported with a supervised init")
+	}
+
+	policy, err := supervisor.ParsePolicy(opts.OnExit)
+	if err != nil {
+		fatal("Invalid exit policy", slog.Any("error", err))
+	}
+
+	s := &supervisor.Supervisor{Path: opts.Cmd, Argv: argv, Env: initEnviron(opts), Helpers: tracker.Done()}
+
+	var restarts int
+	for {
+		slog.Info("Starting supervised init", slog.Any("cmd", opts.Cmd))
+
+		started := time.Now()
+
+		exit, err := s.Run()
+		if err != nil {
+			fatal("Failed to start init", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+		}
+
+		ran := time.Since(started)
+
+		slog.Warn("Supervised init exited", slog.Any("cmd", opts.Cmd),
+			slog.String("status", exit.String()), slog.Duration("ran", ran))
+
+		// Init exiting after being asked to is a requested shutdown (eg.
+		// ctrl-alt-del, or a container being stopped).
+		switch exit.Signal {
+		case unix.SIGINT:
+			shutdown(unix.LINUX_REBOOT_CMD_RESTART, exit)
+		case unix.SIGTERM:
+			shutdown(unix.LINUX_REBOOT_CMD_POWER_OFF, exit)
+		}
+
+		switch policy {
+		case supervisor.Restart:
+			if ran >= stableAfter {
+				restarts = 0
+			}
+
+			restarts++
+			if restarts > opts.MaxRestarts {
+				fatal("Supervised init exited too many times", slog.Any("cmd", opts.Cmd),
+					slog.Int("restarts", restarts-1))
+			}
+
+			delay := failure.Backoff(restarts, opts.RestartDelay)
+
+			slog.Info("Restarting supervised init", slog.Int("restart", restarts), slog.Duration("delay", delay))
+
+			time.Sleep(delay)
+		case supervisor.Reboot:
+			shutdown(unix.LINUX_REBOOT_CMD_RESTART, exit)
+		case supervisor.Poweroff:
+			shutdown(unix.LINUX_REBOOT_CMD_POWER_OFF, exit)
+		case supervisor.Shell:
+			printConsole(printer.Sprintf(i18n.MsgInitExited, exit.String()))
+
+			env := append(os.Environ(), "MATCHSTICK_ERROR=init "+exit.String())
+			if err := emergency.Shell(emergency.DefaultConsole, env, lockdown); err != nil {
+				slog.Error("Emergency shell failed", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// shutdown syncs filesystems, and reboots (or powers off) the machine. When
+// not running as PID 1 it simply exits with init's exit status.
+func shutdown(cmd int, exit *supervisor.Exit) {
+	unix.Sync()
+
+	if os.Getpid() == 1 {
+		slog.Info("Shutting down", slog.Bool("reboot", cmd == unix.LINUX_REBOOT_CMD_RESTART))
+
+		if err := unix.Reboot(cmd); err != nil {
+			slog.Error("Failed to shut down", slog.Any("error", err))
+		}
+	}
+
+	os.Exit(exit.Status.ExitStatus())
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/tmpfiles"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// readTmpfiles reads the skeleton structure declared by the image, in the
+// root filesystem at root.
+func readTmpfiles(opts *config.Options, root string) []tmpfiles.Entry {
+	entries, err := tmpfiles.Read(filepath.Join("/", root, opts.TmpfilesDir))
+	if err != nil {
+		degrade("Failed to read tmpfiles configuration", slog.Any("error", err))
+		return nil
+	}
+
+	return entries
+}
+
+// applyTmpfiles creates the skeleton structure declared by the image, within
+// root (where the root filesystem init will see is).
+func applyTmpfiles(tracker *stage.Tracker, root string, entries []tmpfiles.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	slog.Info("Creating tmpfiles", slog.Int("entries", len(entries)))
+
+	err := tracker.Run(context.Background(), "tmpfiles", 0, func(ctx context.Context) error {
+		return tmpfiles.Apply(filepath.Join("/", root), entries)
+	})
+	if err != nil {
+		degrade("Failed to create tmpfiles", slog.Any("error", err))
+	}
+}
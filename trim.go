@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/immutos/matchstick/internal/fstrim"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+// runTrim implements the trim subcommand.
+func runTrim(args []string) error {
+	var fs pflag.FlagSet
+	fs.Init("trim", pflag.ContinueOnError)
+
+	mount := fs.String("mount", "/mnt/data", "The mountpoint of the data filesystem")
+	interval := fs.Duration("interval", 0, "Skip trimming if the data filesystem was trimmed within this interval")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !fstrim.Due(*mount, *interval, time.Now()) {
+		slog.Info("Data filesystem was trimmed recently, skipping", slog.String("mount", *mount))
+		return nil
+	}
+
+	slog.Info("Trimming data filesystem", slog.String("mount", *mount))
+
+	trimmed, err := fstrim.Trim(*mount)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Finished trimming data filesystem", slog.Uint64("trimmed", trimmed))
+
+	return fstrim.Record(*mount, time.Now())
+}
+
+// startTrim starts the trim subcommand as a low priority background process
+// that will outlive the exec of init.
+func startTrim(opts *config.Options) error {
+	return startBackground("trim", "--mount="+opts.Mount, "--interval="+opts.TrimInterval.String())
+}
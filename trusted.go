@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/immutos/matchstick/internal/cmdline"
+	"github.com/immutos/matchstick/internal/dmi"
+	"github.com/immutos/matchstick/internal/verify"
+	"github.com/immutos/matchstick/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// trustedConfigPath is the signed in-image config, its presence enables
+	// lockdown.
+	trustedConfigPath = "/etc/matchstick/trusted.conf"
+	// trustedKeyPath is the (base64 encoded) ed25519 public key the trusted
+	// config is signed with.
+	trustedKeyPath = "/etc/matchstick/trusted.pub"
+)
+
+// lockedDown returns true if only the trusted config should be honored.
+func lockedDown(container bool) bool {
+	if container {
+		return false
+	}
+
+	_, err := os.Stat(trustedConfigPath)
+	return err == nil
+}
+
+// decodeTrustedOptions replaces opts with the built-in defaults and the
+// options from the trusted config. It returns the options that were set
+// elsewhere (eg. on the kernel command line), which are ignored.
+func decodeTrustedOptions(opts *config.Options, fs *pflag.FlagSet) ([]string, error) {
+	var violations []string
+
+	// A dry run doesn't mount (or execute) anything, so is harmless.
+	fs.Visit(func(f *pflag.Flag) {
+		if f.Name != "dry-run" {
+			violations = append(violations, "--"+f.Name)
+		}
+	})
+
+	if m, err := dmi.AsMap(); err == nil {
+		violations = append(violations, config.OptionKeys(m)...)
+	}
+
+	cl := cmdline.NewCmdLine()
+	if cl.Err != nil {
+		return nil, fmt.Errorf("error reading /proc/cmdline: %w", cl.Err)
+	}
+
+	params := make(map[string][]string)
+	for _, p := range cl.Params {
+		params[p.Key] = append(params[p.Key], p.Value)
+	}
+
+	violations = append(violations, config.OptionKeys(params)...)
+
+	for _, key := range config.OptionKeys(config.EnvironAsMap(os.Environ())) {
+		violations = append(violations, strings.ToUpper(strings.ReplaceAll(key, ".", "_")))
+	}
+
+	key, err := os.ReadFile(trustedKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trusted config key: %w", err)
+	}
+
+	if err := verify.Signature(trustedConfigPath, strings.TrimSpace(string(key))); err != nil {
+		return nil, fmt.Errorf("error verifying trusted config: %w", err)
+	}
+
+	m, err := config.ReadOptionsFile(trustedConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trusted config: %w", err)
+	}
+
+	dryRun := opts.DryRun
+
+	*opts = config.Options{}
+	_ = config.NewFlagSet(os.Args[0], opts)
+	opts.DryRun = dryRun
+
+	if err := config.DecodeMulti(opts, m); err != nil {
+		return nil, fmt.Errorf("error decoding trusted config: %w", err)
+	}
+
+	config.NormalizeCmd(opts)
+
+	return violations, nil
+}
+
+// enforceLockdown applies the violation policy to options that were set
+// outside of the trusted config.
+func enforceLockdown(opts *config.Options, violations []string) {
+	if len(violations) == 0 {
+		return
+	}
+
+	switch opts.OnViolation {
+	case "fail":
+		fatal("Options set outside of the trusted config", slog.Any("options", violations))
+	default:
+		slog.Warn("Ignoring options set outside of the trusted config", slog.Any("options", violations))
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/immutos/matchstick/internal/stage"
+	"github.com/immutos/matchstick/internal/verify"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+// initUntrusted is set if init failed verification, or couldn't be hardened
+// (so must not be executed, even with the continue failure policy).
+var initUntrusted bool
+
+// verifyInit verifies init (and the critical files listed in the manifest)
+// against the pinned digest and signatures, refusing to execute it if
+// verification fails.
+func verifyInit(tracker *stage.Tracker, opts *config.Options) {
+	if opts.InitSHA256 == "" && opts.VerifyPublicKey == "" && opts.VerifyManifest == "" {
+		return
+	}
+
+	err := tracker.Run(context.Background(), "verify", 0, func(ctx context.Context) error {
+		var errs []error
+
+		if opts.InitSHA256 != "" {
+			errs = append(errs, verify.Digest(opts.Cmd, opts.InitSHA256))
+		}
+
+		if opts.VerifyPublicKey != "" {
+			errs = append(errs, verify.Signature(opts.Cmd, opts.VerifyPublicKey))
+		}
+
+		if opts.VerifyManifest != "" {
+			// An unsigned manifest could be replaced along with the files it
+			// lists.
+			if opts.VerifyPublicKey != "" {
+				if err := verify.Signature(opts.VerifyManifest, opts.VerifyPublicKey); err != nil {
+					return errors.Join(append(errs, fmt.Errorf("manifest: %w", err))...)
+				}
+			}
+
+			errs = append(errs, verify.Manifest("/", opts.VerifyManifest))
+		}
+
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		initUntrusted = true
+		fatal("Refusing to execute init, verification failed", slog.Any("cmd", opts.Cmd), slog.Any("error", err))
+	}
+
+	slog.Info("Verified init", slog.Any("cmd", opts.Cmd))
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+/*
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/immutos/matchstick/internal/watchdog"
+	"github.com/immutos/matchstick/pkg/config"
+)
+
+var (
+	// wdogMu guards wdog, as failures may be handled concurrently with setup.
+	wdogMu sync.Mutex
+	// wdog is the armed hardware watchdog (if any).
+	wdog *watchdog.Watchdog
+)
+
+// armWatchdog opens (and so arms) the hardware watchdog, and pets it until
+// the configured limit has elapsed.
+func armWatchdog(opts *config.Options) {
+	w, err := watchdog.Open(opts.Watchdog, opts.WatchdogTimeout)
+	if err != nil {
+		slog.Warn("Failed to open watchdog", slog.String("device", opts.Watchdog), slog.Any("error", err))
+		return
+	}
+
+	slog.Info("Armed watchdog", slog.String("device", opts.Watchdog),
+		slog.Duration("timeout", w.Timeout()), slog.Duration("limit", opts.WatchdogLimit))
+
+	w.Start(opts.WatchdogLimit)
+
+	wdogMu.Lock()
+	wdog = w
+	wdogMu.Unlock()
+}
+
+// releaseWatchdog closes the watchdog before init is executed. If handoff is
+// set, the watchdog is left armed so init (eg. systemd with
+// RuntimeWatchdogSec=) can reopen the device and take over petting it,
+// otherwise it's disarmed.
+func releaseWatchdog(handoff bool) {
+	wdogMu.Lock()
+	defer wdogMu.Unlock()
+
+	if wdog == nil {
+		return
+	}
+
+	var err error
+	if handoff {
+		slog.Info("Handing off watchdog to init", slog.Duration("timeout", wdog.Timeout()))
+
+		err = wdog.Handoff()
+	} else {
+		slog.Debug("Disarming watchdog")
+
+		err = wdog.Disarm()
+	}
+	if err != nil {
+		slog.Warn("Failed to release watchdog", slog.Bool("handoff", handoff), slog.Any("error", err))
+	}
+
+	wdog = nil
+}